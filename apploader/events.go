@@ -0,0 +1,27 @@
+package apploader
+
+import (
+	"fmt"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/events"
+	"github.com/Dimitriy14/image-resizing/events/nats"
+	"github.com/Dimitriy14/image-resizing/events/pgnotify"
+	"github.com/Dimitriy14/image-resizing/logger"
+)
+
+// loadEvents creates events publisher chosen by EventsBroker setting, it requires loaded database
+func loadEvents() (err error) {
+	switch config.Conf.EventsBroker {
+	case "", events.BrokerMemory:
+		events.Client = events.NewMemoryPublisher()
+	case events.BrokerNATS:
+		events.Client, err = nats.NewPublisher(logger.Log, config.Conf.NATSURL, config.Conf.EventsChannel)
+	case events.BrokerPostgres:
+		events.Client, err = pgnotify.NewPublisher(logger.Log, postgres.Client, postgres.ConnectionInfo(), config.Conf.EventsChannel)
+	default:
+		err = fmt.Errorf("unknown events broker %q", config.Conf.EventsBroker)
+	}
+	return err
+}
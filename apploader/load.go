@@ -3,7 +3,6 @@ package apploader
 import (
 	"fmt"
 
-	"github.com/Dimitriy14/image-resizing/clients/bucket"
 	"github.com/Dimitriy14/image-resizing/clients/postgres"
 	"github.com/Dimitriy14/image-resizing/config"
 	"github.com/Dimitriy14/image-resizing/logger"
@@ -23,7 +22,8 @@ var basicLoaders = LoaderList{
 
 var clientLoaders = LoaderList{
 	{"database", postgres.Load},
-	{"bucket", bucket.Load},
+	{"storage", loadStorage},
+	{"events", loadEvents},
 }
 
 func LoadApplicationServices() error {
@@ -0,0 +1,189 @@
+package apploader
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/clients/bucket"
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/aws"
+	"github.com/Dimitriy14/image-resizing/storage/cdn"
+	"github.com/Dimitriy14/image-resizing/storage/encrypted"
+	"github.com/Dimitriy14/image-resizing/storage/filesystem"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+	"github.com/Dimitriy14/image-resizing/storage/resilient"
+	"github.com/Dimitriy14/image-resizing/storage/tiered"
+)
+
+// loadStorage creates storage chosen by StorageBackend setting, aws credentials are required only by aws backend.
+// Objects are replicated to StorageSecondaryBackend when it is set, originals are moved to StorageColdBackend
+// when it is set
+func loadStorage() (err error) {
+	if storage.Keys, err = storage.NewKeyTemplate(config.Conf.StorageKeyTemplate); err != nil {
+		return err
+	}
+	storage.ResizedCacheControl = config.Conf.StorageResizedCacheControl
+
+	if config.Conf.EncryptionKeyID != "" {
+		if keyring, err = encrypted.LoadKeyring(config.Conf.EncryptionKeyID, config.Conf.EncryptionKeys, config.Conf.EncryptionKeysFile); err != nil {
+			return fmt.Errorf("cannot load encryption keys: %s", err)
+		}
+	}
+
+	front, err := cdnFront()
+	if err != nil {
+		return fmt.Errorf("cannot create cdn: %s", err)
+	}
+
+	primary, err := newBackend(config.Conf.StorageBackend, config.Conf.StorageRoot, storageURL(), func() (aws.Storage, error) {
+		if err := bucket.Load(); err != nil {
+			return nil, err
+		}
+		return aws.NewStorage(bucket.Client), nil
+	}, front)
+	if err != nil {
+		return err
+	}
+
+	hot, err := replicate(primary)
+	if err != nil {
+		return err
+	}
+
+	if config.Conf.StorageColdBackend == "" {
+		storage.Client = hot
+		return nil
+	}
+
+	cold, err := newBackend(config.Conf.StorageColdBackend, config.Conf.StorageColdRoot, coldStorageURL(), func() (aws.Storage, error) {
+		if config.Conf.AWSColdBucket == "" {
+			return nil, fmt.Errorf("AWSColdBucket is not set")
+		}
+		bucketS3, err := bucket.NewClient(config.Conf.AWSColdBucket)
+		if err != nil {
+			return nil, err
+		}
+		return aws.NewBucketStorage(bucketS3, config.Conf.AWSColdBucket, config.Conf.StorageColdURL, config.Conf.AWSColdStorageClass), nil
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create cold storage: %s", err)
+	}
+
+	storage.Client = tiered.NewStorage(hot, cold)
+	return nil
+}
+
+// replicate replicates objects of the primary to StorageSecondaryBackend, the primary is returned as is when it isn't set
+func replicate(primary storage.Storage) (storage.Storage, error) {
+	if config.Conf.StorageSecondaryBackend == "" {
+		return primary, nil
+	}
+
+	secondary, err := newBackend(config.Conf.StorageSecondaryBackend, config.Conf.StorageSecondaryRoot, config.Conf.StorageSecondaryURL, func() (aws.Storage, error) {
+		if config.Conf.AWSSecondaryBucket == "" {
+			return nil, fmt.Errorf("AWSSecondaryBucket is not set")
+		}
+		bucketS3, err := bucket.NewClient(config.Conf.AWSSecondaryBucket)
+		if err != nil {
+			return nil, err
+		}
+		return aws.NewBucketStorage(bucketS3, config.Conf.AWSSecondaryBucket, config.Conf.StorageSecondaryURL, ""), nil
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create secondary storage: %s", err)
+	}
+
+	return replicated.NewStorage(logger.Log, primary, secondary, config.Conf.StorageReplication)
+}
+
+// keyring encrypts objects of every backend when EncryptionKeyID is set
+var keyring *encrypted.Keyring
+
+// newBackend creates the backend whose calls are limited by deadlines, retried and stopped by the circuit breaker,
+// so the failure of one backend of replicated storage doesn't slow down the other one. Objects are encrypted
+// below the deadlines and retries, so retried uploads are encrypted again.
+// url is used by filesystem and memory backends, aws backend is created by newAWS.
+// front puts the CDN in front of the backend, it is nil for backends which aren't its origin
+func newBackend(backend, root, url string, newAWS func() (aws.Storage, error), front func(storage.Storage) storage.Storage) (storage.Storage, error) {
+	var (
+		s         storage.Storage
+		retryable resilient.RetryableFunc
+		err       error
+	)
+
+	switch backend {
+	case "", storage.BackendAWS:
+		s, err = newAWS()
+		retryable = aws.IsRetryable
+	case storage.BackendFilesystem:
+		s, err = filesystem.NewStorage(root, url)
+	case storage.BackendMemory:
+		s = memory.NewStorage(url)
+	default:
+		err = fmt.Errorf("unknown storage backend %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if front != nil {
+		s = front(s)
+	}
+
+	if keyring != nil {
+		s = encrypted.NewStorage(logger.Log, s, keyring)
+	}
+
+	return resilient.NewStorage(logger.Log, s, retryable), nil
+}
+
+// storageURL returns StorageURL or the url of files served by the service itself
+func storageURL() string {
+	if config.Conf.StorageURL != "" {
+		return config.Conf.StorageURL
+	}
+	return fmt.Sprintf("http://localhost%s%s%s", config.Conf.ListenURL, config.Conf.BasePath, storage.FilesPath)
+}
+
+// coldStorageURL returns StorageColdURL or the url of cold files served by the service itself
+func coldStorageURL() string {
+	if config.Conf.StorageColdURL != "" {
+		return config.Conf.StorageColdURL
+	}
+	return fmt.Sprintf("http://localhost%s%s%s", config.Conf.ListenURL, config.Conf.BasePath, storage.ColdFilesPath)
+}
+
+// cdnFront returns the decorator which links objects by CDNHosts and purges them by CDNPurgeURL,
+// it is nil when CDNHosts isn't set. Encrypted objects are served by the API, so they are never linked by the CDN
+func cdnFront() (func(storage.Storage) storage.Storage, error) {
+	if config.Conf.CDNHosts == "" {
+		return nil, nil
+	}
+	if keyring != nil {
+		logger.Log.Warnf("objects are encrypted, so they are served by the API and CDNHosts is ignored")
+		return nil, nil
+	}
+
+	links, err := cdn.NewURLBuilder(cdn.ParseHosts(config.Conf.CDNHosts), config.Conf.CDNSigningKey,
+		time.Duration(config.Conf.CDNLinkTTLSec)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var purger cdn.Purger = cdn.NopPurger{}
+	if config.Conf.CDNPurgeURL != "" {
+		var client *http.Client
+		if config.Conf.CDNPurgeTimeoutSec > 0 {
+			client = &http.Client{Timeout: time.Duration(config.Conf.CDNPurgeTimeoutSec) * time.Second}
+		}
+		purger = cdn.NewHTTPPurger(config.Conf.CDNPurgeURL, config.Conf.CDNPurgeToken, client)
+	}
+
+	return func(s storage.Storage) storage.Storage {
+		return cdn.NewStorage(s, links, purger)
+	}, nil
+}
@@ -1,38 +1,107 @@
 package bucket
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+
 	"github.com/Dimitriy14/image-resizing/config"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// urlProbeKey is used to build the url of an object, the key is stripped from it afterwards
+const urlProbeKey = "key"
+
 var Client *S3Client
 
 type S3Client struct {
-	Uploader   *s3manager.Uploader
-	Downloader *s3manager.Downloader
+	S3       s3iface.S3API
+	Uploader *s3manager.Uploader
+
+	// URL is the url of bucket objects as the sdk addresses them, it doesn't end with slash
+	URL string
+}
+
+// Load creates client of AWSBucket
+func Load() (err error) {
+	Client, err = NewClient(config.Conf.AWSBucket)
+	return err
 }
 
-func Load() error {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(config.Conf.AWSRegion),
-		Credentials: credentials.NewStaticCredentials(config.Conf.AWSID, config.Conf.AWSSecret, ""),
+// NewClient creates client of the bucket in AWS S3 or S3-compatible storage (AWSEndpoint),
+// static credentials are used when they are set, otherwise the default credential chain
+// (env, shared profile, IAM role) is used
+func NewClient(bucketName string) (*S3Client, error) {
+	cfg := aws.NewConfig().
+		WithRegion(config.Conf.AWSRegion).
+		WithS3ForcePathStyle(config.Conf.AWSPathStyle)
+
+	if config.Conf.AWSEndpoint != "" {
+		cfg = cfg.WithEndpoint(config.Conf.AWSEndpoint)
+	}
+
+	if config.Conf.AWSInsecureSkipVerify {
+		cfg = cfg.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec // only for dev setups with self-signed certificates
+			},
+		})
+	}
+
+	if config.Conf.AWSID != "" || config.Conf.AWSSecret != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(config.Conf.AWSID, config.Conf.AWSSecret, ""))
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		Profile:           config.Conf.AWSProfile,
+		SharedConfigState: session.SharedConfigEnable,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_, err = sess.Config.Credentials.Get()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("cannot get aws credentials: %s", err)
+	}
+
+	svc := s3.New(sess)
+
+	objectsURL, err := bucketURL(svc, bucketName)
+	if err != nil {
+		return nil, err
 	}
 
-	Client = &S3Client{
-		Uploader:   s3manager.NewUploader(sess),
-		Downloader: s3manager.NewDownloader(sess),
+	return &S3Client{
+		S3:       svc,
+		Uploader: s3manager.NewUploader(sess),
+		URL:      objectsURL,
+	}, nil
+}
+
+// bucketURL builds request of an object the same way as the sdk does and strips the key from its url,
+// so the result respects the endpoint, region and addressing style
+func bucketURL(svc *s3.S3, bucketName string) (string, error) {
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(urlProbeKey),
+	})
+	if err := req.Build(); err != nil {
+		return "", fmt.Errorf("cannot build bucket url: %s", err)
 	}
 
-	return nil
+	u := *req.HTTPRequest.URL
+	u.RawQuery = ""
+	u.RawPath = ""
+	u.Path = strings.TrimSuffix(u.Path, "/"+urlProbeKey)
+
+	return u.String(), nil
 }
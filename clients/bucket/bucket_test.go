@@ -0,0 +1,75 @@
+package bucket
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+)
+
+func TestBucketURL(t *testing.T) {
+	testCases := []struct {
+		name      string
+		endpoint  string
+		pathStyle bool
+		expURL    string
+	}{
+		{
+			name:   "AWS case",
+			expURL: "https://resized-images-yal.s3.eu-central-1.amazonaws.com",
+		},
+		{
+			name:      "AWS path style case",
+			pathStyle: true,
+			expURL:    "https://s3.eu-central-1.amazonaws.com/resized-images-yal",
+		},
+		{
+			name:      "MinIO case",
+			endpoint:  "http://localhost:9000",
+			pathStyle: true,
+			expURL:    "http://localhost:9000/resized-images-yal",
+		},
+		{
+			name:     "Virtual hosted endpoint case",
+			endpoint: "https://storage.example.com",
+			expURL:   "https://resized-images-yal.storage.example.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := aws.NewConfig().
+				WithRegion("eu-central-1").
+				WithS3ForcePathStyle(tc.pathStyle).
+				WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))
+			if tc.endpoint != "" {
+				cfg = cfg.WithEndpoint(tc.endpoint)
+			}
+
+			url, err := bucketURL(s3.New(session.Must(session.NewSession(cfg))), "resized-images-yal")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expURL, url)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer func(conf config.Configuration) { config.Conf = conf }(config.Conf)
+
+	config.Conf.AWSRegion = "us-east-1"
+	config.Conf.AWSBucket = "images"
+	config.Conf.AWSEndpoint = "https://minio.local:9000"
+	config.Conf.AWSPathStyle = true
+	config.Conf.AWSInsecureSkipVerify = true
+	config.Conf.AWSID = "id"
+	config.Conf.AWSSecret = "secret"
+
+	if assert.NoError(t, Load()) {
+		assert.Equal(t, "https://minio.local:9000/images", Client.URL)
+	}
+}
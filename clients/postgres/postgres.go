@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/Dimitriy14/image-resizing/config"
 	"github.com/Dimitriy14/image-resizing/logger"
@@ -20,8 +21,9 @@ type PGClient struct {
 
 const pg = "postgres"
 
-func Load() error {
-	url := fmt.Sprintf(
+// ConnectionInfo returns connection string built from configuration
+func ConnectionInfo() string {
+	return fmt.Sprintf(
 		dbInfo,
 		config.Conf.PostgresHost,
 		config.Conf.PostgresPort,
@@ -29,6 +31,10 @@ func Load() error {
 		config.Conf.PostgresPassword,
 		config.Conf.PostgresDBName,
 	)
+}
+
+func Load() error {
+	url := ConnectionInfo()
 
 	fmt.Println(url)
 
@@ -41,6 +47,72 @@ func Load() error {
 	db.SetLogger(logger.NewGormLogger(logger.Log))
 	db.LogMode(true)
 
-	db.AutoMigrate(&models.Images{})
+	db.AutoMigrate(&models.Images{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.OutboxEvent{}, &models.Export{}, &models.Upload{}, &models.ResumableUpload{}, &models.Compensation{}, &migration{})
+
+	// chunks are looked up by garbage collection, gorm doesn't create indexes of arrays
+	if err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_resumable_uploads_chunks ON resumable_uploads USING gin (chunks)`).Error; err != nil {
+		return fmt.Errorf("creating index of chunks: %s", err)
+	}
+
+	if err = runOnce(db, "links-to-keys", migrateLinksToKeys); err != nil {
+		return fmt.Errorf("migrating links to keys: %s", err)
+	}
+	if err = migrateTiers(db); err != nil {
+		return fmt.Errorf("migrating tiers: %s", err)
+	}
 	return nil
 }
+
+// migration is recorded when the data migration is applied, so it doesn't scan tables on every start
+type migration struct {
+	Name      string    `gorm:"primary_key; column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (migration) TableName() string {
+	return "schema_migrations"
+}
+
+// runOnce applies the migration unless it is recorded, migrations are idempotent,
+// so instances which start simultaneously could both apply it
+func runOnce(db *gorm.DB, name string, migrate func(db *gorm.DB) error) error {
+	var applied int
+	if err := db.Model(&migration{}).Where("name = ?", name).Count(&applied).Error; err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	if err := migrate(db); err != nil {
+		return err
+	}
+
+	return db.Exec(`INSERT INTO schema_migrations (name, applied_at) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		name, time.Now().UTC()).Error
+}
+
+// linkPrefix matches scheme and host of the link, the rest of the link is the object key
+const linkPrefix = `'^[a-z]+://[^/]+/'`
+
+// migrateLinksToKeys fills keys of images and exports which were saved with links only
+func migrateLinksToKeys(db *gorm.DB) error {
+	if db.Dialect().HasColumn(models.Images{}.TableName(), "original") {
+		err := db.Exec(`UPDATE images
+			SET original_key = regexp_replace(original, ` + linkPrefix + `, ''),
+				resized_key = regexp_replace(resized, ` + linkPrefix + `, '')
+			WHERE coalesce(original_key, '') = ''`).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(`UPDATE exports SET archive = regexp_replace(archive, ` + linkPrefix + `, '') WHERE archive LIKE '%://%'`).Error
+}
+
+// migrateTiers marks images created before tiering as hot ones which are read at migration,
+// so their originals are moved to the cold tier only after TieringColdAfterDays
+func migrateTiers(db *gorm.DB) error {
+	return db.Exec(`UPDATE images SET tier = ?, original_read_at = coalesce(original_read_at, ?) WHERE coalesce(tier, '') = ''`,
+		models.TierHot, time.Now().UTC()).Error
+}
@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const defaultBackfillBatch = 100
+
+// errAttributesNotKept is returned when the storage ignores attributes, otherwise its objects would be rewritten
+// by every backfill
+var errAttributesNotKept = errors.New("storage doesn't keep attributes of objects")
+
+// AttributeUpdate is the object whose attributes are set by the backfill
+type AttributeUpdate struct {
+	ImageID uuid.UUID
+	Key     string
+}
+
+// BackfillReport describes updated objects, in dry run the updates are only planned
+type BackfillReport struct {
+	Images  int
+	Objects int
+	// Current objects already have the attributes
+	Current int
+	Updated int
+	Failed  int
+	Updates []AttributeUpdate
+}
+
+// AttributeBackfiller sets attributes of objects stored before they were introduced or changed, e.g. Cache-Control.
+// S3 objects are copied onto themselves, objects of other storages are rewritten with the same content verified
+// by its checksum. An object deleted by the service while it is rewritten could be stored again,
+// it is collected by the garbage collector then
+type AttributeBackfiller struct {
+	log    logger.Logger
+	repo   repository.Repository
+	bucket storage.Storage
+}
+
+// NewAttributeBackfiller creates new backfiller
+func NewAttributeBackfiller(log logger.Logger, repo repository.Repository, bucket storage.Storage) *AttributeBackfiller {
+	return &AttributeBackfiller{
+		log:    log,
+		repo:   repo,
+		bucket: bucket,
+	}
+}
+
+func runBackfillAttributes(args []string) error {
+	flags := flag.NewFlagSet("backfill-attributes", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report objects whose attributes differ without rewriting them")
+	batch := flags.Int("batch", defaultBackfillBatch, "number of images read from the db at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	backfiller := NewAttributeBackfiller(logger.Log, repository.NewRepository(postgres.Client), storage.Client)
+
+	report, err := backfiller.Backfill(context.Background(), *dryRun, *batch)
+	printBackfillReport(os.Stdout, report, *dryRun)
+	return err
+}
+
+// Backfill sets attributes of objects of all images, failed objects are reported and skipped.
+// The error is returned when the images cannot be read or the storage doesn't keep attributes
+func (b *AttributeBackfiller) Backfill(ctx context.Context, dryRun bool, batch int) (BackfillReport, error) {
+	var (
+		report BackfillReport
+		lastID uuid.UUID
+	)
+
+	if batch <= 0 {
+		batch = defaultBackfillBatch
+	}
+
+	b.log.Infof("Started backfill of object attributes, dry run: %t", dryRun)
+
+	for {
+		images, err := b.repo.GetImagesAfter(lastID, batch)
+		if err != nil {
+			return report, fmt.Errorf("cannot retrieve images: %s", err)
+		}
+
+		for _, img := range images {
+			report.Images++
+
+			for _, object := range b.objects(img) {
+				report.Objects++
+
+				updated, err := b.backfillObject(ctx, object, dryRun)
+				switch {
+				case err == errAttributesNotKept:
+					return report, err
+				case err != nil:
+					b.log.Errorf("cannot set attributes of %q of image %q due to: %s", object.key, img.ID, err)
+					report.Failed++
+				case !updated:
+					report.Current++
+				default:
+					report.Updates = append(report.Updates, AttributeUpdate{ImageID: img.ID, Key: object.key})
+					if !dryRun {
+						report.Updated++
+					}
+				}
+			}
+		}
+
+		if len(images) < batch {
+			break
+		}
+		lastID = images[len(images)-1].ID
+	}
+
+	b.log.Infof("Finished backfill of object attributes: %d images, %d objects, %d current, %d updated, %d failed",
+		report.Images, report.Objects, report.Current, report.Updated, report.Failed)
+
+	return report, nil
+}
+
+// imageObject is the object of the image with the attributes which it should have
+type imageObject struct {
+	bucket   storage.Storage
+	key      string
+	checksum string
+	attrs    storage.Attributes
+}
+
+// objects returns objects of the image, cold originals are rewritten in the cold tier
+func (b *AttributeBackfiller) objects(img models.Images) []imageObject {
+	var objects []imageObject
+
+	if img.OriginalKey != "" {
+		originals := b.bucket
+		if tiers, ok := storage.AsTiered(b.bucket); ok && img.Tier == models.TierCold {
+			originals = tiers.Cold()
+		}
+
+		objects = append(objects, imageObject{
+			bucket:   originals,
+			key:      img.OriginalKey,
+			checksum: img.OriginalSHA256,
+			attrs: storage.ImageAttributes(storage.KeyParams{
+				UserID:  img.UserID,
+				ImageID: img.ID,
+				Variant: storage.VariantOriginal,
+			}, img.Filename),
+		})
+	}
+
+	if img.ResizedKey != "" {
+		variant, ok := storage.Keys.Variant(img.ResizedKey)
+		if !ok {
+			variant = storage.VariantResized
+		}
+
+		objects = append(objects, imageObject{
+			bucket:   b.bucket,
+			key:      img.ResizedKey,
+			checksum: img.ResizedSHA256,
+			attrs: storage.ImageAttributes(storage.KeyParams{
+				UserID:  img.UserID,
+				ImageID: img.ID,
+				Variant: variant,
+			}, img.Filename),
+		})
+	}
+
+	return objects
+}
+
+func (b *AttributeBackfiller) backfillObject(ctx context.Context, object imageObject, dryRun bool) (bool, error) {
+	info, err := object.bucket.Stat(ctx, object.key)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat %s: %s", object.key, err)
+	}
+
+	if info.Attributes.Equal(object.attrs) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	if err = b.rewrite(ctx, object, info); err != nil {
+		return false, err
+	}
+
+	if info, err = object.bucket.Stat(ctx, object.key); err != nil {
+		return false, fmt.Errorf("cannot stat %s: %s", object.key, err)
+	}
+	if !info.Attributes.Equal(object.attrs) {
+		return false, errAttributesNotKept
+	}
+
+	return true, nil
+}
+
+// rewrite sets the attributes by the storage, rewritten content is verified by the checksum of the image
+// or the one kept by the backend, so corrupted objects are never stored as valid ones
+func (b *AttributeBackfiller) rewrite(ctx context.Context, object imageObject, info storage.ObjectInfo) error {
+	if object.checksum != "" {
+		info.SHA256 = object.checksum
+	}
+
+	if err := storage.SetAttributes(ctx, object.bucket, info, object.attrs); err != nil {
+		return fmt.Errorf("cannot rewrite %s: %s", object.key, err)
+	}
+
+	return nil
+}
+
+func printBackfillReport(w io.Writer, report BackfillReport, dryRun bool) {
+	verb := "updated"
+	if dryRun {
+		verb = "would update"
+	}
+
+	for _, update := range report.Updates {
+		fmt.Fprintf(w, "image %s: %s attributes of %s\n", update.ImageID, verb, update.Key)
+	}
+
+	fmt.Fprintf(w, "images: %d, objects: %d, current: %d, updated: %d, failed: %d\n",
+		report.Images, report.Objects, report.Current, report.Updated, report.Failed)
+}
@@ -0,0 +1,172 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+)
+
+func TestAttributeBackfiller_Backfill(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	testCases := []struct {
+		name      string
+		dryRun    bool
+		current   bool
+		missing   bool
+		corrupted bool
+		ignored   bool
+		expReport BackfillReport
+		expErr    bool
+		expSet    bool
+	}{
+		{
+			name:      "Good case",
+			expReport: BackfillReport{Images: 1, Objects: 2, Updated: 2},
+			expSet:    true,
+		},
+		{
+			name:      "Dry run case",
+			dryRun:    true,
+			expReport: BackfillReport{Images: 1, Objects: 2},
+		},
+		{
+			name:      "Current attributes case",
+			current:   true,
+			expReport: BackfillReport{Images: 1, Objects: 2, Current: 2},
+			expSet:    true,
+		},
+		{
+			name:      "Missing object case",
+			missing:   true,
+			expReport: BackfillReport{Images: 1, Objects: 2, Updated: 1, Failed: 1},
+		},
+		{
+			name:      "Corrupted object case",
+			corrupted: true,
+			expReport: BackfillReport{Images: 1, Objects: 2, Updated: 1, Failed: 1},
+		},
+		{
+			name:      "Attributes ignored by storage case",
+			ignored:   true,
+			expReport: BackfillReport{Images: 1, Objects: 1},
+			expErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				ctx                    = context.Background()
+				repo                   = mocks.NewMockRepository(ctrl)
+				mem                    = memory.NewStorage("http://localhost/files")
+				bucket storage.Storage = mem
+				img                    = models.Images{
+					ID:             uuid.New(),
+					UserID:         uuid.New(),
+					Filename:       "cat.png",
+					OriginalSHA256: storage.Checksum([]byte("original")),
+					ResizedSHA256:  storage.Checksum([]byte("resized")),
+				}
+				original = storage.KeyParams{UserID: img.UserID, ImageID: img.ID, Variant: storage.VariantOriginal, Ext: ".png"}
+				resized  = storage.KeyParams{UserID: img.UserID, ImageID: img.ID, Variant: storage.ResizedVariant(2, 1), Ext: ".png"}
+			)
+			img.OriginalKey = storage.Keys.Key(original)
+			img.ResizedKey = storage.Keys.Key(resized)
+
+			if tc.ignored {
+				bucket = attributesIgnored{mem}
+			}
+
+			var originalAttrs, resizedAttrs storage.Attributes
+			if tc.current {
+				originalAttrs = storage.ImageAttributes(original, img.Filename)
+				resizedAttrs = storage.ImageAttributes(resized, img.Filename)
+			}
+
+			if !tc.missing {
+				assert.NoError(t, mem.Put(ctx, img.OriginalKey, bytes.NewBufferString("original"), storage.PutOptions{
+					ContentType: "image/png",
+					Size:        -1,
+					Attributes:  originalAttrs,
+				}))
+			}
+			resizedContent := "resized"
+			if tc.corrupted {
+				resizedContent = "modified"
+			}
+			assert.NoError(t, mem.Put(ctx, img.ResizedKey, bytes.NewBufferString(resizedContent), storage.PutOptions{
+				ContentType: "image/png",
+				Size:        -1,
+				Attributes:  resizedAttrs,
+			}))
+
+			repo.EXPECT().GetImagesAfter(uuid.Nil, 2).Return([]models.Images{img}, nil)
+
+			report, err := NewAttributeBackfiller(log, repo, bucket).Backfill(ctx, tc.dryRun, 2)
+			assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+
+			assert.Equal(t, tc.expReport.Images, report.Images, "unexpected number of images")
+			assert.Equal(t, tc.expReport.Objects, report.Objects, "unexpected number of objects")
+			assert.Equal(t, tc.expReport.Current, report.Current, "unexpected number of current objects")
+			assert.Equal(t, tc.expReport.Updated, report.Updated, "unexpected number of updated objects")
+			assert.Equal(t, tc.expReport.Failed, report.Failed, "unexpected number of failed objects")
+
+			if tc.ignored {
+				return
+			}
+			if tc.missing || tc.corrupted {
+				assert.Equal(t, resizedContent, readObject(t, mem, img.ResizedKey), "content shouldn't be changed")
+				return
+			}
+
+			for key, params := range map[string]storage.KeyParams{img.OriginalKey: original, img.ResizedKey: resized} {
+				info, err := mem.Stat(ctx, key)
+				assert.NoError(t, err)
+				assert.Equal(t, "image/png", info.ContentType, "content type should be kept")
+
+				set := info.Attributes.Equal(storage.ImageAttributes(params, img.Filename))
+				assert.Equal(t, tc.expSet, set, "unexpected attributes of %s: %+v", params.Variant, info.Attributes)
+			}
+			assert.Equal(t, "original", readObject(t, mem, img.OriginalKey), "content should be kept")
+		})
+	}
+}
+
+// attributesIgnored is the storage which doesn't keep attributes of objects, e.g. filesystem
+type attributesIgnored struct {
+	storage.Storage
+}
+
+func (s attributesIgnored) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	info, err := s.Storage.Stat(ctx, key)
+	info.Attributes = storage.Attributes{}
+	return info, err
+}
+
+func readObject(t *testing.T, s storage.Storage, key string) string {
+	var buf bytes.Buffer
+	body, err := s.Get(context.Background(), key)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer body.Close()
+
+	_, err = buf.ReadFrom(body)
+	assert.NoError(t, err)
+	return buf.String()
+}
@@ -0,0 +1,63 @@
+// Package commands contains maintenance commands which are run instead of the server,
+// e.g. `image-resizing migrate-keys -dry-run`
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+)
+
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"migrate-keys":        {"move objects to StorageKeyTemplate layout and rewrite their keys", runMigrateKeys},
+	"gc":                  {"delete bucket objects which are not referenced by the db", runGC},
+	"repair-replicas":     {"copy objects missing in one of replicated storages to the other one", runRepairReplicas},
+	"tier-originals":      {"move originals between storage tiers by the time they were read", runTierOriginals},
+	"reencrypt":           {"encrypt stored objects by the current EncryptionKeyID", runReencrypt},
+	"scrub":               {"verify objects of images by their checksums and report missing or corrupted ones", runScrub},
+	"backfill-attributes": {"set Cache-Control, Content-Disposition and metadata of stored objects", runBackfillAttributes},
+}
+
+// Run runs the command named by the first argument, the rest of arguments are its flags.
+// Application services must be loaded before
+func Run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command is not set, available commands:\n%s", usage())
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, available commands:\n%s", args[0], usage())
+	}
+
+	err := cmd.run(args[1:])
+
+	// objects written by the command are replicated in background, so they are waited for before exit
+	if replicas, ok := replicated.Find(storage.Client); ok {
+		replicas.Flush()
+	}
+
+	return err
+}
+
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  %s\t%s", name, commands[name].description))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/gc"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+func runGC(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report orphaned objects without deleting them")
+	grace := flags.Duration("grace", 0, "minimal age of deleted objects, GCGracePeriodHours is used when it is zero")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	collector := gc.NewCollector(logger.Log, repository.NewObjectRepository(postgres.Client), storage.Client)
+	if *grace > 0 {
+		collector.SetGracePeriod(*grace)
+	}
+
+	report, err := collector.Collect(context.Background(), *dryRun)
+	printGCReport(os.Stdout, report, *dryRun)
+	return err
+}
+
+func printGCReport(w io.Writer, report gc.Report, dryRun bool) {
+	verb := "orphan"
+	if dryRun {
+		verb = "would delete"
+	}
+
+	for _, info := range report.Orphans {
+		fmt.Fprintf(w, "%s %s (%d bytes, modified %s)\n", verb, info.Key, info.Size, info.LastModified.UTC().Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(w, "expired uploads: %d, objects: %d, unmanaged: %d, recent: %d, referenced: %d, orphans: %d (%d bytes), deleted: %d, failed: %d\n",
+		report.ExpiredUploads, report.Objects, report.Unmanaged, report.Recent, report.Referenced, len(report.Orphans), report.OrphanSize, report.Deleted, report.Failed)
+}
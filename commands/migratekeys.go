@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const defaultMigrationBatch = 100
+
+// errKeysChanged is returned when the image is changed by the service while its objects are copied
+var errKeysChanged = errors.New("keys of the image have been changed during migration")
+
+// KeyMove is a move of the object to the key built by the template
+type KeyMove struct {
+	ImageID uuid.UUID
+	From    string
+	To      string
+
+	info storage.ObjectInfo
+}
+
+// MigrationReport describes moved objects, in dry run the moves are only planned
+type MigrationReport struct {
+	Images   int
+	Migrated int
+	// Skipped images are already stored according to the template
+	Skipped int
+	Failed  int
+	Moves   []KeyMove
+}
+
+// KeyMigrator moves objects of images stored with other layout to the keys built by the template.
+// Objects are copied, then the keys are replaced in the db and only then old objects are deleted,
+// so the images are available during migration and it could be run again after a failure
+type KeyMigrator struct {
+	log    logger.Logger
+	repo   repository.Repository
+	bucket storage.Storage
+	keys   storage.KeyTemplate
+}
+
+// NewKeyMigrator creates new migrator
+func NewKeyMigrator(log logger.Logger, repo repository.Repository, bucket storage.Storage, keys storage.KeyTemplate) *KeyMigrator {
+	return &KeyMigrator{
+		log:    log,
+		repo:   repo,
+		bucket: bucket,
+		keys:   keys,
+	}
+}
+
+func runMigrateKeys(args []string) error {
+	flags := flag.NewFlagSet("migrate-keys", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report planned moves without changing anything")
+	batch := flags.Int("batch", defaultMigrationBatch, "number of images read from the db at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	migrator := NewKeyMigrator(logger.Log, repository.NewRepository(postgres.Client), storage.Client, storage.Keys)
+
+	report, err := migrator.Migrate(context.Background(), *dryRun, *batch)
+	printMigrationReport(os.Stdout, report, *dryRun)
+	return err
+}
+
+// Migrate moves objects of all images, failed images are reported and skipped,
+// the error is returned only when the images cannot be read
+func (m *KeyMigrator) Migrate(ctx context.Context, dryRun bool, batch int) (MigrationReport, error) {
+	var (
+		report MigrationReport
+		lastID uuid.UUID
+	)
+
+	if batch <= 0 {
+		batch = defaultMigrationBatch
+	}
+
+	m.log.Infof("Started migration of keys to %q layout, dry run: %t", m.keys, dryRun)
+
+	for {
+		images, err := m.repo.GetImagesAfter(lastID, batch)
+		if err != nil {
+			return report, fmt.Errorf("cannot retrieve images: %s", err)
+		}
+
+		for _, img := range images {
+			report.Images++
+
+			moves, err := m.migrateImage(ctx, img, dryRun)
+			if err != nil {
+				m.log.Errorf("cannot migrate keys of image %q due to: %s", img.ID, err)
+				report.Failed++
+				continue
+			}
+
+			if len(moves) == 0 {
+				report.Skipped++
+				continue
+			}
+
+			report.Migrated++
+			report.Moves = append(report.Moves, moves...)
+		}
+
+		if len(images) < batch {
+			break
+		}
+		lastID = images[len(images)-1].ID
+	}
+
+	m.log.Infof("Finished migration of keys: %d images, %d migrated, %d skipped, %d failed",
+		report.Images, report.Migrated, report.Skipped, report.Failed)
+
+	return report, nil
+}
+
+func (m *KeyMigrator) migrateImage(ctx context.Context, img models.Images, dryRun bool) ([]KeyMove, error) {
+	var (
+		moves    []KeyMove
+		migrated = img
+	)
+
+	for _, object := range []struct {
+		key      *string
+		checksum string
+		variant  string
+	}{
+		{&migrated.OriginalKey, img.OriginalSHA256, storage.VariantOriginal},
+		{&migrated.ResizedKey, img.ResizedSHA256, storage.VariantResized},
+	} {
+		if *object.key == "" || m.keys.Match(*object.key) {
+			continue
+		}
+
+		info, err := m.bucket.Stat(ctx, *object.key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot stat %s: %s", *object.key, err)
+		}
+		// the copy is verified by the checksum of the image, the one kept by the backend is used for older images
+		if object.checksum != "" {
+			info.SHA256 = object.checksum
+		}
+
+		to := m.keys.Key(storage.KeyParams{
+			UserID:  img.UserID,
+			ImageID: img.ID,
+			Variant: object.variant,
+			Ext:     path.Ext(*object.key),
+			Time:    info.LastModified,
+		})
+		if to == *object.key {
+			continue
+		}
+
+		moves = append(moves, KeyMove{ImageID: img.ID, From: *object.key, To: to, info: info})
+		*object.key = to
+	}
+
+	if dryRun || len(moves) == 0 {
+		return moves, nil
+	}
+
+	for i, move := range moves {
+		if err := m.copyObject(ctx, move); err != nil {
+			m.deleteObjects(ctx, moves[:i], func(move KeyMove) string { return move.To })
+			return nil, err
+		}
+	}
+
+	replaced, err := m.repo.ReplaceImageKeys(img, migrated)
+	if err == nil && !replaced {
+		err = errKeysChanged
+	}
+	if err != nil {
+		m.deleteObjects(ctx, moves, func(move KeyMove) string { return move.To })
+		return nil, err
+	}
+
+	m.deleteObjects(ctx, moves, func(move KeyMove) string { return move.From })
+
+	return moves, nil
+}
+
+func (m *KeyMigrator) copyObject(ctx context.Context, move KeyMove) error {
+	content, err := m.bucket.Get(ctx, move.From)
+	if err != nil {
+		return fmt.Errorf("cannot download %s: %s", move.From, err)
+	}
+	defer content.Close()
+
+	err = m.bucket.Put(ctx, move.To, content, storage.PutOptions{
+		ContentType: move.info.ContentType,
+		Size:        move.info.Size,
+		SHA256:      move.info.SHA256,
+		Attributes:  move.info.Attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot copy %s to %s: %s", move.From, move.To, err)
+	}
+
+	return nil
+}
+
+func (m *KeyMigrator) deleteObjects(ctx context.Context, moves []KeyMove, key func(KeyMove) string) {
+	for _, move := range moves {
+		if err := m.bucket.Delete(ctx, key(move)); err != nil {
+			m.log.Errorf("cannot delete %s due to: %s", key(move), err)
+		}
+	}
+}
+
+func printMigrationReport(w io.Writer, report MigrationReport, dryRun bool) {
+	verb := "moved"
+	if dryRun {
+		verb = "would move"
+	}
+
+	for _, move := range report.Moves {
+		fmt.Fprintf(w, "image %s: %s %s -> %s\n", move.ImageID, verb, move.From, move.To)
+	}
+
+	fmt.Fprintf(w, "images: %d, migrated: %d, skipped: %d, failed: %d\n",
+		report.Images, report.Migrated, report.Skipped, report.Failed)
+}
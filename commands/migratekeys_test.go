@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+)
+
+func TestKeyMigrator_Migrate(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	testCases := []struct {
+		name        string
+		dryRun      bool
+		migrated    bool
+		missing     bool
+		corrupted   bool
+		replaced    bool
+		replaceErr  error
+		expReport   MigrationReport
+		expReplaced bool
+		expMoved    bool
+	}{
+		{
+			name:        "Good case",
+			replaced:    true,
+			expReport:   MigrationReport{Images: 1, Migrated: 1},
+			expReplaced: true,
+			expMoved:    true,
+		},
+		{
+			name:      "Dry run case",
+			dryRun:    true,
+			expReport: MigrationReport{Images: 1, Migrated: 1},
+		},
+		{
+			name:      "Already migrated case",
+			migrated:  true,
+			expReport: MigrationReport{Images: 1, Skipped: 1},
+		},
+		{
+			name:      "Missing object case",
+			missing:   true,
+			expReport: MigrationReport{Images: 1, Failed: 1},
+		},
+		{
+			name:      "Corrupted object case",
+			corrupted: true,
+			expReport: MigrationReport{Images: 1, Failed: 1},
+		},
+		{
+			name:        "Changed during migration case",
+			expReport:   MigrationReport{Images: 1, Failed: 1},
+			expReplaced: true,
+		},
+		{
+			name:        "Db error case",
+			replaceErr:  errors.New("DB ERROR"),
+			expReport:   MigrationReport{Images: 1, Failed: 1},
+			expReplaced: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				ctx    = context.Background()
+				repo   = mocks.NewMockRepository(ctrl)
+				bucket = memory.NewStorage("http://localhost/files")
+				keys   storage.KeyTemplate
+				img    = models.Images{
+					ID:             uuid.New(),
+					UserID:         uuid.New(),
+					OriginalKey:    storage.NewKey(".png"),
+					ResizedKey:     storage.NewKey(".png"),
+					OriginalSHA256: storage.Checksum([]byte("original")),
+					ResizedSHA256:  storage.Checksum([]byte("resized")),
+				}
+				replacedWith models.Images
+			)
+
+			if tc.migrated {
+				img.OriginalKey = keys.Key(storage.KeyParams{UserID: img.UserID, ImageID: img.ID, Variant: storage.VariantOriginal, Ext: ".png"})
+				img.ResizedKey = keys.Key(storage.KeyParams{UserID: img.UserID, ImageID: img.ID, Variant: storage.ResizedVariant(2, 1), Ext: ".png"})
+			}
+
+			assert.NoError(t, bucket.Put(ctx, img.OriginalKey, bytes.NewBufferString("original"), storage.PutOptions{ContentType: "image/png", Size: -1}))
+			resized := "resized"
+			if tc.corrupted {
+				resized = "corrupted"
+			}
+			if !tc.missing {
+				assert.NoError(t, bucket.Put(ctx, img.ResizedKey, bytes.NewBufferString(resized), storage.PutOptions{ContentType: "image/png", Size: -1}))
+			}
+
+			repo.EXPECT().GetImagesAfter(uuid.Nil, 2).Return([]models.Images{img}, nil)
+			repo.EXPECT().ReplaceImageKeys(img, gomock.Any()).DoAndReturn(func(old, new models.Images) (bool, error) {
+				replacedWith = new
+				return tc.replaced, tc.replaceErr
+			}).Times(boolToTimes(tc.expReplaced))
+
+			report, err := NewKeyMigrator(log, repo, bucket, keys).Migrate(ctx, tc.dryRun, 2)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expReport.Images, report.Images, "unexpected number of images")
+			assert.Equal(t, tc.expReport.Migrated, report.Migrated, "unexpected number of migrated images")
+			assert.Equal(t, tc.expReport.Skipped, report.Skipped, "unexpected number of skipped images")
+			assert.Equal(t, tc.expReport.Failed, report.Failed, "unexpected number of failed images")
+
+			if tc.expMoved {
+				assert.True(t, keys.Match(replacedWith.OriginalKey), "original should be moved to the template layout")
+				assert.True(t, keys.Match(replacedWith.ResizedKey), "resized should be moved to the template layout")
+				assert.ElementsMatch(t, []string{replacedWith.OriginalKey, replacedWith.ResizedKey}, bucket.Keys(), "old objects should be deleted")
+				return
+			}
+
+			// nothing is changed unless the keys are replaced in the db
+			expKeys := []string{img.OriginalKey}
+			if !tc.missing {
+				expKeys = append(expKeys, img.ResizedKey)
+			}
+			assert.ElementsMatch(t, expKeys, bucket.Keys(), "objects shouldn't be changed")
+		})
+	}
+}
+
+func TestKeyMigrator_Migrate_Batches(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		repo   = mocks.NewMockRepository(ctrl)
+		images = []models.Images{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	)
+
+	gomock.InOrder(
+		repo.EXPECT().GetImagesAfter(uuid.Nil, 2).Return(images[:2], nil),
+		repo.EXPECT().GetImagesAfter(images[1].ID, 2).Return(images[2:], nil),
+	)
+
+	report, err := NewKeyMigrator(log, repo, memory.NewStorage("http://localhost/files"), storage.KeyTemplate{}).Migrate(context.Background(), false, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Images)
+	assert.Equal(t, 3, report.Skipped, "images without objects should be skipped")
+}
+
+func boolToTimes(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
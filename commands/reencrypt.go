@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/encrypted"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+)
+
+func runReencrypt(args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report objects which are not encrypted by the current key without changing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	backends := findEncrypted(storage.Client)
+	if len(backends) == 0 {
+		return errors.New("objects aren't encrypted, EncryptionKeyID is not set")
+	}
+
+	var failed int
+	for _, s := range backends {
+		report, err := s.Reencrypt(context.Background(), *dryRun)
+		printReencryptReport(os.Stdout, report, *dryRun)
+		if err != nil {
+			return err
+		}
+		failed += report.Failed
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d objects are not re-encrypted", failed)
+	}
+	return nil
+}
+
+func printReencryptReport(w io.Writer, report encrypted.ReencryptReport, dryRun bool) {
+	verb := "re-encrypted"
+	if dryRun {
+		verb = "would re-encrypt"
+	}
+
+	for _, c := range report.Changes {
+		from := c.From
+		if from == "" {
+			from = "plain text"
+		}
+		fmt.Fprintf(w, "%s %s from %s\n", verb, c.Key, from)
+	}
+
+	fmt.Fprintf(w, "objects: %d, current: %d, re-wrapped: %d, encrypted: %d, failed: %d\n",
+		report.Objects, report.Current, report.Rewrapped, report.Encrypted, report.Failed)
+}
+
+// findEncrypted returns encrypted backends of the chain of decorators, including both tiers and both replicas
+func findEncrypted(s storage.Storage) []*encrypted.Storage {
+	var found []*encrypted.Storage
+
+	for ; s != nil; s = storage.Unwrap(s) {
+		if tiers, ok := s.(storage.Tiered); ok {
+			found = append(found, findEncrypted(tiers.Cold())...)
+		}
+		if replicas, ok := s.(*replicated.Storage); ok {
+			found = append(found, findEncrypted(replicas.Secondary())...)
+		}
+		if backend, ok := s.(*encrypted.Storage); ok {
+			found = append(found, backend)
+		}
+	}
+
+	return found
+}
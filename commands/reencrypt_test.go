@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/encrypted"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+	"github.com/Dimitriy14/image-resizing/storage/resilient"
+	"github.com/Dimitriy14/image-resizing/storage/tiered"
+)
+
+func TestFindEncrypted(t *testing.T) {
+	log := logger.NewMokLogger()
+
+	keys, err := encrypted.NewKeyring("key", map[string]string{"key": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))})
+	if err != nil {
+		t.Fatalf("cannot create keyring: %s", err)
+	}
+
+	newBackend := func() *encrypted.Storage {
+		return encrypted.NewStorage(log, memory.NewStorage("http://localhost/files"), keys)
+	}
+
+	var (
+		primary   = newBackend()
+		secondary = newBackend()
+		cold      = newBackend()
+	)
+
+	replicas, err := replicated.NewStorage(log, resilient.NewStorage(log, primary, nil), resilient.NewStorage(log, secondary, nil), replicated.ModeSync)
+	if err != nil {
+		t.Fatalf("cannot create replicated storage: %s", err)
+	}
+
+	testCases := []struct {
+		name     string
+		storage  storage.Storage
+		expFound []*encrypted.Storage
+	}{
+		{
+			name:    "Plain case",
+			storage: memory.NewStorage("http://localhost/files"),
+		},
+		{
+			name:     "Single backend case",
+			storage:  resilient.NewStorage(log, primary, nil),
+			expFound: []*encrypted.Storage{primary},
+		},
+		{
+			name:     "Replicated tiers case",
+			storage:  tiered.NewStorage(replicas, resilient.NewStorage(log, cold, nil)),
+			expFound: []*encrypted.Storage{primary, secondary, cold},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ElementsMatch(t, tc.expFound, findEncrypted(tc.storage))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+)
+
+func runRepairReplicas(args []string) error {
+	flags := flag.NewFlagSet("repair-replicas", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report missing objects without copying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	replicas, ok := replicated.Find(storage.Client)
+	if !ok {
+		return errors.New("objects aren't replicated, StorageSecondaryBackend is not set")
+	}
+
+	report, err := replicas.Repair(context.Background(), *dryRun)
+	printRepairReport(os.Stdout, report, *dryRun)
+	return err
+}
+
+func printRepairReport(w io.Writer, report replicated.RepairReport, dryRun bool) {
+	verb := "copied"
+	if dryRun {
+		verb = "would copy"
+	}
+
+	for _, c := range report.Copies {
+		fmt.Fprintf(w, "%s %s from %s to %s\n", verb, c.Key, c.From, c.To)
+	}
+
+	fmt.Fprintf(w, "objects: %d, missing: %d, copied: %d, failed: %d\n",
+		report.Objects, report.Missing, report.Copied, report.Failed)
+}
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/scrub"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+func runScrub(args []string) error {
+	flags := flag.NewFlagSet("scrub", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	scrubber := scrub.NewScrubber(logger.Log, repository.NewRepository(postgres.Client), storage.Client)
+
+	report, err := scrubber.Scrub(context.Background())
+	printScrubReport(os.Stdout, report)
+	if err != nil {
+		return err
+	}
+
+	if problems := len(report.Missing) + len(report.Corrupted); problems > 0 {
+		return fmt.Errorf("%d objects are missing or corrupted", problems)
+	}
+	return nil
+}
+
+// problemBackend names the backend of the problem when the storage has several ones
+func problemBackend(p scrub.Problem) string {
+	if p.Backend == "" {
+		return ""
+	}
+	return " in " + p.Backend
+}
+
+func printScrubReport(w io.Writer, report scrub.Report) {
+	for _, p := range report.Missing {
+		fmt.Fprintf(w, "missing %s %s of image %s%s\n", p.Variant, p.Key, p.ImageID, problemBackend(p))
+	}
+	for _, p := range report.Corrupted {
+		fmt.Fprintf(w, "corrupted %s %s of image %s%s\n", p.Variant, p.Key, p.ImageID, problemBackend(p))
+	}
+
+	fmt.Fprintf(w, "images: %d, objects: %d, verified: %d, unverified: %d, missing: %d, corrupted: %d, failed: %d\n",
+		report.Images, report.Objects, report.Verified, report.Unverified, len(report.Missing), len(report.Corrupted), report.Failed)
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/tiering"
+)
+
+func runTierOriginals(args []string) error {
+	flags := flag.NewFlagSet("tier-originals", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report planned moves without moving originals")
+	coldAfter := flags.Duration("cold-after", 0, "time since the last read after which originals are moved, TieringColdAfterDays is used when it is zero")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	tiers, ok := storage.AsTiered(storage.Client)
+	if !ok {
+		return errors.New("storage isn't tiered, StorageColdBackend is not set")
+	}
+
+	mover := tiering.NewMover(logger.Log, repository.NewRepository(postgres.Client), tiers)
+	if *coldAfter > 0 {
+		mover.SetColdAfter(*coldAfter)
+	}
+
+	report, err := mover.Move(context.Background(), *dryRun)
+	printTieringReport(os.Stdout, report, *dryRun)
+	return err
+}
+
+func printTieringReport(w io.Writer, report tiering.Report, dryRun bool) {
+	verb := "move"
+	if dryRun {
+		verb = "would move"
+	}
+
+	for _, move := range report.Moves {
+		fmt.Fprintf(w, "%s %s of image %s to %s tier\n", verb, move.Key, move.ImageID, move.To)
+	}
+
+	fmt.Fprintf(w, "images: %d, moved: %d, restored: %d, failed: %d\n",
+		report.Images, report.Moved, report.Restored, report.Failed)
+}
@@ -0,0 +1,123 @@
+// Package compensations retries compensating actions which failed when an operation was rolled back
+package compensations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	defaultRetryInterval = time.Minute
+	batchSize            = 100
+	maxBackoff           = 24 * time.Hour
+)
+
+// Retrier retries recorded compensations with exponential backoff
+type Retrier struct {
+	log      logger.Logger
+	repo     repository.CompensationRepository
+	objects  repository.ObjectRepository
+	bucket   storage.Storage
+	interval time.Duration
+}
+
+// NewRetrier creates retrier, it doesn't retry anything until Start is called.
+// Objects are deleted only when their keys aren't referenced, since keys could be used again
+func NewRetrier(log logger.Logger, repo repository.CompensationRepository, objects repository.ObjectRepository, bucket storage.Storage) *Retrier {
+	r := &Retrier{
+		log:      log,
+		repo:     repo,
+		objects:  objects,
+		bucket:   bucket,
+		interval: time.Duration(config.Conf.CompensationRetryIntervalSec) * time.Second,
+	}
+
+	if r.interval <= 0 {
+		r.interval = defaultRetryInterval
+	}
+
+	return r
+}
+
+// Start retries due compensations in background
+func (r *Retrier) Start() {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			r.Flush()
+		}
+	}()
+}
+
+// Flush retries all due compensations
+func (r *Retrier) Flush() {
+	for {
+		retried, err := r.repo.RetryCompensations(batchSize, r.run, r.backoff)
+		if err != nil {
+			r.log.Errorf("cannot retry compensations due to: %s", err)
+			return
+		}
+
+		if retried < batchSize {
+			return
+		}
+	}
+}
+
+func (r *Retrier) run(c models.Compensation) error {
+	var err error
+
+	switch c.Action {
+	case models.CompensationDeleteObject:
+		err = r.deleteReleased(c.Key)
+	case models.CompensationPurgeObject:
+		err = storage.Purge(context.Background(), r.bucket, c.Key)
+	default:
+		err = fmt.Errorf("unknown compensation action %q", c.Action)
+	}
+
+	if err != nil {
+		r.log.Errorf("compensation %s of %q failed after %d attempts: %s", c.Action, c.Key, c.Attempts, err)
+		return err
+	}
+
+	r.log.Debugf("Compensation %s of %q succeeded after %d attempts", c.Action, c.Key, c.Attempts)
+	return nil
+}
+
+// backoff doubles the retry interval with every attempt
+func (r *Retrier) backoff(attempts int) time.Duration {
+	backoff := r.interval
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// deleteReleased deletes the object unless its key is referenced again, e.g. by the image
+// resized back to the size of the replaced object
+func (r *Retrier) deleteReleased(key string) error {
+	referenced, err := r.objects.ReferencedKeys([]string{key})
+	if err != nil {
+		return fmt.Errorf("cannot check references: %s", err)
+	}
+	if referenced[key] {
+		r.log.Debugf("Object %q is referenced again, so it is kept", key)
+		return nil
+	}
+
+	return r.bucket.Delete(context.Background(), key)
+}
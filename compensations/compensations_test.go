@@ -0,0 +1,164 @@
+package compensations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/cdn"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+)
+
+func TestRetrier_Flush(t *testing.T) {
+	testCases := []struct {
+		name       string
+		action     models.CompensationAction
+		deleteErr  error
+		referenced bool
+		refsErr    error
+		purgeErr   error
+		expErr     bool
+		expKeys    []string
+		expPurged  []string
+	}{
+		{
+			name:    "Good case",
+			action:  models.CompensationDeleteObject,
+			expKeys: []string{"pictures/kept.png"},
+		},
+		{
+			name:      "Deleting error case",
+			action:    models.CompensationDeleteObject,
+			deleteErr: errors.New("DELETE ERROR"),
+			expErr:    true,
+			expKeys:   []string{"pictures/kept.png", "pictures/orphan.png"},
+		},
+		{
+			name:       "Referenced again case",
+			action:     models.CompensationDeleteObject,
+			referenced: true,
+			expKeys:    []string{"pictures/kept.png", "pictures/orphan.png"},
+		},
+		{
+			name:    "Checking references error case",
+			action:  models.CompensationDeleteObject,
+			refsErr: errors.New("DB ERROR"),
+			expErr:  true,
+			expKeys: []string{"pictures/kept.png", "pictures/orphan.png"},
+		},
+		{
+			name:      "Purge case",
+			action:    models.CompensationPurgeObject,
+			expKeys:   []string{"pictures/kept.png", "pictures/orphan.png"},
+			expPurged: []string{"https://cdn.example.com/pictures/orphan.png"},
+		},
+		{
+			name:      "Purging error case",
+			action:    models.CompensationPurgeObject,
+			purgeErr:  errors.New("PURGE ERROR"),
+			expErr:    true,
+			expKeys:   []string{"pictures/kept.png", "pictures/orphan.png"},
+			expPurged: []string{"https://cdn.example.com/pictures/orphan.png"},
+		},
+		{
+			name:    "Unknown action case",
+			action:  "unknown",
+			expErr:  true,
+			expKeys: []string{"pictures/kept.png", "pictures/orphan.png"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				ctx    = context.Background()
+				repo   = mocks.NewMockCompensationRepository(ctrl)
+				bucket = memory.NewStorage("http://localhost/files")
+			)
+
+			for _, key := range []string{"pictures/kept.png", "pictures/orphan.png"} {
+				assert.NoError(t, bucket.Put(ctx, key, bytes.NewBufferString("content"), storage.PutOptions{Size: -1}))
+			}
+			bucket.InjectFailure(func(op memory.Op, key string) error {
+				if op == memory.OpDelete {
+					return tc.deleteErr
+				}
+				return nil
+			})
+
+			repo.EXPECT().RetryCompensations(batchSize, gomock.Any(), gomock.Any()).DoAndReturn(
+				func(limit int, run func(models.Compensation) error, backoff func(int) time.Duration) (int, error) {
+					err := run(models.Compensation{ID: uuid.New(), Action: tc.action, Key: "pictures/orphan.png", Attempts: 1})
+					assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+					return 1, nil
+				})
+
+			links, err := cdn.NewURLBuilder([]string{"https://cdn.example.com"}, "", 0)
+			assert.NoError(t, err)
+			purger := &recordingPurger{err: tc.purgeErr}
+
+			objects := mocks.NewMockObjectRepository(ctrl)
+			objects.EXPECT().ReferencedKeys([]string{"pictures/orphan.png"}).
+				Return(map[string]bool{"pictures/orphan.png": tc.referenced}, tc.refsErr).AnyTimes()
+
+			NewRetrier(logger.NewMokLogger(), repo, objects, cdn.NewStorage(bucket, links, purger)).Flush()
+
+			assert.ElementsMatch(t, tc.expKeys, bucket.Keys())
+			assert.Equal(t, tc.expPurged, purger.links)
+		})
+	}
+}
+
+type recordingPurger struct {
+	links []string
+	err   error
+}
+
+func (p *recordingPurger) Purge(_ context.Context, links []string) error {
+	p.links = append(p.links, links...)
+	return p.err
+}
+
+func TestRetrier_Flush_Batches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	repo := mocks.NewMockCompensationRepository(ctrl)
+	gomock.InOrder(
+		repo.EXPECT().RetryCompensations(batchSize, gomock.Any(), gomock.Any()).Return(batchSize, nil),
+		repo.EXPECT().RetryCompensations(batchSize, gomock.Any(), gomock.Any()).Return(0, errors.New("DB ERROR")),
+	)
+
+	NewRetrier(logger.NewMokLogger(), repo, mocks.NewMockObjectRepository(ctrl), memory.NewStorage("http://localhost/files")).Flush()
+}
+
+func TestRetrier_backoff(t *testing.T) {
+	r := &Retrier{interval: time.Minute}
+
+	testCases := []struct {
+		attempts int
+		exp      time.Duration
+	}{
+		{attempts: 1, exp: time.Minute},
+		{attempts: 2, exp: 2 * time.Minute},
+		{attempts: 5, exp: 16 * time.Minute},
+		{attempts: 100, exp: maxBackoff},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.exp, r.backoff(tc.attempts), "unexpected backoff of %d attempts", tc.attempts)
+	}
+}
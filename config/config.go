@@ -27,6 +27,47 @@ type Configuration struct {
 	PostgresUser     string `json:"PostgresUser"     default:"app"`
 	PostgresPassword string `json:"PostgresPassword" default:"1337"`
 
+	StorageBackend     string `json:"StorageBackend"     default:"aws" envconfig:"StorageBackend"`
+	StorageRoot        string `json:"StorageRoot"        default:"data"`
+	StorageURL         string `json:"StorageURL"`
+	StorageKeyTemplate string `json:"StorageKeyTemplate" default:"{user}/{yyyy}/{mm}/{imageID}/{variant}.{ext}"`
+
+	StorageResizedCacheControl string `json:"StorageResizedCacheControl" default:"public, max-age=31536000, immutable"`
+
+	StorageSecondaryBackend string `json:"StorageSecondaryBackend"`
+	StorageSecondaryRoot    string `json:"StorageSecondaryRoot"    default:"data-secondary"`
+	StorageSecondaryURL     string `json:"StorageSecondaryURL"`
+	StorageReplication      string `json:"StorageReplication"      default:"async"`
+
+	StorageColdBackend   string `json:"StorageColdBackend"`
+	StorageColdRoot      string `json:"StorageColdRoot"      default:"data-cold"`
+	StorageColdURL       string `json:"StorageColdURL"`
+	TieringColdAfterDays int    `json:"TieringColdAfterDays" default:"30"`
+	TieringIntervalMin   int    `json:"TieringIntervalMin"   default:"1440"`
+
+	CDNHosts           string `json:"CDNHosts"`
+	CDNSigningKey      string `json:"-"                  envconfig:"CDN_SIGNING_KEY"`
+	CDNLinkTTLSec      int    `json:"CDNLinkTTLSec"      default:"3600"`
+	CDNPurgeURL        string `json:"CDNPurgeURL"`
+	CDNPurgeToken      string `json:"-"                  envconfig:"CDN_PURGE_TOKEN"`
+	CDNPurgeTimeoutSec int    `json:"CDNPurgeTimeoutSec" default:"10"`
+
+	EncryptionKeyID    string `json:"EncryptionKeyID"`
+	EncryptionKeysFile string `json:"EncryptionKeysFile"`
+	EncryptionKeys     string `json:"-"                  envconfig:"ENCRYPTION_KEYS"`
+
+	StorageTimeoutSec        int `json:"StorageTimeoutSec"        default:"30"`
+	StorageRetryAttempts     int `json:"StorageRetryAttempts"     default:"3"`
+	StorageRetryBackoffMS    int `json:"StorageRetryBackoffMS"    default:"100"`
+	StorageRetryMaxBackoffMS int `json:"StorageRetryMaxBackoffMS" default:"2000"`
+	StorageBreakerThreshold  int `json:"StorageBreakerThreshold"  default:"5"`
+	StorageBreakerOpenSec    int `json:"StorageBreakerOpenSec"    default:"30"`
+
+	GCIntervalMin      int `json:"GCIntervalMin"      default:"1440"`
+	GCGracePeriodHours int `json:"GCGracePeriodHours" default:"24"`
+
+	CompensationRetryIntervalSec int `json:"CompensationRetryIntervalSec" default:"60"`
+
 	AWSID     string `json:"-"     envconfig:"AWS_ACCESS_KEY_ID"`
 	AWSSecret string `json:"-"     envconfig:"AWS_SECRET_ACCESS_KEY"`
 
@@ -34,7 +75,48 @@ type Configuration struct {
 	AWSBucket               string `json:"AWSBucket"               default:"resized-images-yal"`
 	AWSACL                  string `json:"AWSACL"                  default:"public-read"`
 	AWSServerSideEncryption string `json:"AWSServerSideEncryption" default:"AES256"`
-	AWSImageStorageURL      string `json:"AWSImageStorageURL"      default:"https://resized-images-yal.s3.eu-central-1.amazonaws.com"`
+	AWSImageStorageURL      string `json:"AWSImageStorageURL"`
+	AWSEndpoint             string `json:"AWSEndpoint"             envconfig:"AWSEndpoint"`
+	AWSPathStyle            bool   `json:"AWSPathStyle"`
+	AWSInsecureSkipVerify   bool   `json:"AWSInsecureSkipVerify"`
+	AWSProfile              string `json:"AWSProfile"`
+	AWSPrivate              bool   `json:"AWSPrivate"`
+	AWSPresignTTLSec        int    `json:"AWSPresignTTLSec"        default:"900"`
+	AWSSecondaryBucket      string `json:"AWSSecondaryBucket"`
+	AWSColdBucket           string `json:"AWSColdBucket"`
+	AWSColdStorageClass     string `json:"AWSColdStorageClass"     default:"STANDARD_IA"`
+	AWSObjectTagging        bool   `json:"AWSObjectTagging"`
+
+	WebhookWorkers          int      `json:"WebhookWorkers"          default:"4"`
+	WebhookMaxAttempts      int      `json:"WebhookMaxAttempts"      default:"5"`
+	WebhookInitialBackoffMS int      `json:"WebhookInitialBackoffMS" default:"1000"`
+	WebhookTimeoutSec       int      `json:"WebhookTimeoutSec"       default:"10"`
+	WebhookAllowedCIDRs     []string `json:"WebhookAllowedCIDRs"`
+
+	SSEBufferSize      int `json:"SSEBufferSize"      default:"100"`
+	SSEKeepAliveSec    int `json:"SSEKeepAliveSec"    default:"15"`
+	SSEReplayWindowSec int `json:"SSEReplayWindowSec" default:"300"`
+
+	EventsBroker         string `json:"EventsBroker"         default:"memory"`
+	EventsChannel        string `json:"EventsChannel"        default:"resizer_events"`
+	NATSURL              string `json:"NATSURL"              default:"nats://localhost:4222"`
+	OutboxPollIntervalMS int    `json:"OutboxPollIntervalMS" default:"500"`
+	OutboxBatchSize      int    `json:"OutboxBatchSize"      default:"100"`
+
+	RemoteFetchMaxSize      int      `json:"RemoteFetchMaxSize"      default:"10485760"`
+	RemoteFetchTimeoutSec   int      `json:"RemoteFetchTimeoutSec"   default:"10"`
+	RemoteFetchMaxRedirects int      `json:"RemoteFetchMaxRedirects" default:"3"`
+	RemoteFetchAllowedCIDRs []string `json:"RemoteFetchAllowedCIDRs"`
+
+	BatchConcurrency int `json:"BatchConcurrency" default:"4"`
+	BatchMaxItems    int `json:"BatchMaxItems"    default:"100"`
+
+	UploadURLTTLSec int `json:"UploadURLTTLSec" default:"900"`
+	UploadMaxSize   int `json:"UploadMaxSize"   default:"52428800"`
+
+	ExportWorkers    int    `json:"ExportWorkers"    default:"1"`
+	ExportLinkTTLSec int    `json:"ExportLinkTTLSec" default:"3600"`
+	ExportSigningKey string `json:"-"                envconfig:"EXPORT_SIGNING_KEY"`
 
 	LogFile  string `json:"LogFile"`
 	LogLevel string `json:"LogLevel"                 default:"debug"`
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+const (
+	// BrokerMemory delivers events only inside the current process
+	BrokerMemory = "memory"
+	// BrokerNATS delivers events through NATS subject
+	BrokerNATS = "nats"
+	// BrokerPostgres delivers events through Postgres LISTEN/NOTIFY channel
+	BrokerPostgres = "postgres"
+)
+
+// Client is a publisher chosen by EventsBroker setting
+var Client Publisher
+
+// Handler receives published events, it shouldn't block
+type Handler func(event models.Event)
+
+//go:generate mockgen -destination=../mocks/mock-publisher.go -mock_names=Publisher=MockPublisher -package=mocks github.com/Dimitriy14/image-resizing/events Publisher
+type Publisher interface {
+	Publish(event models.Event) error
+	Subscribe(handler Handler) (unsubscribe func(), err error)
+	Close() error
+}
+
+// envelope is a representation of event sent through brokers, unlike API it contains user id
+type envelope struct {
+	UserID uuid.UUID    `json:"user_id"`
+	Event  models.Event `json:"event"`
+}
+
+// Encode marshals event for sending it through broker
+func Encode(event models.Event) ([]byte, error) {
+	return json.Marshal(envelope{UserID: event.UserID, Event: event})
+}
+
+// Decode unmarshals event received from broker
+func Decode(data []byte) (models.Event, error) {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return models.Event{}, err
+	}
+
+	e.Event.UserID = e.UserID
+	return e.Event, nil
+}
@@ -0,0 +1,99 @@
+package events_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/events"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	event := models.NewEvent(models.EventImageCreated, uuid.New(), models.Images{ID: uuid.New()})
+
+	data, err := events.Encode(event)
+	if err != nil {
+		t.Fatalf("cannot encode event: %s", err)
+	}
+
+	decoded, err := events.Decode(data)
+	if err != nil {
+		t.Fatalf("cannot decode event: %s", err)
+	}
+
+	assert.Equal(t, event.ID, decoded.ID)
+	assert.Equal(t, event.UserID, decoded.UserID, "user id should be sent through broker")
+	assert.Equal(t, event.Type, decoded.Type)
+	assert.JSONEq(t, string(event.Data), string(decoded.Data))
+
+	_, err = events.Decode([]byte("invalid"))
+	assert.Error(t, err)
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	var (
+		p        = events.NewMemoryPublisher()
+		first    []models.Event
+		second   []models.Event
+		event    = models.NewEvent(models.EventImageDeleted, uuid.New(), nil)
+		anyEvent = models.NewEvent(models.EventImageResized, uuid.New(), nil)
+	)
+
+	unsubscribe, err := p.Subscribe(func(e models.Event) { first = append(first, e) })
+	assert.NoError(t, err)
+	_, err = p.Subscribe(func(e models.Event) { second = append(second, e) })
+	assert.NoError(t, err)
+
+	assert.NoError(t, p.Publish(event))
+	unsubscribe()
+	assert.NoError(t, p.Publish(anyEvent))
+
+	assert.Equal(t, []models.Event{event}, first, "unsubscribed handler shouldn't receive events")
+	assert.Equal(t, []models.Event{event, anyEvent}, second)
+	assert.NoError(t, p.Close())
+}
+
+func TestRelay_Flush(t *testing.T) {
+	config.Conf.OutboxBatchSize = 2
+
+	testCases := []struct {
+		name     string
+		batches  []int
+		batchErr error
+	}{
+		{
+			name:    "Single batch case",
+			batches: []int{1},
+		},
+		{
+			name:    "Several batches case",
+			batches: []int{2, 2, 0},
+		},
+		{
+			name:     "Publishing error case",
+			batches:  []int{2},
+			batchErr: errors.New("ERROR"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repo := mocks.NewMockOutboxRepository(ctrl)
+			for _, published := range tc.batches {
+				repo.EXPECT().PublishEvents(2, gomock.Any()).Return(published, tc.batchErr)
+			}
+
+			events.NewRelay(logger.NewMokLogger(), repo, events.NewMemoryPublisher()).Flush()
+		})
+	}
+}
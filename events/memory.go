@@ -0,0 +1,47 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+// NewMemoryPublisher creates publisher which synchronously calls handlers of the current process
+func NewMemoryPublisher() Publisher {
+	return &memoryPublisher{handlers: make(map[int]Handler)}
+}
+
+type memoryPublisher struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]Handler
+}
+
+func (p *memoryPublisher) Publish(event models.Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, handler := range p.handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (p *memoryPublisher) Subscribe(handler Handler) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextID
+	p.nextID++
+	p.handlers[id] = handler
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.handlers, id)
+	}, nil
+}
+
+func (p *memoryPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package nats
+
+import (
+	"github.com/nats-io/nats.go"
+
+	"github.com/Dimitriy14/image-resizing/events"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+// NewPublisher connects to NATS server, every event is published to the subject
+func NewPublisher(log logger.Logger, url, subject string) (events.Publisher, error) {
+	conn, err := nats.Connect(url, nats.Name("image-resizer"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &publisherImpl{
+		log:     log,
+		conn:    conn,
+		subject: subject,
+	}, nil
+}
+
+type publisherImpl struct {
+	log     logger.Logger
+	conn    *nats.Conn
+	subject string
+}
+
+func (p *publisherImpl) Publish(event models.Event) error {
+	data, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	if err = p.conn.Publish(p.subject, data); err != nil {
+		return err
+	}
+
+	// outbox marks event as published only when the server has received it
+	return p.conn.Flush()
+}
+
+func (p *publisherImpl) Subscribe(handler events.Handler) (func(), error) {
+	sub, err := p.conn.Subscribe(p.subject, func(msg *nats.Msg) {
+		event, err := events.Decode(msg.Data)
+		if err != nil {
+			p.log.Errorf("cannot decode event from NATS subject %s due to: %s", p.subject, err)
+			return
+		}
+		handler(event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := sub.Unsubscribe(); err != nil {
+			p.log.Errorf("cannot unsubscribe from NATS subject %s due to: %s", p.subject, err)
+		}
+	}, nil
+}
+
+func (p *publisherImpl) Close() error {
+	p.conn.Close()
+	return nil
+}
@@ -0,0 +1,103 @@
+package pgnotify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/events"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+const (
+	minReconnectInterval = 100 * time.Millisecond
+	maxReconnectInterval = time.Minute
+)
+
+// NewPublisher creates publisher which sends events with NOTIFY and receives them with LISTEN on the channel
+func NewPublisher(log logger.Logger, client *postgres.PGClient, connInfo, channel string) (events.Publisher, error) {
+	p := &publisherImpl{
+		log:      log,
+		client:   client,
+		channel:  channel,
+		handlers: make(map[int]events.Handler),
+	}
+
+	p.listener = pq.NewListener(connInfo, minReconnectInterval, maxReconnectInterval, p.onListenerEvent)
+	if err := p.listener.Listen(channel); err != nil {
+		p.listener.Close()
+		return nil, err
+	}
+
+	go p.receive()
+	return p, nil
+}
+
+type publisherImpl struct {
+	log      logger.Logger
+	client   *postgres.PGClient
+	listener *pq.Listener
+	channel  string
+
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]events.Handler
+}
+
+func (p *publisherImpl) Publish(event models.Event) error {
+	data, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	return p.client.Session.Exec("SELECT pg_notify(?, ?)", p.channel, string(data)).Error
+}
+
+func (p *publisherImpl) Subscribe(handler events.Handler) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextID
+	p.nextID++
+	p.handlers[id] = handler
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.handlers, id)
+	}, nil
+}
+
+func (p *publisherImpl) Close() error {
+	return p.listener.Close()
+}
+
+func (p *publisherImpl) receive() {
+	for notification := range p.listener.Notify {
+		// nil notification is sent after reconnect, events sent meanwhile are lost
+		if notification == nil {
+			continue
+		}
+
+		event, err := events.Decode([]byte(notification.Extra))
+		if err != nil {
+			p.log.Errorf("cannot decode event from channel %s due to: %s", p.channel, err)
+			continue
+		}
+
+		p.mu.RLock()
+		for _, handler := range p.handlers {
+			handler(event)
+		}
+		p.mu.RUnlock()
+	}
+}
+
+func (p *publisherImpl) onListenerEvent(event pq.ListenerEventType, err error) {
+	if err != nil {
+		p.log.Errorf("postgres listener of channel %s got an error: %s", p.channel, err)
+	}
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/repository"
+)
+
+const (
+	defaultPollInterval = 500 * time.Millisecond
+	defaultBatchSize    = 100
+)
+
+// Relay publishes events from the transactional outbox
+type Relay struct {
+	log          logger.Logger
+	repo         repository.OutboxRepository
+	publisher    Publisher
+	pollInterval time.Duration
+	batchSize    int
+}
+
+// NewRelay creates relay, it doesn't publish anything until Start is called
+func NewRelay(log logger.Logger, repo repository.OutboxRepository, publisher Publisher) *Relay {
+	r := &Relay{
+		log:          log,
+		repo:         repo,
+		publisher:    publisher,
+		pollInterval: time.Duration(config.Conf.OutboxPollIntervalMS) * time.Millisecond,
+		batchSize:    config.Conf.OutboxBatchSize,
+	}
+
+	if r.pollInterval <= 0 {
+		r.pollInterval = defaultPollInterval
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultBatchSize
+	}
+
+	return r
+}
+
+// Start polls the outbox in background
+func (r *Relay) Start() {
+	go func() {
+		ticker := time.NewTicker(r.pollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			r.Flush()
+		}
+	}()
+}
+
+// Flush publishes all events which are waiting in the outbox
+func (r *Relay) Flush() {
+	for {
+		published, err := r.repo.PublishEvents(r.batchSize, r.publisher.Publish)
+		if err != nil {
+			r.log.Errorf("cannot publish events from outbox due to: %s", err)
+			return
+		}
+
+		if published < r.batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package exports
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	// ManifestName is the name of archive entry which contains metadata of exported images
+	ManifestName = "manifest.json"
+
+	archiveExt     = ".zip"
+	contentType    = "application/zip"
+	queueSize      = 128
+	defaultWorkers = 1
+	// staleAfter is the time after which running export is considered to be abandoned by crashed instance
+	staleAfter = time.Hour
+)
+
+//go:generate mockgen -destination=../mocks/mock-exporter.go -mock_names=Exporter=MockExporter -package=mocks github.com/Dimitriy14/image-resizing/exports Exporter
+
+// Exporter builds archives of users images in background
+type Exporter interface {
+	Start()
+	Enqueue(export models.Export)
+}
+
+// ManifestEntry describes exported image and the names of its files inside of the archive
+type ManifestEntry struct {
+	models.Images
+	OriginalFile string `json:"original_file,omitempty"`
+	ResizedFile  string `json:"resized_file,omitempty"`
+}
+
+// NewExporter creates new exporter, it doesn't build anything until Start is called
+func NewExporter(log logger.Logger, repo repository.ExportRepository, images repository.Repository, bucket storage.Storage) Exporter {
+	e := &exporterImpl{
+		log:     log,
+		repo:    repo,
+		images:  images,
+		bucket:  bucket,
+		queue:   make(chan models.Export, queueSize),
+		workers: config.Conf.ExportWorkers,
+	}
+
+	if e.workers <= 0 {
+		e.workers = defaultWorkers
+	}
+
+	return e
+}
+
+type exporterImpl struct {
+	log     logger.Logger
+	repo    repository.ExportRepository
+	images  repository.Repository
+	bucket  storage.Storage
+	queue   chan models.Export
+	workers int
+}
+
+// Start runs background workers and resumes exports which were not finished before restart
+func (e *exporterImpl) Start() {
+	for i := 0; i < e.workers; i++ {
+		go e.work()
+	}
+
+	go e.resumePending()
+}
+
+// Enqueue queues an export without blocking the caller, the export stays pending when the queue is full
+func (e *exporterImpl) Enqueue(export models.Export) {
+	select {
+	case e.queue <- export:
+	default:
+		e.log.Errorf("export queue is full, export %s stays pending", export.ID)
+	}
+}
+
+func (e *exporterImpl) resumePending() {
+	exports, err := e.repo.GetPendingExports(time.Now().Add(-staleAfter))
+	if err != nil {
+		e.log.Errorf("cannot retrieve pending exports due to: %s", err)
+		return
+	}
+
+	for _, export := range exports {
+		e.Enqueue(export)
+	}
+}
+
+func (e *exporterImpl) work() {
+	for export := range e.queue {
+		e.run(export)
+	}
+}
+
+func (e *exporterImpl) run(export models.Export) {
+	// every instance resumes pending exports, only one of them builds the archive
+	claimed, err := e.repo.ClaimExport(export.ID, time.Now().Add(-staleAfter))
+	if err != nil {
+		e.log.Errorf("cannot claim export %s due to: %s", export.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	e.log.Debugf("Started building export %s for user %q", export.ID, export.UserID)
+
+	export.Archive, export.Images, err = e.build(export)
+	if err != nil {
+		e.log.Errorf("cannot build export %s due to: %s", export.ID, err)
+		export.Status = models.ExportFailed
+		export.Error = err.Error()
+	} else {
+		export.Status = models.ExportCompleted
+		export.Error = ""
+	}
+
+	if _, err = e.repo.SaveExport(export); err != nil {
+		e.log.Errorf("cannot save export %s due to: %s", export.ID, err)
+		return
+	}
+
+	e.log.Debugf("Finished export %s for user %q with status %s", export.ID, export.UserID, export.Status)
+}
+
+// build streams files of every image into a temporary archive, so memory use doesn't depend
+// on the size of the library, and stores the archive, it returns the key of the archive
+func (e *exporterImpl) build(export models.Export) (string, int, error) {
+	ctx := context.Background()
+
+	images, err := e.images.GetAllImages(export.UserID)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot retrieve images: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile("", "export-*"+archiveExt)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot create temporary archive: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var (
+		zw       = zip.NewWriter(tmp)
+		manifest = make([]ManifestEntry, 0, len(images))
+	)
+
+	for _, img := range images {
+		entry := ManifestEntry{Images: img}
+
+		if export.Includes(models.ExportOriginals) && img.OriginalKey != "" {
+			entry.OriginalFile = fileName(models.ExportOriginals, img, img.OriginalKey)
+			if err = e.addFile(ctx, zw, entry.OriginalFile, img.OriginalKey, img.OriginalSHA256); err != nil {
+				return "", 0, err
+			}
+		}
+
+		if export.Includes(models.ExportResized) && img.ResizedKey != "" {
+			entry.ResizedFile = fileName(models.ExportResized, img, img.ResizedKey)
+			if err = e.addFile(ctx, zw, entry.ResizedFile, img.ResizedKey, img.ResizedSHA256); err != nil {
+				return "", 0, err
+			}
+		}
+
+		manifest = append(manifest, entry)
+	}
+
+	w, err := zw.Create(ManifestName)
+	if err != nil {
+		return "", 0, err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(manifest); err != nil {
+		return "", 0, fmt.Errorf("cannot write manifest: %s", err)
+	}
+
+	if err = zw.Close(); err != nil {
+		return "", 0, fmt.Errorf("cannot finish archive: %s", err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	key := storage.Keys.Key(storage.KeyParams{
+		UserID:  export.UserID,
+		ImageID: export.ID,
+		Variant: storage.VariantExport,
+		Ext:     archiveExt,
+	})
+	if err = e.bucket.Put(ctx, key, tmp, storage.PutOptions{ContentType: contentType, Size: size, Private: true}); err != nil {
+		return "", 0, fmt.Errorf("cannot store archive: %s", err)
+	}
+
+	return key, len(images), nil
+}
+
+// addFile copies the object to the archive, it is verified by the checksum of the image,
+// so the export fails instead of handing over a corrupted object
+func (e *exporterImpl) addFile(ctx context.Context, zw *zip.Writer, name, key, checksum string) error {
+	content, err := e.bucket.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("cannot download %s: %s", key, err)
+	}
+	defer content.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(w, storage.Verify(content, checksum)); err != nil {
+		return fmt.Errorf("cannot download %s: %s", key, err)
+	}
+
+	return nil
+}
+
+func fileName(content models.ExportContent, img models.Images, key string) string {
+	return path.Join(string(content), img.ID.String()+path.Ext(key))
+}
@@ -0,0 +1,197 @@
+package exports
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+func TestExporterImpl_Run(t *testing.T) {
+	var (
+		userID = uuid.New()
+		first  = models.Images{ID: uuid.New(), OriginalKey: "pictures/1.png", ResizedKey: "pictures/2.png"}
+		second = models.Images{ID: uuid.New(), OriginalKey: "pictures/3.jpg", ResizedKey: "pictures/4.jpg"}
+	)
+
+	// objects contain their keys
+	first.OriginalSHA256, first.ResizedSHA256 = storage.Checksum([]byte(first.OriginalKey)), storage.Checksum([]byte(first.ResizedKey))
+	second.OriginalSHA256 = storage.Checksum([]byte(second.OriginalKey))
+
+	testCases := []struct {
+		name        string
+		include     []string
+		claimed     bool
+		downloadErr error
+		corrupted   string
+		expStatus   models.ExportStatus
+		expErr      string
+		expFiles    []string
+	}{
+		{
+			name:      "Originals and resized case",
+			include:   []string{string(models.ExportOriginals), string(models.ExportResized)},
+			claimed:   true,
+			expStatus: models.ExportCompleted,
+			expFiles: []string{
+				"originals/" + first.ID.String() + ".png",
+				"resized/" + first.ID.String() + ".png",
+				"originals/" + second.ID.String() + ".jpg",
+				"resized/" + second.ID.String() + ".jpg",
+				ManifestName,
+			},
+		},
+		{
+			name:      "Only resized case",
+			include:   []string{string(models.ExportResized)},
+			claimed:   true,
+			expStatus: models.ExportCompleted,
+			expFiles: []string{
+				"resized/" + first.ID.String() + ".png",
+				"resized/" + second.ID.String() + ".jpg",
+				ManifestName,
+			},
+		},
+		{
+			name:        "Download error case",
+			include:     []string{string(models.ExportOriginals)},
+			claimed:     true,
+			downloadErr: errors.New("DOWNLOAD ERROR"),
+			expStatus:   models.ExportFailed,
+		},
+		{
+			name:      "Corrupted object case",
+			include:   []string{string(models.ExportResized)},
+			claimed:   true,
+			corrupted: first.ResizedKey,
+			expStatus: models.ExportFailed,
+			expErr:    "cannot download pictures/2.png: " + storage.ErrChecksumMismatch.Error(),
+		},
+		{
+			name:    "Claimed by another instance case",
+			include: []string{string(models.ExportOriginals)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				repo    = mocks.NewMockExportRepository(ctrl)
+				images  = mocks.NewMockRepository(ctrl)
+				bucket  = mocks.NewMockStorage(ctrl)
+				archive []byte
+				key     string
+				saved   models.Export
+				export  = models.Export{ID: uuid.New(), UserID: userID, Include: tc.include, Status: models.ExportPending}
+			)
+
+			repo.EXPECT().ClaimExport(export.ID, gomock.Any()).Return(tc.claimed, nil)
+			images.EXPECT().GetAllImages(userID).Return([]models.Images{first, second}, nil).AnyTimes()
+			bucket.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string) (io.ReadCloser, error) {
+				if key == tc.corrupted {
+					return ioutil.NopCloser(strings.NewReader("corrupted")), nil
+				}
+				return ioutil.NopCloser(strings.NewReader(key)), tc.downloadErr
+			}).AnyTimes()
+			bucket.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, k string, r io.Reader, opts storage.PutOptions) error {
+				key = k
+				assert.Equal(t, ".zip", path.Ext(key))
+				assert.Equal(t, "application/zip", opts.ContentType)
+				assert.True(t, opts.Private, "archive should be private")
+				archive, _ = ioutil.ReadAll(r)
+				assert.Equal(t, opts.Size, int64(len(archive)), "unexpected archive size")
+				return nil
+			}).AnyTimes()
+			repo.EXPECT().SaveExport(gomock.Any()).DoAndReturn(func(e models.Export) (models.Export, error) {
+				saved = e
+				return e, nil
+			}).AnyTimes()
+
+			e := NewExporter(logger.NewMokLogger(), repo, images, bucket).(*exporterImpl)
+			e.run(export)
+
+			assert.Equal(t, tc.expStatus, saved.Status, "unexpected status")
+			if tc.expErr != "" {
+				assert.Equal(t, tc.expErr, saved.Error)
+			}
+			if tc.expStatus != models.ExportCompleted {
+				return
+			}
+
+			assert.Equal(t, key, saved.Archive, "archive key should be saved")
+			assert.Equal(t, 2, saved.Images)
+
+			zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+			if err != nil {
+				t.Fatalf("cannot read archive: %s", err)
+			}
+
+			var (
+				names    []string
+				manifest []ManifestEntry
+			)
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+
+				r, err := f.Open()
+				assert.NoError(t, err)
+				content, err := ioutil.ReadAll(r)
+				assert.NoError(t, err)
+
+				if f.Name == ManifestName {
+					assert.NoError(t, json.Unmarshal(content, &manifest), "cannot unmarshal manifest")
+				}
+			}
+
+			assert.Equal(t, tc.expFiles, names, "unexpected archive entries")
+			if assert.Len(t, manifest, 2) {
+				assert.Equal(t, first.ID, manifest[0].ID)
+				assert.Empty(t, manifest[0].OriginalKey, "storage keys shouldn't be exported")
+				assert.Equal(t, export.Includes(models.ExportOriginals), manifest[0].OriginalFile != "")
+			}
+		})
+	}
+}
+
+func TestSigner(t *testing.T) {
+	signer, err := NewSigner("key", time.Minute)
+	if err != nil {
+		t.Fatalf("cannot create signer: %s", err)
+	}
+
+	id := uuid.New()
+	expires, signature := signer.Sign(id)
+	unix := strconv.FormatInt(expires.Unix(), 10)
+
+	assert.NoError(t, signer.Verify(id, unix, signature), "signature should be valid")
+	assert.Equal(t, ErrInvalidSignature, signer.Verify(uuid.New(), unix, signature), "signature of another export should be invalid")
+	assert.Equal(t, ErrInvalidSignature, signer.Verify(id, strconv.FormatInt(expires.Unix()+1, 10), signature), "prolonged link should be invalid")
+	assert.Equal(t, ErrInvalidSignature, signer.Verify(id, "invalid", signature))
+
+	another, err := NewSigner("another key", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrInvalidSignature, another.Verify(id, unix, signature), "link signed with another key should be invalid")
+
+	past := time.Now().Add(-time.Minute).Unix()
+	assert.Equal(t, ErrLinkExpired, signer.Verify(id, strconv.FormatInt(past, 10), signer.signature(id, past)))
+}
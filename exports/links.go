@@ -0,0 +1,81 @@
+package exports
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	keySize        = 32
+	defaultLinkTTL = time.Hour
+)
+
+var (
+	// ErrLinkExpired is returned when download link is used after its expiration
+	ErrLinkExpired = errors.New("download link is expired")
+	// ErrInvalidSignature is returned when download link is forged or modified
+	ErrInvalidSignature = errors.New("download link signature is invalid")
+)
+
+// Signer issues time-limited download links of export archives
+type Signer struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewSigner creates signer, links signed by one instance are valid for others only if they share the key.
+// Random key is generated if the key is empty, so links become invalid after restart.
+func NewSigner(key string, ttl time.Duration) (*Signer, error) {
+	s := &Signer{key: []byte(key), ttl: ttl}
+
+	if s.ttl <= 0 {
+		s.ttl = defaultLinkTTL
+	}
+
+	if len(s.key) == 0 {
+		s.key = make([]byte, keySize)
+		if _, err := rand.Read(s.key); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Sign returns the expiration time and the signature of download link of the export
+func (s *Signer) Sign(exportID uuid.UUID) (time.Time, string) {
+	expires := time.Now().Add(s.ttl).Truncate(time.Second)
+	return expires, s.signature(exportID, expires.Unix())
+}
+
+// Verify checks that the link of the export is signed by the signer and is not expired
+func (s *Signer) Verify(exportID uuid.UUID, expires, signature string) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(s.signature(exportID, unix)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > unix {
+		return ErrLinkExpired
+	}
+
+	return nil
+}
+
+func (s *Signer) signature(exportID uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(exportID[:])
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,174 @@
+// Package gc deletes bucket objects which are not referenced by the db, e.g. objects left behind
+// by failed saves or by failed deletions of replaced images
+package gc
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	defaultGracePeriod = 24 * time.Hour
+	batchSize          = 500
+)
+
+// Report describes the objects checked by the collection, in dry run orphans and expired uploads are not deleted
+type Report struct {
+	// ExpiredUploads are abandoned uploads whose objects are collected
+	ExpiredUploads int64
+	Objects        int
+	// Unmanaged objects are not created by the service, they are never deleted
+	Unmanaged int
+	// Recent objects are younger than the grace period, they could be referenced by requests in progress
+	Recent     int
+	Referenced int
+	Orphans    []storage.ObjectInfo
+	OrphanSize int64
+	Deleted    int
+	Failed     int
+}
+
+// Collector deletes unreferenced objects older than the grace period.
+// Deletions are idempotent, so collections of several instances could overlap
+type Collector struct {
+	log         logger.Logger
+	repo        repository.ObjectRepository
+	bucket      storage.Storage
+	gracePeriod time.Duration
+	interval    time.Duration
+}
+
+// NewCollector creates collector, it doesn't collect anything until Start or Collect is called
+func NewCollector(log logger.Logger, repo repository.ObjectRepository, bucket storage.Storage) *Collector {
+	c := &Collector{
+		log:         log,
+		repo:        repo,
+		bucket:      bucket,
+		gracePeriod: time.Duration(config.Conf.GCGracePeriodHours) * time.Hour,
+		interval:    time.Duration(config.Conf.GCIntervalMin) * time.Minute,
+	}
+
+	if c.gracePeriod <= 0 {
+		c.gracePeriod = defaultGracePeriod
+	}
+
+	return c
+}
+
+// SetGracePeriod overrides GCGracePeriodHours setting
+func (c *Collector) SetGracePeriod(gracePeriod time.Duration) {
+	c.gracePeriod = gracePeriod
+}
+
+// Start collects orphans in background every GCIntervalMin, zero interval disables the job
+func (c *Collector) Start() {
+	if c.interval <= 0 {
+		c.log.Infof("Scheduled garbage collection of objects is disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := c.Collect(context.Background(), false); err != nil {
+				c.log.Errorf("garbage collection of objects failed due to: %s", err)
+			}
+		}
+	}()
+}
+
+// Collect deletes uploads which are abandoned for the grace period, then lists the bucket and deletes objects
+// which are not referenced by the db, failed deletions are reported and left for the next collection
+func (c *Collector) Collect(ctx context.Context, dryRun bool) (Report, error) {
+	var (
+		report Report
+		cutoff = time.Now().Add(-c.gracePeriod)
+		batch  = make([]storage.ObjectInfo, 0, batchSize)
+	)
+
+	c.log.Infof("Started garbage collection of objects older than %s, dry run: %t", cutoff.UTC().Format(time.RFC3339), dryRun)
+
+	if !dryRun {
+		expired, err := c.repo.DeleteExpiredUploads(cutoff)
+		if err != nil {
+			return report, err
+		}
+		report.ExpiredUploads = expired
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		keys := make([]string, 0, len(batch))
+		for _, info := range batch {
+			keys = append(keys, info.Key)
+		}
+
+		referenced, err := c.repo.ReferencedKeys(keys)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range batch {
+			if referenced[info.Key] {
+				report.Referenced++
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, info)
+			report.OrphanSize += info.Size
+
+			if dryRun {
+				continue
+			}
+
+			if err = c.bucket.Delete(ctx, info.Key); err != nil {
+				c.log.Errorf("cannot delete orphaned object %q due to: %s", info.Key, err)
+				report.Failed++
+				continue
+			}
+			report.Deleted++
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	err := c.bucket.List(ctx, "", func(info storage.ObjectInfo) error {
+		report.Objects++
+
+		switch {
+		case !storage.Managed(info.Key):
+			report.Unmanaged++
+		case info.LastModified.After(cutoff):
+			report.Recent++
+		default:
+			batch = append(batch, info)
+			if len(batch) == batchSize {
+				return flush()
+			}
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return report, err
+	}
+
+	c.log.Infof("Finished garbage collection of objects: %d expired uploads, %d objects, %d orphans, %d deleted, %d failed",
+		report.ExpiredUploads, report.Objects, len(report.Orphans), report.Deleted, report.Failed)
+
+	return report, nil
+}
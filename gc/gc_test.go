@@ -0,0 +1,158 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/filesystem"
+)
+
+func TestCollector_Collect(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	var (
+		old        = time.Now().Add(-48 * time.Hour)
+		orphan     = storage.Keys.Key(storage.KeyParams{UserID: uuid.New(), ImageID: uuid.New(), Variant: storage.VariantOriginal, Ext: ".png"})
+		referenced = storage.Keys.Key(storage.KeyParams{UserID: uuid.New(), ImageID: uuid.New(), Variant: storage.VariantOriginal, Ext: ".png"})
+		legacy     = storage.NewKey(".png")
+		chunk      = storage.ChunkKeyPrefix + uuid.New().String() + "/00000000000000000000-chunk"
+		recent     = storage.Keys.Key(storage.KeyParams{UserID: uuid.New(), ImageID: uuid.New(), Variant: storage.VariantOriginal, Ext: ".png"})
+		unmanaged  = "backups/db.dump"
+	)
+
+	testCases := []struct {
+		name       string
+		dryRun     bool
+		refErr     error
+		expiryErr  error
+		deleteErr  bool
+		expDeleted []string
+		expReport  Report
+		expFail    bool
+	}{
+		{
+			name:       "Good case",
+			expDeleted: []string{orphan, legacy, chunk},
+			expReport:  Report{ExpiredUploads: 2, Objects: 6, Unmanaged: 1, Recent: 1, Referenced: 1, Deleted: 3},
+		},
+		{
+			name:      "Dry run case",
+			dryRun:    true,
+			expReport: Report{Objects: 6, Unmanaged: 1, Recent: 1, Referenced: 1},
+		},
+		{
+			name:      "Db error case",
+			refErr:    errors.New("DB ERROR"),
+			expReport: Report{ExpiredUploads: 2, Objects: 6, Unmanaged: 1, Recent: 1},
+			expFail:   true,
+		},
+		{
+			name:      "Expiring uploads error case",
+			expiryErr: errors.New("DB ERROR"),
+			expFail:   true,
+		},
+		{
+			name:      "Delete error case",
+			deleteErr: true,
+			expReport: Report{ExpiredUploads: 2, Objects: 6, Unmanaged: 1, Recent: 1, Referenced: 1, Failed: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			root, err := ioutil.TempDir("", "gc")
+			if err != nil {
+				t.Fatalf("cannot create directory: %s", err)
+			}
+			defer os.RemoveAll(root)
+
+			files, err := filesystem.NewStorage(root, "http://localhost/files")
+			if err != nil {
+				t.Fatalf("cannot create storage: %s", err)
+			}
+
+			var bucket storage.Storage = files
+			if tc.deleteErr {
+				bucket = failingDelete{files}
+			}
+
+			ctx := context.Background()
+			for _, key := range []string{orphan, referenced, legacy, chunk, recent, unmanaged} {
+				assert.NoError(t, bucket.Put(ctx, key, bytes.NewBufferString("content"), storage.PutOptions{Size: -1}))
+				if key != recent {
+					assert.NoError(t, os.Chtimes(filepath.Join(root, key), old, old))
+				}
+			}
+
+			repo := mocks.NewMockObjectRepository(ctrl)
+			// uploads are expired before objects are listed, so their objects are collected by the same run
+			if !tc.dryRun {
+				repo.EXPECT().DeleteExpiredUploads(gomock.Any()).DoAndReturn(func(before time.Time) (int64, error) {
+					assert.WithinDuration(t, time.Now().Add(-defaultGracePeriod), before, time.Minute, "unexpected expiration time")
+					return 2, tc.expiryErr
+				})
+			}
+			if tc.expiryErr == nil {
+				repo.EXPECT().ReferencedKeys(gomock.Any()).DoAndReturn(func(keys []string) (map[string]bool, error) {
+					assert.ElementsMatch(t, []string{orphan, referenced, legacy, chunk}, keys, "only old managed objects should be checked")
+					return map[string]bool{referenced: true}, tc.refErr
+				})
+			}
+
+			report, err := NewCollector(log, repo, bucket).Collect(ctx, tc.dryRun)
+			assert.Equal(t, tc.expFail, err != nil, "unexpected error: %v", err)
+
+			assert.Equal(t, tc.expReport.ExpiredUploads, report.ExpiredUploads, "unexpected number of expired uploads")
+			assert.Equal(t, tc.expReport.Objects, report.Objects, "unexpected number of objects")
+			assert.Equal(t, tc.expReport.Unmanaged, report.Unmanaged, "unexpected number of unmanaged objects")
+			assert.Equal(t, tc.expReport.Recent, report.Recent, "unexpected number of recent objects")
+			assert.Equal(t, tc.expReport.Referenced, report.Referenced, "unexpected number of referenced objects")
+			assert.Equal(t, tc.expReport.Deleted, report.Deleted, "unexpected number of deleted objects")
+			assert.Equal(t, tc.expReport.Failed, report.Failed, "unexpected number of failed deletions")
+			if !tc.expFail {
+				assert.Len(t, report.Orphans, 3, "unexpected number of orphans")
+			}
+
+			for _, key := range []string{orphan, referenced, legacy, chunk, recent, unmanaged} {
+				exists, err := bucket.Exists(ctx, key)
+				assert.NoError(t, err)
+				assert.Equal(t, !contains(tc.expDeleted, key), exists, "unexpected existence of %s", key)
+			}
+		})
+	}
+}
+
+// failingDelete is a storage which cannot delete objects
+type failingDelete struct {
+	storage.Storage
+}
+
+func (failingDelete) Delete(context.Context, string) error {
+	return errors.New("DELETE ERROR")
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
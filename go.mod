@@ -10,6 +10,8 @@ require (
 	github.com/gorilla/mux v1.7.3
 	github.com/jinzhu/gorm v1.9.11
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/lib/pq v1.1.1
+	github.com/nats-io/nats.go v1.9.2
 	github.com/pkg/errors v0.8.0
 	github.com/rs/cors v1.7.0
 	github.com/sirupsen/logrus v1.4.2
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/Dimitriy14/image-resizing/apploader"
+	"github.com/Dimitriy14/image-resizing/commands"
 	"github.com/Dimitriy14/image-resizing/config"
 	"github.com/Dimitriy14/image-resizing/logger"
 	"github.com/Dimitriy14/image-resizing/services"
@@ -25,13 +26,25 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// arguments name a maintenance command which is run instead of the server
+	if flag.NArg() > 0 {
+		if err = commands.Run(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	middlewareManager := negroni.New()
 	middlewareManager.Use(negroni.NewRecovery())
 	negroniLogger := negroni.NewLogger()
 	negroniLogger.ALogger = logger.NewNegroniLogger(logger.Log)
 
 	middlewareManager.Use(negroniLogger)
-	middlewareManager.UseHandler(services.NewRouter())
+	router, err := services.NewRouter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	middlewareManager.UseHandler(router)
 
 	server := &http.Server{
 		Addr:    config.Conf.ListenURL,
@@ -0,0 +1,48 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/repository (interfaces: CompensationRepository)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	models "github.com/Dimitriy14/image-resizing/models"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+	time "time"
+)
+
+// MockCompensationRepository is a mock of CompensationRepository interface
+type MockCompensationRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockCompensationRepositoryMockRecorder
+}
+
+// MockCompensationRepositoryMockRecorder is the mock recorder for MockCompensationRepository
+type MockCompensationRepositoryMockRecorder struct {
+	mock *MockCompensationRepository
+}
+
+// NewMockCompensationRepository creates a new mock instance
+func NewMockCompensationRepository(ctrl *gomock.Controller) *MockCompensationRepository {
+	mock := &MockCompensationRepository{ctrl: ctrl}
+	mock.recorder = &MockCompensationRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockCompensationRepository) EXPECT() *MockCompensationRepositoryMockRecorder {
+	return m.recorder
+}
+
+// RetryCompensations mocks base method
+func (m *MockCompensationRepository) RetryCompensations(arg0 int, arg1 func(models.Compensation) error, arg2 func(int) time.Duration) (int, error) {
+	ret := m.ctrl.Call(m, "RetryCompensations", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RetryCompensations indicates an expected call of RetryCompensations
+func (mr *MockCompensationRepositoryMockRecorder) RetryCompensations(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryCompensations", reflect.TypeOf((*MockCompensationRepository)(nil).RetryCompensations), arg0, arg1, arg2)
+}
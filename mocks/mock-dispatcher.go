@@ -0,0 +1,100 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/webhooks (interfaces: Notifier,Dispatcher)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	models "github.com/Dimitriy14/image-resizing/models"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+)
+
+// MockNotifier is a mock of Notifier interface
+type MockNotifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockNotifierMockRecorder
+}
+
+// MockNotifierMockRecorder is the mock recorder for MockNotifier
+type MockNotifierMockRecorder struct {
+	mock *MockNotifier
+}
+
+// NewMockNotifier creates a new mock instance
+func NewMockNotifier(ctrl *gomock.Controller) *MockNotifier {
+	mock := &MockNotifier{ctrl: ctrl}
+	mock.recorder = &MockNotifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockNotifier) EXPECT() *MockNotifierMockRecorder {
+	return m.recorder
+}
+
+// Notify mocks base method
+func (m *MockNotifier) Notify(arg0 models.Event) {
+	m.ctrl.Call(m, "Notify", arg0)
+}
+
+// Notify indicates an expected call of Notify
+func (mr *MockNotifierMockRecorder) Notify(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockNotifier)(nil).Notify), arg0)
+}
+
+// MockDispatcher is a mock of Dispatcher interface
+type MockDispatcher struct {
+	ctrl     *gomock.Controller
+	recorder *MockDispatcherMockRecorder
+}
+
+// MockDispatcherMockRecorder is the mock recorder for MockDispatcher
+type MockDispatcherMockRecorder struct {
+	mock *MockDispatcher
+}
+
+// NewMockDispatcher creates a new mock instance
+func NewMockDispatcher(ctrl *gomock.Controller) *MockDispatcher {
+	mock := &MockDispatcher{ctrl: ctrl}
+	mock.recorder = &MockDispatcherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockDispatcher) EXPECT() *MockDispatcherMockRecorder {
+	return m.recorder
+}
+
+// Notify mocks base method
+func (m *MockDispatcher) Notify(arg0 models.Event) {
+	m.ctrl.Call(m, "Notify", arg0)
+}
+
+// Notify indicates an expected call of Notify
+func (mr *MockDispatcherMockRecorder) Notify(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockDispatcher)(nil).Notify), arg0)
+}
+
+// Replay mocks base method
+func (m *MockDispatcher) Replay(arg0 models.WebhookDelivery) (models.WebhookDelivery, error) {
+	ret := m.ctrl.Call(m, "Replay", arg0)
+	ret0, _ := ret[0].(models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Replay indicates an expected call of Replay
+func (mr *MockDispatcherMockRecorder) Replay(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Replay", reflect.TypeOf((*MockDispatcher)(nil).Replay), arg0)
+}
+
+// Start mocks base method
+func (m *MockDispatcher) Start() {
+	m.ctrl.Call(m, "Start")
+}
+
+// Start indicates an expected call of Start
+func (mr *MockDispatcherMockRecorder) Start() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockDispatcher)(nil).Start))
+}
@@ -0,0 +1,114 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/repository (interfaces: ExportRepository)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	models "github.com/Dimitriy14/image-resizing/models"
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
+	reflect "reflect"
+	time "time"
+)
+
+// MockExportRepository is a mock of ExportRepository interface
+type MockExportRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockExportRepositoryMockRecorder
+}
+
+// MockExportRepositoryMockRecorder is the mock recorder for MockExportRepository
+type MockExportRepositoryMockRecorder struct {
+	mock *MockExportRepository
+}
+
+// NewMockExportRepository creates a new mock instance
+func NewMockExportRepository(ctrl *gomock.Controller) *MockExportRepository {
+	mock := &MockExportRepository{ctrl: ctrl}
+	mock.recorder = &MockExportRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockExportRepository) EXPECT() *MockExportRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ClaimExport mocks base method
+func (m *MockExportRepository) ClaimExport(arg0 uuid.UUID, arg1 time.Time) (bool, error) {
+	ret := m.ctrl.Call(m, "ClaimExport", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimExport indicates an expected call of ClaimExport
+func (mr *MockExportRepositoryMockRecorder) ClaimExport(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimExport", reflect.TypeOf((*MockExportRepository)(nil).ClaimExport), arg0, arg1)
+}
+
+// GetExport mocks base method
+func (m *MockExportRepository) GetExport(arg0 uuid.UUID) (models.Export, error) {
+	ret := m.ctrl.Call(m, "GetExport", arg0)
+	ret0, _ := ret[0].(models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExport indicates an expected call of GetExport
+func (mr *MockExportRepositoryMockRecorder) GetExport(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExport", reflect.TypeOf((*MockExportRepository)(nil).GetExport), arg0)
+}
+
+// GetExportByID mocks base method
+func (m *MockExportRepository) GetExportByID(arg0, arg1 uuid.UUID) (models.Export, error) {
+	ret := m.ctrl.Call(m, "GetExportByID", arg0, arg1)
+	ret0, _ := ret[0].(models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExportByID indicates an expected call of GetExportByID
+func (mr *MockExportRepositoryMockRecorder) GetExportByID(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExportByID", reflect.TypeOf((*MockExportRepository)(nil).GetExportByID), arg0, arg1)
+}
+
+// GetExports mocks base method
+func (m *MockExportRepository) GetExports(arg0 uuid.UUID) ([]models.Export, error) {
+	ret := m.ctrl.Call(m, "GetExports", arg0)
+	ret0, _ := ret[0].([]models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExports indicates an expected call of GetExports
+func (mr *MockExportRepositoryMockRecorder) GetExports(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExports", reflect.TypeOf((*MockExportRepository)(nil).GetExports), arg0)
+}
+
+// GetPendingExports mocks base method
+func (m *MockExportRepository) GetPendingExports(arg0 time.Time) ([]models.Export, error) {
+	ret := m.ctrl.Call(m, "GetPendingExports", arg0)
+	ret0, _ := ret[0].([]models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingExports indicates an expected call of GetPendingExports
+func (mr *MockExportRepositoryMockRecorder) GetPendingExports(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingExports", reflect.TypeOf((*MockExportRepository)(nil).GetPendingExports), arg0)
+}
+
+// SaveExport mocks base method
+func (m *MockExportRepository) SaveExport(arg0 models.Export) (models.Export, error) {
+	ret := m.ctrl.Call(m, "SaveExport", arg0)
+	ret0, _ := ret[0].(models.Export)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveExport indicates an expected call of SaveExport
+func (mr *MockExportRepositoryMockRecorder) SaveExport(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveExport", reflect.TypeOf((*MockExportRepository)(nil).SaveExport), arg0)
+}
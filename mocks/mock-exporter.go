@@ -0,0 +1,54 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/exports (interfaces: Exporter)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	models "github.com/Dimitriy14/image-resizing/models"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+)
+
+// MockExporter is a mock of Exporter interface
+type MockExporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockExporterMockRecorder
+}
+
+// MockExporterMockRecorder is the mock recorder for MockExporter
+type MockExporterMockRecorder struct {
+	mock *MockExporter
+}
+
+// NewMockExporter creates a new mock instance
+func NewMockExporter(ctrl *gomock.Controller) *MockExporter {
+	mock := &MockExporter{ctrl: ctrl}
+	mock.recorder = &MockExporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockExporter) EXPECT() *MockExporterMockRecorder {
+	return m.recorder
+}
+
+// Enqueue mocks base method
+func (m *MockExporter) Enqueue(arg0 models.Export) {
+	m.ctrl.Call(m, "Enqueue", arg0)
+}
+
+// Enqueue indicates an expected call of Enqueue
+func (mr *MockExporterMockRecorder) Enqueue(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockExporter)(nil).Enqueue), arg0)
+}
+
+// Start mocks base method
+func (m *MockExporter) Start() {
+	m.ctrl.Call(m, "Start")
+}
+
+// Start indicates an expected call of Start
+func (mr *MockExporterMockRecorder) Start() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockExporter)(nil).Start))
+}
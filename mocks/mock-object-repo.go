@@ -0,0 +1,60 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/repository (interfaces: ObjectRepository)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+	time "time"
+)
+
+// MockObjectRepository is a mock of ObjectRepository interface
+type MockObjectRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockObjectRepositoryMockRecorder
+}
+
+// MockObjectRepositoryMockRecorder is the mock recorder for MockObjectRepository
+type MockObjectRepositoryMockRecorder struct {
+	mock *MockObjectRepository
+}
+
+// NewMockObjectRepository creates a new mock instance
+func NewMockObjectRepository(ctrl *gomock.Controller) *MockObjectRepository {
+	mock := &MockObjectRepository{ctrl: ctrl}
+	mock.recorder = &MockObjectRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockObjectRepository) EXPECT() *MockObjectRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteExpiredUploads mocks base method
+func (m *MockObjectRepository) DeleteExpiredUploads(arg0 time.Time) (int64, error) {
+	ret := m.ctrl.Call(m, "DeleteExpiredUploads", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredUploads indicates an expected call of DeleteExpiredUploads
+func (mr *MockObjectRepositoryMockRecorder) DeleteExpiredUploads(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredUploads", reflect.TypeOf((*MockObjectRepository)(nil).DeleteExpiredUploads), arg0)
+}
+
+// ReferencedKeys mocks base method
+func (m *MockObjectRepository) ReferencedKeys(arg0 []string) (map[string]bool, error) {
+	ret := m.ctrl.Call(m, "ReferencedKeys", arg0)
+	ret0, _ := ret[0].(map[string]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReferencedKeys indicates an expected call of ReferencedKeys
+func (mr *MockObjectRepositoryMockRecorder) ReferencedKeys(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReferencedKeys", reflect.TypeOf((*MockObjectRepository)(nil).ReferencedKeys), arg0)
+}
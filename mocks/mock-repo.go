@@ -6,9 +6,11 @@ package mocks
 
 import (
 	models "github.com/Dimitriy14/image-resizing/models"
+	repository "github.com/Dimitriy14/image-resizing/repository"
 	gomock "github.com/golang/mock/gomock"
 	uuid "github.com/google/uuid"
 	reflect "reflect"
+	time "time"
 )
 
 // MockRepository is a mock of Repository interface
@@ -34,6 +36,18 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// DeleteImage mocks base method
+func (m *MockRepository) DeleteImage(arg0, arg1 uuid.UUID) error {
+	ret := m.ctrl.Call(m, "DeleteImage", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteImage indicates an expected call of DeleteImage
+func (mr *MockRepositoryMockRecorder) DeleteImage(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteImage", reflect.TypeOf((*MockRepository)(nil).DeleteImage), arg0, arg1)
+}
+
 // GetAllImages mocks base method
 func (m *MockRepository) GetAllImages(arg0 uuid.UUID) ([]models.Images, error) {
 	ret := m.ctrl.Call(m, "GetAllImages", arg0)
@@ -60,6 +74,94 @@ func (mr *MockRepositoryMockRecorder) GetImageByID(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImageByID", reflect.TypeOf((*MockRepository)(nil).GetImageByID), arg0, arg1)
 }
 
+// GetImagesAfter mocks base method
+func (m *MockRepository) GetImagesAfter(arg0 uuid.UUID, arg1 int) ([]models.Images, error) {
+	ret := m.ctrl.Call(m, "GetImagesAfter", arg0, arg1)
+	ret0, _ := ret[0].([]models.Images)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetImagesAfter indicates an expected call of GetImagesAfter
+func (mr *MockRepositoryMockRecorder) GetImagesAfter(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImagesAfter", reflect.TypeOf((*MockRepository)(nil).GetImagesAfter), arg0, arg1)
+}
+
+// GetImagesToMove mocks base method
+func (m *MockRepository) GetImagesToMove(arg0 time.Time, arg1 uuid.UUID, arg2 int) ([]models.Images, error) {
+	ret := m.ctrl.Call(m, "GetImagesToMove", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.Images)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetImagesToMove indicates an expected call of GetImagesToMove
+func (mr *MockRepositoryMockRecorder) GetImagesToMove(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImagesToMove", reflect.TypeOf((*MockRepository)(nil).GetImagesToMove), arg0, arg1, arg2)
+}
+
+// MarkOriginalRead mocks base method
+func (m *MockRepository) MarkOriginalRead(arg0 uuid.UUID, arg1, arg2 time.Time) error {
+	ret := m.ctrl.Call(m, "MarkOriginalRead", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOriginalRead indicates an expected call of MarkOriginalRead
+func (mr *MockRepositoryMockRecorder) MarkOriginalRead(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOriginalRead", reflect.TypeOf((*MockRepository)(nil).MarkOriginalRead), arg0, arg1, arg2)
+}
+
+// ReferencedKeys mocks base method
+func (m *MockRepository) ReferencedKeys(arg0 []string) (map[string]bool, error) {
+	ret := m.ctrl.Call(m, "ReferencedKeys", arg0)
+	ret0, _ := ret[0].(map[string]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReferencedKeys indicates an expected call of ReferencedKeys
+func (mr *MockRepositoryMockRecorder) ReferencedKeys(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReferencedKeys", reflect.TypeOf((*MockRepository)(nil).ReferencedKeys), arg0)
+}
+
+// ReplaceImageKeys mocks base method
+func (m *MockRepository) ReplaceImageKeys(arg0, arg1 models.Images) (bool, error) {
+	ret := m.ctrl.Call(m, "ReplaceImageKeys", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReplaceImageKeys indicates an expected call of ReplaceImageKeys
+func (mr *MockRepositoryMockRecorder) ReplaceImageKeys(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceImageKeys", reflect.TypeOf((*MockRepository)(nil).ReplaceImageKeys), arg0, arg1)
+}
+
+// SaveCompensation mocks base method
+func (m *MockRepository) SaveCompensation(arg0 models.Compensation) error {
+	ret := m.ctrl.Call(m, "SaveCompensation", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveCompensation indicates an expected call of SaveCompensation
+func (mr *MockRepositoryMockRecorder) SaveCompensation(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCompensation", reflect.TypeOf((*MockRepository)(nil).SaveCompensation), arg0)
+}
+
+// SaveEvent mocks base method
+func (m *MockRepository) SaveEvent(arg0 models.Event) error {
+	ret := m.ctrl.Call(m, "SaveEvent", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveEvent indicates an expected call of SaveEvent
+func (mr *MockRepositoryMockRecorder) SaveEvent(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveEvent", reflect.TypeOf((*MockRepository)(nil).SaveEvent), arg0)
+}
+
 // SaveImage mocks base method
 func (m *MockRepository) SaveImage(arg0 models.Images) (models.Images, error) {
 	ret := m.ctrl.Call(m, "SaveImage", arg0)
@@ -73,6 +175,31 @@ func (mr *MockRepositoryMockRecorder) SaveImage(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveImage", reflect.TypeOf((*MockRepository)(nil).SaveImage), arg0)
 }
 
+// SetImageTier mocks base method
+func (m *MockRepository) SetImageTier(arg0 models.Images, arg1 models.StorageTier) (bool, error) {
+	ret := m.ctrl.Call(m, "SetImageTier", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetImageTier indicates an expected call of SetImageTier
+func (mr *MockRepositoryMockRecorder) SetImageTier(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetImageTier", reflect.TypeOf((*MockRepository)(nil).SetImageTier), arg0, arg1)
+}
+
+// Transaction mocks base method
+func (m *MockRepository) Transaction(arg0 func(repository.Repository) error) error {
+	ret := m.ctrl.Call(m, "Transaction", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Transaction indicates an expected call of Transaction
+func (mr *MockRepositoryMockRecorder) Transaction(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transaction", reflect.TypeOf((*MockRepository)(nil).Transaction), arg0)
+}
+
 // UpdateImage mocks base method
 func (m *MockRepository) UpdateImage(arg0 models.Images) (models.Images, error) {
 	ret := m.ctrl.Call(m, "UpdateImage", arg0)
@@ -7,6 +7,7 @@ package mocks
 import (
 	models "github.com/Dimitriy14/image-resizing/models"
 	gomock "github.com/golang/mock/gomock"
+	io "io"
 	reflect "reflect"
 )
 
@@ -34,7 +35,7 @@ func (m *MockResizer) EXPECT() *MockResizerMockRecorder {
 }
 
 // Resize mocks base method
-func (m *MockResizer) Resize(arg0 []byte, arg1 models.ResizeParams) ([]byte, error) {
+func (m *MockResizer) Resize(arg0 io.Reader, arg1 models.ResizeParams) ([]byte, error) {
 	ret := m.ctrl.Call(m, "Resize", arg0, arg1)
 	ret0, _ := ret[0].([]byte)
 	ret1, _ := ret[1].(error)
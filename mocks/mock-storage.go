@@ -5,7 +5,10 @@
 package mocks
 
 import (
+	context "context"
+	storage "github.com/Dimitriy14/image-resizing/storage"
 	gomock "github.com/golang/mock/gomock"
+	io "io"
 	reflect "reflect"
 )
 
@@ -32,54 +35,102 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
-// DeleteImage mocks base method
-func (m *MockStorage) DeleteImage(arg0 string) error {
-	ret := m.ctrl.Call(m, "DeleteImage", arg0)
+// Delete mocks base method
+func (m *MockStorage) Delete(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DeleteImage indicates an expected call of DeleteImage
-func (mr *MockStorageMockRecorder) DeleteImage(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteImage", reflect.TypeOf((*MockStorage)(nil).DeleteImage), arg0)
+// Delete indicates an expected call of Delete
+func (mr *MockStorageMockRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), arg0, arg1)
 }
 
-// Download mocks base method
-func (m *MockStorage) Download(arg0 string) ([]byte, error) {
-	ret := m.ctrl.Call(m, "Download", arg0)
-	ret0, _ := ret[0].([]byte)
+// Exists mocks base method
+func (m *MockStorage) Exists(arg0 context.Context, arg1 string) (bool, error) {
+	ret := m.ctrl.Call(m, "Exists", arg0, arg1)
+	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Download indicates an expected call of Download
-func (mr *MockStorageMockRecorder) Download(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockStorage)(nil).Download), arg0)
+// Exists indicates an expected call of Exists
+func (mr *MockStorageMockRecorder) Exists(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exists", reflect.TypeOf((*MockStorage)(nil).Exists), arg0, arg1)
 }
 
-// Upload mocks base method
-func (m *MockStorage) Upload(arg0 string, arg1 []byte) (string, error) {
-	ret := m.ctrl.Call(m, "Upload", arg0, arg1)
+// Get mocks base method
+func (m *MockStorage) Get(arg0 context.Context, arg1 string) (io.ReadCloser, error) {
+	ret := m.ctrl.Call(m, "Get", arg0, arg1)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get
+func (mr *MockStorageMockRecorder) Get(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockStorage)(nil).Get), arg0, arg1)
+}
+
+// Key mocks base method
+func (m *MockStorage) Key(arg0 string) (string, error) {
+	ret := m.ctrl.Call(m, "Key", arg0)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Upload indicates an expected call of Upload
-func (mr *MockStorageMockRecorder) Upload(arg0, arg1 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upload", reflect.TypeOf((*MockStorage)(nil).Upload), arg0, arg1)
+// Key indicates an expected call of Key
+func (mr *MockStorageMockRecorder) Key(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Key", reflect.TypeOf((*MockStorage)(nil).Key), arg0)
 }
 
-// UploadWithOriginal mocks base method
-func (m *MockStorage) UploadWithOriginal(arg0 string, arg1, arg2 []byte) (string, string, error) {
-	ret := m.ctrl.Call(m, "UploadWithOriginal", arg0, arg1, arg2)
+// List mocks base method
+func (m *MockStorage) List(arg0 context.Context, arg1 string, arg2 func(storage.ObjectInfo) error) error {
+	ret := m.ctrl.Call(m, "List", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// List indicates an expected call of List
+func (mr *MockStorageMockRecorder) List(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockStorage)(nil).List), arg0, arg1, arg2)
+}
+
+// Put mocks base method
+func (m *MockStorage) Put(arg0 context.Context, arg1 string, arg2 io.Reader, arg3 storage.PutOptions) error {
+	ret := m.ctrl.Call(m, "Put", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Put indicates an expected call of Put
+func (mr *MockStorageMockRecorder) Put(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockStorage)(nil).Put), arg0, arg1, arg2, arg3)
+}
+
+// Stat mocks base method
+func (m *MockStorage) Stat(arg0 context.Context, arg1 string) (storage.ObjectInfo, error) {
+	ret := m.ctrl.Call(m, "Stat", arg0, arg1)
+	ret0, _ := ret[0].(storage.ObjectInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat
+func (mr *MockStorageMockRecorder) Stat(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockStorage)(nil).Stat), arg0, arg1)
+}
+
+// URL mocks base method
+func (m *MockStorage) URL(arg0 string) string {
+	ret := m.ctrl.Call(m, "URL", arg0)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(string)
-	ret2, _ := ret[2].(error)
-	return ret0, ret1, ret2
+	return ret0
 }
 
-// UploadWithOriginal indicates an expected call of UploadWithOriginal
-func (mr *MockStorageMockRecorder) UploadWithOriginal(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UploadWithOriginal", reflect.TypeOf((*MockStorage)(nil).UploadWithOriginal), arg0, arg1, arg2)
+// URL indicates an expected call of URL
+func (mr *MockStorageMockRecorder) URL(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "URL", reflect.TypeOf((*MockStorage)(nil).URL), arg0)
 }
@@ -0,0 +1,139 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/repository (interfaces: UploadRepository)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	models "github.com/Dimitriy14/image-resizing/models"
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
+	reflect "reflect"
+	time "time"
+)
+
+// MockUploadRepository is a mock of UploadRepository interface
+type MockUploadRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockUploadRepositoryMockRecorder
+}
+
+// MockUploadRepositoryMockRecorder is the mock recorder for MockUploadRepository
+type MockUploadRepositoryMockRecorder struct {
+	mock *MockUploadRepository
+}
+
+// NewMockUploadRepository creates a new mock instance
+func NewMockUploadRepository(ctrl *gomock.Controller) *MockUploadRepository {
+	mock := &MockUploadRepository{ctrl: ctrl}
+	mock.recorder = &MockUploadRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockUploadRepository) EXPECT() *MockUploadRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AppendChunk mocks base method
+func (m *MockUploadRepository) AppendChunk(arg0 uuid.UUID, arg1, arg2 int64, arg3 string) (bool, error) {
+	ret := m.ctrl.Call(m, "AppendChunk", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AppendChunk indicates an expected call of AppendChunk
+func (mr *MockUploadRepositoryMockRecorder) AppendChunk(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendChunk", reflect.TypeOf((*MockUploadRepository)(nil).AppendChunk), arg0, arg1, arg2, arg3)
+}
+
+// ClaimFinish mocks base method
+func (m *MockUploadRepository) ClaimFinish(arg0 uuid.UUID, arg1 time.Time) (bool, error) {
+	ret := m.ctrl.Call(m, "ClaimFinish", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimFinish indicates an expected call of ClaimFinish
+func (mr *MockUploadRepositoryMockRecorder) ClaimFinish(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimFinish", reflect.TypeOf((*MockUploadRepository)(nil).ClaimFinish), arg0, arg1)
+}
+
+// ClaimUpload mocks base method
+func (m *MockUploadRepository) ClaimUpload(arg0 uuid.UUID, arg1 time.Time) (bool, error) {
+	ret := m.ctrl.Call(m, "ClaimUpload", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimUpload indicates an expected call of ClaimUpload
+func (mr *MockUploadRepositoryMockRecorder) ClaimUpload(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimUpload", reflect.TypeOf((*MockUploadRepository)(nil).ClaimUpload), arg0, arg1)
+}
+
+// DeleteResumableUpload mocks base method
+func (m *MockUploadRepository) DeleteResumableUpload(arg0, arg1 uuid.UUID) error {
+	ret := m.ctrl.Call(m, "DeleteResumableUpload", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteResumableUpload indicates an expected call of DeleteResumableUpload
+func (mr *MockUploadRepositoryMockRecorder) DeleteResumableUpload(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteResumableUpload", reflect.TypeOf((*MockUploadRepository)(nil).DeleteResumableUpload), arg0, arg1)
+}
+
+// GetResumableUpload mocks base method
+func (m *MockUploadRepository) GetResumableUpload(arg0, arg1 uuid.UUID) (models.ResumableUpload, error) {
+	ret := m.ctrl.Call(m, "GetResumableUpload", arg0, arg1)
+	ret0, _ := ret[0].(models.ResumableUpload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetResumableUpload indicates an expected call of GetResumableUpload
+func (mr *MockUploadRepositoryMockRecorder) GetResumableUpload(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetResumableUpload", reflect.TypeOf((*MockUploadRepository)(nil).GetResumableUpload), arg0, arg1)
+}
+
+// GetUpload mocks base method
+func (m *MockUploadRepository) GetUpload(arg0, arg1 uuid.UUID) (models.Upload, error) {
+	ret := m.ctrl.Call(m, "GetUpload", arg0, arg1)
+	ret0, _ := ret[0].(models.Upload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUpload indicates an expected call of GetUpload
+func (mr *MockUploadRepositoryMockRecorder) GetUpload(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpload", reflect.TypeOf((*MockUploadRepository)(nil).GetUpload), arg0, arg1)
+}
+
+// SaveResumableUpload mocks base method
+func (m *MockUploadRepository) SaveResumableUpload(arg0 models.ResumableUpload) (models.ResumableUpload, error) {
+	ret := m.ctrl.Call(m, "SaveResumableUpload", arg0)
+	ret0, _ := ret[0].(models.ResumableUpload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveResumableUpload indicates an expected call of SaveResumableUpload
+func (mr *MockUploadRepositoryMockRecorder) SaveResumableUpload(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveResumableUpload", reflect.TypeOf((*MockUploadRepository)(nil).SaveResumableUpload), arg0)
+}
+
+// SaveUpload mocks base method
+func (m *MockUploadRepository) SaveUpload(arg0 models.Upload) (models.Upload, error) {
+	ret := m.ctrl.Call(m, "SaveUpload", arg0)
+	ret0, _ := ret[0].(models.Upload)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveUpload indicates an expected call of SaveUpload
+func (mr *MockUploadRepositoryMockRecorder) SaveUpload(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveUpload", reflect.TypeOf((*MockUploadRepository)(nil).SaveUpload), arg0)
+}
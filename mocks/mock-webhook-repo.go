@@ -0,0 +1,164 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Dimitriy14/image-resizing/repository (interfaces: WebhookRepository)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	models "github.com/Dimitriy14/image-resizing/models"
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
+	reflect "reflect"
+)
+
+// MockWebhookRepository is a mock of WebhookRepository interface
+type MockWebhookRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookRepositoryMockRecorder
+}
+
+// MockWebhookRepositoryMockRecorder is the mock recorder for MockWebhookRepository
+type MockWebhookRepositoryMockRecorder struct {
+	mock *MockWebhookRepository
+}
+
+// NewMockWebhookRepository creates a new mock instance
+func NewMockWebhookRepository(ctrl *gomock.Controller) *MockWebhookRepository {
+	mock := &MockWebhookRepository{ctrl: ctrl}
+	mock.recorder = &MockWebhookRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockWebhookRepository) EXPECT() *MockWebhookRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateDelivery mocks base method
+func (m *MockWebhookRepository) CreateDelivery(arg0 models.WebhookDelivery) (bool, error) {
+	ret := m.ctrl.Call(m, "CreateDelivery", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateDelivery indicates an expected call of CreateDelivery
+func (mr *MockWebhookRepositoryMockRecorder) CreateDelivery(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDelivery", reflect.TypeOf((*MockWebhookRepository)(nil).CreateDelivery), arg0)
+}
+
+// DeleteWebhook mocks base method
+func (m *MockWebhookRepository) DeleteWebhook(arg0, arg1 uuid.UUID) error {
+	ret := m.ctrl.Call(m, "DeleteWebhook", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook
+func (mr *MockWebhookRepositoryMockRecorder) DeleteWebhook(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockWebhookRepository)(nil).DeleteWebhook), arg0, arg1)
+}
+
+// GetDeliveries mocks base method
+func (m *MockWebhookRepository) GetDeliveries(arg0, arg1 uuid.UUID) ([]models.WebhookDelivery, error) {
+	ret := m.ctrl.Call(m, "GetDeliveries", arg0, arg1)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeliveries indicates an expected call of GetDeliveries
+func (mr *MockWebhookRepositoryMockRecorder) GetDeliveries(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeliveries", reflect.TypeOf((*MockWebhookRepository)(nil).GetDeliveries), arg0, arg1)
+}
+
+// GetDeliveryByID mocks base method
+func (m *MockWebhookRepository) GetDeliveryByID(arg0, arg1 uuid.UUID) (models.WebhookDelivery, error) {
+	ret := m.ctrl.Call(m, "GetDeliveryByID", arg0, arg1)
+	ret0, _ := ret[0].(models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeliveryByID indicates an expected call of GetDeliveryByID
+func (mr *MockWebhookRepositoryMockRecorder) GetDeliveryByID(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeliveryByID", reflect.TypeOf((*MockWebhookRepository)(nil).GetDeliveryByID), arg0, arg1)
+}
+
+// GetPendingDeliveries mocks base method
+func (m *MockWebhookRepository) GetPendingDeliveries() ([]models.WebhookDelivery, error) {
+	ret := m.ctrl.Call(m, "GetPendingDeliveries")
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingDeliveries indicates an expected call of GetPendingDeliveries
+func (mr *MockWebhookRepositoryMockRecorder) GetPendingDeliveries() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingDeliveries", reflect.TypeOf((*MockWebhookRepository)(nil).GetPendingDeliveries))
+}
+
+// GetWebhookByID mocks base method
+func (m *MockWebhookRepository) GetWebhookByID(arg0, arg1 uuid.UUID) (models.Webhook, error) {
+	ret := m.ctrl.Call(m, "GetWebhookByID", arg0, arg1)
+	ret0, _ := ret[0].(models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookByID indicates an expected call of GetWebhookByID
+func (mr *MockWebhookRepositoryMockRecorder) GetWebhookByID(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookByID", reflect.TypeOf((*MockWebhookRepository)(nil).GetWebhookByID), arg0, arg1)
+}
+
+// GetWebhooks mocks base method
+func (m *MockWebhookRepository) GetWebhooks(arg0 uuid.UUID) ([]models.Webhook, error) {
+	ret := m.ctrl.Call(m, "GetWebhooks", arg0)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhooks indicates an expected call of GetWebhooks
+func (mr *MockWebhookRepositoryMockRecorder) GetWebhooks(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhooks", reflect.TypeOf((*MockWebhookRepository)(nil).GetWebhooks), arg0)
+}
+
+// GetWebhooksForEvent mocks base method
+func (m *MockWebhookRepository) GetWebhooksForEvent(arg0 uuid.UUID, arg1 models.EventType) ([]models.Webhook, error) {
+	ret := m.ctrl.Call(m, "GetWebhooksForEvent", arg0, arg1)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhooksForEvent indicates an expected call of GetWebhooksForEvent
+func (mr *MockWebhookRepositoryMockRecorder) GetWebhooksForEvent(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhooksForEvent", reflect.TypeOf((*MockWebhookRepository)(nil).GetWebhooksForEvent), arg0, arg1)
+}
+
+// SaveDelivery mocks base method
+func (m *MockWebhookRepository) SaveDelivery(arg0 models.WebhookDelivery) (models.WebhookDelivery, error) {
+	ret := m.ctrl.Call(m, "SaveDelivery", arg0)
+	ret0, _ := ret[0].(models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveDelivery indicates an expected call of SaveDelivery
+func (mr *MockWebhookRepositoryMockRecorder) SaveDelivery(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveDelivery", reflect.TypeOf((*MockWebhookRepository)(nil).SaveDelivery), arg0)
+}
+
+// SaveWebhook mocks base method
+func (m *MockWebhookRepository) SaveWebhook(arg0 models.Webhook) (models.Webhook, error) {
+	ret := m.ctrl.Call(m, "SaveWebhook", arg0)
+	ret0, _ := ret[0].(models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveWebhook indicates an expected call of SaveWebhook
+func (mr *MockWebhookRepositoryMockRecorder) SaveWebhook(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebhook", reflect.TypeOf((*MockWebhookRepository)(nil).SaveWebhook), arg0)
+}
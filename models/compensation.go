@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CompensationAction is an action which undoes a side effect of failed operation
+type CompensationAction string
+
+const (
+	// CompensationDeleteObject deletes the object stored by failed operation or left by replaced image
+	CompensationDeleteObject CompensationAction = "delete_object"
+	// CompensationPurgeObject purges cached copies of the object which is overwritten or deleted
+	CompensationPurgeObject CompensationAction = "purge_object"
+)
+
+// Compensation is a compensating action which failed, it is retried until it succeeds
+type Compensation struct {
+	ID            uuid.UUID          `gorm:"primary_key; column:id"`
+	Action        CompensationAction `gorm:"column:action"`
+	Key           string             `gorm:"column:key"`
+	Attempts      int                `gorm:"column:attempts"`
+	LastError     string             `gorm:"column:last_error"`
+	NextAttemptAt time.Time          `gorm:"column:next_attempt_at; index"`
+	CreatedAt     time.Time          `gorm:"column:created_at"`
+}
+
+func (c Compensation) TableName() string {
+	return "compensations"
+}
+
+// NewObjectDeletion records failed deletion of the object
+func NewObjectDeletion(key string, err error) Compensation {
+	return newCompensation(CompensationDeleteObject, key, err)
+}
+
+// NewObjectPurge records failed purge of cached copies of the object
+func NewObjectPurge(key string, err error) Compensation {
+	return newCompensation(CompensationPurgeObject, key, err)
+}
+
+func newCompensation(action CompensationAction, key string, err error) Compensation {
+	now := time.Now().UTC()
+	return Compensation{
+		ID:            uuid.New(),
+		Action:        action,
+		Key:           key,
+		Attempts:      1,
+		LastError:     err.Error(),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType is a name of image lifecycle event
+type EventType string
+
+const (
+	// EventImageCreated is sent when new image has been resized and saved
+	EventImageCreated EventType = "image.created"
+	// EventImageResized is sent when already existed image has been resized
+	EventImageResized EventType = "image.resized"
+	// EventImageDeleted is sent when image has been deleted
+	EventImageDeleted EventType = "image.deleted"
+	// EventJobFailed is sent when processing of an image has failed
+	EventJobFailed EventType = "job.failed"
+)
+
+// EventTypes contains all known event types
+var EventTypes = []EventType{EventImageCreated, EventImageResized, EventImageDeleted, EventJobFailed}
+
+// IsValid reports whether event type is known
+func (t EventType) IsValid() bool {
+	for _, et := range EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event describes something that happened to users image
+type Event struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      EventType       `json:"type"`
+	UserID    uuid.UUID       `json:"-"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// JobFailure is a payload of job.failed event
+type JobFailure struct {
+	ImageID   uuid.UUID `json:"image_id"`
+	Operation string    `json:"operation"`
+	Error     string    `json:"error"`
+}
+
+// NewEvent creates event of given type with data marshaled to JSON
+func NewEvent(eventType EventType, userID uuid.UUID, data interface{}) Event {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload = []byte("null")
+	}
+
+	return Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+		Data:      payload,
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ExportStatus is a state of export job
+type ExportStatus string
+
+const (
+	// ExportPending means that archive is waiting to be built
+	ExportPending ExportStatus = "pending"
+	// ExportRunning means that archive is being built by one of instances
+	ExportRunning ExportStatus = "running"
+	// ExportCompleted means that archive is stored and could be downloaded
+	ExportCompleted ExportStatus = "completed"
+	// ExportFailed means that archive cannot be built
+	ExportFailed ExportStatus = "failed"
+)
+
+// ExportContent is a kind of files included into export archive
+type ExportContent string
+
+const (
+	// ExportOriginals includes original images
+	ExportOriginals ExportContent = "originals"
+	// ExportResized includes current resized images
+	ExportResized ExportContent = "resized"
+)
+
+// IsValid reports whether content is known
+func (c ExportContent) IsValid() bool {
+	return c == ExportOriginals || c == ExportResized
+}
+
+// Export is an archive of all users images with manifest of their metadata
+type Export struct {
+	ID          uuid.UUID      `json:"id"                     gorm:"primary_key; column:id"`
+	UserID      uuid.UUID      `json:"-"                      gorm:"column:user_id"`
+	Include     pq.StringArray `json:"include"                gorm:"column:include; type:text[]"`
+	Status      ExportStatus   `json:"status"                 gorm:"column:status"`
+	Archive     string         `json:"-"                      gorm:"column:archive; index"`
+	Images      int            `json:"images"                 gorm:"column:images"`
+	Error       string         `json:"error,omitempty"        gorm:"column:error"`
+	DownloadURL string         `json:"download_url,omitempty" gorm:"-"`
+	LinkExpires *time.Time     `json:"link_expires,omitempty" gorm:"-"`
+	CreatedAt   time.Time      `json:"created_at"             gorm:"column:created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"             gorm:"column:updated_at"`
+}
+
+func (e Export) TableName() string {
+	return "exports"
+}
+
+// Includes reports whether archive contains files of given kind
+func (e Export) Includes(content ExportContent) bool {
+	for _, c := range e.Include {
+		if ExportContent(c) == content {
+			return true
+		}
+	}
+	return false
+}
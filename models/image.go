@@ -1,28 +1,69 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 )
 
+// StorageTier is the tier of the storage where the original is kept
+type StorageTier string
+
+const (
+	// TierHot keeps originals which are read recently
+	TierHot StorageTier = "hot"
+	// TierCold keeps originals which aren't read for TieringColdAfterDays in a cheaper storage
+	TierCold StorageTier = "cold"
+)
+
 // ResizeParams contains resized data
 type ResizeParams struct {
 	With   uint `json:"with"`
 	Height uint `json:"height"`
 }
 
-// Images contains links for original and resized image
+// Images contains storage keys of original and resized image,
+// links are not stored since they depend on storage settings and could expire
 type Images struct {
-	ID       uuid.UUID `json:"id"        gorm:"primary_key; column:id"`
-	Original string    `json:"original"  gorm:"column:original"`
-	Resized  string    `json:"resized"   gorm:"column:resized"`
-	UserID   uuid.UUID `json:"-"         gorm:"column:user_id"`
+	ID          uuid.UUID `json:"id"           gorm:"primary_key; column:id"`
+	OriginalKey string    `json:"-"        gorm:"column:original_key; index"`
+	ResizedKey  string    `json:"-"        gorm:"column:resized_key; index"`
+	Original    string    `json:"original" gorm:"-"`
+	Resized     string    `json:"resized"  gorm:"-"`
+	UserID      uuid.UUID `json:"-"        gorm:"column:user_id"`
+	// Filename is the name of uploaded original, it is suggested to clients which download the image
+	Filename string `json:"filename,omitempty" gorm:"column:filename"`
+	// Tier is the storage tier of the original, the resized image is always hot
+	Tier StorageTier `json:"tier" gorm:"column:tier"`
+	// OriginalReadAt is the time when the original was stored or read for the last time, it decides the tier
+	OriginalReadAt *time.Time `json:"-" gorm:"column:original_read_at; index"`
+	// OriginalSHA256 and ResizedSHA256 are hex encoded checksums of stored objects, downloads are verified
+	// by them. They are empty for images stored before checksums were introduced
+	OriginalSHA256 string `json:"original_sha256,omitempty" gorm:"column:original_sha256"`
+	ResizedSHA256  string `json:"resized_sha256,omitempty"  gorm:"column:resized_sha256"`
 }
 
 func (i Images) TableName() string {
 	return "images"
 }
 
+// BeforeCreate generates id of the image unless it is set, the id is a part of storage keys.
+// New originals are stored in the hot tier
 func (i *Images) BeforeCreate(scope *gorm.Scope) error {
+	if i.Tier == "" {
+		if err := scope.SetColumn("tier", TierHot); err != nil {
+			return err
+		}
+	}
+	if i.OriginalReadAt == nil {
+		if err := scope.SetColumn("original_read_at", time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+
+	if i.ID != uuid.Nil {
+		return nil
+	}
 	return scope.SetColumn("id", uuid.New())
 }
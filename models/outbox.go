@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxEvent is an event saved in the same transaction as the change it describes,
+// it is published only after the transaction has been committed
+type OutboxEvent struct {
+	ID          uuid.UUID  `gorm:"primary_key; column:id"`
+	UserID      uuid.UUID  `gorm:"column:user_id"`
+	Type        EventType  `gorm:"column:type"`
+	Data        string     `gorm:"column:data"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+	PublishedAt *time.Time `gorm:"column:published_at; index"`
+}
+
+func (o OutboxEvent) TableName() string {
+	return "outbox_events"
+}
+
+// NewOutboxEvent converts event to the outbox record
+func NewOutboxEvent(event Event) OutboxEvent {
+	return OutboxEvent{
+		ID:        event.ID,
+		UserID:    event.UserID,
+		Type:      event.Type,
+		Data:      string(event.Data),
+		CreatedAt: event.CreatedAt,
+	}
+}
+
+// Event converts outbox record back to the event
+func (o OutboxEvent) Event() Event {
+	return Event{
+		ID:        o.ID,
+		Type:      o.Type,
+		UserID:    o.UserID,
+		CreatedAt: o.CreatedAt,
+		Data:      json.RawMessage(o.Data),
+	}
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// UploadStatus is a state of direct upload
+type UploadStatus string
+
+const (
+	// UploadPending means that the client uploads the original to the bucket
+	UploadPending UploadStatus = "pending"
+	// UploadProcessing means that the uploaded original is being resized by one of instances
+	UploadProcessing UploadStatus = "processing"
+	// UploadCompleted means that the image is created from the uploaded original
+	UploadCompleted UploadStatus = "completed"
+)
+
+// Upload is an original image which the client uploads directly to the bucket by presigned request,
+// ImageID is reserved on creation and the image with this id exists once the upload is completed
+type Upload struct {
+	ID          uuid.UUID    `json:"id"                 gorm:"primary_key; column:id"`
+	UserID      uuid.UUID    `json:"-"                  gorm:"column:user_id"`
+	ObjectKey   string       `json:"-"                  gorm:"column:object_key; index"`
+	Filename    string       `json:"filename"           gorm:"column:filename"`
+	ContentType string       `json:"content_type"       gorm:"column:content_type"`
+	Size        int64        `json:"size"               gorm:"column:size"`
+	Width       uint         `json:"width"              gorm:"column:width"`
+	Height      uint         `json:"height"             gorm:"column:height"`
+	Status      UploadStatus `json:"status"             gorm:"column:status"`
+	ImageID     *uuid.UUID   `json:"image_id,omitempty" gorm:"column:image_id; type:uuid"`
+	ExpiresAt   time.Time    `json:"expires_at"         gorm:"column:expires_at"`
+	CreatedAt   time.Time    `json:"created_at"         gorm:"column:created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"         gorm:"column:updated_at"`
+}
+
+func (u Upload) TableName() string {
+	return "uploads"
+}
+
+// ResumableUpload is an original which the client uploads by chunks with tus protocol,
+// every chunk is stored as a separate object until the upload is finished
+type ResumableUpload struct {
+	ID       uuid.UUID      `json:"id"                 gorm:"primary_key; column:id"`
+	UserID   uuid.UUID      `json:"-"                  gorm:"column:user_id"`
+	Length   int64          `json:"length"             gorm:"column:length"`
+	Offset   int64          `json:"offset"             gorm:"column:offset"`
+	Filename string         `json:"filename"           gorm:"column:filename"`
+	Width    uint           `json:"width"              gorm:"column:width"`
+	Height   uint           `json:"height"             gorm:"column:height"`
+	Chunks   pq.StringArray `json:"-"                  gorm:"column:chunks; type:text[]"`
+	ImageID  *uuid.UUID     `json:"image_id,omitempty" gorm:"column:image_id; type:uuid"`
+	// Finishing is set while received chunks are handed off to resizing
+	Finishing bool      `json:"-"          gorm:"column:finishing"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+func (u ResumableUpload) TableName() string {
+	return "resumable_uploads"
+}
+
+// Received reports whether all bytes of the upload are received
+func (u ResumableUpload) Received() bool {
+	return u.Offset == u.Length
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DeliveryStatus is a state of webhook delivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending means that delivery is waiting for the next attempt
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySucceeded means that receiver has accepted the delivery
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryFailed means that all attempts have been exhausted
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Webhook is an URL registered by user for receiving events
+type Webhook struct {
+	ID        uuid.UUID      `json:"id"               gorm:"primary_key; column:id"`
+	UserID    uuid.UUID      `json:"-"                gorm:"column:user_id"`
+	URL       string         `json:"url"              gorm:"column:url"`
+	Secret    string         `json:"secret,omitempty" gorm:"column:secret"`
+	Events    pq.StringArray `json:"events"           gorm:"column:events; type:text[]"`
+	CreatedAt time.Time      `json:"created_at"       gorm:"column:created_at"`
+}
+
+func (w Webhook) TableName() string {
+	return "webhooks"
+}
+
+// Subscribed reports whether webhook wants to receive events of given type
+func (w Webhook) Subscribed(eventType EventType) bool {
+	for _, e := range w.Events {
+		if EventType(e) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is a log entry of sending an event to the webhook
+type WebhookDelivery struct {
+	ID            uuid.UUID      `json:"id"                        gorm:"primary_key; column:id"`
+	WebhookID     uuid.UUID      `json:"webhook_id"                gorm:"column:webhook_id"`
+	UserID        uuid.UUID      `json:"-"                         gorm:"column:user_id"`
+	EventID       uuid.UUID      `json:"event_id"                  gorm:"column:event_id"`
+	Event         EventType      `json:"event"                     gorm:"column:event"`
+	Payload       string         `json:"payload"                   gorm:"column:payload"`
+	Status        DeliveryStatus `json:"status"                    gorm:"column:status"`
+	Attempts      int            `json:"attempts"                  gorm:"column:attempts"`
+	ResponseCode  int            `json:"response_code,omitempty"   gorm:"column:response_code"`
+	LastError     string         `json:"last_error,omitempty"      gorm:"column:last_error"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty" gorm:"column:next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at"                gorm:"column:created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"                gorm:"column:updated_at"`
+}
+
+func (d WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+//go:generate mockgen -destination=../mocks/mock-compensation-repo.go -mock_names=CompensationRepository=MockCompensationRepository -package=mocks github.com/Dimitriy14/image-resizing/repository CompensationRepository
+type CompensationRepository interface {
+	// RetryCompensations locks up to limit due compensations and passes them to run, succeeded ones are removed
+	// and failed ones are postponed by backoff of their attempts, it returns the number of locked compensations
+	RetryCompensations(limit int, run func(models.Compensation) error, backoff func(attempts int) time.Duration) (int, error)
+}
+
+type compensationRepoImpl struct {
+	db *postgres.PGClient
+}
+
+func NewCompensationRepository(client *postgres.PGClient) CompensationRepository {
+	return &compensationRepoImpl{db: client}
+}
+
+func (r *compensationRepoImpl) RetryCompensations(limit int, run func(models.Compensation) error, backoff func(int) time.Duration) (int, error) {
+	tx := r.db.Session.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	var compensations []models.Compensation
+	// SKIP LOCKED lets several instances of the service retry compensations concurrently
+	err := tx.Raw(
+		"SELECT * FROM compensations WHERE next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED",
+		time.Now().UTC(), limit,
+	).Scan(&compensations).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, c := range compensations {
+		if runErr := run(c); runErr != nil {
+			c.Attempts++
+			err = tx.Model(&models.Compensation{}).Where("id = ?", c.ID).Updates(map[string]interface{}{
+				"attempts":        c.Attempts,
+				"last_error":      runErr.Error(),
+				"next_attempt_at": time.Now().UTC().Add(backoff(c.Attempts)),
+			}).Error
+		} else {
+			err = tx.Where("id = ?", c.ID).Delete(&models.Compensation{}).Error
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return len(compensations), nil
+}
+
+func (r *repoImpl) SaveCompensation(c models.Compensation) error {
+	return r.db.Session.Create(&c).Error
+}
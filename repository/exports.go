@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/google/uuid"
+)
+
+//go:generate mockgen -destination=../mocks/mock-export-repo.go -mock_names=ExportRepository=MockExportRepository -package=mocks github.com/Dimitriy14/image-resizing/repository ExportRepository
+type ExportRepository interface {
+	GetExports(userID uuid.UUID) ([]models.Export, error)
+	GetExportByID(userID, exportID uuid.UUID) (models.Export, error)
+	// GetExport isn't limited by user, it is used only for downloads by signed links
+	GetExport(exportID uuid.UUID) (models.Export, error)
+	SaveExport(models.Export) (models.Export, error)
+	// GetPendingExports returns exports waiting to be built and running ones which were not updated since staleBefore
+	GetPendingExports(staleBefore time.Time) ([]models.Export, error)
+	// ClaimExport marks export as running unless another instance has already claimed it
+	ClaimExport(exportID uuid.UUID, staleBefore time.Time) (claimed bool, err error)
+}
+
+type exportRepoImpl struct {
+	db *postgres.PGClient
+}
+
+func NewExportRepository(client *postgres.PGClient) ExportRepository {
+	return &exportRepoImpl{db: client}
+}
+
+func (r *exportRepoImpl) GetExports(userID uuid.UUID) ([]models.Export, error) {
+	var exports []models.Export
+	err := r.db.Session.Where("user_id = ?", userID).Order("created_at desc").Find(&exports).Error
+	return exports, err
+}
+
+func (r *exportRepoImpl) GetExportByID(userID, exportID uuid.UUID) (models.Export, error) {
+	var export models.Export
+	err := r.db.Session.Where("user_id = ? AND id = ?", userID, exportID).Find(&export).Error
+	return export, err
+}
+
+func (r *exportRepoImpl) GetExport(exportID uuid.UUID) (models.Export, error) {
+	var export models.Export
+	err := r.db.Session.Where("id = ?", exportID).Find(&export).Error
+	return export, err
+}
+
+func (r *exportRepoImpl) SaveExport(export models.Export) (models.Export, error) {
+	err := r.db.Session.Save(&export).Error
+	return export, err
+}
+
+func (r *exportRepoImpl) GetPendingExports(staleBefore time.Time) ([]models.Export, error) {
+	var exports []models.Export
+	err := r.db.Session.
+		Where("status = ? OR (status = ? AND updated_at < ?)", models.ExportPending, models.ExportRunning, staleBefore).
+		Order("created_at").
+		Find(&exports).Error
+	return exports, err
+}
+
+func (r *exportRepoImpl) ClaimExport(exportID uuid.UUID, staleBefore time.Time) (bool, error) {
+	db := r.db.Session.Model(&models.Export{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))", exportID, models.ExportPending, models.ExportRunning, staleBefore).
+		Updates(map[string]interface{}{"status": models.ExportRunning, "updated_at": time.Now()})
+	return db.RowsAffected > 0, db.Error
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/Dimitriy14/image-resizing/models"
 	"github.com/google/uuid"
 )
@@ -26,3 +28,48 @@ func (r *repoImpl) UpdateImage(img models.Images) (models.Images, error) {
 	err := r.db.Session.Model(&models.Images{}).Update(&img).Error
 	return img, err
 }
+
+func (r *repoImpl) DeleteImage(userID, imageID uuid.UUID) error {
+	return r.db.Session.Where("user_id = ? AND id = ?", userID, imageID).Delete(&models.Images{}).Error
+}
+
+func (r *repoImpl) GetImagesAfter(imageID uuid.UUID, limit int) ([]models.Images, error) {
+	var images []models.Images
+	err := r.db.Session.Where("id > ?", imageID).Order("id").Limit(limit).Find(&images).Error
+	return images, err
+}
+
+// ReplaceImageKeys moves the original to the hot tier when its key is replaced, since new objects are stored there
+func (r *repoImpl) ReplaceImageKeys(old, new models.Images) (bool, error) {
+	updates := map[string]interface{}{"original_key": new.OriginalKey, "resized_key": new.ResizedKey}
+	if new.OriginalKey != old.OriginalKey {
+		updates["tier"] = models.TierHot
+	}
+
+	db := r.db.Session.Model(&models.Images{}).
+		Where("id = ? AND original_key = ? AND resized_key = ?", old.ID, old.OriginalKey, old.ResizedKey).
+		Updates(updates)
+	return db.RowsAffected > 0, db.Error
+}
+
+func (r *repoImpl) GetImagesToMove(cutoff time.Time, afterID uuid.UUID, limit int) ([]models.Images, error) {
+	var images []models.Images
+	err := r.db.Session.
+		Where("id > ? AND ((tier = ? AND original_read_at < ?) OR (tier = ? AND original_read_at >= ?))",
+			afterID, models.TierHot, cutoff, models.TierCold, cutoff).
+		Order("id").Limit(limit).Find(&images).Error
+	return images, err
+}
+
+func (r *repoImpl) MarkOriginalRead(imageID uuid.UUID, readAt, since time.Time) error {
+	return r.db.Session.Model(&models.Images{}).
+		Where("id = ? AND (original_read_at IS NULL OR original_read_at < ?)", imageID, since).
+		Update("original_read_at", readAt).Error
+}
+
+func (r *repoImpl) SetImageTier(img models.Images, tier models.StorageTier) (bool, error) {
+	db := r.db.Session.Model(&models.Images{}).
+		Where("id = ? AND original_key = ?", img.ID, img.OriginalKey).
+		Update("tier", tier)
+	return db.RowsAffected > 0, db.Error
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+//go:generate mockgen -destination=../mocks/mock-object-repo.go -mock_names=ObjectRepository=MockObjectRepository -package=mocks github.com/Dimitriy14/image-resizing/repository ObjectRepository
+type ObjectRepository interface {
+	// ReferencedKeys returns those of keys which are referenced by images, uploads, chunks or exports
+	ReferencedKeys(keys []string) (map[string]bool, error)
+	// DeleteExpiredUploads deletes direct uploads which are pending since their expiration before the time given
+	// and resumable uploads which are not finished and not updated since it, so their objects become orphans
+	DeleteExpiredUploads(before time.Time) (deleted int64, err error)
+}
+
+type objectRepoImpl struct {
+	db *postgres.PGClient
+}
+
+func NewObjectRepository(client *postgres.PGClient) ObjectRepository {
+	return &objectRepoImpl{db: client}
+}
+
+func (r *objectRepoImpl) ReferencedKeys(keys []string) (map[string]bool, error) {
+	return referencedKeys(r.db, keys)
+}
+
+func (r *objectRepoImpl) DeleteExpiredUploads(before time.Time) (int64, error) {
+	db := r.db.Session.Where("status = ? AND expires_at < ?", models.UploadPending, before).Delete(&models.Upload{})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	deleted := db.RowsAffected
+
+	db = r.db.Session.Where("image_id IS NULL AND updated_at < ?", before).Delete(&models.ResumableUpload{})
+	return deleted + db.RowsAffected, db.Error
+}
+
+// ReferencedKeys lets the service check keys of deleted objects, since they could be reused by deterministic keys
+func (r *repoImpl) ReferencedKeys(keys []string) (map[string]bool, error) {
+	return referencedKeys(r.db, keys)
+}
+
+func referencedKeys(db *postgres.PGClient, keys []string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	if len(keys) == 0 {
+		return referenced, nil
+	}
+
+	rows, err := db.Session.Raw(`
+		SELECT k FROM unnest(?::text[]) AS k
+		WHERE EXISTS (SELECT 1 FROM images WHERE original_key = k OR resized_key = k)
+		   OR EXISTS (SELECT 1 FROM uploads WHERE object_key = k)
+		   OR EXISTS (SELECT 1 FROM resumable_uploads WHERE chunks @> ARRAY[k])
+		   OR EXISTS (SELECT 1 FROM exports WHERE archive = k)`,
+		pq.StringArray(keys),
+	).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		referenced[key] = true
+	}
+
+	return referenced, rows.Err()
+}
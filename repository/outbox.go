@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+//go:generate mockgen -destination=../mocks/mock-outbox-repo.go -mock_names=OutboxRepository=MockOutboxRepository -package=mocks github.com/Dimitriy14/image-resizing/repository OutboxRepository
+type OutboxRepository interface {
+	// PublishEvents locks up to limit unpublished events, passes them to publish in order of creation
+	// and marks successfully published ones, it returns the number of published events
+	PublishEvents(limit int, publish func(models.Event) error) (int, error)
+}
+
+type outboxRepoImpl struct {
+	db *postgres.PGClient
+}
+
+func NewOutboxRepository(client *postgres.PGClient) OutboxRepository {
+	return &outboxRepoImpl{db: client}
+}
+
+func (r *outboxRepoImpl) PublishEvents(limit int, publish func(models.Event) error) (int, error) {
+	tx := r.db.Session.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	var events []models.OutboxEvent
+	// SKIP LOCKED lets several instances of the service relay the outbox concurrently
+	err := tx.Raw(
+		"SELECT * FROM outbox_events WHERE published_at IS NULL ORDER BY created_at LIMIT ? FOR UPDATE SKIP LOCKED",
+		limit,
+	).Scan(&events).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var (
+		published  int
+		publishErr error
+	)
+	for _, e := range events {
+		if publishErr = publish(e.Event()); publishErr != nil {
+			break
+		}
+
+		err = tx.Model(&models.OutboxEvent{}).Where("id = ?", e.ID).Update("published_at", time.Now().UTC()).Error
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		published++
+	}
+
+	if err = tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+func (r *repoImpl) SaveEvent(event models.Event) error {
+	outboxEvent := models.NewOutboxEvent(event)
+	return r.db.Session.Create(&outboxEvent).Error
+}
+
+func (r *repoImpl) Transaction(fn func(Repository) error) (err error) {
+	tx := r.db.Session.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(&repoImpl{db: &postgres.PGClient{Session: tx}}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/Dimitriy14/image-resizing/clients/postgres"
 	"github.com/Dimitriy14/image-resizing/models"
 	"github.com/google/uuid"
@@ -12,6 +14,29 @@ type Repository interface {
 	GetImageByID(userID, imageID uuid.UUID) (models.Images, error)
 	SaveImage(models.Images) (models.Images, error)
 	UpdateImage(models.Images) (models.Images, error)
+	DeleteImage(userID, imageID uuid.UUID) error
+	// GetImagesAfter returns images of all users ordered by id, it is used by maintenance commands
+	GetImagesAfter(imageID uuid.UUID, limit int) ([]models.Images, error)
+	// ReplaceImageKeys sets keys of the image unless they have been changed since it was read
+	ReplaceImageKeys(old, new models.Images) (replaced bool, err error)
+	// GetImagesToMove returns images ordered by id whose originals should change the tier:
+	// hot ones read before cutoff and cold ones read since it
+	GetImagesToMove(cutoff time.Time, afterID uuid.UUID, limit int) ([]models.Images, error)
+	// MarkOriginalRead sets the time when the original is read unless it has been read since the time given
+	MarkOriginalRead(imageID uuid.UUID, readAt, since time.Time) error
+	// SetImageTier sets the tier of the original unless it has been replaced or the image has been deleted
+	SetImageTier(img models.Images, tier models.StorageTier) (set bool, err error)
+
+	// SaveCompensation records failed compensating action, it is retried by compensations.Retrier
+	SaveCompensation(models.Compensation) error
+	// ReferencedKeys returns those of keys which are referenced by images, uploads, chunks or exports
+	ReferencedKeys(keys []string) (map[string]bool, error)
+
+	// SaveEvent adds event to the outbox, it is published after the transaction is committed
+	SaveEvent(models.Event) error
+	// Transaction runs fn with repository bound to a single database transaction,
+	// the transaction is rolled back when fn returns an error
+	Transaction(fn func(Repository) error) error
 }
 
 type repoImpl struct {
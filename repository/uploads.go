@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+//go:generate mockgen -destination=../mocks/mock-upload-repo.go -mock_names=UploadRepository=MockUploadRepository -package=mocks github.com/Dimitriy14/image-resizing/repository UploadRepository
+type UploadRepository interface {
+	GetUpload(userID, uploadID uuid.UUID) (models.Upload, error)
+	SaveUpload(models.Upload) (models.Upload, error)
+	// ClaimUpload marks upload as processing unless another request has already claimed it
+	ClaimUpload(uploadID uuid.UUID, staleBefore time.Time) (claimed bool, err error)
+
+	GetResumableUpload(userID, uploadID uuid.UUID) (models.ResumableUpload, error)
+	SaveResumableUpload(models.ResumableUpload) (models.ResumableUpload, error)
+	DeleteResumableUpload(userID, uploadID uuid.UUID) error
+	// AppendChunk moves the offset of the upload and records the chunk unless the offset has already been moved
+	AppendChunk(uploadID uuid.UUID, offset, newOffset int64, key string) (appended bool, err error)
+	// ClaimFinish marks received upload as finishing unless another request is finishing it
+	ClaimFinish(uploadID uuid.UUID, staleBefore time.Time) (claimed bool, err error)
+}
+
+type uploadRepoImpl struct {
+	db *postgres.PGClient
+}
+
+func NewUploadRepository(client *postgres.PGClient) UploadRepository {
+	return &uploadRepoImpl{db: client}
+}
+
+func (r *uploadRepoImpl) GetUpload(userID, uploadID uuid.UUID) (models.Upload, error) {
+	var upload models.Upload
+	err := r.db.Session.Where("user_id = ? AND id = ?", userID, uploadID).Find(&upload).Error
+	return upload, err
+}
+
+func (r *uploadRepoImpl) SaveUpload(upload models.Upload) (models.Upload, error) {
+	err := r.db.Session.Save(&upload).Error
+	return upload, err
+}
+
+func (r *uploadRepoImpl) ClaimUpload(uploadID uuid.UUID, staleBefore time.Time) (bool, error) {
+	db := r.db.Session.Model(&models.Upload{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))", uploadID, models.UploadPending, models.UploadProcessing, staleBefore).
+		Updates(map[string]interface{}{"status": models.UploadProcessing, "updated_at": time.Now()})
+	return db.RowsAffected > 0, db.Error
+}
+
+func (r *uploadRepoImpl) GetResumableUpload(userID, uploadID uuid.UUID) (models.ResumableUpload, error) {
+	var upload models.ResumableUpload
+	err := r.db.Session.Where("user_id = ? AND id = ?", userID, uploadID).Find(&upload).Error
+	return upload, err
+}
+
+func (r *uploadRepoImpl) SaveResumableUpload(upload models.ResumableUpload) (models.ResumableUpload, error) {
+	err := r.db.Session.Save(&upload).Error
+	return upload, err
+}
+
+func (r *uploadRepoImpl) DeleteResumableUpload(userID, uploadID uuid.UUID) error {
+	return r.db.Session.Where("user_id = ? AND id = ?", userID, uploadID).Delete(&models.ResumableUpload{}).Error
+}
+
+func (r *uploadRepoImpl) AppendChunk(uploadID uuid.UUID, offset, newOffset int64, key string) (bool, error) {
+	db := r.db.Session.Model(&models.ResumableUpload{}).
+		Where("id = ? AND \"offset\" = ?", uploadID, offset).
+		Updates(map[string]interface{}{
+			"offset":     newOffset,
+			"chunks":     gorm.Expr("array_append(chunks, ?)", key),
+			"updated_at": time.Now(),
+		})
+	return db.RowsAffected > 0, db.Error
+}
+
+func (r *uploadRepoImpl) ClaimFinish(uploadID uuid.UUID, staleBefore time.Time) (bool, error) {
+	db := r.db.Session.Model(&models.ResumableUpload{}).
+		Where("id = ? AND \"offset\" = length AND image_id IS NULL AND (NOT finishing OR updated_at < ?)", uploadID, staleBefore).
+		Updates(map[string]interface{}{"finishing": true, "updated_at": time.Now()})
+	return db.RowsAffected > 0, db.Error
+}
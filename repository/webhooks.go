@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/google/uuid"
+)
+
+//go:generate mockgen -destination=../mocks/mock-webhook-repo.go -mock_names=WebhookRepository=MockWebhookRepository -package=mocks github.com/Dimitriy14/image-resizing/repository WebhookRepository
+type WebhookRepository interface {
+	GetWebhooks(userID uuid.UUID) ([]models.Webhook, error)
+	GetWebhooksForEvent(userID uuid.UUID, event models.EventType) ([]models.Webhook, error)
+	GetWebhookByID(userID, webhookID uuid.UUID) (models.Webhook, error)
+	SaveWebhook(models.Webhook) (models.Webhook, error)
+	DeleteWebhook(userID, webhookID uuid.UUID) error
+
+	GetDeliveries(userID, webhookID uuid.UUID) ([]models.WebhookDelivery, error)
+	GetDeliveryByID(userID, deliveryID uuid.UUID) (models.WebhookDelivery, error)
+	GetPendingDeliveries() ([]models.WebhookDelivery, error)
+	// CreateDelivery inserts delivery unless a delivery with the same id already exists
+	CreateDelivery(models.WebhookDelivery) (created bool, err error)
+	SaveDelivery(models.WebhookDelivery) (models.WebhookDelivery, error)
+}
+
+type webhookRepoImpl struct {
+	db *postgres.PGClient
+}
+
+func NewWebhookRepository(client *postgres.PGClient) WebhookRepository {
+	return &webhookRepoImpl{db: client}
+}
+
+func (r *webhookRepoImpl) GetWebhooks(userID uuid.UUID) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	err := r.db.Session.Where("user_id = ?", userID).Order("created_at").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r *webhookRepoImpl) GetWebhooksForEvent(userID uuid.UUID, event models.EventType) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	err := r.db.Session.Where("user_id = ? AND ? = ANY(events)", userID, string(event)).Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r *webhookRepoImpl) GetWebhookByID(userID, webhookID uuid.UUID) (models.Webhook, error) {
+	var webhook models.Webhook
+	err := r.db.Session.Where("user_id = ? AND id = ?", userID, webhookID).Find(&webhook).Error
+	return webhook, err
+}
+
+func (r *webhookRepoImpl) SaveWebhook(webhook models.Webhook) (models.Webhook, error) {
+	err := r.db.Session.Save(&webhook).Error
+	return webhook, err
+}
+
+func (r *webhookRepoImpl) DeleteWebhook(userID, webhookID uuid.UUID) error {
+	return r.db.Session.Where("user_id = ? AND id = ?", userID, webhookID).Delete(&models.Webhook{}).Error
+}
+
+func (r *webhookRepoImpl) GetDeliveries(userID, webhookID uuid.UUID) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Session.Where("user_id = ? AND webhook_id = ?", userID, webhookID).Order("created_at desc").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *webhookRepoImpl) GetDeliveryByID(userID, deliveryID uuid.UUID) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.db.Session.Where("user_id = ? AND id = ?", userID, deliveryID).Find(&delivery).Error
+	return delivery, err
+}
+
+func (r *webhookRepoImpl) GetPendingDeliveries() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Session.Where("status = ?", models.DeliveryPending).Order("created_at").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *webhookRepoImpl) CreateDelivery(delivery models.WebhookDelivery) (bool, error) {
+	db := r.db.Session.Set("gorm:insert_option", "ON CONFLICT (id) DO NOTHING").Create(&delivery)
+	return db.RowsAffected > 0, db.Error
+}
+
+func (r *webhookRepoImpl) SaveDelivery(delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	err := r.db.Session.Save(&delivery).Error
+	return delivery, err
+}
@@ -0,0 +1,180 @@
+// Package scrub reads objects of all images and verifies them by checksums of the db,
+// so corrupted or lost objects are found before clients request them. Every replica and tier
+// is read directly, so a lost copy isn't hidden by reads falling back to another one
+package scrub
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/encrypted"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+)
+
+const batchSize = 500
+
+// Problem is the object of the image which is missing or corrupted in the backend
+type Problem struct {
+	ImageID uuid.UUID
+	Key     string
+	Variant string
+	// Backend is the tier and the replica which keeps the object, e.g. "hot/secondary",
+	// it is empty when the storage has a single backend
+	Backend string
+}
+
+// Report describes checked objects, every copy of the object is counted separately.
+// Objects of images stored before checksums were introduced are only checked to exist and counted as unverified
+type Report struct {
+	Images     int
+	Objects    int
+	Verified   int
+	Unverified int
+	Missing    []Problem
+	Corrupted  []Problem
+	// Failed objects couldn't be read, e.g. the storage is unavailable
+	Failed int
+}
+
+// Scrubber checks objects of images, it only reads them, so scrubs of several instances could overlap
+type Scrubber struct {
+	log    logger.Logger
+	repo   repository.Repository
+	bucket storage.Storage
+}
+
+// NewScrubber creates scrubber of objects of the bucket
+func NewScrubber(log logger.Logger, repo repository.Repository, bucket storage.Storage) *Scrubber {
+	return &Scrubber{
+		log:    log,
+		repo:   repo,
+		bucket: bucket,
+	}
+}
+
+// Scrub checks objects of all images, the error is returned only when images cannot be read
+func (s *Scrubber) Scrub(ctx context.Context) (Report, error) {
+	var (
+		report Report
+		lastID uuid.UUID
+	)
+
+	s.log.Infof("Started scrubbing of stored objects")
+
+	for {
+		images, err := s.repo.GetImagesAfter(lastID, batchSize)
+		if err != nil {
+			return report, err
+		}
+
+		for _, img := range images {
+			report.Images++
+			s.check(ctx, &report, img, img.OriginalKey, img.OriginalSHA256, storage.VariantOriginal)
+			s.check(ctx, &report, img, img.ResizedKey, img.ResizedSHA256, storage.VariantResized)
+		}
+
+		if len(images) < batchSize {
+			break
+		}
+		lastID = images[len(images)-1].ID
+	}
+
+	s.log.Infof("Finished scrubbing of stored objects: %d images, %d objects, %d verified, %d unverified, %d missing, %d corrupted, %d failed",
+		report.Images, report.Objects, report.Verified, report.Unverified, len(report.Missing), len(report.Corrupted), report.Failed)
+
+	return report, nil
+}
+
+// backend is the storage which keeps its own copy of objects
+type backend struct {
+	name   string
+	bucket storage.Storage
+}
+
+// backends returns the tier of the object and every replica of it, originals of cold images are in the cold tier
+func (s *Scrubber) backends(img models.Images, variant string) []backend {
+	var (
+		bucket = s.bucket
+		tier   string
+	)
+
+	if tiers, ok := storage.AsTiered(s.bucket); ok {
+		bucket, tier = tiers.Hot(), string(models.TierHot)
+		if variant == storage.VariantOriginal && img.Tier == models.TierCold {
+			bucket, tier = tiers.Cold(), string(models.TierCold)
+		}
+	}
+
+	replicas, ok := replicated.Find(bucket)
+	if !ok {
+		return []backend{{name: tier, bucket: bucket}}
+	}
+
+	return []backend{
+		{name: backendName(tier, "primary"), bucket: replicas.Unwrap()},
+		{name: backendName(tier, "secondary"), bucket: replicas.Secondary()},
+	}
+}
+
+func (s *Scrubber) check(ctx context.Context, report *Report, img models.Images, key, checksum, variant string) {
+	if key == "" {
+		return
+	}
+
+	for _, b := range s.backends(img, variant) {
+		s.checkBackend(ctx, report, b, img, key, checksum, variant)
+	}
+}
+
+func (s *Scrubber) checkBackend(ctx context.Context, report *Report, b backend, img models.Images, key, checksum, variant string) {
+	report.Objects++
+
+	var err error
+	if checksum == "" {
+		var exists bool
+		if exists, err = b.bucket.Exists(ctx, key); err == nil && !exists {
+			err = storage.ErrNotFound
+		}
+	} else {
+		_, err = storage.Download(ctx, b.bucket, key, checksum)
+	}
+
+	var (
+		problem = Problem{ImageID: img.ID, Key: key, Variant: variant, Backend: b.name}
+		where   string
+	)
+	if b.name != "" {
+		where = " in " + b.name
+	}
+
+	switch err {
+	case nil:
+		if checksum == "" {
+			report.Unverified++
+		} else {
+			report.Verified++
+		}
+	case storage.ErrNotFound:
+		s.log.Errorf("%s %q of image %s is missing%s", variant, key, img.ID, where)
+		report.Missing = append(report.Missing, problem)
+	case storage.ErrChecksumMismatch, encrypted.ErrCorrupted:
+		s.log.Errorf("%s %q of image %s is corrupted%s", variant, key, img.ID, where)
+		report.Corrupted = append(report.Corrupted, problem)
+	default:
+		s.log.Errorf("cannot check %s %q of image %s%s due to: %s", variant, key, img.ID, where, err)
+		report.Failed++
+	}
+}
+
+// backendName joins names of the tier and the replica, the tier is empty when the storage isn't tiered
+func backendName(tier, replica string) string {
+	if tier == "" {
+		return replica
+	}
+	return tier + "/" + replica
+}
@@ -0,0 +1,145 @@
+package scrub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+	"github.com/Dimitriy14/image-resizing/storage/tiered"
+)
+
+func TestScrubber_Scrub(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	var (
+		ctx      = context.Background()
+		content  = []byte("content")
+		checksum = storage.Checksum(content)
+		verified = models.Images{ID: uuid.New(), OriginalKey: "pictures/1.png", ResizedKey: "pictures/2.png", OriginalSHA256: checksum, ResizedSHA256: checksum}
+		legacy   = models.Images{ID: uuid.New(), OriginalKey: "pictures/3.png", ResizedKey: "pictures/4.png"}
+		broken   = models.Images{ID: uuid.New(), OriginalKey: "pictures/5.png", ResizedKey: "pictures/6.png", OriginalSHA256: checksum, ResizedSHA256: checksum}
+	)
+
+	testCases := []struct {
+		name      string
+		images    []models.Images
+		getErr    error
+		failKey   string
+		expReport Report
+		expErr    bool
+	}{
+		{
+			name:      "Good case",
+			images:    []models.Images{verified, legacy},
+			expReport: Report{Images: 2, Objects: 4, Verified: 2, Unverified: 2},
+		},
+		{
+			name:   "Broken objects case",
+			images: []models.Images{verified, broken},
+			expReport: Report{
+				Images:    2,
+				Objects:   4,
+				Verified:  2,
+				Missing:   []Problem{{ImageID: broken.ID, Key: broken.ResizedKey, Variant: storage.VariantResized}},
+				Corrupted: []Problem{{ImageID: broken.ID, Key: broken.OriginalKey, Variant: storage.VariantOriginal}},
+			},
+		},
+		{
+			name:      "Storage error case",
+			images:    []models.Images{verified},
+			failKey:   verified.OriginalKey,
+			expReport: Report{Images: 1, Objects: 2, Verified: 1, Failed: 1},
+		},
+		{
+			name:   "Getting images error case",
+			getErr: errors.New("ERROR"),
+			expErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				repo   = mocks.NewMockRepository(ctrl)
+				bucket = memory.NewStorage("http://localhost/files")
+			)
+
+			for _, key := range []string{verified.OriginalKey, verified.ResizedKey, legacy.OriginalKey, legacy.ResizedKey} {
+				assert.NoError(t, storage.Upload(ctx, bucket, storage.Object{Key: key, Content: content}))
+			}
+			assert.NoError(t, storage.Upload(ctx, bucket, storage.Object{Key: broken.OriginalKey, Content: []byte("corrupted")}))
+
+			bucket.InjectFailure(func(_ memory.Op, key string) error {
+				if key == tc.failKey {
+					return errors.New("STORAGE ERROR")
+				}
+				return nil
+			})
+
+			repo.EXPECT().GetImagesAfter(uuid.Nil, batchSize).Return(tc.images, tc.getErr)
+
+			report, err := NewScrubber(log, repo, bucket).Scrub(ctx)
+			assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+			assert.Equal(t, tc.expReport, report)
+		})
+	}
+}
+
+// TestScrubber_Scrub_Backends checks that copies missing in one backend aren't hidden by reads of another one
+func TestScrubber_Scrub_Backends(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		ctx       = context.Background()
+		content   = []byte("content")
+		checksum  = storage.Checksum(content)
+		primary   = memory.NewStorage("http://localhost/files")
+		secondary = memory.NewStorage("http://localhost/secondary")
+		cold      = memory.NewStorage("http://localhost/files-cold")
+		repo      = mocks.NewMockRepository(ctrl)
+		hotImg    = models.Images{ID: uuid.New(), OriginalKey: "pictures/1.png", ResizedKey: "pictures/2.png", OriginalSHA256: checksum, ResizedSHA256: checksum, Tier: models.TierHot}
+		coldImg   = models.Images{ID: uuid.New(), OriginalKey: "pictures/3.png", ResizedKey: "pictures/4.png", OriginalSHA256: checksum, ResizedSHA256: checksum, Tier: models.TierCold}
+	)
+
+	replicas, err := replicated.NewStorage(log, primary, secondary, replicated.ModeSync)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, key := range []string{hotImg.OriginalKey, hotImg.ResizedKey, coldImg.ResizedKey} {
+		assert.NoError(t, storage.Upload(ctx, primary, storage.Object{Key: key, Content: content}))
+	}
+	assert.NoError(t, storage.Upload(ctx, secondary, storage.Object{Key: hotImg.OriginalKey, Content: []byte("corrupted")}))
+	assert.NoError(t, storage.Upload(ctx, secondary, storage.Object{Key: coldImg.ResizedKey, Content: content}))
+	assert.NoError(t, storage.Upload(ctx, cold, storage.Object{Key: coldImg.OriginalKey, Content: content}))
+
+	repo.EXPECT().GetImagesAfter(uuid.Nil, batchSize).Return([]models.Images{hotImg, coldImg}, nil)
+
+	report, err := NewScrubber(log, repo, tiered.NewStorage(replicas, cold)).Scrub(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Report{
+		Images:    2,
+		Objects:   7,
+		Verified:  5,
+		Missing:   []Problem{{ImageID: hotImg.ID, Key: hotImg.ResizedKey, Variant: storage.VariantResized, Backend: "hot/secondary"}},
+		Corrupted: []Problem{{ImageID: hotImg.ID, Key: hotImg.OriginalKey, Variant: storage.VariantOriginal, Backend: "hot/secondary"}},
+	}, report)
+}
@@ -3,15 +3,16 @@ package common
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 
 	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
 )
 
 type UserKey string
@@ -58,6 +59,12 @@ func RenderJSON(w http.ResponseWriter, response interface{}) {
 	render(w, http.StatusOK, data)
 }
 
+// RenderNoContent is used for responding without body, e.g. when resource has been deleted
+func RenderNoContent(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func render(w http.ResponseWriter, code int, response []byte) {
 	w.Header().Set(contentType, applicationJSON)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -125,6 +132,20 @@ func SendInternalServerError(w http.ResponseWriter, message string, err error) {
 	SendError(w, http.StatusInternalServerError, message, err)
 }
 
+// SendServerError sends Service Unavailable when the storage fails fast and Internal Server Error otherwise
+func SendServerError(w http.ResponseWriter, message string, err error) {
+	SendError(w, ServerErrorStatus(err), message, err)
+}
+
+// ServerErrorStatus returns the status of the failure which isn't caused by the client,
+// the client could retry the request later when the storage is unavailable
+func ServerErrorStatus(err error) int {
+	if errors.Cause(err) == storage.ErrUnavailable {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 // GetUserIDFromCtx retrieves user id from context
 func GetUserIDFromCtx(ctx context.Context) uuid.UUID {
 	id, ok := ctx.Value(UserID).(uuid.UUID)
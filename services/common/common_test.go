@@ -13,8 +13,10 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/google/uuid"
+	pkgerrors "github.com/pkg/errors"
 
 	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
 )
 
 func init() {
@@ -234,6 +236,28 @@ func TestSendNotFound(t *testing.T) {
 	}
 }
 
+func TestSendServerError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		expCode int
+	}{
+		{"Unavailable storage", storage.ErrUnavailable, http.StatusServiceUnavailable},
+		{"Wrapped unavailable storage", pkgerrors.Wrap(storage.ErrUnavailable, "cannot upload"), http.StatusServiceUnavailable},
+		{"Other error", errors.New("error"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rr = httptest.NewRecorder()
+			SendServerError(rr, "message", test.err)
+
+			assert.Equal(t, test.expCode, rr.Code)
+			assert.JSONEq(t, `{"error":{"message":"message"}}`, rr.Body.String())
+		})
+	}
+}
+
 func Test_CloseWithErrCheck(t *testing.T) {
 
 	tests := []struct {
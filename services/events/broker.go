@@ -0,0 +1,162 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+const subscriberBuffer = 16
+
+// Message is an event numbered by broker, the number is used as SSE event id
+type Message struct {
+	ID    uint64
+	Event models.Event
+}
+
+// Broker keeps the last events of every user and fans them out to subscribers
+type Broker interface {
+	Notify(event models.Event)
+	// Subscribe returns buffered messages newer than lastEventID and a channel with the next ones,
+	// the channel is closed when subscriber is too slow or cancel is called
+	Subscribe(userID uuid.UUID, lastEventID uint64) (backlog []Message, messages <-chan Message, cancel func())
+}
+
+// NewBroker creates broker which keeps up to bufferSize last events of every user,
+// events of users without subscribers are dropped when no new ones come within replayWindow
+func NewBroker(bufferSize int, replayWindow time.Duration) Broker {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	if replayWindow <= 0 {
+		replayWindow = defaultReplayWindow
+	}
+
+	return &brokerImpl{
+		bufferSize:   bufferSize,
+		replayWindow: replayWindow,
+		now:          time.Now,
+		buffers:      make(map[uuid.UUID]*buffer),
+		subscribers:  make(map[uuid.UUID]map[chan Message]struct{}),
+	}
+}
+
+type brokerImpl struct {
+	mu           sync.Mutex
+	seq          uint64
+	bufferSize   int
+	replayWindow time.Duration
+	now          func() time.Time
+	lastSweep    time.Time
+	buffers      map[uuid.UUID]*buffer
+	subscribers  map[uuid.UUID]map[chan Message]struct{}
+}
+
+type buffer struct {
+	messages []Message
+	updated  time.Time
+}
+
+// Notify stores image events and sends them to the subscribers of the user, other events are ignored
+func (b *brokerImpl) Notify(event models.Event) {
+	switch event.Type {
+	case models.EventImageCreated, models.EventImageResized, models.EventImageDeleted:
+	default:
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	b.seq++
+	msg := Message{ID: b.seq, Event: event}
+
+	buf, ok := b.buffers[event.UserID]
+	if !ok {
+		buf = &buffer{}
+		b.buffers[event.UserID] = buf
+	}
+	buf.messages = append(buf.messages, msg)
+	if len(buf.messages) > b.bufferSize {
+		buf.messages = buf.messages[len(buf.messages)-b.bufferSize:]
+	}
+	buf.updated = now
+
+	for sub := range b.subscribers[event.UserID] {
+		select {
+		case sub <- msg:
+		default:
+			// slow subscriber is disconnected, it can resume with Last-Event-ID
+			b.unsubscribe(event.UserID, sub)
+		}
+	}
+}
+
+func (b *brokerImpl) Subscribe(userID uuid.UUID, lastEventID uint64) ([]Message, <-chan Message, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(b.now())
+
+	var backlog []Message
+	if buf, ok := b.buffers[userID]; ok && lastEventID > 0 {
+		for _, msg := range buf.messages {
+			if msg.ID > lastEventID {
+				backlog = append(backlog, msg)
+			}
+		}
+	}
+
+	sub := make(chan Message, subscriberBuffer)
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan Message]struct{})
+	}
+	b.subscribers[userID][sub] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.unsubscribe(userID, sub)
+	}
+
+	return backlog, sub, cancel
+}
+
+// sweep drops buffers of users without subscribers which are idle longer than the replay window,
+// it walks all buffers at most once per window and must be called with locked mutex
+func (b *brokerImpl) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < b.replayWindow {
+		return
+	}
+	b.lastSweep = now
+
+	for userID, buf := range b.buffers {
+		if _, ok := b.subscribers[userID]; !ok && now.Sub(buf.updated) >= b.replayWindow {
+			delete(b.buffers, userID)
+		}
+	}
+}
+
+// unsubscribe must be called with locked mutex
+func (b *brokerImpl) unsubscribe(userID uuid.UUID, sub chan Message) {
+	subs, ok := b.subscribers[userID]
+	if !ok {
+		return
+	}
+
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub)
+	if len(subs) == 0 {
+		delete(b.subscribers, userID)
+	}
+}
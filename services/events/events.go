@@ -0,0 +1,135 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/services/common"
+)
+
+const (
+	headerLastEventID = "Last-Event-ID"
+	// queryLastEventID is used by EventSource polyfills which cannot set headers
+	queryLastEventID = "lastEventId"
+
+	defaultBufferSize   = 100
+	defaultReplayWindow = 5 * time.Minute
+	defaultKeepAlive    = 15 * time.Second
+)
+
+// Service streams users image events as Server-Sent Events
+type Service interface {
+	Stream(w http.ResponseWriter, r *http.Request)
+}
+
+// NewService creates new service
+func NewService(log logger.Logger, broker Broker) Service {
+	keepAlive := time.Duration(config.Conf.SSEKeepAliveSec) * time.Second
+	if keepAlive <= 0 {
+		keepAlive = defaultKeepAlive
+	}
+
+	return &serviceImpl{
+		log:       log,
+		broker:    broker,
+		keepAlive: keepAlive,
+	}
+}
+
+type serviceImpl struct {
+	log       logger.Logger
+	broker    Broker
+	keepAlive time.Duration
+}
+
+func (s *serviceImpl) Stream(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.log.Errorf("streaming is not supported by response writer")
+		common.SendInternalServerError(w, "streaming is not supported", nil)
+		return
+	}
+
+	lastEventID, err := getLastEventID(r)
+	if err != nil {
+		s.log.Errorf("cannot parse last event id due to: %s", err)
+		common.SendError(w, http.StatusBadRequest, "invalid last event id", err)
+		return
+	}
+
+	backlog, messages, cancel := s.broker.Subscribe(uid, lastEventID)
+	defer cancel()
+
+	s.log.Debugf("Started streaming events for user %q from event %d", uid, lastEventID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, msg := range backlog {
+		if err := writeMessage(w, msg); err != nil {
+			s.log.Debugf("Stopped streaming events for user %q: %s", uid, err)
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(s.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			s.log.Debugf("Client of user %q has disconnected from events stream", uid)
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				s.log.Warnf("Events stream of user %q has been closed by broker", uid)
+				return
+			}
+			if err := writeMessage(w, msg); err != nil {
+				s.log.Debugf("Stopped streaming events for user %q: %s", uid, err)
+				return
+			}
+		case <-ticker.C:
+			// comment line keeps proxies from closing idle connection
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeMessage(w http.ResponseWriter, msg Message) error {
+	data, err := json.Marshal(msg.Event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", msg.ID, msg.Event.Type, data)
+	return err
+}
+
+func getLastEventID(r *http.Request) (uint64, error) {
+	id := r.Header.Get(headerLastEventID)
+	if id == "" {
+		id = r.URL.Query().Get(queryLastEventID)
+	}
+
+	if id == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(id, 10, 64)
+}
@@ -0,0 +1,167 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/services/common"
+)
+
+func TestBrokerImpl_Subscribe(t *testing.T) {
+	var (
+		b     = NewBroker(2, time.Minute)
+		user  = uuid.New()
+		other = uuid.New()
+	)
+
+	b.Notify(models.NewEvent(models.EventImageCreated, user, nil))
+	b.Notify(models.NewEvent(models.EventJobFailed, user, nil))
+	b.Notify(models.NewEvent(models.EventImageResized, other, nil))
+	b.Notify(models.NewEvent(models.EventImageResized, user, nil))
+	b.Notify(models.NewEvent(models.EventImageDeleted, user, nil))
+
+	backlog, _, cancel := b.Subscribe(user, 0)
+	assert.Empty(t, backlog, "backlog should be empty without last event id")
+	cancel()
+
+	backlog, _, cancel = b.Subscribe(user, 1)
+	defer cancel()
+	if assert.Len(t, backlog, 2, "backlog should be bounded by buffer size") {
+		assert.Equal(t, models.EventImageResized, backlog[0].Event.Type)
+		assert.Equal(t, uint64(3), backlog[0].ID)
+		assert.Equal(t, models.EventImageDeleted, backlog[1].Event.Type)
+		assert.Equal(t, uint64(4), backlog[1].ID)
+	}
+
+	backlog, messages, cancelUser := b.Subscribe(user, 4)
+	assert.Empty(t, backlog, "backlog should be empty when client is up to date")
+
+	b.Notify(models.NewEvent(models.EventImageCreated, other, nil))
+	b.Notify(models.NewEvent(models.EventImageCreated, user, nil))
+
+	msg := <-messages
+	assert.Equal(t, uint64(6), msg.ID, "only events of subscribed user should be received")
+
+	cancelUser()
+	_, ok := <-messages
+	assert.False(t, ok, "channel should be closed after cancel")
+	cancelUser()
+}
+
+func TestBrokerImpl_Sweep(t *testing.T) {
+	var (
+		b          = NewBroker(10, time.Minute).(*brokerImpl)
+		now        = time.Now()
+		idle       = uuid.New()
+		subscribed = uuid.New()
+		active     = uuid.New()
+	)
+	b.now = func() time.Time { return now }
+
+	b.Notify(models.NewEvent(models.EventImageCreated, idle, nil))
+	b.Notify(models.NewEvent(models.EventImageCreated, subscribed, nil))
+	_, _, cancel := b.Subscribe(subscribed, 0)
+	defer cancel()
+
+	now = now.Add(30 * time.Second)
+	b.Notify(models.NewEvent(models.EventImageCreated, active, nil))
+	assert.Len(t, b.buffers, 3, "buffers shouldn't be dropped within replay window")
+
+	now = now.Add(45 * time.Second)
+	b.Notify(models.NewEvent(models.EventImageResized, active, nil))
+	assert.NotContains(t, b.buffers, idle, "idle buffer without subscribers should be dropped")
+	assert.Contains(t, b.buffers, subscribed, "buffer with subscribers should be kept")
+	assert.Contains(t, b.buffers, active, "buffer updated within replay window should be kept")
+
+	backlog, _, cancelIdle := b.Subscribe(idle, 1)
+	defer cancelIdle()
+	assert.Empty(t, backlog, "backlog of dropped buffer should be empty")
+}
+
+func TestServiceImpl_Stream(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	var (
+		user   = uuid.New()
+		broker = NewBroker(10, time.Minute)
+		s      = NewService(log, broker)
+	)
+
+	broker.Notify(models.NewEvent(models.EventImageCreated, user, nil))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Stream(w, r.WithContext(context.WithValue(r.Context(), common.UserID, user)))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("cannot create request: %s", err)
+	}
+	req.Header.Set(headerLastEventID, "0")
+	req = req.WithContext(ctx)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot connect to stream: %s", err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		broker.Notify(models.NewEvent(models.EventImageResized, user, nil))
+	}()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "id: ") || strings.HasPrefix(line, "event: ") {
+				got = append(got, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event hasn't been streamed, got: %v", got)
+		}
+	}
+
+	assert.Equal(t, []string{"id: 2", "event: image.resized"}, got, "only events after Last-Event-ID should be streamed")
+}
+
+func TestServiceImpl_StreamInvalidLastEventID(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	s := NewService(log, NewBroker(1, time.Minute))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://foo?lastEventId=abc", nil)
+	s.Stream(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "unexpected status code")
+}
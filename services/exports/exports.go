@@ -0,0 +1,227 @@
+package exports
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/exports"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	queryExpires   = "expires"
+	querySignature = "signature"
+)
+
+// Service provides functionality to export all users images as a single archive
+type Service interface {
+	CreateExport(w http.ResponseWriter, r *http.Request)
+	GetExports(w http.ResponseWriter, r *http.Request)
+	GetExport(w http.ResponseWriter, r *http.Request)
+	DownloadExport(w http.ResponseWriter, r *http.Request)
+}
+
+// ExportRequest contains kinds of files which should be included into archive, all of them by default
+type ExportRequest struct {
+	Include []models.ExportContent `json:"include"`
+}
+
+// NewService creates new service
+func NewService(log logger.Logger, repo repository.ExportRepository, exporter exports.Exporter, signer *exports.Signer, bucket storage.Storage) Service {
+	return &serviceImpl{
+		log:      log,
+		repo:     repo,
+		exporter: exporter,
+		signer:   signer,
+		bucket:   bucket,
+	}
+}
+
+type serviceImpl struct {
+	log      logger.Logger
+	repo     repository.ExportRepository
+	exporter exports.Exporter
+	signer   *exports.Signer
+	bucket   storage.Storage
+}
+
+func (s *serviceImpl) CreateExport(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		req ExportRequest
+	)
+
+	s.log.Debugf("Started creating export for user %q", uid)
+
+	// body is optional
+	if err := common.ReadRequestJSONBodyToStruct(r, &req); err != nil && err != io.EOF {
+		s.log.Errorf("cannot extract data from request due to: %s", err)
+		common.SendError(w, http.StatusBadRequest, "invalid input data", err)
+		return
+	}
+
+	if len(req.Include) == 0 {
+		req.Include = []models.ExportContent{models.ExportOriginals, models.ExportResized}
+	}
+
+	include := make([]string, 0, len(req.Include))
+	for _, c := range req.Include {
+		if !c.IsValid() {
+			err := fmt.Errorf("unknown export content %q", c)
+			common.SendError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		include = append(include, string(c))
+	}
+
+	export, err := s.repo.SaveExport(models.Export{
+		ID:      uuid.New(),
+		UserID:  uid,
+		Include: include,
+		Status:  models.ExportPending,
+	})
+	if err != nil {
+		s.log.Errorf("cannot save export due to: %s", err)
+		common.SendInternalServerError(w, "cannot save export", err)
+		return
+	}
+
+	s.exporter.Enqueue(export)
+
+	s.log.Debugf("Export %q has been queued for user %q", export.ID, uid)
+
+	common.RenderJSONCreated(w, &export)
+}
+
+func (s *serviceImpl) GetExports(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	exports, err := s.repo.GetExports(uid)
+	if err != nil {
+		s.log.Errorf("cannot retrieve exports due to: %s", err)
+		common.SendInternalServerError(w, "cannot retrieve exports", err)
+		return
+	}
+
+	for i := range exports {
+		s.addLink(r, &exports[i])
+	}
+
+	common.RenderJSON(w, exports)
+}
+
+func (s *serviceImpl) GetExport(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		id  = mux.Vars(r)["id"]
+	)
+
+	exportID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse export id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid export id", err)
+		return
+	}
+
+	export, err := s.repo.GetExportByID(uid, exportID)
+	if err != nil {
+		s.sendRetrievalError(w, exportID, err)
+		return
+	}
+
+	s.addLink(r, &export)
+
+	common.RenderJSON(w, &export)
+}
+
+// DownloadExport serves the archive to anyone who has a valid signed link, user id isn't required
+func (s *serviceImpl) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	exportID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse export id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid export id", err)
+		return
+	}
+
+	query := r.URL.Query()
+	if err = s.signer.Verify(exportID, query.Get(queryExpires), query.Get(querySignature)); err != nil {
+		common.SendError(w, http.StatusForbidden, err.Error(), err)
+		return
+	}
+
+	export, err := s.repo.GetExport(exportID)
+	if err != nil {
+		s.sendRetrievalError(w, exportID, err)
+		return
+	}
+
+	if export.Status != models.ExportCompleted {
+		common.SendConflictError(w, fmt.Sprintf("export is %s", export.Status))
+		return
+	}
+
+	content, err := s.bucket.Get(r.Context(), export.Archive)
+	if err != nil {
+		s.log.Errorf("cannot download archive of export %q due to: %s", exportID, err)
+		common.SendServerError(w, "cannot download archive", err)
+		return
+	}
+	defer common.CloseWithErrCheck(content, export.Archive)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "export-"+export.ID.String()+".zip"))
+	w.WriteHeader(http.StatusOK)
+	if _, err = io.Copy(w, content); err != nil {
+		s.log.Debugf("Failed to Write archive of export %q (err: %v)", exportID, err)
+	}
+}
+
+// addLink adds signed download link to completed export, the link points to the host which served the request
+func (s *serviceImpl) addLink(r *http.Request, export *models.Export) {
+	if export.Status != models.ExportCompleted {
+		return
+	}
+
+	expires, signature := s.signer.Sign(export.ID)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	link := url.URL{
+		Scheme: scheme,
+		Host:   r.Host,
+		Path:   fmt.Sprintf("%s/v1/exports/%s/download", config.Conf.BasePath, export.ID),
+		RawQuery: url.Values{
+			queryExpires:   {fmt.Sprint(expires.Unix())},
+			querySignature: {signature},
+		}.Encode(),
+	}
+
+	export.DownloadURL = link.String()
+	export.LinkExpires = &expires
+}
+
+func (s *serviceImpl) sendRetrievalError(w http.ResponseWriter, exportID uuid.UUID, err error) {
+	if gorm.IsRecordNotFoundError(err) {
+		common.SendNotFound(w, "export id is not found: %s", err)
+		return
+	}
+
+	s.log.Errorf("cannot retrieve export %q due to: %s", exportID, err)
+	common.SendInternalServerError(w, "cannot retrieve export due to db problems", err)
+}
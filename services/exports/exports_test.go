@@ -0,0 +1,257 @@
+package exports
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/exports"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+)
+
+func TestNewService(t *testing.T) {
+	assert.NotNil(t, NewService(nil, nil, nil, nil, nil), "NewService shouldn't be nil")
+}
+
+func TestServiceImpl_CreateExport(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	testCases := []struct {
+		name       string
+		body       string
+		expCode    int
+		expInclude []string
+		saveErr    error
+	}{
+		{
+			name:       "Default content case",
+			expCode:    http.StatusCreated,
+			expInclude: []string{"originals", "resized"},
+		},
+		{
+			name:       "Only originals case",
+			body:       `{"include":["originals"]}`,
+			expCode:    http.StatusCreated,
+			expInclude: []string{"originals"},
+		},
+		{
+			name:    "Unknown content case",
+			body:    `{"include":["thumbnails"]}`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Invalid body case",
+			body:    `invalid body`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Saving error case",
+			expCode:    http.StatusInternalServerError,
+			expInclude: []string{"originals", "resized"},
+			saveErr:    errors.New("ERROR"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repo := mocks.NewMockExportRepository(ctrl)
+			exporter := mocks.NewMockExporter(ctrl)
+
+			repo.EXPECT().SaveExport(gomock.Any()).DoAndReturn(func(e models.Export) (models.Export, error) {
+				assert.Equal(t, tc.expInclude, []string(e.Include))
+				assert.Equal(t, models.ExportPending, e.Status)
+				return e, tc.saveErr
+			}).AnyTimes()
+
+			if tc.expCode == http.StatusCreated {
+				exporter.EXPECT().Enqueue(gomock.Any())
+			}
+
+			s := NewService(log, repo, exporter, nil, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "http://foo", bytes.NewBufferString(tc.body))
+			rr := httptest.NewRecorder()
+			s.CreateExport(rr, req)
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+		})
+	}
+}
+
+func TestServiceImpl_GetExport(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	signer, err := exports.NewSigner("key", time.Minute)
+	if err != nil {
+		t.Fatalf("cannot create signer: %s", err)
+	}
+
+	testCases := []struct {
+		name    string
+		id      string
+		status  models.ExportStatus
+		getErr  error
+		expCode int
+		expLink bool
+	}{
+		{
+			name:    "Completed case",
+			id:      uuid.New().String(),
+			status:  models.ExportCompleted,
+			expCode: http.StatusOK,
+			expLink: true,
+		},
+		{
+			name:    "Pending case",
+			id:      uuid.New().String(),
+			status:  models.ExportPending,
+			expCode: http.StatusOK,
+		},
+		{
+			name:    "Invalid id case",
+			id:      "invalid",
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Not found case",
+			id:      uuid.New().String(),
+			getErr:  gorm.ErrRecordNotFound,
+			expCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repo := mocks.NewMockExportRepository(ctrl)
+			repo.EXPECT().GetExportByID(gomock.Any(), gomock.Any()).DoAndReturn(func(_, id uuid.UUID) (models.Export, error) {
+				return models.Export{ID: id, Status: tc.status}, tc.getErr
+			}).AnyTimes()
+
+			s := NewService(log, repo, nil, signer, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "http://foo", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tc.id})
+			rr := httptest.NewRecorder()
+			s.GetExport(rr, req)
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+			if tc.expCode != http.StatusOK {
+				return
+			}
+
+			var export models.Export
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+			assert.Equal(t, tc.expLink, export.DownloadURL != "", "unexpected download link")
+			if !tc.expLink {
+				return
+			}
+
+			link, err := url.Parse(export.DownloadURL)
+			assert.NoError(t, err)
+			assert.Equal(t, "/v1/exports/"+tc.id+"/download", link.Path)
+			assert.NoError(t, signer.Verify(export.ID, link.Query().Get(queryExpires), link.Query().Get(querySignature)))
+		})
+	}
+}
+
+func TestServiceImpl_DownloadExport(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	signer, err := exports.NewSigner("key", time.Minute)
+	if err != nil {
+		t.Fatalf("cannot create signer: %s", err)
+	}
+
+	exportID := uuid.New()
+	expires, signature := signer.Sign(exportID)
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{
+		queryExpires:   {unix},
+		querySignature: {signature},
+	}
+
+	testCases := []struct {
+		name        string
+		query       string
+		status      models.ExportStatus
+		downloadErr error
+		expCode     int
+	}{
+		{
+			name:    "Good case",
+			query:   query.Encode(),
+			status:  models.ExportCompleted,
+			expCode: http.StatusOK,
+		},
+		{
+			name:    "Invalid signature case",
+			query:   queryExpires + "=" + unix + "&" + querySignature + "=forged",
+			status:  models.ExportCompleted,
+			expCode: http.StatusForbidden,
+		},
+		{
+			name:    "Not completed case",
+			query:   query.Encode(),
+			status:  models.ExportRunning,
+			expCode: http.StatusConflict,
+		},
+		{
+			name:        "Download error case",
+			query:       query.Encode(),
+			status:      models.ExportCompleted,
+			downloadErr: errors.New("ERROR"),
+			expCode:     http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			repo := mocks.NewMockExportRepository(ctrl)
+			bucket := mocks.NewMockStorage(ctrl)
+
+			repo.EXPECT().GetExport(exportID).Return(models.Export{ID: exportID, Status: tc.status, Archive: "export.zip"}, nil).AnyTimes()
+			bucket.EXPECT().Get(gomock.Any(), "export.zip").Return(ioutil.NopCloser(strings.NewReader("archive")), tc.downloadErr).AnyTimes()
+
+			s := NewService(log, repo, nil, signer, bucket)
+
+			req := httptest.NewRequest(http.MethodGet, "http://foo/v1/exports/"+exportID.String()+"/download?"+tc.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": exportID.String()})
+			rr := httptest.NewRecorder()
+			s.DownloadExport(rr, req)
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+			if tc.expCode == http.StatusOK {
+				assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+				assert.Equal(t, "archive", rr.Body.String())
+			}
+		})
+	}
+}
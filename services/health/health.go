@@ -0,0 +1,96 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/resilient"
+)
+
+const (
+	// StatusOK means that all dependencies are available
+	StatusOK = "ok"
+	// StatusDegraded means that some requests fail fast, e.g. storage circuit breaker is open
+	StatusDegraded = "degraded"
+)
+
+// Service reports the state of the service and its dependencies
+type Service interface {
+	Health(w http.ResponseWriter, r *http.Request)
+}
+
+// Report is the health output, storages are omitted when their calls aren't guarded by circuit breaker
+type Report struct {
+	Status  string            `json:"status"`
+	Storage *resilient.Health `json:"storage,omitempty"`
+	// SecondaryStorage is the storage where objects are replicated
+	SecondaryStorage *resilient.Health `json:"secondaryStorage,omitempty"`
+}
+
+// NewService creates new service
+func NewService(log logger.Logger, bucket storage.Storage) Service {
+	return &serviceImpl{
+		log:    log,
+		bucket: bucket,
+	}
+}
+
+type serviceImpl struct {
+	log    logger.Logger
+	bucket storage.Storage
+}
+
+// Health responds with 200 even when the service is degraded, since requests which don't touch the storage
+// are still served, the status should be checked by monitoring
+func (s *serviceImpl) Health(w http.ResponseWriter, r *http.Request) {
+	report := Report{Status: StatusOK}
+
+	report.Storage = storageHealth(s.bucket, &report)
+	if secondary, ok := findSecondary(s.bucket); ok {
+		report.SecondaryStorage = storageHealth(secondary, &report)
+	}
+
+	common.RenderJSON(w, report)
+}
+
+// storageHealth returns the state of the breaker of the storage and marks the report degraded when it isn't closed
+func storageHealth(bucket storage.Storage, report *Report) *resilient.Health {
+	reporter, ok := findReporter(bucket)
+	if !ok {
+		return nil
+	}
+
+	h := reporter.Health()
+	if h.State != resilient.StateClosed {
+		report.Status = StatusDegraded
+	}
+	return &h
+}
+
+type reporter interface {
+	Health() resilient.Health
+}
+
+type replicator interface {
+	Secondary() storage.Storage
+}
+
+func findSecondary(s storage.Storage) (storage.Storage, bool) {
+	for ; s != nil; s = storage.Unwrap(s) {
+		if r, ok := s.(replicator); ok {
+			return r.Secondary(), true
+		}
+	}
+	return nil, false
+}
+
+func findReporter(s storage.Storage) (reporter, bool) {
+	for ; s != nil; s = storage.Unwrap(s) {
+		if r, ok := s.(reporter); ok {
+			return r, true
+		}
+	}
+	return nil, false
+}
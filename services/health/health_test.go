@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/replicated"
+	"github.com/Dimitriy14/image-resizing/storage/resilient"
+)
+
+func TestServiceImpl_Health(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+	config.Conf.StorageRetryAttempts = 1
+	config.Conf.StorageBreakerThreshold = 1
+
+	testCases := []struct {
+		name      string
+		bucket    func() storage.Storage
+		expStatus string
+		expState  resilient.State
+		// expSecondaryState is the state of the breaker of the secondary storage, empty when it isn't replicated
+		expSecondaryState resilient.State
+	}{
+		{
+			name:      "Without breaker case",
+			bucket:    func() storage.Storage { return memory.NewStorage("http://localhost/files") },
+			expStatus: StatusOK,
+		},
+		{
+			name: "Closed breaker case",
+			bucket: func() storage.Storage {
+				return resilient.NewStorage(log, memory.NewStorage("http://localhost/files"), nil)
+			},
+			expStatus: StatusOK,
+			expState:  resilient.StateClosed,
+		},
+		{
+			name: "Open breaker case",
+			bucket: func() storage.Storage {
+				backend := memory.NewStorage("http://localhost/files")
+				backend.InjectFailure(func(memory.Op, string) error { return errors.New("ERROR") })
+
+				s := resilient.NewStorage(log, backend, nil)
+				s.Delete(context.Background(), "pictures/image.png")
+				return s
+			},
+			expStatus: StatusDegraded,
+			expState:  resilient.StateOpen,
+		},
+		{
+			name: "Open secondary breaker case",
+			bucket: func() storage.Storage {
+				backend := memory.NewStorage("http://localhost/secondary")
+				backend.InjectFailure(func(memory.Op, string) error { return errors.New("ERROR") })
+
+				secondary := resilient.NewStorage(log, backend, nil)
+				secondary.Delete(context.Background(), "pictures/image.png")
+
+				s, _ := replicated.NewStorage(log, resilient.NewStorage(log, memory.NewStorage("http://localhost/files"), nil), secondary, replicated.ModeSync)
+				return s
+			},
+			expStatus:         StatusDegraded,
+			expState:          resilient.StateClosed,
+			expSecondaryState: resilient.StateOpen,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			NewService(log, tc.bucket()).Health(rr, httptest.NewRequest(http.MethodGet, "http://foo/health", nil))
+
+			assert.Equal(t, http.StatusOK, rr.Code, "health should be served even when the service is degraded")
+
+			var report Report
+			if !assert.NoError(t, json.NewDecoder(rr.Body).Decode(&report)) {
+				return
+			}
+
+			assert.Equal(t, tc.expStatus, report.Status)
+			if tc.expState == "" {
+				assert.Nil(t, report.Storage, "storage without breaker shouldn't be reported")
+				return
+			}
+			if assert.NotNil(t, report.Storage) {
+				assert.Equal(t, tc.expState, report.Storage.State)
+			}
+
+			if tc.expSecondaryState == "" {
+				assert.Nil(t, report.SecondaryStorage, "not replicated storage shouldn't report the secondary")
+				return
+			}
+			if assert.NotNil(t, report.SecondaryStorage) {
+				assert.Equal(t, tc.expSecondaryState, report.SecondaryStorage.State)
+			}
+		})
+	}
+}
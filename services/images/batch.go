@@ -0,0 +1,274 @@
+package images
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/services/common"
+)
+
+const (
+	archive    = "archive"
+	formParams = "params"
+
+	defaultBatchConcurrency = 4
+	defaultBatchMaxItems    = 100
+)
+
+// BatchParams overrides shared resize parameters for a single file of the batch
+type BatchParams struct {
+	Width  uint `json:"width"`
+	Height uint `json:"height"`
+}
+
+// BatchItemResult is a result of processing one file of the batch
+type BatchItemResult struct {
+	Filename string         `json:"filename"`
+	Status   int            `json:"status"`
+	Image    *models.Images `json:"image,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// batchItem is opened only by the worker which processes it, so files are not kept in memory all together
+type batchItem struct {
+	filename string
+	params   models.ResizeParams
+	err      error
+	open     func() (io.ReadCloser, error)
+}
+
+func (s *serviceImpl) ResizeNewImages(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	s.log.Debugf("Started resizing batch of images for user %q", uid)
+
+	items, archives, err := extractBatchFormData(r)
+	defer closeArchives(archives)
+	if err != nil {
+		s.log.Errorf("cannot extract batch from request due to: %s", err)
+		common.SendError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	results := s.processBatch(r.Context(), uid, items)
+
+	status := http.StatusCreated
+	for _, result := range results {
+		if result.Status != http.StatusCreated {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+
+	s.log.Debugf("Finished resizing batch of %d images for user %q", len(items), uid)
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		common.SendInternalServerError(w, "failed to marshal response", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	if _, err = w.Write(data); err != nil {
+		s.log.Debugf("Failed to Write response (err: %v)", err)
+	}
+}
+
+// processBatch creates images with at most BatchConcurrency of them being processed at the same time
+func (s *serviceImpl) processBatch(ctx context.Context, uid uuid.UUID, items []batchItem) []BatchItemResult {
+	concurrency := config.Conf.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	var (
+		results = make([]BatchItemResult, len(items))
+		sem     = make(chan struct{}, concurrency)
+		wg      = new(sync.WaitGroup)
+	)
+
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, item batchItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[i] = s.processBatchItem(ctx, uid, item)
+		}(i, item)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func (s *serviceImpl) processBatchItem(ctx context.Context, uid uuid.UUID, item batchItem) BatchItemResult {
+	result := BatchItemResult{Filename: item.filename}
+
+	if item.err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = item.err.Error()
+		return result
+	}
+
+	content, err := readBatchItem(item)
+	if err != nil {
+		s.log.Errorf("cannot read %q of the batch due to: %s", item.filename, err)
+		result.Status = http.StatusBadRequest
+		result.Error = err.Error()
+		return result
+	}
+
+	img, msg, err := s.createImage(ctx, uid, content, item.filename, item.params)
+	if err != nil {
+		result.Status = common.ServerErrorStatus(err)
+		result.Error = msg
+		return result
+	}
+
+	result.Status = http.StatusCreated
+	result.Image = &img
+	return result
+}
+
+func readBatchItem(item batchItem) ([]byte, error) {
+	file, err := item.open()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %s", err)
+	}
+	defer common.CloseWithErrCheck(file, item.filename)
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image content: %s", err)
+	}
+
+	if len(content) > maxImageSize {
+		return nil, fmt.Errorf("image exceeds the limit of %d bytes", maxImageSize)
+	}
+
+	return content, nil
+}
+
+// extractBatchFormData collects files from image parts and ZIP archive parts of multipart form,
+// width and height fields are shared by all files, params field could override them per filename.
+// Returned archives stay open until the batch is processed.
+func extractBatchFormData(r *http.Request) (items []batchItem, archives []multipart.File, err error) {
+	err = r.ParseMultipartForm(int64(maxImageSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing multipart form error: %s", err)
+	}
+
+	perFile := make(map[string]BatchParams)
+	if raw := r.FormValue(formParams); raw != "" {
+		if err = json.Unmarshal([]byte(raw), &perFile); err != nil {
+			return nil, nil, fmt.Errorf("cannot parse params: %s", err)
+		}
+	}
+
+	shared, sharedErr := parseResizeParams(r.FormValue(formWidth), r.FormValue(formHeight))
+
+	add := func(filename string, open func() (io.ReadCloser, error)) {
+		item := batchItem{filename: filename, open: open, params: shared, err: sharedErr}
+		if p, ok := perFile[filename]; ok {
+			item.params = models.ResizeParams{With: p.Width, Height: p.Height}
+			item.err = nil
+		}
+		items = append(items, item)
+	}
+
+	for _, head := range r.MultipartForm.File[image] {
+		add(head.Filename, openFormFile(head))
+	}
+
+	for _, head := range r.MultipartForm.File[archive] {
+		file, err := addArchiveFiles(head, add)
+		if err != nil {
+			return nil, archives, fmt.Errorf("cannot read archive %q: %s", head.Filename, err)
+		}
+		archives = append(archives, file)
+	}
+
+	maxItems := config.Conf.BatchMaxItems
+	if maxItems <= 0 {
+		maxItems = defaultBatchMaxItems
+	}
+
+	switch {
+	case len(items) == 0:
+		return nil, archives, fmt.Errorf("batch doesn't contain any %q or %q files", image, archive)
+	case len(items) > maxItems:
+		return nil, archives, fmt.Errorf("batch contains %d files, the limit is %d", len(items), maxItems)
+	}
+
+	return items, archives, nil
+}
+
+func openFormFile(head *multipart.FileHeader) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return head.Open()
+	}
+}
+
+func addArchiveFiles(head *multipart.FileHeader, add func(string, func() (io.ReadCloser, error))) (multipart.File, error) {
+	file, err := head.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(file, head.Size)
+	if err != nil {
+		common.CloseWithErrCheck(file, head.Filename)
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isHiddenArchivePath(f.Name) {
+			continue
+		}
+
+		f := f
+		add(f.Name, func() (io.ReadCloser, error) {
+			if f.UncompressedSize64 > maxImageSize {
+				return nil, fmt.Errorf("image exceeds the limit of %d bytes", maxImageSize)
+			}
+			return f.Open()
+		})
+	}
+
+	return file, nil
+}
+
+func closeArchives(archives []multipart.File) {
+	for _, a := range archives {
+		common.CloseWithErrCheck(a, archive)
+	}
+}
+
+// isHiddenArchivePath skips metadata added by archivers, e.g. __MACOSX/ and ._ files
+func isHiddenArchivePath(name string) bool {
+	for _, part := range strings.Split(path.Clean(name), "/") {
+		if strings.HasPrefix(part, ".") || strings.HasPrefix(part, "__") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package images
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/services/common"
+)
+
+func TestServiceImpl_ResizeNewImages(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	config.Conf.BatchMaxItems = 4
+	defer func() { config.Conf.BatchMaxItems = 0 }()
+
+	testCases := []struct {
+		name       string
+		images     []string
+		archive    []string
+		broken     bool
+		width      string
+		height     string
+		params     string
+		expCode    int
+		expResults map[string]int
+	}{
+		{
+			name:    "Images and archive case",
+			images:  []string{"a.jpg", "b.jpg"},
+			archive: []string{"c.jpg", "dir/", "__MACOSX/._c.jpg", ".DS_Store"},
+			width:   "100",
+			height:  "100",
+			expCode: http.StatusCreated,
+			expResults: map[string]int{
+				"a.jpg": http.StatusCreated,
+				"b.jpg": http.StatusCreated,
+				"c.jpg": http.StatusCreated,
+			},
+		},
+		{
+			name:    "Per file params case",
+			images:  []string{"a.jpg", "b.jpg"},
+			params:  `{"a.jpg":{"width":100,"height":100},"b.jpg":{"width":100,"height":100}}`,
+			expCode: http.StatusCreated,
+			expResults: map[string]int{
+				"a.jpg": http.StatusCreated,
+				"b.jpg": http.StatusCreated,
+			},
+		},
+		{
+			name:    "Partial failure case",
+			images:  []string{"a.jpg", "b.jpg", "c.jpg"},
+			params:  `{"a.jpg":{"width":100,"height":100},"b.jpg":{"width":1,"height":1}}`,
+			expCode: http.StatusMultiStatus,
+			expResults: map[string]int{
+				"a.jpg": http.StatusCreated,
+				"b.jpg": http.StatusInternalServerError,
+				"c.jpg": http.StatusBadRequest,
+			},
+		},
+		{
+			name:    "Invalid params case",
+			images:  []string{"a.jpg"},
+			params:  `invalid`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Invalid archive case",
+			images:  []string{"a.jpg"},
+			broken:  true,
+			width:   "100",
+			height:  "100",
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Empty batch case",
+			width:   "100",
+			height:  "100",
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Too many files case",
+			images:  []string{"a.jpg", "b.jpg", "c.jpg"},
+			archive: []string{"d.jpg", "e.jpg"},
+			width:   "100",
+			height:  "100",
+			expCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			bucket := mocks.NewMockStorage(ctrl)
+			repo := mocks.NewMockRepository(ctrl)
+			resizer := mocks.NewMockResizer(ctrl)
+			publisher := mocks.NewMockPublisher(ctrl)
+
+			var created, failed int
+			for _, code := range tc.expResults {
+				switch code {
+				case http.StatusCreated:
+					created++
+				case http.StatusInternalServerError:
+					failed++
+				}
+			}
+
+			repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo)).AnyTimes()
+			repo.EXPECT().SaveImage(gomock.Any()).Return(models.Images{}, nil).Times(created)
+			repo.EXPECT().SaveEvent(gomock.Any()).Return(nil).Times(created)
+			publisher.EXPECT().Publish(gomock.Any()).Return(nil).Times(failed)
+			bucket.EXPECT().Put(gomock.Any(), hasExt(".jpg"), gomock.Any(), gomock.Any()).Return(nil).Times(2 * created)
+			bucket.EXPECT().URL(gomock.Any()).Return("").AnyTimes()
+			resizer.EXPECT().Resize(gomock.Any(), models.ResizeParams{With: 100, Height: 100}).Return([]byte{}, nil).Times(created)
+			resizer.EXPECT().Resize(gomock.Any(), models.ResizeParams{With: 1, Height: 1}).Return(nil, errors.New("RESIZE ERROR")).Times(failed)
+
+			s := NewService(log, bucket, repo, resizer, publisher, nil, nil)
+
+			req := newBatchRequest(t, tc.images, tc.archive, tc.broken, tc.width, tc.height, tc.params)
+			rr := httptest.NewRecorder()
+			s.ResizeNewImages(rr, req)
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+
+			if tc.expResults == nil {
+				return
+			}
+
+			var results []BatchItemResult
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results), "cannot unmarshal response")
+
+			got := make(map[string]int, len(results))
+			for _, result := range results {
+				got[result.Filename] = result.Status
+			}
+			assert.Equal(t, tc.expResults, got, "unexpected results")
+		})
+	}
+}
+
+func newBatchRequest(t *testing.T, images, archived []string, brokenArchive bool, width, height, params string) *http.Request {
+	buf := bytes.NewBuffer([]byte{})
+	mw := multipart.NewWriter(buf)
+
+	for _, name := range images {
+		if _, err := mw.CreateFormFile(image, name); err != nil {
+			t.Errorf("cannot create form file: %s", err)
+		}
+	}
+
+	if len(archived) != 0 || brokenArchive {
+		fw, err := mw.CreateFormFile(archive, "images.zip")
+		if err != nil {
+			t.Errorf("cannot create form file: %s", err)
+		}
+
+		if brokenArchive {
+			_, err = fw.Write([]byte("not a zip"))
+			assert.NoError(t, err)
+		} else {
+			zw := zip.NewWriter(fw)
+			for _, name := range archived {
+				if _, err = zw.Create(name); err != nil {
+					t.Errorf("cannot create archive file: %s", err)
+				}
+			}
+			common.CloseWithErrCheck(zw, "archive")
+		}
+	}
+
+	fields := map[string]string{formWidth: width, formHeight: height, formParams: params}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := mw.WriteField(name, value); err != nil {
+			t.Errorf("cannot write field: %s", err)
+		}
+	}
+
+	common.CloseWithErrCheck(mw, "multipart form")
+
+	req := httptest.NewRequest(http.MethodPost, "http://foo", buf)
+	req.Header.Add("Content-type", mw.FormDataContentType())
+	return req
+}
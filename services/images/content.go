@@ -0,0 +1,398 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// readAtPrecision limits how often the time when the original is read is updated
+const readAtPrecision = time.Hour
+
+// GetOriginal streams the original of the image, images of storages which don't share objects by links are served by it
+func (s *serviceImpl) GetOriginal(w http.ResponseWriter, r *http.Request) {
+	s.serveImage(w, r, storage.VariantOriginal)
+}
+
+// GetResized streams the resized image
+func (s *serviceImpl) GetResized(w http.ResponseWriter, r *http.Request) {
+	s.serveImage(w, r, storage.VariantResized)
+}
+
+func (s *serviceImpl) serveImage(w http.ResponseWriter, r *http.Request, variant string) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		id  = mux.Vars(r)["id"]
+	)
+
+	imageID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse image id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid image id", err)
+		return
+	}
+
+	img, err := s.repo.GetImageByID(uid, imageID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			common.SendNotFound(w, "image id is not found: %s", err)
+			return
+		}
+
+		s.log.Errorf("cannot retrieve image with id (%q) for user (%q) due to: %s", imageID, uid, err)
+		common.SendInternalServerError(w, "cannot retrieve image due to db problems", err)
+		return
+	}
+
+	key, checksum := img.OriginalKey, img.OriginalSHA256
+	if variant == storage.VariantResized {
+		key, checksum = img.ResizedKey, img.ResizedSHA256
+	}
+
+	info, err := s.bucket.Stat(r.Context(), key)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrNotFound {
+			common.SendNotFound(w, "%s image is not found", variant)
+			return
+		}
+
+		s.log.Errorf("cannot stat %q due to: %s", key, err)
+		common.SendServerError(w, "cannot download image", err)
+		return
+	}
+
+	if checksum == "" {
+		checksum = info.SHA256
+	}
+	if variant == storage.VariantOriginal {
+		s.markOriginalRead(img)
+	}
+
+	header := w.Header()
+	// the link of the image is kept when it is resized again, so clients revalidate it by the ETag
+	header.Set("Cache-Control", "private, no-cache")
+	if checksum != "" {
+		header.Set("ETag", strconv.Quote(checksum))
+
+		// revalidated images are answered without downloading the object
+		if notModified(r, header.Get("ETag"), info.LastModified) {
+			if !info.LastModified.IsZero() {
+				header.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	content := &objectReader{
+		ctx:      r.Context(),
+		bucket:   s.bucket,
+		key:      key,
+		checksum: checksum,
+		size:     info.Size,
+		ranges:   requestedRanges(r.Header.Get("Range"), info.Size),
+		header:   header,
+	}
+	defer content.Close()
+
+	// the object is requested before the response is written, so failures of the backend are reported by the status
+	if r.Header.Get("Range") == "" || len(content.ranges) > 0 {
+		content.offset = content.first()
+		if err = content.open(); err != nil {
+			s.log.Errorf("cannot download %q due to: %s", key, err)
+			if errors.Cause(err) == storage.ErrNotFound {
+				common.SendNotFound(w, "%s image is not found", variant)
+				return
+			}
+			common.SendServerError(w, "cannot download image", err)
+			return
+		}
+		content.offset = 0
+	}
+
+	if info.ContentType != "" {
+		header.Set("Content-Type", info.ContentType)
+	}
+	if info.ContentDisposition != "" {
+		header.Set("Content-Disposition", info.ContentDisposition)
+	}
+
+	// ServeContent answers conditional and range requests by the ETag and the modification time
+	http.ServeContent(w, r, "", info.LastModified, content)
+
+	if content.err != nil {
+		s.log.Errorf("cannot stream %q due to: %s", key, content.err)
+		// the status has been sent already, the connection is aborted, so the client doesn't take the partial
+		// or corrupted content for the image
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// markOriginalRead keeps the original which is being read in or moves it to the hot tier,
+// the time is updated once per readAtPrecision since tiering counts it in days
+func (s *serviceImpl) markOriginalRead(img models.Images) {
+	now := time.Now().UTC()
+	since := now.Add(-readAtPrecision)
+	if img.OriginalReadAt != nil && img.OriginalReadAt.After(since) {
+		return
+	}
+
+	if err := s.repo.MarkOriginalRead(img.ID, now, since); err != nil {
+		s.log.Errorf("cannot mark original of image %q as read due to: %s", img.ID, err)
+	}
+}
+
+// notModified reports whether the client has the current image, If-Modified-Since is used
+// only when If-None-Match is missing and ETags are compared weakly as RFC 7232 requires for GET
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
+
+// requestedRanges returns lengths of ranges of the Range header by their starts, so only them are read from
+// the backend. It is nil when the header is missing or invalid, http.ServeContent answers invalid ranges itself
+func requestedRanges(header string, size int64) map[int64]int64 {
+	const unit = "bytes="
+	if !strings.HasPrefix(header, unit) {
+		return nil
+	}
+
+	ranges := make(map[int64]int64)
+	for _, spec := range strings.Split(strings.TrimPrefix(header, unit), ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		var start, end int64
+		if first == "" {
+			// the suffix range of last bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil
+			}
+			if n > size {
+				n = size
+			}
+			start, end = size-n, size
+		} else {
+			var err error
+			if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+				return nil
+			}
+			end = size
+			if last != "" {
+				l, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || l < start {
+					return nil
+				}
+				if l < size {
+					end = l + 1
+				}
+			}
+		}
+
+		if start < size && end-start > ranges[start] {
+			ranges[start] = end - start
+		}
+	}
+	return ranges
+}
+
+// objectReader is the content of http.ServeContent which reads the object only at positions which are read.
+// The whole object is streamed and verified by the checksum, its last bytes are returned only when it matches,
+// so a corrupted image is never sent completely. Requested ranges are read from the backend without the rest
+// of the object and can't be verified
+type objectReader struct {
+	ctx      context.Context
+	bucket   storage.Storage
+	key      string
+	checksum string
+	size     int64
+	// ranges are lengths of requested ranges by their starts, they are dropped when the response
+	// is the whole object, e.g. If-Range doesn't match or ranges overlap
+	ranges map[int64]int64
+	// header is the header of the response, which tells whether ranges are served
+	header http.Header
+
+	// offset is the position of the reader, body is read from pos until end
+	offset int64
+	pos    int64
+	end    int64
+	body   io.ReadCloser
+	reader io.Reader
+	// verified is set when body is the whole object which is verified by the checksum
+	verified bool
+	// err is the failure of the backend or ErrChecksumMismatch
+	err error
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.ranges != nil && !servesRanges(o.header) {
+		o.ranges = nil
+	}
+
+	if err := o.open(); err != nil {
+		o.err = err
+		return 0, err
+	}
+
+	if o.verified && o.pos+int64(len(p)) >= o.size {
+		n, err := io.ReadFull(o.reader, p[:o.size-o.pos])
+		if err == nil {
+			err = o.verify()
+		}
+		if err != nil {
+			o.err = err
+			return 0, err
+		}
+
+		o.pos += int64(n)
+		o.offset = o.pos
+		return n, io.EOF
+	}
+
+	n, err := o.reader.Read(p)
+	o.pos += int64(n)
+	o.offset = o.pos
+	if err != nil && err != io.EOF {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("seek to negative position")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+
+	err := o.body.Close()
+	o.body, o.reader = nil, nil
+	return err
+}
+
+// first returns the position which is read first
+func (o *objectReader) first() int64 {
+	first := int64(-1)
+	for start := range o.ranges {
+		if first < 0 || start < first {
+			first = start
+		}
+	}
+	if first < 0 {
+		return 0
+	}
+	return first
+}
+
+// open requests the object at the offset unless it is being read there already, the body of a range
+// is read only while the range is served
+func (o *objectReader) open() error {
+	if o.body != nil && o.pos == o.offset && o.pos < o.end && (o.ranges != nil || o.end == o.size) {
+		return nil
+	}
+	o.Close()
+
+	length, ok := o.ranges[o.offset]
+	if !ok {
+		length = o.size - o.offset
+	}
+
+	var err error
+	if o.offset == 0 && length == o.size {
+		o.body, err = o.bucket.Get(o.ctx, o.key)
+		o.verified = o.checksum != ""
+	} else {
+		o.body, err = storage.GetRange(o.ctx, o.bucket, o.key, o.offset, length)
+		o.verified = false
+	}
+	if err != nil {
+		o.body = nil
+		return err
+	}
+
+	o.reader, o.pos, o.end = o.body, o.offset, o.offset+length
+	if o.verified {
+		o.reader = storage.Verify(o.body, o.checksum)
+	}
+	return nil
+}
+
+// servesRanges reports whether http.ServeContent answers by ranges, it sets Content-Range of the single range
+// or the multipart type before the content is read
+func servesRanges(header http.Header) bool {
+	return header.Get("Content-Range") != "" || strings.HasPrefix(header.Get("Content-Type"), "multipart/byteranges")
+}
+
+// verify reads the end of the verified object, so the checksum is compared
+func (o *objectReader) verify() error {
+	extra, err := ioutil.ReadAll(io.LimitReader(o.reader, 1))
+	if err == nil && len(extra) > 0 {
+		err = storage.ErrChecksumMismatch
+	}
+	return err
+}
+
+// link returns the link of the object given to clients, objects which the storage doesn't share by links
+// are linked to the API
+func link(bucket storage.Storage, imageID uuid.UUID, key, variant string) (string, error) {
+	l, err := storage.Link(bucket, key)
+	if errors.Cause(err) == storage.ErrNoLinks {
+		return apiLink(imageID, variant), nil
+	}
+	return l, err
+}
+
+// apiLink returns the path of the API which serves the image
+func apiLink(imageID uuid.UUID, variant string) string {
+	return fmt.Sprintf("%s/v1/images/%s/%s", config.Conf.BasePath, imageID, variant)
+}
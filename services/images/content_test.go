@@ -0,0 +1,329 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+)
+
+func TestServiceImpl_GetOriginal(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+	imgID, uid := uuid.New(), uuid.New()
+	recently := time.Now().UTC().Add(-time.Minute)
+
+	testCases := []struct {
+		name        string
+		id          string
+		resized     bool
+		stored      bool
+		checksum    string
+		readAt      *time.Time
+		getImageErr error
+		reqHeaders  map[string]string
+		expCode     int
+		expBody     string
+		expHeaders  map[string]string
+		expParts    []string
+		expAbort    bool
+	}{
+		{
+			name:    "Good case",
+			id:      imgID.String(),
+			stored:  true,
+			expCode: http.StatusOK,
+			expBody: "original",
+		},
+		{
+			name:    "Recently read case",
+			id:      imgID.String(),
+			stored:  true,
+			readAt:  &recently,
+			expCode: http.StatusOK,
+			expBody: "original",
+		},
+		{
+			name:    "Resized case",
+			id:      imgID.String(),
+			resized: true,
+			stored:  true,
+			expCode: http.StatusOK,
+			expBody: "resized",
+		},
+		{
+			name:     "Verified case",
+			id:       imgID.String(),
+			stored:   true,
+			checksum: storage.Checksum([]byte("original")),
+			expCode:  http.StatusOK,
+			expBody:  "original",
+		},
+		{
+			name:       "Not modified by ETag case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"If-None-Match": `"other", W/"` + storage.Checksum([]byte("original")) + `"`},
+			expCode:    http.StatusNotModified,
+			expHeaders: map[string]string{"ETag": `"` + storage.Checksum([]byte("original")) + `"`},
+		},
+		{
+			name:       "Changed ETag case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"If-None-Match": `"other"`},
+			expCode:    http.StatusOK,
+			expBody:    "original",
+		},
+		{
+			name:       "Not modified since case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			expCode:    http.StatusNotModified,
+		},
+		{
+			name:       "Modified since case",
+			id:         imgID.String(),
+			stored:     true,
+			reqHeaders: map[string]string{"If-Modified-Since": time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)},
+			expCode:    http.StatusOK,
+			expBody:    "original",
+			expHeaders: map[string]string{"ETag": `"` + storage.Checksum([]byte("original")) + `"`},
+		},
+		{
+			name:       "Range case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"Range": "bytes=2-5"},
+			expCode:    http.StatusPartialContent,
+			expBody:    "igin",
+			expHeaders: map[string]string{"Content-Range": "bytes 2-5/8", "Content-Type": "image/png"},
+		},
+		{
+			name:       "Several ranges case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"Range": "bytes=0-1,-2"},
+			expCode:    http.StatusPartialContent,
+			expParts:   []string{"Content-Range: bytes 0-1/8\r\nContent-Type: image/png\r\n\r\nor", "Content-Range: bytes 6-7/8\r\nContent-Type: image/png\r\n\r\nal"},
+		},
+		{
+			name:       "Stale If-Range case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`},
+			expCode:    http.StatusOK,
+			expBody:    "original",
+			expHeaders: map[string]string{"Content-Length": "8", "Content-Range": ""},
+		},
+		{
+			name:       "Overlapping ranges case",
+			id:         imgID.String(),
+			stored:     true,
+			checksum:   storage.Checksum([]byte("original")),
+			reqHeaders: map[string]string{"Range": "bytes=0-5,2-7"},
+			expCode:    http.StatusOK,
+			expBody:    "original",
+			expHeaders: map[string]string{"Content-Length": "8", "Content-Range": ""},
+		},
+		{
+			name:       "Unsatisfiable range case",
+			id:         imgID.String(),
+			stored:     true,
+			reqHeaders: map[string]string{"Range": "bytes=100-"},
+			expCode:    http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:     "Corrupted case",
+			id:       imgID.String(),
+			stored:   true,
+			checksum: storage.Checksum([]byte("other content")),
+			expCode:  http.StatusOK,
+			expAbort: true,
+		},
+		{
+			name:    "Invalid ID case",
+			id:      "invalid id",
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:        "Not found case",
+			id:          imgID.String(),
+			getImageErr: gorm.ErrRecordNotFound,
+			expCode:     http.StatusNotFound,
+		},
+		{
+			name:        "Getting image error case",
+			id:          imgID.String(),
+			getImageErr: errors.New("ERROR"),
+			expCode:     http.StatusInternalServerError,
+		},
+		{
+			name:    "Missing object case",
+			id:      imgID.String(),
+			expCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				bucket = memory.NewStorage("http://localhost/files")
+				repo   = mocks.NewMockRepository(ctrl)
+				img    = models.Images{ID: imgID, OriginalKey: "pictures/original.png", ResizedKey: "pictures/resized.png", OriginalSHA256: tc.checksum, OriginalReadAt: tc.readAt}
+			)
+
+			if tc.stored {
+				for key, content := range map[string]string{img.OriginalKey: "original", img.ResizedKey: "resized"} {
+					err := bucket.Put(context.Background(), key, bytes.NewBufferString(content), storage.PutOptions{ContentType: "image/png", Size: -1})
+					assert.NoError(t, err)
+				}
+			}
+			// images are looked up by the user of the request, so images of other users are not found
+			repo.EXPECT().GetImageByID(uid, imgID).Return(img, tc.getImageErr).AnyTimes()
+			// originals which are read are kept in the hot tier, the time is updated once per readAtPrecision
+			if tc.stored && !tc.resized && tc.readAt == nil {
+				repo.EXPECT().MarkOriginalRead(imgID, gomock.Any(), gomock.Any()).DoAndReturn(func(_ uuid.UUID, readAt, since time.Time) error {
+					assert.Equal(t, readAtPrecision, readAt.Sub(since), "unexpected throttling of read time")
+					return nil
+				})
+			}
+
+			s := NewService(log, bucket, repo, nil, nil, nil, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "http://foo", nil)
+			req = mux.SetURLVars(req.WithContext(context.WithValue(req.Context(), common.UserID, uid)), map[string]string{"id": tc.id})
+			for name, value := range tc.reqHeaders {
+				req.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			serve := func() {
+				if tc.resized {
+					s.GetResized(rr, req)
+				} else {
+					s.GetOriginal(rr, req)
+				}
+			}
+
+			if tc.expAbort {
+				// the status is sent before the content is verified, so the response is aborted
+				assert.PanicsWithValue(t, http.ErrAbortHandler, serve)
+				assert.NotEqual(t, "original", rr.Body.String(), "corrupted image shouldn't be sent completely")
+				return
+			}
+			serve()
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+			if tc.expCode == http.StatusOK {
+				assert.Equal(t, tc.expBody, rr.Body.String())
+				assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+				assert.Equal(t, strconv.Itoa(len(tc.expBody)), rr.Header().Get("Content-Length"))
+			}
+			if tc.expCode == http.StatusPartialContent && tc.expBody != "" {
+				assert.Equal(t, tc.expBody, rr.Body.String())
+			}
+			for _, part := range tc.expParts {
+				assert.Contains(t, rr.Body.String(), part)
+			}
+			if tc.expCode == http.StatusNotModified {
+				assert.Empty(t, rr.Body.String(), "not modified image shouldn't be sent")
+			}
+			for name, value := range tc.expHeaders {
+				assert.Equal(t, value, rr.Header().Get(name), "unexpected %s header", name)
+			}
+		})
+	}
+}
+
+func TestRequestedRanges(t *testing.T) {
+	testCases := []struct {
+		name      string
+		header    string
+		expRanges map[int64]int64
+	}{
+		{"Missing header case", "", nil},
+		{"Closed range case", "bytes=2-5", map[int64]int64{2: 4}},
+		{"Open range case", "bytes=6-", map[int64]int64{6: 4}},
+		{"Suffix range case", "bytes=-3", map[int64]int64{7: 3}},
+		{"Range past the end case", "bytes=8-100", map[int64]int64{8: 2}},
+		{"Several ranges case", "bytes=0-1, 0-4,7-8", map[int64]int64{0: 5, 7: 2}},
+		{"Unsatisfiable range case", "bytes=10-", map[int64]int64{}},
+		{"Invalid range case", "bytes=5-2", nil},
+		{"Other unit case", "items=0-1", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expRanges, requestedRanges(tc.header, 10))
+		})
+	}
+}
+
+// noLinksStorage doesn't share objects by links like encrypted storages do
+type noLinksStorage struct {
+	*mocks.MockStorage
+}
+
+func (noLinksStorage) Link(string) (string, error) {
+	return "", storage.ErrNoLinks
+}
+
+func TestServiceImpl_GetAllImages_APILinks(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+	basePath := config.Conf.BasePath
+	config.Conf.BasePath = "/image-resizing"
+	defer func() { config.Conf.BasePath = basePath }()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		repo = mocks.NewMockRepository(ctrl)
+		img  = models.Images{ID: uuid.New(), OriginalKey: "pictures/1.png", ResizedKey: "pictures/2.png"}
+	)
+
+	repo.EXPECT().GetAllImages(gomock.Any()).Return([]models.Images{img}, nil)
+
+	s := NewService(log, noLinksStorage{mocks.NewMockStorage(ctrl)}, repo, nil, nil, nil, nil)
+
+	rr := httptest.NewRecorder()
+	s.GetAllImages(rr, httptest.NewRequest(http.MethodGet, "http://foo", nil))
+
+	var images []models.Images
+	assert.Equal(t, http.StatusOK, rr.Code, "unexpected status code")
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&images))
+	if assert.Len(t, images, 1) {
+		assert.Equal(t, "/image-resizing/v1/images/"+img.ID.String()+"/original", images[0].Original, "image should be served by the API")
+		assert.Equal(t, "/image-resizing/v1/images/"+img.ID.String()+"/resized", images[0].Resized, "image should be served by the API")
+	}
+}
@@ -1,14 +1,17 @@
 package images
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"time"
 
-	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/events"
 	"github.com/Dimitriy14/image-resizing/logger"
 	"github.com/Dimitriy14/image-resizing/models"
 	"github.com/Dimitriy14/image-resizing/repository"
@@ -18,6 +21,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -25,32 +29,59 @@ const (
 	maxImageSize = 10 << 24 // max image size is 10MB
 	formWidth    = "width"
 	formHeight   = "height"
+
+	operationCreate = "create"
+	operationResize = "resize"
+	operationDelete = "delete"
 )
 
 // Service provides functionality to retrieving and saving images
 type Service interface {
 	GetAllImages(w http.ResponseWriter, r *http.Request)
 	ResizeNewImage(w http.ResponseWriter, r *http.Request)
+	ResizeNewImageFromURL(w http.ResponseWriter, r *http.Request)
+	ResizeNewImages(w http.ResponseWriter, r *http.Request)
 	ResizeExistedImage(w http.ResponseWriter, r *http.Request)
+	DeleteImage(w http.ResponseWriter, r *http.Request)
+	GetOriginal(w http.ResponseWriter, r *http.Request)
+	GetResized(w http.ResponseWriter, r *http.Request)
+	CreateUpload(w http.ResponseWriter, r *http.Request)
+	CompleteUpload(w http.ResponseWriter, r *http.Request)
+	TusOptions(w http.ResponseWriter, r *http.Request)
+	CreateTusUpload(w http.ResponseWriter, r *http.Request)
+	GetTusUpload(w http.ResponseWriter, r *http.Request)
+	PatchTusUpload(w http.ResponseWriter, r *http.Request)
+	DeleteTusUpload(w http.ResponseWriter, r *http.Request)
+}
+
+// RemoteImageRequest contains url of an image which should be fetched and resized
+type RemoteImageRequest struct {
+	URL    string `json:"url"`
+	Width  uint   `json:"width"`
+	Height uint   `json:"height"`
 }
 
 // NewService creates new service
-func NewService(log logger.Logger, bucket storage.Storage, repo repository.Repository, resizer usecases.ImageResizer) Service {
+func NewService(log logger.Logger, bucket storage.Storage, repo repository.Repository, resizer usecases.ImageResizer, publisher events.Publisher, fetcher usecases.ImageFetcher, uploads repository.UploadRepository) Service {
 	return &serviceImpl{
-		log:           log,
-		bucket:        bucket,
-		repo:          repo,
-		resizer:       resizer,
-		awsStorageUrl: config.Conf.AWSImageStorageURL,
+		log:       log,
+		bucket:    bucket,
+		repo:      repo,
+		resizer:   resizer,
+		publisher: publisher,
+		fetcher:   fetcher,
+		uploads:   uploads,
 	}
 }
 
 type serviceImpl struct {
-	log           logger.Logger
-	bucket        storage.Storage
-	repo          repository.Repository
-	resizer       usecases.ImageResizer
-	awsStorageUrl string
+	log       logger.Logger
+	bucket    storage.Storage
+	repo      repository.Repository
+	resizer   usecases.ImageResizer
+	publisher events.Publisher
+	fetcher   usecases.ImageFetcher
+	uploads   repository.UploadRepository
 }
 
 func (s *serviceImpl) GetAllImages(w http.ResponseWriter, r *http.Request) {
@@ -65,6 +96,14 @@ func (s *serviceImpl) GetAllImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i := range images {
+		if images[i], err = s.withLinks(images[i]); err != nil {
+			s.log.Errorf("cannot build links of image %q due to: %s", images[i].ID, err)
+			common.SendInternalServerError(w, "cannot retrieve image", err)
+			return
+		}
+	}
+
 	s.log.Debugf("Successfully retrieved all images for user %q", uid)
 
 	common.RenderJSON(w, images)
@@ -82,35 +121,109 @@ func (s *serviceImpl) ResizeNewImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resizedImg, err := s.resizer.Resize(fileContent, params)
+	img, msg, err := s.createImage(r.Context(), uid, fileContent, filename, params)
 	if err != nil {
-		s.log.Errorf("cannot resize image due to: %s", err)
-		common.SendInternalServerError(w, "image cannot be resized", err)
+		common.SendServerError(w, msg, err)
+		return
+	}
+
+	common.RenderJSONCreated(w, &img)
+}
+
+func (s *serviceImpl) ResizeNewImageFromURL(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		req RemoteImageRequest
+	)
+
+	s.log.Debugf("Started resizing remote image for user %q", uid)
+
+	if err := common.ReadRequestJSONBodyToStruct(r, &req); err != nil {
+		s.log.Errorf("cannot extract data from request due to: %s", err)
+		common.SendError(w, http.StatusBadRequest, "invalid input data", err)
 		return
 	}
 
-	original, resized, err := s.bucket.UploadWithOriginal(filepath.Ext(filename), fileContent, resizedImg)
+	if req.URL == "" {
+		common.SendError(w, http.StatusBadRequest, "image url is required", nil)
+		return
+	}
+
+	fileContent, filename, err := s.fetcher.Fetch(r.Context(), req.URL)
 	if err != nil {
-		s.log.Errorf("cannot upload images due to: %s", err)
-		common.SendInternalServerError(w, "cannot upload images", err)
+		s.log.Errorf("cannot fetch image from %q due to: %s", req.URL, err)
+		if errors.Cause(err) == usecases.ErrRemoteUnavailable {
+			common.SendError(w, http.StatusBadGateway, "cannot fetch image", err)
+			return
+		}
+		common.SendError(w, http.StatusBadRequest, fmt.Sprintf("cannot fetch image: %s", err), err)
 		return
 	}
 
-	img, err := s.repo.SaveImage(models.Images{
-		ID:       uuid.New(),
-		Original: original,
-		Resized:  resized,
-		UserID:   uid,
+	img, msg, err := s.createImage(r.Context(), uid, fileContent, filename, models.ResizeParams{
+		With:   req.Width,
+		Height: req.Height,
 	})
 	if err != nil {
-		s.log.Errorf("cannot save images due to: %s", err)
-		common.SendInternalServerError(w, "cannot save images", err)
+		common.SendServerError(w, msg, err)
 		return
 	}
 
+	common.RenderJSONCreated(w, &img)
+}
+
+// createImage resizes the image, uploads both versions and saves them,
+// on failure it returns the message which could be shown to the client
+func (s *serviceImpl) createImage(ctx context.Context, uid uuid.UUID, fileContent []byte, filename string, params models.ResizeParams) (models.Images, string, error) {
+	resizedImg, err := s.resizer.Resize(bytes.NewReader(fileContent), params)
+	if err != nil {
+		s.log.Errorf("cannot resize image due to: %s", err)
+		s.notifyFailure(uid, uuid.Nil, operationCreate, err)
+		return models.Images{}, "image cannot be resized", err
+	}
+
+	var (
+		imageID        = uuid.New()
+		ext            = filepath.Ext(filename)
+		originalParams = storage.KeyParams{UserID: uid, ImageID: imageID, Variant: storage.VariantOriginal, Ext: ext}
+		resizedParams  = storage.KeyParams{UserID: uid, ImageID: imageID, Variant: storage.ResizedVariant(params.With, params.Height), Ext: ext}
+		original       = storage.Keys.Key(originalParams)
+		resized        = storage.Keys.Key(resizedParams)
+	)
+
+	err = storage.UploadWithOriginal(ctx, s.bucket,
+		storage.Object{Key: original, Content: fileContent, Attributes: storage.ImageAttributes(originalParams, filename)},
+		storage.Object{Key: resized, Content: resizedImg, Attributes: storage.ImageAttributes(resizedParams, filename)},
+	)
+	if err != nil {
+		s.log.Errorf("cannot upload images due to: %s", err)
+		s.notifyFailure(uid, uuid.Nil, operationCreate, err)
+		// one of the versions could be stored
+		s.rollback(original, resized)
+		return models.Images{}, "cannot upload images", err
+	}
+
+	img, err := s.saveWithEvent(models.EventImageCreated, func(repo repository.Repository) (models.Images, error) {
+		return repo.SaveImage(models.Images{
+			ID:             imageID,
+			OriginalKey:    original,
+			ResizedKey:     resized,
+			UserID:         uid,
+			Filename:       filepath.Base(filename),
+			OriginalSHA256: storage.Checksum(fileContent),
+			ResizedSHA256:  storage.Checksum(resizedImg),
+		})
+	})
+	if err != nil {
+		s.log.Errorf("cannot save images due to: %s", err)
+		s.notifyFailure(uid, uuid.Nil, operationCreate, err)
+		s.rollback(original, resized)
+		return models.Images{}, "cannot save images", err
+	}
+
 	s.log.Debugf("Successfully resized and saved image for user %q", uid)
 
-	common.RenderJSONCreated(w, &img)
+	return img, "", nil
 }
 
 func (s *serviceImpl) ResizeExistedImage(w http.ResponseWriter, r *http.Request) {
@@ -149,49 +262,279 @@ func (s *serviceImpl) ResizeExistedImage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	imageContent, err := s.bucket.Download(img.Original)
+	original, err := storage.Download(r.Context(), s.bucket, img.OriginalKey, img.OriginalSHA256)
 	if err != nil {
 		s.log.Errorf("cannot download image from s3 due to: %s", err)
-		common.SendInternalServerError(w, "invalid input data", err)
+		s.notifyFailure(uid, imageID, operationResize, err)
+		if err == storage.ErrChecksumMismatch {
+			common.SendInternalServerError(w, "original is corrupted", err)
+			return
+		}
+		common.SendServerError(w, "invalid input data", err)
 		return
 	}
 
-	resizedImgContent, err := s.resizer.Resize(imageContent, params)
+	resizedImgContent, err := s.resizer.Resize(bytes.NewReader(original), params)
 	if err != nil {
 		s.log.Errorf("cannot resize image with id (%s) for user (%s) due to: %s", err)
+		s.notifyFailure(uid, imageID, operationResize, err)
 		common.SendInternalServerError(w, "cannot resize this image", err)
 		return
 	}
 
-	newResizeLink, err := s.bucket.Upload(filepath.Ext(img.Resized), resizedImgContent)
+	resizedParams := storage.KeyParams{
+		UserID:  uid,
+		ImageID: imageID,
+		Variant: storage.ResizedVariant(params.With, params.Height),
+		Ext:     filepath.Ext(img.ResizedKey),
+	}
+	newResizedKey := storage.Keys.Key(resizedParams)
+
+	// the object of the same size is overwritten by the same content, so it is never rolled back
+	replaced := newResizedKey != img.ResizedKey
+
+	err = storage.Upload(r.Context(), s.bucket, storage.Object{
+		Key:        newResizedKey,
+		Content:    resizedImgContent,
+		Attributes: storage.ImageAttributes(resizedParams, img.Filename),
+	})
 	if err != nil {
 		s.log.Errorf("cannot resize image with id (%q) for user (%q) due to: %s", err)
-		common.SendInternalServerError(w, "invalid input data", err)
+		s.notifyFailure(uid, imageID, operationResize, err)
+		common.SendServerError(w, "invalid input data", err)
 		return
 	}
 
-	newImg, err := s.repo.UpdateImage(models.Images{
-		ID:       imageID,
-		Original: img.Original,
-		Resized:  newResizeLink,
-		UserID:   uid,
+	// the original which has been read is kept in or restored to the hot tier by tiering.Mover
+	readAt := time.Now().UTC()
+
+	// the response and the event describe the whole image, only the changed fields are updated
+	resized := img
+	resized.ResizedKey = newResizedKey
+	resized.ResizedSHA256 = storage.Checksum(resizedImgContent)
+	resized.OriginalReadAt = &readAt
+
+	newImg, err := s.saveWithEvent(models.EventImageResized, func(repo repository.Repository) (models.Images, error) {
+		_, err := repo.UpdateImage(models.Images{
+			ID:             imageID,
+			OriginalKey:    img.OriginalKey,
+			ResizedKey:     resized.ResizedKey,
+			UserID:         uid,
+			OriginalReadAt: resized.OriginalReadAt,
+			ResizedSHA256:  resized.ResizedSHA256,
+		})
+		return resized, err
 	})
 	if err != nil {
 		s.log.Errorf("cannot save images due to: %s", err)
+		s.notifyFailure(uid, imageID, operationResize, err)
+		if replaced {
+			s.rollback(newResizedKey)
+		}
 		common.SendInternalServerError(w, "cannot save images", err)
 		return
 	}
 
-	//user doesn't have to wait till his old resized image will be deleted
-	go s.deleteImage(img.Resized)
+	//user doesn't have to wait till his old resized image will be deleted.
+	//cached copies of the old resized image are stale whether it is deleted or overwritten
+	go func() {
+		if replaced {
+			s.deleteReleased(img.ResizedKey)
+		}
+		s.purge(img.ResizedKey)
+	}()
 	s.log.Debugf("Successfully resized and saved image for user %q", uid)
 
 	common.RenderJSON(w, &newImg)
 }
 
-func (s *serviceImpl) deleteImage(addr string) {
-	if err := s.bucket.DeleteImage(addr); err != nil {
-		s.log.Errorf("got an error while deleting image from S3 with addr: %s", addr)
+func (s *serviceImpl) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		id  = mux.Vars(r)["id"]
+	)
+
+	s.log.Debugf("Started deleting image %q for user %q", id, uid)
+
+	imageID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse image id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid image id", err)
+		return
+	}
+
+	img, err := s.repo.GetImageByID(uid, imageID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			s.log.Errorf("cannot find image with id (%q) for user (%q) due to: %s", imageID, uid, err)
+			common.SendNotFound(w, "image id is not found: %s", err)
+			return
+		}
+
+		s.log.Errorf("cannot retrieve image with id (%q) for user (%q) due to: %s", imageID, uid, err)
+		common.SendInternalServerError(w, "cannot retrieve image due to db problems", err)
+		return
+	}
+
+	_, err = s.saveWithEvent(models.EventImageDeleted, func(repo repository.Repository) (models.Images, error) {
+		return img, repo.DeleteImage(uid, imageID)
+	})
+	if err != nil {
+		s.log.Errorf("cannot delete image with id (%q) for user (%q) due to: %s", imageID, uid, err)
+		s.notifyFailure(uid, imageID, operationDelete, err)
+		common.SendInternalServerError(w, "cannot delete image", err)
+		return
+	}
+
+	//user doesn't have to wait till files will be deleted from the bucket
+	go func() {
+		s.deleteReleased(img.OriginalKey, img.ResizedKey)
+		s.purge(img.OriginalKey, img.ResizedKey)
+	}()
+	s.log.Debugf("Successfully deleted image %q for user %q", imageID, uid)
+
+	common.RenderNoContent(w)
+}
+
+// saveWithEvent runs write and saves the event about its result in the same transaction,
+// so the event is never published for a rolled back change
+func (s *serviceImpl) saveWithEvent(eventType models.EventType, write func(repository.Repository) (models.Images, error)) (models.Images, error) {
+	var img models.Images
+
+	err := s.repo.Transaction(func(repo repository.Repository) error {
+		var err error
+		img, err = write(repo)
+		if err != nil {
+			return err
+		}
+
+		if img, err = s.withLinks(img); err != nil {
+			return err
+		}
+
+		return repo.SaveEvent(models.NewEvent(eventType, img.UserID, eventPayload(img)))
+	})
+
+	return img, err
+}
+
+// withLinks fills links of the image, they are temporary if the storage is private and point to the API
+// if it is encrypted. The link of the original moved to the cold tier is built by that tier
+func (s *serviceImpl) withLinks(img models.Images) (models.Images, error) {
+	var (
+		originals = s.bucket
+		err       error
+	)
+
+	if tiers, ok := storage.AsTiered(s.bucket); ok && img.Tier == models.TierCold {
+		originals = tiers.Cold()
+	}
+
+	if img.OriginalKey != "" {
+		if img.Original, err = link(originals, img.ID, img.OriginalKey, storage.VariantOriginal); err != nil {
+			return img, err
+		}
+	}
+
+	if img.ResizedKey != "" {
+		if img.Resized, err = link(s.bucket, img.ID, img.ResizedKey, storage.VariantResized); err != nil {
+			return img, err
+		}
+	}
+
+	return img, nil
+}
+
+// eventPayload links the image to the API, since events could be delivered after links of the storage expire
+func eventPayload(img models.Images) models.Images {
+	img.Original = apiLink(img.ID, storage.VariantOriginal)
+	img.Resized = apiLink(img.ID, storage.VariantResized)
+	return img
+}
+
+// notifyFailure publishes job.failed event, there is no db change to bind it to, so it bypasses the outbox
+func (s *serviceImpl) notifyFailure(uid, imageID uuid.UUID, operation string, err error) {
+	if s.publisher == nil {
+		return
+	}
+
+	event := models.NewEvent(models.EventJobFailed, uid, models.JobFailure{
+		ImageID:   imageID,
+		Operation: operation,
+		Error:     err.Error(),
+	})
+	if err := s.publisher.Publish(event); err != nil {
+		s.log.Errorf("cannot publish event %s due to: %s", event.Type, err)
+	}
+}
+
+// deleteImage deletes the object, failed deletion is recorded to be retried by compensations.Retrier
+func (s *serviceImpl) deleteImage(key string) {
+	// request context is canceled as soon as the response is written
+	err := s.bucket.Delete(context.Background(), key)
+	if err == nil {
+		return
+	}
+
+	s.log.Errorf("got an error while deleting image from storage with key %q: %s", key, err)
+
+	if err = s.repo.SaveCompensation(models.NewObjectDeletion(key, err)); err != nil {
+		s.log.Errorf("cannot record deletion of %q for retry due to: %s", key, err)
+	}
+}
+
+// purge purges cached copies of the objects, failed purges are recorded and retried like deletions,
+// since resized images are cached as immutable
+func (s *serviceImpl) purge(keys ...string) {
+	// request context is canceled as soon as the response is written
+	err := storage.Purge(context.Background(), s.bucket, keys...)
+	if err == nil {
+		return
+	}
+
+	s.log.Errorf("got an error while purging cached copies of %q: %s", keys, err)
+
+	for _, key := range keys {
+		if err := s.repo.SaveCompensation(models.NewObjectPurge(key, err)); err != nil {
+			s.log.Errorf("cannot record purge of %q for retry due to: %s", key, err)
+		}
+	}
+}
+
+// deleteReleased deletes objects which are no longer referenced. Keys are deterministic, so the key of
+// the replaced or deleted object could be used again by the time it is deleted, e.g. when the image is resized
+// back to the same size, such keys are kept. When references cannot be checked deletions are left to the retrier,
+// which checks them again
+func (s *serviceImpl) deleteReleased(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	referenced, err := s.repo.ReferencedKeys(keys)
+	if err != nil {
+		s.log.Errorf("cannot check references of %q due to: %s", keys, err)
+		for _, key := range keys {
+			if err := s.repo.SaveCompensation(models.NewObjectDeletion(key, err)); err != nil {
+				s.log.Errorf("cannot record deletion of %q for retry due to: %s", key, err)
+			}
+		}
+		return
+	}
+
+	for _, key := range keys {
+		if referenced[key] {
+			s.log.Debugf("Object %q is referenced again, so it is kept", key)
+			continue
+		}
+		s.deleteImage(key)
+	}
+}
+
+// rollback compensates objects stored by failed operation, it is synchronous,
+// so the failed request doesn't leave anything behind once it is responded
+func (s *serviceImpl) rollback(keys ...string) {
+	for _, key := range keys {
+		s.deleteImage(key)
 	}
 }
 
@@ -211,17 +554,26 @@ func extractFormData(r *http.Request) ([]byte, string, models.ResizeParams, erro
 		return nil, "", models.ResizeParams{}, fmt.Errorf("cannot read image content: %s", err)
 	}
 
-	width, err := strconv.ParseUint(r.FormValue(formWidth), 10, 64)
+	params, err := parseResizeParams(r.FormValue(formWidth), r.FormValue(formHeight))
+	if err != nil {
+		return nil, "", models.ResizeParams{}, err
+	}
+
+	return fileContent, head.Filename, params, nil
+}
+
+func parseResizeParams(widthValue, heightValue string) (models.ResizeParams, error) {
+	width, err := strconv.ParseUint(widthValue, 10, 64)
 	if err != nil {
-		return nil, "", models.ResizeParams{}, fmt.Errorf("converting width to uint error: %s", err)
+		return models.ResizeParams{}, fmt.Errorf("converting width to uint error: %s", err)
 	}
 
-	height, err := strconv.ParseUint(r.FormValue(formHeight), 10, 64)
+	height, err := strconv.ParseUint(heightValue, 10, 64)
 	if err != nil {
-		return nil, "", models.ResizeParams{}, fmt.Errorf("converting height to uint error: %s", err)
+		return models.ResizeParams{}, fmt.Errorf("converting height to uint error: %s", err)
 	}
 
-	return fileContent, head.Filename, models.ResizeParams{
+	return models.ResizeParams{
 		With:   uint(width),
 		Height: uint(height),
 	}, nil
@@ -2,20 +2,33 @@ package images
 
 import (
 	"bytes"
-	"errors"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
 	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/usecases"
 
 	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/cdn"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/tiered"
 
 	"github.com/Dimitriy14/image-resizing/logger"
 	"github.com/Dimitriy14/image-resizing/mocks"
@@ -24,7 +37,7 @@ import (
 )
 
 func TestNewService(t *testing.T) {
-	assert.Empty(t, NewService(nil, nil, nil, nil), "NewService shouldn't be empty")
+	assert.Empty(t, NewService(nil, nil, nil, nil, nil, nil, nil), "NewService shouldn't be empty")
 }
 
 func TestServiceImpl_GetAllImages(t *testing.T) {
@@ -55,10 +68,11 @@ func TestServiceImpl_GetAllImages(t *testing.T) {
 			bucket := mocks.NewMockStorage(ctrl)
 			repo := mocks.NewMockRepository(ctrl)
 			resizer := mocks.NewMockResizer(ctrl)
+			publisher := mocks.NewMockPublisher(ctrl)
 
 			repo.EXPECT().GetAllImages(gomock.Any()).Return(nil, tc.getImagesErr)
 
-			s := NewService(log, bucket, repo, resizer)
+			s := NewService(log, bucket, repo, resizer, publisher, nil, nil)
 
 			rr := httptest.NewRecorder()
 			req := httptest.NewRequest(http.MethodGet, "http://foo", nil)
@@ -71,6 +85,75 @@ func TestServiceImpl_GetAllImages(t *testing.T) {
 	}
 }
 
+// linkerStorage shares objects by temporary links like private buckets do
+type linkerStorage struct {
+	*mocks.MockStorage
+}
+
+func (linkerStorage) Link(key string) (string, error) {
+	return "http://bucket/" + key + "?signature=sig", nil
+}
+
+func TestServiceImpl_GetAllImages_Links(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		repo = mocks.NewMockRepository(ctrl)
+		img  = models.Images{ID: uuid.New(), OriginalKey: "pictures/1.png", ResizedKey: "pictures/2.png"}
+	)
+
+	repo.EXPECT().GetAllImages(gomock.Any()).Return([]models.Images{img}, nil)
+
+	s := NewService(log, linkerStorage{mocks.NewMockStorage(ctrl)}, repo, nil, nil, nil, nil)
+
+	rr := httptest.NewRecorder()
+	s.GetAllImages(rr, httptest.NewRequest(http.MethodGet, "http://foo", nil))
+
+	var images []models.Images
+	assert.Equal(t, http.StatusOK, rr.Code, "unexpected status code")
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&images))
+	if assert.Len(t, images, 1) {
+		assert.Empty(t, images[0].OriginalKey, "storage keys shouldn't be exposed")
+		assert.Equal(t, "http://bucket/pictures/1.png?signature=sig", images[0].Original, "link should be built by storage")
+		assert.Equal(t, "http://bucket/pictures/2.png?signature=sig", images[0].Resized, "link should be built by storage")
+	}
+}
+
+func TestServiceImpl_GetAllImages_ColdLinks(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		repo = mocks.NewMockRepository(ctrl)
+		hot  = memory.NewStorage("http://localhost/files")
+		cold = memory.NewStorage("http://localhost/cold")
+		img  = models.Images{ID: uuid.New(), OriginalKey: "pictures/1.png", ResizedKey: "pictures/2.png", Tier: models.TierCold}
+	)
+
+	repo.EXPECT().GetAllImages(gomock.Any()).Return([]models.Images{img}, nil)
+
+	s := NewService(log, tiered.NewStorage(hot, cold), repo, nil, nil, nil, nil)
+
+	rr := httptest.NewRecorder()
+	s.GetAllImages(rr, httptest.NewRequest(http.MethodGet, "http://foo", nil))
+
+	var images []models.Images
+	assert.Equal(t, http.StatusOK, rr.Code, "unexpected status code")
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&images))
+	if assert.Len(t, images, 1) {
+		assert.Equal(t, models.TierCold, images[0].Tier)
+		assert.Equal(t, "http://localhost/cold/pictures/1.png", images[0].Original, "link of cold original should be built by the cold tier")
+		assert.Equal(t, "http://localhost/files/pictures/2.png", images[0].Resized)
+	}
+}
+
 func TestServiceImpl_ResizeNewImage(t *testing.T) {
 	log := logger.NewMokLogger()
 	logger.Log = log
@@ -83,12 +166,16 @@ func TestServiceImpl_ResizeNewImage(t *testing.T) {
 		saveImagesErr error
 		resizeErr     error
 		uploadErr     error
+		rollbackErr   error
+		expEvent      models.EventType
+		expRollback   bool
 	}{
 		{
-			name:    "Good case",
-			width:   "100",
-			height:  "100",
-			expCode: http.StatusCreated,
+			name:     "Good case",
+			width:    "100",
+			height:   "100",
+			expCode:  http.StatusCreated,
+			expEvent: models.EventImageCreated,
 		},
 		{
 			name:    "Invalid width",
@@ -108,13 +195,25 @@ func TestServiceImpl_ResizeNewImage(t *testing.T) {
 			height:    "100",
 			expCode:   http.StatusInternalServerError,
 			resizeErr: errors.New("RESIZE ERROR"),
+			expEvent:  models.EventJobFailed,
 		},
 		{
-			name:      "Upload error case",
-			width:     "100",
-			height:    "100",
-			expCode:   http.StatusInternalServerError,
-			uploadErr: errors.New("UPLOAD ERROR"),
+			name:        "Upload error case",
+			width:       "100",
+			height:      "100",
+			expCode:     http.StatusInternalServerError,
+			uploadErr:   errors.New("UPLOAD ERROR"),
+			expEvent:    models.EventJobFailed,
+			expRollback: true,
+		},
+		{
+			name:        "Unavailable storage case",
+			width:       "100",
+			height:      "100",
+			expCode:     http.StatusServiceUnavailable,
+			uploadErr:   storage.ErrUnavailable,
+			expEvent:    models.EventJobFailed,
+			expRollback: true,
 		},
 		{
 			name:          "Saving error case",
@@ -122,6 +221,18 @@ func TestServiceImpl_ResizeNewImage(t *testing.T) {
 			height:        "100",
 			expCode:       http.StatusInternalServerError,
 			saveImagesErr: errors.New("SAVING ERROR"),
+			expEvent:      models.EventJobFailed,
+			expRollback:   true,
+		},
+		{
+			name:          "Rollback error case",
+			width:         "100",
+			height:        "100",
+			expCode:       http.StatusInternalServerError,
+			saveImagesErr: errors.New("SAVING ERROR"),
+			rollbackErr:   errors.New("DELETE ERROR"),
+			expEvent:      models.EventJobFailed,
+			expRollback:   true,
 		},
 	}
 
@@ -133,12 +244,15 @@ func TestServiceImpl_ResizeNewImage(t *testing.T) {
 			bucket := mocks.NewMockStorage(ctrl)
 			repo := mocks.NewMockRepository(ctrl)
 			resizer := mocks.NewMockResizer(ctrl)
+			publisher := mocks.NewMockPublisher(ctrl)
 
 			repo.EXPECT().SaveImage(gomock.Any()).Return(models.Images{}, tc.saveImagesErr).AnyTimes()
-			bucket.EXPECT().UploadWithOriginal(gomock.Any(), gomock.Any(), gomock.Any()).Return("", "", tc.uploadErr).AnyTimes()
+			bucket.EXPECT().URL(gomock.Any()).Return("").AnyTimes()
 			resizer.EXPECT().Resize(gomock.Any(), gomock.Any()).Return([]byte{}, tc.resizeErr).AnyTimes()
+			expectEvent(t, repo, publisher, tc.expEvent)
+			checkRollback := expectRollback(t, bucket, repo, tc.uploadErr, tc.expRollback, tc.rollbackErr)
 
-			s := NewService(log, bucket, repo, resizer)
+			s := NewService(log, bucket, repo, resizer, publisher, nil, nil)
 
 			req := newMultipartRequest(t, tc.width, tc.height)
 			rr := httptest.NewRecorder()
@@ -147,10 +261,164 @@ func TestServiceImpl_ResizeNewImage(t *testing.T) {
 			resp := rr.Result()
 
 			assert.Equal(t, tc.expCode, resp.StatusCode, "unexpected status code")
+			checkRollback()
+		})
+	}
+}
+
+func TestServiceImpl_ResizeNewImageFromURL(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	testCases := []struct {
+		name     string
+		body     string
+		expCode  int
+		fetchErr error
+		expEvent models.EventType
+	}{
+		{
+			name:     "Good case",
+			body:     `{"url":"https://example.com/cat.png","width":100,"height":100}`,
+			expCode:  http.StatusCreated,
+			expEvent: models.EventImageCreated,
+		},
+		{
+			name:    "Invalid body case",
+			body:    `invalid body`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Missing url case",
+			body:    `{"width":100,"height":100}`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Forbidden address case",
+			body:     `{"url":"http://169.254.169.254/latest","width":100,"height":100}`,
+			expCode:  http.StatusBadRequest,
+			fetchErr: errors.Wrap(usecases.ErrForbiddenAddress, "169.254.169.254"),
+		},
+		{
+			name:     "Remote server error case",
+			body:     `{"url":"https://example.com/cat.png","width":100,"height":100}`,
+			expCode:  http.StatusBadGateway,
+			fetchErr: errors.Wrap(usecases.ErrRemoteUnavailable, "status 500"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			bucket := mocks.NewMockStorage(ctrl)
+			repo := mocks.NewMockRepository(ctrl)
+			resizer := mocks.NewMockResizer(ctrl)
+			publisher := mocks.NewMockPublisher(ctrl)
+			fetcher := mocks.NewMockFetcher(ctrl)
+
+			fetcher.EXPECT().Fetch(gomock.Any(), gomock.Any()).Return([]byte{}, "cat.png", tc.fetchErr).AnyTimes()
+			repo.EXPECT().SaveImage(gomock.Any()).Return(models.Images{}, nil).AnyTimes()
+			bucket.EXPECT().Put(gomock.Any(), hasExt(".png"), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			bucket.EXPECT().URL(gomock.Any()).Return("").AnyTimes()
+			resizer.EXPECT().Resize(gomock.Any(), models.ResizeParams{With: 100, Height: 100}).Return([]byte{}, nil).AnyTimes()
+			expectEvent(t, repo, publisher, tc.expEvent)
+
+			s := NewService(log, bucket, repo, resizer, publisher, fetcher, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "http://foo", bytes.NewBufferString(tc.body))
+			rr := httptest.NewRecorder()
+			s.ResizeNewImageFromURL(rr, req)
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
 		})
 	}
 }
 
+// expectEvent checks that domain event is saved to the outbox and failure is published directly
+func expectEvent(t *testing.T, repo *mocks.MockRepository, publisher *mocks.MockPublisher, eventType models.EventType) {
+	checkType := func(e models.Event) {
+		assert.Equal(t, eventType, e.Type, "unexpected event type")
+	}
+
+	repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo)).AnyTimes()
+
+	switch eventType {
+	case "":
+		repo.EXPECT().SaveEvent(gomock.Any()).Times(0)
+		publisher.EXPECT().Publish(gomock.Any()).Times(0)
+	case models.EventJobFailed:
+		repo.EXPECT().SaveEvent(gomock.Any()).Times(0)
+		publisher.EXPECT().Publish(gomock.Any()).Do(checkType).Return(nil)
+	default:
+		repo.EXPECT().SaveEvent(gomock.Any()).Do(checkType).Return(nil)
+		publisher.EXPECT().Publish(gomock.Any()).Times(0)
+	}
+}
+
+// expectRollback records stored objects and returns the check that all of them are deleted
+// when the operation fails, failed deletions should be recorded for retry
+func expectRollback(t *testing.T, bucket *mocks.MockStorage, repo *mocks.MockRepository, putErr error, rollback bool, deleteErr error) func() {
+	var (
+		mu      sync.Mutex
+		stored  []string
+		deleted []string
+	)
+
+	bucket.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, key string, _ io.Reader, _ storage.PutOptions) error {
+			mu.Lock()
+			defer mu.Unlock()
+			stored = append(stored, key)
+			return putErr
+		}).AnyTimes()
+
+	if !rollback {
+		return func() {}
+	}
+
+	bucket.EXPECT().Delete(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string) error {
+		deleted = append(deleted, key)
+		return deleteErr
+	}).Times(2)
+
+	compensations := 0
+	if deleteErr != nil {
+		compensations = 2
+	}
+	repo.EXPECT().SaveCompensation(gomock.Any()).Do(func(c models.Compensation) {
+		assert.Equal(t, models.CompensationDeleteObject, c.Action)
+		assert.Contains(t, deleted, c.Key, "only failed deletion should be recorded")
+	}).Return(nil).Times(compensations)
+
+	return func() {
+		assert.ElementsMatch(t, stored, deleted, "stored objects should be deleted")
+	}
+}
+
+// hasExt matches object keys with given file extension
+func hasExt(ext string) gomock.Matcher {
+	return extMatcher(ext)
+}
+
+type extMatcher string
+
+func (m extMatcher) Matches(x interface{}) bool {
+	key, ok := x.(string)
+	return ok && filepath.Ext(key) == string(m)
+}
+
+func (m extMatcher) String() string {
+	return "has extension " + string(m)
+}
+
+func inTransaction(repo *mocks.MockRepository) func(func(repository.Repository) error) error {
+	return func(fn func(repository.Repository) error) error {
+		return fn(repo)
+	}
+}
+
 func newMultipartRequest(t *testing.T, width, height string) *http.Request {
 	buf := bytes.NewBuffer([]byte{})
 	mw := multipart.NewWriter(buf)
@@ -190,11 +458,14 @@ func TestServiceImpl_ResizeExistedImage(t *testing.T) {
 	}
 
 	testCases := []struct {
-		name    string
-		body    []byte
-		expCode int
-		id      string
-		errors  errorCases
+		name        string
+		body        []byte
+		resizedKey  string
+		checksum    string
+		expCode     int
+		id          string
+		errors      errorCases
+		expRollback bool
 	}{
 		{
 			name:    "Good case",
@@ -202,6 +473,23 @@ func TestServiceImpl_ResizeExistedImage(t *testing.T) {
 			expCode: http.StatusOK,
 			id:      imgID.String(),
 		},
+		{
+			name:       "Same size case",
+			body:       []byte(`{"with":100, "height":100}`),
+			resizedKey: storage.Keys.Key(storage.KeyParams{ImageID: imgID, Variant: storage.ResizedVariant(100, 100)}),
+			expCode:    http.StatusOK,
+			id:         imgID.String(),
+		},
+		{
+			name:       "Same size update error case",
+			body:       []byte(`{"with":100, "height":100}`),
+			resizedKey: storage.Keys.Key(storage.KeyParams{ImageID: imgID, Variant: storage.ResizedVariant(100, 100)}),
+			expCode:    http.StatusInternalServerError,
+			id:         imgID.String(),
+			errors: errorCases{
+				updateErr: errors.New("ERROR"),
+			},
+		},
 		{
 			name:    "Invalid ID case",
 			expCode: http.StatusBadRequest,
@@ -240,6 +528,13 @@ func TestServiceImpl_ResizeExistedImage(t *testing.T) {
 				downloadErr: errors.New("ERROR"),
 			},
 		},
+		{
+			name:     "Corrupted original case",
+			body:     []byte(`{"width":100, "height":100}`),
+			checksum: storage.Checksum([]byte("other content")),
+			expCode:  http.StatusInternalServerError,
+			id:       imgID.String(),
+		},
 		{
 			name:    "Resizing image error case",
 			body:    []byte(`{"width":100, "height":100}`),
@@ -266,6 +561,7 @@ func TestServiceImpl_ResizeExistedImage(t *testing.T) {
 			errors: errorCases{
 				updateErr: errors.New("ERROR"),
 			},
+			expRollback: true,
 		},
 		{
 			name:    "Deleting image error case",
@@ -286,15 +582,43 @@ func TestServiceImpl_ResizeExistedImage(t *testing.T) {
 			bucket := mocks.NewMockStorage(ctrl)
 			repo := mocks.NewMockRepository(ctrl)
 			resizer := mocks.NewMockResizer(ctrl)
-
-			repo.EXPECT().UpdateImage(gomock.Any()).Return(models.Images{ID: imgID}, tc.errors.updateErr).AnyTimes()
-			repo.EXPECT().GetImageByID(gomock.Any(), imgID).Return(models.Images{ID: imgID}, tc.errors.getImageErr).AnyTimes()
-			bucket.EXPECT().Upload(gomock.Any(), gomock.Any()).Return("", tc.errors.uploadErr).AnyTimes()
-			bucket.EXPECT().Download(gomock.Any()).Return([]byte{}, tc.errors.downloadErr).AnyTimes()
-			bucket.EXPECT().DeleteImage(gomock.Any()).Return(tc.errors.deleteErr).AnyTimes()
+			publisher := mocks.NewMockPublisher(ctrl)
+
+			repo.EXPECT().UpdateImage(gomock.Any()).DoAndReturn(func(img models.Images) (models.Images, error) {
+				assert.NotNil(t, img.OriginalReadAt, "read of the original should be recorded for tiering")
+				assert.Empty(t, img.Tier, "tier shouldn't be updated")
+				assert.Equal(t, storage.Checksum([]byte{}), img.ResizedSHA256, "checksum of the new resized image should be saved")
+				return models.Images{ID: imgID, OriginalKey: "key", ResizedKey: "resized"}, tc.errors.updateErr
+			}).AnyTimes()
+			resizedKey := "key"
+			if tc.resizedKey != "" {
+				resizedKey = tc.resizedKey
+			}
+
+			repo.EXPECT().GetImageByID(gomock.Any(), imgID).Return(models.Images{ID: imgID, OriginalKey: "key", ResizedKey: resizedKey, OriginalSHA256: tc.checksum, Filename: "cat.png"}, tc.errors.getImageErr).AnyTimes()
+			bucket.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(tc.errors.uploadErr).AnyTimes()
+			bucket.EXPECT().URL(gomock.Any()).Return("").AnyTimes()
+			bucket.EXPECT().Get(gomock.Any(), "key").Return(ioutil.NopCloser(bytes.NewReader(nil)), tc.errors.downloadErr).AnyTimes()
+			bucket.EXPECT().Delete(gomock.Any(), "key").Return(tc.errors.deleteErr).AnyTimes()
+			// the new resized object is deleted only when the update fails
+			rollbacks := 0
+			if tc.expRollback {
+				rollbacks = 1
+			}
+			bucket.EXPECT().Delete(gomock.Any(), gomock.Not("key")).Return(nil).Times(rollbacks)
+			repo.EXPECT().SaveCompensation(gomock.Any()).Return(nil).AnyTimes()
+			repo.EXPECT().ReferencedKeys(gomock.Any()).Return(map[string]bool{}, nil).AnyTimes()
 			resizer.EXPECT().Resize(gomock.Any(), gomock.Any()).Return([]byte{}, tc.errors.resizeErr).AnyTimes()
+			repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo)).AnyTimes()
+			repo.EXPECT().SaveEvent(gomock.Any()).DoAndReturn(func(e models.Event) error {
+				var payload models.Images
+				assert.NoError(t, json.Unmarshal(e.Data, &payload))
+				assert.Equal(t, "cat.png", payload.Filename, "event should describe the whole image")
+				return nil
+			}).AnyTimes()
+			publisher.EXPECT().Publish(gomock.Any()).Return(nil).AnyTimes()
 
-			s := NewService(log, bucket, repo, resizer)
+			s := NewService(log, bucket, repo, resizer, publisher, nil, nil)
 
 			req := httptest.NewRequest(http.MethodPost, "http://foo", bytes.NewBuffer(tc.body))
 			req = mux.SetURLVars(req, map[string]string{
@@ -305,6 +629,218 @@ func TestServiceImpl_ResizeExistedImage(t *testing.T) {
 
 			resp := rr.Result()
 
+			assert.Equal(t, tc.expCode, resp.StatusCode, "unexpected status code")
+			if resp.StatusCode == http.StatusOK {
+				var img models.Images
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&img))
+				assert.Equal(t, "cat.png", img.Filename, "unchanged fields should be returned")
+				assert.Equal(t, tc.checksum, img.OriginalSHA256, "unchanged fields should be returned")
+				assert.Equal(t, storage.Checksum([]byte{}), img.ResizedSHA256)
+			}
+		})
+	}
+}
+
+// purgeRecorder sends purged links to the channel, purges run after the response is written
+type purgeRecorder struct {
+	purged chan []string
+	err    error
+}
+
+func (p purgeRecorder) Purge(_ context.Context, links []string) error {
+	p.purged <- links
+	return p.err
+}
+
+func TestServiceImpl_ResizeExistedImage_Purge(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	testCases := []struct {
+		name           string
+		sameSize       bool
+		referenced     bool
+		purgeErr       error
+		expOldDeleted  bool
+		expCompensated bool
+	}{
+		{
+			name:          "Replaced case",
+			expOldDeleted: true,
+		},
+		{
+			name:     "Overwritten case",
+			sameSize: true,
+		},
+		{
+			name:       "Referenced again case",
+			referenced: true,
+		},
+		{
+			name:           "Purge error case",
+			purgeErr:       errors.New("PURGE ERROR"),
+			expOldDeleted:  true,
+			expCompensated: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				ctx       = context.Background()
+				mem       = memory.NewStorage("http://localhost/files")
+				purger    = purgeRecorder{purged: make(chan []string, 1), err: tc.purgeErr}
+				repo      = mocks.NewMockRepository(ctrl)
+				resizer   = mocks.NewMockResizer(ctrl)
+				publisher = mocks.NewMockPublisher(ctrl)
+				uid       = uuid.New()
+				img       = models.Images{ID: uuid.New(), UserID: uid, OriginalKey: "pictures/original.png"}
+			)
+
+			links, err := cdn.NewURLBuilder([]string{"https://cdn.example.com"}, "", 0)
+			assert.NoError(t, err)
+			bucket := cdn.NewStorage(mem, links, purger)
+
+			variant := storage.ResizedVariant(50, 50)
+			if tc.sameSize {
+				variant = storage.ResizedVariant(100, 100)
+			}
+			img.ResizedKey = storage.Keys.Key(storage.KeyParams{UserID: uid, ImageID: img.ID, Variant: variant, Ext: ".png"})
+
+			for key, content := range map[string]string{img.OriginalKey: "original", img.ResizedKey: "resized"} {
+				assert.NoError(t, mem.Put(ctx, key, bytes.NewBufferString(content), storage.PutOptions{Size: -1}))
+			}
+
+			repo.EXPECT().GetImageByID(uid, img.ID).Return(img, nil)
+			resizer.EXPECT().Resize(gomock.Any(), gomock.Any()).Return([]byte("resized again"), nil)
+			repo.EXPECT().UpdateImage(gomock.Any()).DoAndReturn(func(updated models.Images) (models.Images, error) {
+				return updated, nil
+			})
+			repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo))
+			repo.EXPECT().SaveEvent(gomock.Any()).Return(nil)
+			// the key of the old resized image could be used again, e.g. by another resize to its size
+			repo.EXPECT().ReferencedKeys([]string{img.ResizedKey}).Return(map[string]bool{img.ResizedKey: tc.referenced}, nil).AnyTimes()
+
+			publisher.EXPECT().Publish(gomock.Any()).Return(nil).AnyTimes()
+
+			compensations := 0
+			if tc.expCompensated {
+				compensations = 1
+			}
+			compensated := make(chan models.Compensation, 1)
+			repo.EXPECT().SaveCompensation(gomock.Any()).Do(func(c models.Compensation) {
+				compensated <- c
+			}).Return(nil).Times(compensations)
+
+			s := NewService(log, bucket, repo, resizer, publisher, nil, nil)
+
+			req := httptest.NewRequest(http.MethodPut, "http://foo", bytes.NewBufferString(`{"with":100, "height":100}`))
+			req = mux.SetURLVars(req.WithContext(context.WithValue(req.Context(), common.UserID, uid)), map[string]string{"id": img.ID.String()})
+			rr := httptest.NewRecorder()
+			s.ResizeExistedImage(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code, "unexpected status code")
+
+			select {
+			case purged := <-purger.purged:
+				assert.Equal(t, []string{links.URL(img.ResizedKey)}, purged, "cached copies of the old resized image should be purged")
+			case <-time.After(time.Second):
+				t.Fatal("old resized image is not purged")
+			}
+
+			if tc.expCompensated {
+				select {
+				case c := <-compensated:
+					assert.Equal(t, models.CompensationPurgeObject, c.Action)
+					assert.Equal(t, img.ResizedKey, c.Key)
+				case <-time.After(time.Second):
+					t.Fatal("failed purge is not recorded")
+				}
+			}
+
+			exists, err := mem.Exists(ctx, img.ResizedKey)
+			assert.NoError(t, err)
+			assert.Equal(t, !tc.expOldDeleted, exists, "old resized image should be deleted before it is purged unless it is referenced")
+		})
+	}
+}
+
+func TestServiceImpl_DeleteImage(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+	imgID := uuid.New()
+
+	testCases := []struct {
+		name        string
+		id          string
+		expCode     int
+		getImageErr error
+		deleteErr   error
+		expEvent    models.EventType
+	}{
+		{
+			name:     "Good case",
+			id:       imgID.String(),
+			expCode:  http.StatusNoContent,
+			expEvent: models.EventImageDeleted,
+		},
+		{
+			name:    "Invalid ID case",
+			id:      "invalid id",
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:        "Not found case",
+			id:          imgID.String(),
+			expCode:     http.StatusNotFound,
+			getImageErr: gorm.ErrRecordNotFound,
+		},
+		{
+			name:        "Getting image error case",
+			id:          imgID.String(),
+			expCode:     http.StatusInternalServerError,
+			getImageErr: errors.New("ERROR"),
+		},
+		{
+			name:      "Deleting image error case",
+			id:        imgID.String(),
+			expCode:   http.StatusInternalServerError,
+			deleteErr: errors.New("ERROR"),
+			expEvent:  models.EventJobFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			bucket := mocks.NewMockStorage(ctrl)
+			repo := mocks.NewMockRepository(ctrl)
+			resizer := mocks.NewMockResizer(ctrl)
+			publisher := mocks.NewMockPublisher(ctrl)
+
+			repo.EXPECT().GetImageByID(gomock.Any(), imgID).Return(models.Images{ID: imgID, OriginalKey: "key", ResizedKey: "key"}, tc.getImageErr).AnyTimes()
+			repo.EXPECT().DeleteImage(gomock.Any(), imgID).Return(tc.deleteErr).AnyTimes()
+			bucket.EXPECT().URL(gomock.Any()).Return("").AnyTimes()
+			bucket.EXPECT().Delete(gomock.Any(), "key").Return(nil).AnyTimes()
+			repo.EXPECT().ReferencedKeys(gomock.Any()).Return(map[string]bool{}, nil).AnyTimes()
+			expectEvent(t, repo, publisher, tc.expEvent)
+
+			s := NewService(log, bucket, repo, resizer, publisher, nil, nil)
+
+			req := httptest.NewRequest(http.MethodDelete, "http://foo", nil)
+			req = mux.SetURLVars(req, map[string]string{
+				"id": tc.id,
+			})
+			rr := httptest.NewRecorder()
+			s.DeleteImage(rr, req)
+
+			resp := rr.Result()
+
 			assert.Equal(t, tc.expCode, resp.StatusCode, "unexpected status code")
 		})
 	}
@@ -0,0 +1,453 @@
+package images
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// tus protocol headers, see https://tus.io/protocols/resumable-upload.html
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+
+	headerTusResumable  = "Tus-Resumable"
+	headerTusVersion    = "Tus-Version"
+	headerTusExtension  = "Tus-Extension"
+	headerTusMaxSize    = "Tus-Max-Size"
+	headerUploadLength  = "Upload-Length"
+	headerUploadOffset  = "Upload-Offset"
+	headerUploadMeta    = "Upload-Metadata"
+	headerImageID       = "Image-Id"
+	contentTypeTusChunk = "application/offset+octet-stream"
+
+	// TusPath is the path of tus uploads under BasePath
+	TusPath = "/v1/tus"
+
+	// tusFinishStaleAfter is the time after which finishing upload could be claimed again
+	tusFinishStaleAfter = 10 * time.Minute
+)
+
+var errTusFinishing = errors.New("upload is being finished by another request")
+
+// TusOptions describes supported protocol version and extensions, it is served without user
+func (s *serviceImpl) TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerTusResumable, tusVersion)
+	w.Header().Set(headerTusVersion, tusVersion)
+	w.Header().Set(headerTusExtension, tusExtensions)
+	w.Header().Set(headerTusMaxSize, strconv.FormatInt(uploadMaxSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateTusUpload creates the upload of Upload-Length bytes, Upload-Metadata must contain
+// filename, width and height of the image
+func (s *serviceImpl) CreateTusUpload(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	s.log.Debugf("Started creating resumable upload for user %q", uid)
+
+	length, err := strconv.ParseInt(r.Header.Get(headerUploadLength), 10, 64)
+	if err != nil || length <= 0 {
+		common.SendError(w, http.StatusBadRequest, "invalid Upload-Length", err)
+		return
+	}
+
+	if length > uploadMaxSize() {
+		common.SendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload length exceeds %d bytes", uploadMaxSize()), nil)
+		return
+	}
+
+	meta, err := parseTusMetadata(r.Header.Get(headerUploadMeta))
+	if err != nil {
+		common.SendError(w, http.StatusBadRequest, "invalid Upload-Metadata", err)
+		return
+	}
+
+	params, err := parseResizeParams(meta[formWidth], meta[formHeight])
+	if err != nil {
+		common.SendError(w, http.StatusBadRequest, "invalid resize params in Upload-Metadata", err)
+		return
+	}
+
+	upload, err := s.uploads.SaveResumableUpload(models.ResumableUpload{
+		ID:       uuid.New(),
+		UserID:   uid,
+		Length:   length,
+		Filename: meta["filename"],
+		Width:    params.With,
+		Height:   params.Height,
+	})
+	if err != nil {
+		s.log.Errorf("cannot save resumable upload for user %q due to: %s", uid, err)
+		common.SendInternalServerError(w, "cannot create upload", err)
+		return
+	}
+
+	s.log.Debugf("Successfully created resumable upload %q for user %q", upload.ID, uid)
+
+	w.Header().Set("Location", config.Conf.BasePath+TusPath+"/"+upload.ID.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetTusUpload responds to HEAD request with the offset from which the client should resume
+func (s *serviceImpl) GetTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	upload, ok := s.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	setTusUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchTusUpload stores the chunk as a separate object, the last chunk hands off
+// the whole original to resizing. Interrupted chunk isn't stored, it should be sent again
+func (s *serviceImpl) PatchTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != contentTypeTusChunk {
+		common.SendError(w, http.StatusUnsupportedMediaType, "content type should be "+contentTypeTusChunk, nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		common.SendError(w, http.StatusBadRequest, "invalid Upload-Offset", err)
+		return
+	}
+
+	upload, ok := s.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if offset != upload.Offset {
+		common.SendError(w, http.StatusConflict, fmt.Sprintf("upload offset is %d", upload.Offset), nil)
+		return
+	}
+
+	if !upload.Received() {
+		if upload, ok = s.appendTusChunk(w, r, upload); !ok {
+			return
+		}
+	}
+
+	if upload.Received() && upload.ImageID == nil {
+		img, msg, err := s.finishTusUpload(r.Context(), upload)
+		if err == errTusFinishing {
+			common.SendError(w, http.StatusConflict, err.Error(), nil)
+			return
+		}
+		if err != nil {
+			common.SendServerError(w, msg, err)
+			return
+		}
+
+		upload.ImageID = &img.ID
+	}
+
+	setTusUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteTusUpload terminates the upload, the image created from finished upload isn't deleted
+func (s *serviceImpl) DeleteTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	upload, ok := s.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.uploads.DeleteResumableUpload(upload.UserID, upload.ID); err != nil {
+		s.log.Errorf("cannot delete resumable upload %q due to: %s", upload.ID, err)
+		common.SendInternalServerError(w, "cannot delete upload", err)
+		return
+	}
+
+	go s.deleteChunks(upload.Chunks)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *serviceImpl) getTusUpload(w http.ResponseWriter, r *http.Request) (models.ResumableUpload, bool) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		id  = mux.Vars(r)["id"]
+	)
+
+	uploadID, err := uuid.Parse(id)
+	if err != nil {
+		common.SendError(w, http.StatusNotFound, "invalid upload id", err)
+		return models.ResumableUpload{}, false
+	}
+
+	upload, err := s.uploads.GetResumableUpload(uid, uploadID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			common.SendNotFound(w, "upload id is not found: %s", err)
+			return models.ResumableUpload{}, false
+		}
+
+		s.log.Errorf("cannot retrieve resumable upload %q for user %q due to: %s", uploadID, uid, err)
+		common.SendInternalServerError(w, "cannot retrieve upload due to db problems", err)
+		return models.ResumableUpload{}, false
+	}
+
+	return upload, true
+}
+
+// appendTusChunk stores the body of the request and moves the offset,
+// the chunk of the request which lost the race for the offset is removed.
+// The prefix of the body which is received before the connection breaks is kept, so the client resumes after it
+func (s *serviceImpl) appendTusChunk(w http.ResponseWriter, r *http.Request, upload models.ResumableUpload) (models.ResumableUpload, bool) {
+	var (
+		remaining = upload.Length - upload.Offset
+		key       = fmt.Sprintf("%s%s/%020d-%s", storage.ChunkKeyPrefix, upload.ID, upload.Offset, uuid.New())
+		received  = &prefixReader{r: io.LimitReader(r.Body, remaining)}
+		body      = &countingReader{r: received}
+	)
+
+	// the request context is canceled when the client goes away, the received prefix is stored anyway
+	err := s.bucket.Put(context.Background(), key, body, storage.PutOptions{ContentType: "application/octet-stream", Size: -1})
+	if err != nil {
+		s.log.Errorf("cannot store chunk of upload %q due to: %s", upload.ID, err)
+		common.SendServerError(w, "cannot store chunk", err)
+		return upload, false
+	}
+
+	if received.err != nil {
+		s.log.Errorf("chunk of upload %q is received partially (%d bytes) due to: %s", upload.ID, body.n, received.err)
+	} else if n, _ := r.Body.Read(make([]byte, 1)); n > 0 {
+		go s.deleteChunks([]string{key})
+		common.SendError(w, http.StatusRequestEntityTooLarge, "chunk exceeds upload length", nil)
+		return upload, false
+	}
+
+	if body.n == 0 {
+		go s.deleteChunks([]string{key})
+		if received.err != nil {
+			common.SendError(w, http.StatusBadRequest, "cannot read chunk", received.err)
+		}
+		return upload, received.err == nil
+	}
+
+	appended, err := s.uploads.AppendChunk(upload.ID, upload.Offset, upload.Offset+body.n, key)
+	if err != nil || !appended {
+		go s.deleteChunks([]string{key})
+		if err != nil {
+			s.log.Errorf("cannot append chunk to upload %q due to: %s", upload.ID, err)
+			common.SendInternalServerError(w, "cannot store chunk", err)
+			return upload, false
+		}
+		common.SendError(w, http.StatusConflict, "upload offset has been changed by another request", nil)
+		return upload, false
+	}
+
+	upload.Offset += body.n
+	upload.Chunks = append(upload.Chunks, key)
+
+	if received.err != nil {
+		setTusUploadHeaders(w, upload)
+		common.SendError(w, http.StatusBadRequest, "chunk is received partially", received.err)
+		return upload, false
+	}
+
+	return upload, true
+}
+
+// finishTusUpload hands off received original to resize-and-save flow and removes the chunks,
+// failed upload could be finished again by PATCH with the final offset
+func (s *serviceImpl) finishTusUpload(ctx context.Context, upload models.ResumableUpload) (models.Images, string, error) {
+	claimed, err := s.uploads.ClaimFinish(upload.ID, time.Now().Add(-tusFinishStaleAfter))
+	if err != nil {
+		s.log.Errorf("cannot claim finishing of upload %q due to: %s", upload.ID, err)
+		return models.Images{}, "cannot finish upload", err
+	}
+	if !claimed {
+		return models.Images{}, "", errTusFinishing
+	}
+
+	chunks := upload.Chunks
+
+	img, msg, err := s.createTusImage(ctx, upload)
+
+	upload.Finishing = false
+	if err == nil {
+		upload.ImageID = &img.ID
+		upload.Chunks = nil
+	}
+
+	if _, saveErr := s.uploads.SaveResumableUpload(upload); saveErr != nil {
+		s.log.Errorf("cannot save resumable upload %q due to: %s", upload.ID, saveErr)
+	}
+
+	if err != nil {
+		return models.Images{}, msg, err
+	}
+
+	go s.deleteChunks(chunks)
+
+	s.log.Debugf("Successfully finished resumable upload %q for user %q", upload.ID, upload.UserID)
+
+	return img, "", nil
+}
+
+// createTusImage reads chunks one after another right into the content of the original
+func (s *serviceImpl) createTusImage(ctx context.Context, upload models.ResumableUpload) (models.Images, string, error) {
+	chunks := make([]io.Reader, 0, len(upload.Chunks))
+	for _, key := range upload.Chunks {
+		chunk := &chunkReader{ctx: ctx, bucket: s.bucket, key: key}
+		defer chunk.Close()
+		chunks = append(chunks, chunk)
+	}
+
+	content := make([]byte, upload.Length)
+	if _, err := io.ReadFull(io.MultiReader(chunks...), content); err != nil {
+		s.log.Errorf("cannot download chunks of upload %q due to: %s", upload.ID, err)
+		return models.Images{}, "cannot read uploaded chunks", err
+	}
+
+	return s.createImage(ctx, upload.UserID, content, upload.Filename, models.ResizeParams{
+		With:   upload.Width,
+		Height: upload.Height,
+	})
+}
+
+func (s *serviceImpl) deleteChunks(keys []string) {
+	s.deleteReleased(keys...)
+}
+
+// checkTusResumable rejects requests of unsupported protocol version
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set(headerTusResumable, tusVersion)
+
+	if r.Header.Get(headerTusResumable) != tusVersion {
+		w.Header().Set(headerTusVersion, tusVersion)
+		common.SendError(w, http.StatusPreconditionFailed, "unsupported tus version", nil)
+		return false
+	}
+
+	return true
+}
+
+func setTusUploadHeaders(w http.ResponseWriter, upload models.ResumableUpload) {
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	if upload.ImageID != nil {
+		w.Header().Set(headerImageID, upload.ImageID.String())
+	}
+}
+
+// parseTusMetadata parses comma separated pairs of key and base64 encoded value, the value could be omitted
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			meta[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode value of %q: %s", parts[0], err)
+			}
+			meta[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+
+	return meta, nil
+}
+
+func uploadMaxSize() int64 {
+	if config.Conf.UploadMaxSize > 0 {
+		return int64(config.Conf.UploadMaxSize)
+	}
+	return defaultUploadMaxSize
+}
+
+// prefixReader ends the content at the first read error, so the prefix read before it could be stored
+type prefixReader struct {
+	r   io.Reader
+	err error
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		p.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkReader downloads the chunk when it is read for the first time
+type chunkReader struct {
+	ctx    context.Context
+	bucket storage.Storage
+	key    string
+	body   io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.body == nil {
+		body, err := c.bucket.Get(c.ctx, c.key)
+		if err != nil {
+			return 0, fmt.Errorf("cannot download chunk %q: %s", c.key, err)
+		}
+		c.body = body
+	}
+	return c.body.Read(p)
+}
+
+func (c *chunkReader) Close() {
+	if c.body != nil {
+		common.CloseWithErrCheck(c.body, c.key)
+	}
+}
+
+// countingReader counts bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,461 @@
+package images
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+)
+
+func TestServiceImpl_CreateTusUpload(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("cat.png")) +
+		",width " + base64.StdEncoding.EncodeToString([]byte("20")) +
+		",height " + base64.StdEncoding.EncodeToString([]byte("10"))
+
+	testCases := []struct {
+		name     string
+		version  string
+		length   string
+		metadata string
+		saveErr  error
+		expCode  int
+	}{
+		{
+			name:     "Good case",
+			version:  tusVersion,
+			length:   "100",
+			metadata: metadata,
+			expCode:  http.StatusCreated,
+		},
+		{
+			name:     "Unsupported version case",
+			version:  "0.2.2",
+			length:   "100",
+			metadata: metadata,
+			expCode:  http.StatusPreconditionFailed,
+		},
+		{
+			name:     "Invalid length case",
+			version:  tusVersion,
+			length:   "-1",
+			metadata: metadata,
+			expCode:  http.StatusBadRequest,
+		},
+		{
+			name:     "Too large case",
+			version:  tusVersion,
+			length:   "1000000000",
+			metadata: metadata,
+			expCode:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "Invalid metadata case",
+			version:  tusVersion,
+			length:   "100",
+			metadata: "width not-base64!",
+			expCode:  http.StatusBadRequest,
+		},
+		{
+			name:     "Missing resize params case",
+			version:  tusVersion,
+			length:   "100",
+			metadata: "filename " + base64.StdEncoding.EncodeToString([]byte("cat.png")),
+			expCode:  http.StatusBadRequest,
+		},
+		{
+			name:     "Db error case",
+			version:  tusVersion,
+			length:   "100",
+			metadata: metadata,
+			saveErr:  errors.New("DB ERROR"),
+			expCode:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				uploads = mocks.NewMockUploadRepository(ctrl)
+				saved   models.ResumableUpload
+			)
+
+			uploads.EXPECT().SaveResumableUpload(gomock.Any()).DoAndReturn(func(u models.ResumableUpload) (models.ResumableUpload, error) {
+				saved = u
+				return u, tc.saveErr
+			}).AnyTimes()
+
+			s := NewService(log, memory.NewStorage("http://localhost/files"), nil, nil, nil, nil, uploads)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://foo", nil)
+			req.Header.Set(headerTusResumable, tc.version)
+			req.Header.Set(headerUploadLength, tc.length)
+			req.Header.Set(headerUploadMeta, tc.metadata)
+			s.CreateTusUpload(rr, req)
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+			assert.Equal(t, tusVersion, rr.Header().Get(headerTusResumable))
+
+			if tc.expCode == http.StatusCreated {
+				assert.Equal(t, TusPath+"/"+saved.ID.String(), rr.Header().Get("Location"))
+				assert.Equal(t, "cat.png", saved.Filename)
+				assert.Equal(t, int64(100), saved.Length)
+				assert.Equal(t, uint(20), saved.Width)
+				assert.Equal(t, uint(10), saved.Height)
+			}
+		})
+	}
+}
+
+func TestServiceImpl_PatchTusUpload(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	const length = 10
+
+	testCases := []struct {
+		name        string
+		contentType string
+		received    int64
+		offset      string
+		body        string
+		broken      bool
+		getErr      error
+		appended    bool
+		resizeErr   error
+		expCode     int
+		expOffset   int64
+		expImage    bool
+	}{
+		{
+			name:        "First chunk case",
+			contentType: contentTypeTusChunk,
+			offset:      "0",
+			body:        "01234",
+			appended:    true,
+			expCode:     http.StatusNoContent,
+			expOffset:   5,
+		},
+		{
+			name:        "Last chunk case",
+			contentType: contentTypeTusChunk,
+			received:    5,
+			offset:      "5",
+			body:        "56789",
+			appended:    true,
+			expCode:     http.StatusNoContent,
+			expOffset:   length,
+			expImage:    true,
+		},
+		{
+			name:        "Retry of failed finishing case",
+			contentType: contentTypeTusChunk,
+			received:    length,
+			offset:      "10",
+			expCode:     http.StatusNoContent,
+			expOffset:   length,
+			expImage:    true,
+		},
+		{
+			name:        "Resize error case",
+			contentType: contentTypeTusChunk,
+			received:    5,
+			offset:      "5",
+			body:        "56789",
+			appended:    true,
+			resizeErr:   errors.New("RESIZE ERROR"),
+			expCode:     http.StatusInternalServerError,
+			expOffset:   length,
+		},
+		{
+			name:        "Wrong offset case",
+			contentType: contentTypeTusChunk,
+			received:    5,
+			offset:      "0",
+			body:        "01234",
+			expCode:     http.StatusConflict,
+			expOffset:   5,
+		},
+		{
+			name:        "Lost race case",
+			contentType: contentTypeTusChunk,
+			offset:      "0",
+			body:        "01234",
+			expCode:     http.StatusConflict,
+		},
+		{
+			name:        "Broken body case",
+			contentType: contentTypeTusChunk,
+			offset:      "0",
+			body:        "012",
+			broken:      true,
+			appended:    true,
+			expCode:     http.StatusBadRequest,
+			expOffset:   3,
+		},
+		{
+			name:        "Broken empty body case",
+			contentType: contentTypeTusChunk,
+			offset:      "0",
+			broken:      true,
+			expCode:     http.StatusBadRequest,
+		},
+		{
+			name:        "Chunk exceeds length case",
+			contentType: contentTypeTusChunk,
+			received:    5,
+			offset:      "5",
+			body:        "56789-extra",
+			expCode:     http.StatusRequestEntityTooLarge,
+			expOffset:   5,
+		},
+		{
+			name:        "Wrong content type case",
+			contentType: "image/png",
+			offset:      "0",
+			body:        "01234",
+			expCode:     http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Not found case",
+			contentType: contentTypeTusChunk,
+			offset:      "0",
+			body:        "01234",
+			getErr:      gorm.ErrRecordNotFound,
+			expCode:     http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				repo      = mocks.NewMockRepository(ctrl)
+				uploads   = mocks.NewMockUploadRepository(ctrl)
+				resizer   = mocks.NewMockResizer(ctrl)
+				publisher = mocks.NewMockPublisher(ctrl)
+				bucket    = memory.NewStorage("http://localhost/files")
+				upload    = models.ResumableUpload{ID: uuid.New(), Length: length, Offset: tc.received, Filename: "cat.png", Width: 2, Height: 1}
+			)
+
+			if tc.received > 0 {
+				key := storage.ChunkKeyPrefix + upload.ID.String() + "/first"
+				assert.NoError(t, bucket.Put(context.Background(), key, strings.NewReader("0123456789"[:tc.received]), storage.PutOptions{Size: -1}))
+				upload.Chunks = append(upload.Chunks, key)
+			}
+
+			uploads.EXPECT().GetResumableUpload(gomock.Any(), upload.ID).Return(upload, tc.getErr).AnyTimes()
+			uploads.EXPECT().AppendChunk(upload.ID, tc.received, gomock.Any(), gomock.Any()).
+				DoAndReturn(func(id uuid.UUID, offset, newOffset int64, key string) (bool, error) {
+					if tc.appended {
+						assert.Equal(t, tc.expOffset, newOffset, "unexpected appended offset")
+					}
+					return tc.appended, nil
+				}).AnyTimes()
+			uploads.EXPECT().ClaimFinish(upload.ID, gomock.Any()).Return(true, nil).AnyTimes()
+			uploads.EXPECT().SaveResumableUpload(gomock.Any()).DoAndReturn(func(u models.ResumableUpload) (models.ResumableUpload, error) {
+				upload = u
+				return u, nil
+			}).AnyTimes()
+			repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo)).AnyTimes()
+			repo.EXPECT().SaveImage(gomock.Any()).DoAndReturn(func(img models.Images) (models.Images, error) {
+				return img, nil
+			}).AnyTimes()
+			repo.EXPECT().SaveEvent(gomock.Any()).Return(nil).AnyTimes()
+			repo.EXPECT().ReferencedKeys(gomock.Any()).Return(map[string]bool{}, nil).AnyTimes()
+			resizer.EXPECT().Resize(gomock.Any(), gomock.Any()).Return([]byte("resized"), tc.resizeErr).AnyTimes()
+			publisher.EXPECT().Publish(gomock.Any()).Return(nil).AnyTimes()
+
+			s := NewService(log, bucket, repo, resizer, publisher, nil, uploads)
+
+			rr := httptest.NewRecorder()
+			var body io.Reader = strings.NewReader(tc.body)
+			if tc.broken {
+				// the connection breaks after the body is sent partially
+				body = io.MultiReader(body, brokenReader{})
+			}
+			req := httptest.NewRequest(http.MethodPatch, "http://foo", body)
+			req.Header.Set(headerTusResumable, tusVersion)
+			req.Header.Set(headerUploadOffset, tc.offset)
+			req.Header.Set("Content-Type", tc.contentType)
+			s.PatchTusUpload(rr, mux.SetURLVars(req, map[string]string{"id": upload.ID.String()}))
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+
+			if tc.expCode == http.StatusNoContent || (tc.broken && tc.appended) {
+				assert.Equal(t, strconv.FormatInt(tc.expOffset, 10), rr.Header().Get(headerUploadOffset), "unexpected offset")
+			}
+
+			if tc.expImage {
+				if assert.NotNil(t, upload.ImageID, "image should be created") {
+					assert.Equal(t, upload.ImageID.String(), rr.Header().Get(headerImageID))
+				}
+				assert.Empty(t, upload.Chunks, "chunks should be released")
+				assert.False(t, upload.Finishing, "upload should be released")
+			} else {
+				assert.Empty(t, rr.Header().Get(headerImageID))
+			}
+
+			if tc.resizeErr != nil {
+				assert.Nil(t, upload.ImageID)
+				assert.False(t, upload.Finishing, "failed upload should be released for retry")
+				assert.Len(t, upload.Chunks, 2, "chunks should be kept for retry")
+			}
+		})
+	}
+}
+
+// brokenReader fails like the body of the request whose client has gone away
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) {
+	return 0, errors.New("unexpected EOF")
+}
+
+func TestServiceImpl_GetTusUpload(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		uploads = mocks.NewMockUploadRepository(ctrl)
+		imageID = uuid.New()
+		upload  = models.ResumableUpload{ID: uuid.New(), Length: 10, Offset: 10, ImageID: &imageID}
+	)
+
+	uploads.EXPECT().GetResumableUpload(gomock.Any(), upload.ID).Return(upload, nil)
+
+	s := NewService(log, memory.NewStorage("http://localhost/files"), nil, nil, nil, nil, uploads)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodHead, "http://foo", nil)
+	req.Header.Set(headerTusResumable, tusVersion)
+	s.GetTusUpload(rr, mux.SetURLVars(req, map[string]string{"id": upload.ID.String()}))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get(headerUploadOffset))
+	assert.Equal(t, "10", rr.Header().Get(headerUploadLength))
+	assert.Equal(t, imageID.String(), rr.Header().Get(headerImageID))
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+}
+
+func TestServiceImpl_DeleteTusUpload(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	testCases := []struct {
+		name      string
+		getErr    error
+		deleteErr error
+		expCode   int
+	}{
+		{
+			name:    "Good case",
+			expCode: http.StatusNoContent,
+		},
+		{
+			name:    "Not found case",
+			getErr:  gorm.ErrRecordNotFound,
+			expCode: http.StatusNotFound,
+		},
+		{
+			name:      "Db error case",
+			deleteErr: errors.New("DB ERROR"),
+			expCode:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				uploads = mocks.NewMockUploadRepository(ctrl)
+				upload  = models.ResumableUpload{ID: uuid.New(), Length: 10}
+			)
+
+			uploads.EXPECT().GetResumableUpload(gomock.Any(), upload.ID).Return(upload, tc.getErr)
+			uploads.EXPECT().DeleteResumableUpload(gomock.Any(), upload.ID).Return(tc.deleteErr).AnyTimes()
+
+			s := NewService(log, memory.NewStorage("http://localhost/files"), nil, nil, nil, nil, uploads)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "http://foo", nil)
+			req.Header.Set(headerTusResumable, tusVersion)
+			s.DeleteTusUpload(rr, mux.SetURLVars(req, map[string]string{"id": upload.ID.String()}))
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+		})
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	testCases := []struct {
+		name    string
+		header  string
+		exp     map[string]string
+		expFail bool
+	}{
+		{
+			name:   "Empty case",
+			header: "",
+			exp:    map[string]string{},
+		},
+		{
+			name:   "Pairs case",
+			header: "filename Y2F0LnBuZw==, width MjA=,flag",
+			exp:    map[string]string{"filename": "cat.png", "width": "20", "flag": ""},
+		},
+		{
+			name:    "Invalid base64 case",
+			header:  "filename !!!",
+			expFail: true,
+		},
+		{
+			name:    "Invalid pair case",
+			header:  "filename a b",
+			expFail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			meta, err := parseTusMetadata(tc.header)
+			if tc.expFail {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.exp, meta)
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	defaultUploadTTL     = 15 * time.Minute
+	defaultUploadMaxSize = 50 << 20
+
+	// uploadStaleAfter is the time after which processing upload could be claimed again
+	uploadStaleAfter = 10 * time.Minute
+)
+
+// UploadRequest describes the original which the client is going to upload to the bucket
+type UploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       uint   `json:"width"`
+	Height      uint   `json:"height"`
+}
+
+// UploadResponse contains the request which uploads the original directly to the bucket
+type UploadResponse struct {
+	models.Upload
+	Request storage.PresignedRequest `json:"request"`
+}
+
+func (s *serviceImpl) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		req UploadRequest
+	)
+
+	s.log.Debugf("Started creating upload for user %q", uid)
+
+	presigner, ok := storage.AsUploadPresigner(s.bucket)
+	if !ok {
+		common.SendError(w, http.StatusNotImplemented, "direct uploads are not supported by the storage", nil)
+		return
+	}
+
+	if err := common.ReadRequestJSONBodyToStruct(r, &req); err != nil {
+		s.log.Errorf("cannot extract data from request due to: %s", err)
+		common.SendError(w, http.StatusBadRequest, "invalid input data", err)
+		return
+	}
+
+	if err := validateUploadRequest(req); err != nil {
+		common.SendError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	ttl := time.Duration(config.Conf.UploadURLTTLSec) * time.Second
+	if ttl <= 0 {
+		ttl = defaultUploadTTL
+	}
+
+	// the image id is reserved, so the original is uploaded under its final key with its attributes
+	imageID := uuid.New()
+	params := storage.KeyParams{
+		UserID:  uid,
+		ImageID: imageID,
+		Variant: storage.VariantOriginal,
+		Ext:     uploadExt(req.Filename, req.ContentType),
+	}
+	key := storage.Keys.Key(params)
+
+	presigned, err := presigner.PresignPut(key, storage.PutOptions{
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		Attributes:  storage.ImageAttributes(params, req.Filename),
+	}, ttl)
+	if err != nil {
+		s.log.Errorf("cannot presign upload for user %q due to: %s", uid, err)
+		common.SendInternalServerError(w, "cannot create upload", err)
+		return
+	}
+
+	upload, err := s.uploads.SaveUpload(models.Upload{
+		ID:          uuid.New(),
+		UserID:      uid,
+		ObjectKey:   key,
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		Width:       req.Width,
+		Height:      req.Height,
+		Status:      models.UploadPending,
+		ImageID:     &imageID,
+		ExpiresAt:   time.Now().Add(ttl),
+	})
+	if err != nil {
+		s.log.Errorf("cannot save upload for user %q due to: %s", uid, err)
+		common.SendInternalServerError(w, "cannot create upload", err)
+		return
+	}
+
+	s.log.Debugf("Successfully created upload %q for user %q", upload.ID, uid)
+
+	common.RenderJSONCreated(w, &UploadResponse{Upload: upload, Request: presigned})
+}
+
+// CompleteUpload checks the uploaded original against the upload conditions and creates the image from it.
+// Completing of already completed upload returns the same image
+func (s *serviceImpl) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		id  = mux.Vars(r)["id"]
+	)
+
+	s.log.Debugf("Started completing upload %q for user %q", id, uid)
+
+	uploadID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse upload id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid upload id", err)
+		return
+	}
+
+	upload, err := s.uploads.GetUpload(uid, uploadID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			common.SendNotFound(w, "upload id is not found: %s", err)
+			return
+		}
+
+		s.log.Errorf("cannot retrieve upload %q for user %q due to: %s", uploadID, uid, err)
+		common.SendInternalServerError(w, "cannot retrieve upload due to db problems", err)
+		return
+	}
+
+	if upload.Status == models.UploadCompleted && upload.ImageID != nil {
+		s.renderCompletedUpload(w, uid, *upload.ImageID)
+		return
+	}
+
+	claimed, err := s.uploads.ClaimUpload(uploadID, time.Now().Add(-uploadStaleAfter))
+	if err != nil {
+		s.log.Errorf("cannot claim upload %q due to: %s", uploadID, err)
+		common.SendInternalServerError(w, "cannot complete upload", err)
+		return
+	}
+	if !claimed {
+		common.SendError(w, http.StatusConflict, "upload is being completed", nil)
+		return
+	}
+
+	img, status, msg, err := s.completeUpload(r, upload)
+	if err != nil {
+		// the client could fix the upload and complete it again
+		upload.Status = models.UploadPending
+		if _, saveErr := s.uploads.SaveUpload(upload); saveErr != nil {
+			s.log.Errorf("cannot release upload %q due to: %s", uploadID, saveErr)
+		}
+
+		common.SendError(w, status, msg, err)
+		return
+	}
+
+	upload.Status = models.UploadCompleted
+	upload.ImageID = &img.ID
+	if _, err = s.uploads.SaveUpload(upload); err != nil {
+		s.log.Errorf("cannot save completed upload %q due to: %s", uploadID, err)
+	}
+
+	s.log.Debugf("Successfully completed upload %q for user %q", uploadID, uid)
+
+	common.RenderJSONCreated(w, &img)
+}
+
+// completeUpload validates the uploaded object and runs resize pipeline for it,
+// on failure it returns the status code and the message which could be shown to the client
+func (s *serviceImpl) completeUpload(r *http.Request, upload models.Upload) (models.Images, int, string, error) {
+	info, err := s.bucket.Stat(r.Context(), upload.ObjectKey)
+	if err == storage.ErrNotFound {
+		return models.Images{}, http.StatusConflict, "original is not uploaded", err
+	}
+	if err != nil {
+		s.log.Errorf("cannot stat uploaded object %q due to: %s", upload.ObjectKey, err)
+		return models.Images{}, common.ServerErrorStatus(err), "cannot check uploaded original", err
+	}
+
+	if err = checkUploadedObject(upload, info); err != nil {
+		s.log.Errorf("uploaded object %q is rejected: %s", upload.ObjectKey, err)
+		// the key is kept, so the object is removed to let the client upload it again
+		s.deleteImage(upload.ObjectKey)
+		return models.Images{}, http.StatusBadRequest, err.Error(), err
+	}
+
+	// the client uploads the original around the service, so its checksum is computed from the stored object
+	original, err := storage.Download(r.Context(), s.bucket, upload.ObjectKey, "")
+	if err != nil {
+		s.log.Errorf("cannot download uploaded object %q due to: %s", upload.ObjectKey, err)
+		s.notifyFailure(upload.UserID, uuid.Nil, operationCreate, err)
+		return models.Images{}, common.ServerErrorStatus(err), "cannot download uploaded original", err
+	}
+
+	resizedImg, err := s.resizer.Resize(bytes.NewReader(original), models.ResizeParams{With: upload.Width, Height: upload.Height})
+	if err != nil {
+		s.log.Errorf("cannot resize uploaded object %q due to: %s", upload.ObjectKey, err)
+		s.notifyFailure(upload.UserID, uuid.Nil, operationCreate, err)
+		return models.Images{}, http.StatusUnprocessableEntity, "image cannot be resized", err
+	}
+
+	imageID := uuid.New()
+	if upload.ImageID != nil {
+		imageID = *upload.ImageID
+	}
+
+	var (
+		originalParams = storage.KeyParams{UserID: upload.UserID, ImageID: imageID, Variant: storage.VariantOriginal}
+		resizedParams  = storage.KeyParams{
+			UserID:  upload.UserID,
+			ImageID: imageID,
+			Variant: storage.ResizedVariant(upload.Width, upload.Height),
+			Ext:     path.Ext(upload.ObjectKey),
+		}
+		resized = storage.Keys.Key(resizedParams)
+	)
+
+	// the original is uploaded directly to the primary storage, so it is replicated here.
+	// Attributes are signed into the upload, originals uploaded without them get them by the storage
+	if attrs := storage.ImageAttributes(originalParams, upload.Filename); info.Attributes.Equal(attrs) {
+		err = storage.Replicate(r.Context(), s.bucket, upload.ObjectKey)
+	} else {
+		info.SHA256 = storage.Checksum(original)
+		err = storage.SetAttributes(r.Context(), s.bucket, info, attrs)
+	}
+	if err != nil {
+		s.log.Errorf("cannot store uploaded object %q due to: %s", upload.ObjectKey, err)
+		s.notifyFailure(upload.UserID, uuid.Nil, operationCreate, err)
+		return models.Images{}, common.ServerErrorStatus(err), "cannot store uploaded original", err
+	}
+
+	err = storage.Upload(r.Context(), s.bucket, storage.Object{
+		Key:        resized,
+		Content:    resizedImg,
+		Attributes: storage.ImageAttributes(resizedParams, upload.Filename),
+	})
+	if err != nil {
+		s.log.Errorf("cannot upload resized image due to: %s", err)
+		s.notifyFailure(upload.UserID, uuid.Nil, operationCreate, err)
+		return models.Images{}, common.ServerErrorStatus(err), "cannot upload images", err
+	}
+
+	img, err := s.saveWithEvent(models.EventImageCreated, func(repo repository.Repository) (models.Images, error) {
+		return repo.SaveImage(models.Images{
+			ID:             imageID,
+			OriginalKey:    upload.ObjectKey,
+			ResizedKey:     resized,
+			UserID:         upload.UserID,
+			Filename:       upload.Filename,
+			OriginalSHA256: storage.Checksum(original),
+			ResizedSHA256:  storage.Checksum(resizedImg),
+		})
+	})
+	if err != nil {
+		s.log.Errorf("cannot save images due to: %s", err)
+		s.notifyFailure(upload.UserID, uuid.Nil, operationCreate, err)
+		s.rollback(resized)
+		return models.Images{}, http.StatusInternalServerError, "cannot save images", err
+	}
+
+	return img, 0, "", nil
+}
+
+func (s *serviceImpl) renderCompletedUpload(w http.ResponseWriter, uid, imageID uuid.UUID) {
+	img, err := s.repo.GetImageByID(uid, imageID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			common.SendNotFound(w, "image of the upload is deleted: %s", err)
+			return
+		}
+
+		s.log.Errorf("cannot retrieve image with id (%q) for user (%q) due to: %s", imageID, uid, err)
+		common.SendInternalServerError(w, "cannot retrieve image due to db problems", err)
+		return
+	}
+
+	if img, err = s.withLinks(img); err != nil {
+		s.log.Errorf("cannot build links of image %q due to: %s", img.ID, err)
+		common.SendInternalServerError(w, "cannot retrieve image", err)
+		return
+	}
+
+	common.RenderJSON(w, &img)
+}
+
+func validateUploadRequest(req UploadRequest) error {
+	maxSize := uploadMaxSize()
+
+	if !strings.HasPrefix(req.ContentType, "image/") {
+		return fmt.Errorf("content type %q is not an image", req.ContentType)
+	}
+
+	if req.Size <= 0 || req.Size > maxSize {
+		return fmt.Errorf("size should be between 1 and %d bytes", maxSize)
+	}
+
+	return nil
+}
+
+// checkUploadedObject compares uploaded object with the conditions of the upload, content type and size
+// are signed by presigned requests of S3, but other storages could accept any content
+func checkUploadedObject(upload models.Upload, info storage.ObjectInfo) error {
+	if info.Size != upload.Size {
+		return fmt.Errorf("uploaded size %d doesn't match declared size %d", info.Size, upload.Size)
+	}
+
+	if info.ContentType != upload.ContentType {
+		return fmt.Errorf("uploaded content type %q doesn't match declared %q", info.ContentType, upload.ContentType)
+	}
+
+	return nil
+}
+
+// uploadExt returns extension of the filename or the one of the content type
+func uploadExt(filename, contentType string) string {
+	if ext := path.Ext(filename); ext != "" {
+		return strings.ToLower(ext)
+	}
+
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,397 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	goimage "image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/clients/bucket"
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	awsStorage "github.com/Dimitriy14/image-resizing/storage/aws"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest/s3fake"
+	"github.com/Dimitriy14/image-resizing/usecases"
+)
+
+const testBucket = "test-bucket"
+
+// TestServiceImpl_Uploads runs the whole direct upload flow against S3-compatible fake
+func TestServiceImpl_Uploads(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	bucketStorage, cleanup := newS3Storage(t)
+	defer cleanup()
+
+	var (
+		repo      = mocks.NewMockRepository(ctrl)
+		uploads   = mocks.NewMockUploadRepository(ctrl)
+		publisher = mocks.NewMockPublisher(ctrl)
+		content   = newPNG(t, 20, 10)
+		saved     models.Upload
+		savedImg  models.Images
+	)
+
+	uploads.EXPECT().SaveUpload(gomock.Any()).DoAndReturn(func(u models.Upload) (models.Upload, error) {
+		saved = u
+		return u, nil
+	}).Times(2)
+	uploads.EXPECT().GetUpload(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ uuid.UUID) (models.Upload, error) {
+		return saved, nil
+	})
+	uploads.EXPECT().ClaimUpload(gomock.Any(), gomock.Any()).Return(true, nil)
+	repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo))
+	repo.EXPECT().SaveImage(gomock.Any()).DoAndReturn(func(img models.Images) (models.Images, error) {
+		savedImg = img
+		return img, nil
+	})
+	repo.EXPECT().SaveEvent(gomock.Any()).DoAndReturn(func(e models.Event) error {
+		var payload models.Images
+		assert.NoError(t, json.Unmarshal(e.Data, &payload))
+		// events could be delivered after presigned links expire, so they link images to the API
+		assert.Equal(t, "/v1/images/"+payload.ID.String()+"/original", strings.TrimPrefix(payload.Original, config.Conf.BasePath))
+		assert.Equal(t, "/v1/images/"+payload.ID.String()+"/resized", strings.TrimPrefix(payload.Resized, config.Conf.BasePath))
+		return nil
+	})
+
+	s := NewService(log, bucketStorage, repo, usecases.NewImageResizer(), publisher, nil, uploads)
+
+	body, _ := json.Marshal(UploadRequest{Filename: "cat.png", ContentType: "image/png", Size: int64(len(content)), Width: 10, Height: 5})
+	rr := httptest.NewRecorder()
+	s.CreateUpload(rr, httptest.NewRequest(http.MethodPost, "http://foo/v1/uploads", bytes.NewReader(body)))
+
+	if !assert.Equal(t, http.StatusCreated, rr.Code, "unexpected status code of upload creation") {
+		return
+	}
+
+	var created UploadResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	assert.Equal(t, models.UploadPending, created.Status)
+	assert.Equal(t, http.MethodPut, created.Request.Method)
+	assert.Equal(t, "image/png", created.Request.Headers["Content-Type"], "content type should be signed")
+	assert.Equal(t, strconv.Itoa(len(content)), created.Request.Headers["Content-Length"], "size should be signed")
+	assert.Equal(t, "inline; filename=cat.png", created.Request.Headers["Content-Disposition"], "attributes should be signed")
+
+	req, err := http.NewRequest(created.Request.Method, created.Request.URL, bytes.NewReader(content))
+	assert.NoError(t, err)
+	for name, value := range created.Request.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err, "upload to the bucket failed") {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "http://foo/v1/uploads/"+created.ID.String()+"/complete", nil)
+	s.CompleteUpload(rr, mux.SetURLVars(req, map[string]string{"id": created.ID.String()}))
+
+	if !assert.Equal(t, http.StatusCreated, rr.Code, "unexpected status code of upload completion") {
+		return
+	}
+
+	var img models.Images
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&img))
+	assert.Empty(t, img.OriginalKey, "storage keys shouldn't be exposed")
+	img = savedImg
+	assert.Equal(t, saved.ObjectKey, img.OriginalKey, "uploaded object should become the original")
+	assert.Equal(t, models.UploadCompleted, saved.Status)
+	assert.Equal(t, created.ImageID, &img.ID, "reserved image id should be used")
+	assert.True(t, storage.Keys.Match(img.OriginalKey) && storage.Keys.Match(img.ResizedKey), "keys should follow the template")
+	assert.True(t, strings.HasSuffix(img.ResizedKey, "/10x5.png"), "resized key should contain the size")
+
+	info, err := bucketStorage.Stat(context.Background(), img.OriginalKey)
+	if assert.NoError(t, err, "original should be stored") {
+		attrs := storage.ImageAttributes(storage.KeyParams{UserID: img.UserID, ImageID: img.ID, Variant: storage.VariantOriginal}, "cat.png")
+		assert.True(t, attrs.Equal(info.Attributes), "original should have attributes, got %+v", info.Attributes)
+	}
+
+	resized, err := bucketStorage.Get(context.Background(), img.ResizedKey)
+	if assert.NoError(t, err, "resized image should be stored") {
+		decoded, err := png.Decode(resized)
+		resized.Close()
+		if assert.NoError(t, err) {
+			assert.Equal(t, goimage.Rect(0, 0, 10, 5), decoded.Bounds())
+		}
+	}
+}
+
+func TestServiceImpl_CreateUpload(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	bucketStorage, cleanup := newS3Storage(t)
+	defer cleanup()
+
+	testCases := []struct {
+		name    string
+		storage storage.Storage
+		body    string
+		saveErr error
+		expCode int
+	}{
+		{
+			name:    "Good case",
+			storage: bucketStorage,
+			body:    `{"filename":"cat.jpg","content_type":"image/jpeg","size":100,"width":10,"height":10}`,
+			expCode: http.StatusCreated,
+		},
+		{
+			name:    "Not an image case",
+			storage: bucketStorage,
+			body:    `{"filename":"cat.txt","content_type":"text/plain","size":100}`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Too large case",
+			storage: bucketStorage,
+			body:    `{"filename":"cat.jpg","content_type":"image/jpeg","size":1000000000}`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Invalid body case",
+			storage: bucketStorage,
+			body:    `{`,
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Saving error case",
+			storage: bucketStorage,
+			body:    `{"filename":"cat.jpg","content_type":"image/jpeg","size":100}`,
+			saveErr: gorm.ErrInvalidSQL,
+			expCode: http.StatusInternalServerError,
+		},
+		{
+			name:    "Storage without direct uploads case",
+			storage: memory.NewStorage("http://localhost/files"),
+			body:    `{"filename":"cat.jpg","content_type":"image/jpeg","size":100}`,
+			expCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			uploads := mocks.NewMockUploadRepository(ctrl)
+			uploads.EXPECT().SaveUpload(gomock.Any()).DoAndReturn(func(u models.Upload) (models.Upload, error) {
+				assert.Equal(t, ".jpg", u.ObjectKey[strings.LastIndex(u.ObjectKey, "."):])
+				return u, tc.saveErr
+			}).AnyTimes()
+
+			s := NewService(log, tc.storage, nil, nil, nil, nil, uploads)
+
+			rr := httptest.NewRecorder()
+			s.CreateUpload(rr, httptest.NewRequest(http.MethodPost, "http://foo/v1/uploads", strings.NewReader(tc.body)))
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+		})
+	}
+}
+
+func TestServiceImpl_CompleteUpload(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	var (
+		imageID = uuid.New()
+		content = []byte("content")
+	)
+
+	testCases := []struct {
+		name        string
+		status      models.UploadStatus
+		uploaded    []byte
+		contentType string
+		getErr      error
+		claimed     bool
+		resizeErr   error
+		expCode     int
+		expStatus   models.UploadStatus
+		expDeleted  bool
+	}{
+		{
+			name:        "Good case",
+			status:      models.UploadPending,
+			uploaded:    content,
+			contentType: "image/png",
+			claimed:     true,
+			expCode:     http.StatusCreated,
+			expStatus:   models.UploadCompleted,
+		},
+		{
+			name:      "Already completed case",
+			status:    models.UploadCompleted,
+			expCode:   http.StatusOK,
+			expStatus: models.UploadCompleted,
+		},
+		{
+			name:      "Not uploaded case",
+			status:    models.UploadPending,
+			claimed:   true,
+			expCode:   http.StatusConflict,
+			expStatus: models.UploadPending,
+		},
+		{
+			name:        "Size mismatch case",
+			status:      models.UploadPending,
+			uploaded:    []byte("longer content"),
+			contentType: "image/png",
+			claimed:     true,
+			expCode:     http.StatusBadRequest,
+			expStatus:   models.UploadPending,
+			expDeleted:  true,
+		},
+		{
+			name:        "Content type mismatch case",
+			status:      models.UploadPending,
+			uploaded:    content,
+			contentType: "text/plain",
+			claimed:     true,
+			expCode:     http.StatusBadRequest,
+			expStatus:   models.UploadPending,
+			expDeleted:  true,
+		},
+		{
+			name:        "Resize error case",
+			status:      models.UploadPending,
+			uploaded:    content,
+			contentType: "image/png",
+			claimed:     true,
+			resizeErr:   errors.New("RESIZE ERROR"),
+			expCode:     http.StatusUnprocessableEntity,
+			expStatus:   models.UploadPending,
+		},
+		{
+			name:      "Claimed by another request case",
+			status:    models.UploadPending,
+			expCode:   http.StatusConflict,
+			expStatus: models.UploadPending,
+		},
+		{
+			name:    "Not found case",
+			getErr:  gorm.ErrRecordNotFound,
+			expCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				repo      = mocks.NewMockRepository(ctrl)
+				uploads   = mocks.NewMockUploadRepository(ctrl)
+				resizer   = mocks.NewMockResizer(ctrl)
+				publisher = mocks.NewMockPublisher(ctrl)
+				bucket    = memory.NewStorage("http://localhost/files")
+				upload    = models.Upload{ID: uuid.New(), ObjectKey: "pictures/upload.png", ContentType: "image/png", Size: int64(len(content)), Status: tc.status}
+			)
+
+			if tc.status == models.UploadCompleted {
+				upload.ImageID = &imageID
+			}
+			if tc.uploaded != nil {
+				assert.NoError(t, bucket.Put(context.Background(), upload.ObjectKey, bytes.NewReader(tc.uploaded), storage.PutOptions{ContentType: tc.contentType}))
+			}
+
+			uploads.EXPECT().GetUpload(gomock.Any(), upload.ID).Return(upload, tc.getErr)
+			uploads.EXPECT().ClaimUpload(upload.ID, gomock.Any()).Return(tc.claimed, nil).AnyTimes()
+			uploads.EXPECT().SaveUpload(gomock.Any()).DoAndReturn(func(u models.Upload) (models.Upload, error) {
+				upload = u
+				return u, nil
+			}).AnyTimes()
+			repo.EXPECT().GetImageByID(gomock.Any(), imageID).Return(models.Images{ID: imageID}, nil).AnyTimes()
+			repo.EXPECT().Transaction(gomock.Any()).DoAndReturn(inTransaction(repo)).AnyTimes()
+			repo.EXPECT().SaveImage(gomock.Any()).DoAndReturn(func(img models.Images) (models.Images, error) {
+				return img, nil
+			}).AnyTimes()
+			repo.EXPECT().SaveEvent(gomock.Any()).Return(nil).AnyTimes()
+			resizer.EXPECT().Resize(gomock.Any(), gomock.Any()).Return([]byte("resized"), tc.resizeErr).AnyTimes()
+			publisher.EXPECT().Publish(gomock.Any()).Return(nil).AnyTimes()
+
+			s := NewService(log, bucket, repo, resizer, publisher, nil, uploads)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://foo", nil)
+			s.CompleteUpload(rr, mux.SetURLVars(req, map[string]string{"id": upload.ID.String()}))
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+			if tc.getErr == nil {
+				assert.Equal(t, tc.expStatus, upload.Status, "unexpected upload status")
+			}
+
+			if tc.uploaded != nil {
+				exists, _ := bucket.Exists(context.Background(), upload.ObjectKey)
+				assert.Equal(t, !tc.expDeleted, exists, "rejected object should be deleted")
+			}
+		})
+	}
+}
+
+func newS3Storage(t *testing.T) (storage.Storage, func()) {
+	srv := s3fake.New()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("eu-central-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatalf("cannot create session: %s", err)
+	}
+
+	conf := config.Conf
+	config.Conf.AWSBucket = testBucket
+	config.Conf.AWSImageStorageURL = ""
+
+	s := awsStorage.NewStorage(&bucket.S3Client{
+		S3:       s3.New(sess),
+		Uploader: s3manager.NewUploader(sess),
+		URL:      srv.URL + "/" + testBucket,
+	})
+
+	return s, func() {
+		config.Conf = conf
+		srv.Close()
+	}
+}
+
+func newPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, goimage.NewRGBA(goimage.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("cannot encode image: %s", err)
+	}
+	return buf.Bytes()
+}
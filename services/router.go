@@ -2,45 +2,148 @@ package services
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
 
-	"github.com/Dimitriy14/image-resizing/clients/bucket"
 	"github.com/Dimitriy14/image-resizing/clients/postgres"
+	"github.com/Dimitriy14/image-resizing/compensations"
 	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/events"
+	"github.com/Dimitriy14/image-resizing/exports"
+	"github.com/Dimitriy14/image-resizing/gc"
 	"github.com/Dimitriy14/image-resizing/logger"
 	"github.com/Dimitriy14/image-resizing/middlewares"
 	"github.com/Dimitriy14/image-resizing/repository"
+	eventService "github.com/Dimitriy14/image-resizing/services/events"
+	exportService "github.com/Dimitriy14/image-resizing/services/exports"
+	"github.com/Dimitriy14/image-resizing/services/health"
 	"github.com/Dimitriy14/image-resizing/services/images"
-	"github.com/Dimitriy14/image-resizing/storage/aws"
+	webhookService "github.com/Dimitriy14/image-resizing/services/webhooks"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/tiering"
 	"github.com/Dimitriy14/image-resizing/usecases"
+	"github.com/Dimitriy14/image-resizing/webhooks"
 	"github.com/gorilla/mux"
 )
 
-func NewRouter() *mux.Router {
+func NewRouter() (*mux.Router, error) {
 	repo := repository.NewRepository(postgres.Client)
-	uploader := aws.NewStorage(bucket.Client)
+	uploader := storage.Client
 	resizer := usecases.NewImageResizer()
-	imageService := images.NewService(logger.Log, uploader, repo, resizer)
+	fetcher, err := usecases.NewImageFetcher()
+	if err != nil {
+		return nil, err
+	}
+
+	webhookRepo := repository.NewWebhookRepository(postgres.Client)
+	webhookClient, err := webhooks.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	dispatcher := webhooks.NewDispatcher(logger.Log, webhookRepo, webhookClient)
+	dispatcher.Start()
+
+	broker := eventService.NewBroker(config.Conf.SSEBufferSize, time.Duration(config.Conf.SSEReplayWindowSec)*time.Second)
+
+	subscribe(events.Client, dispatcher.Notify, broker.Notify)
+	events.NewRelay(logger.Log, repository.NewOutboxRepository(postgres.Client), events.Client).Start()
+
+	exportRepo := repository.NewExportRepository(postgres.Client)
+	exporter := exports.NewExporter(logger.Log, exportRepo, repo, uploader)
+	exporter.Start()
+
+	if config.Conf.ExportSigningKey == "" {
+		logger.Log.Warnf("EXPORT_SIGNING_KEY is not set, export download links are valid only for this instance until restart")
+	}
+	signer, err := exports.NewSigner(config.Conf.ExportSigningKey, time.Duration(config.Conf.ExportLinkTTLSec)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadRepo := repository.NewUploadRepository(postgres.Client)
+
+	gc.NewCollector(logger.Log, repository.NewObjectRepository(postgres.Client), uploader).Start()
+	compensations.NewRetrier(logger.Log, repository.NewCompensationRepository(postgres.Client), repository.NewObjectRepository(postgres.Client), uploader).Start()
+	if tiers, ok := storage.AsTiered(uploader); ok {
+		tiering.NewMover(logger.Log, repo, tiers).Start()
+	}
+
+	imageService := images.NewService(logger.Log, uploader, repo, resizer, events.Client, fetcher, uploadRepo)
+	hooksService := webhookService.NewService(logger.Log, webhookRepo, dispatcher)
+	eventsService := eventService.NewService(logger.Log, broker)
+	exportsService := exportService.NewService(logger.Log, exportRepo, exporter, signer, uploader)
+	healthService := health.NewService(logger.Log, uploader)
 
 	router := mux.NewRouter().StrictSlash(true).PathPrefix(config.Conf.BasePath).Subrouter()
+	if files, ok := storage.AsServer(uploader); ok {
+		router.PathPrefix(storage.FilesPath + "/").Handler(http.StripPrefix(config.Conf.BasePath+storage.FilesPath, files.Handler()))
+	}
+	if tiers, ok := storage.AsTiered(uploader); ok {
+		if files, ok := storage.AsServer(tiers.Cold()); ok {
+			router.PathPrefix(storage.ColdFilesPath + "/").Handler(http.StripPrefix(config.Conf.BasePath+storage.ColdFilesPath, files.Handler()))
+		}
+	}
+
+	router.HandleFunc("/health", healthService.Health).Methods(http.MethodGet)
+
+	// signed download links are shared without user id, so they are served before CheckUser
+	router.HandleFunc("/v1/exports/{id}/download", exportsService.DownloadExport).Methods(http.MethodGet)
+
+	// tus clients discover the server capabilities without user id
+	router.HandleFunc(images.TusPath, imageService.TusOptions).Methods(http.MethodOptions)
+	router.HandleFunc(images.TusPath+"/{id}", imageService.TusOptions).Methods(http.MethodOptions)
+
 	v1router := router.PathPrefix("/v1").Subrouter()
 
 	v1router.Use(middlewares.CheckUser)
 	v1router.HandleFunc("/images", imageService.GetAllImages).Methods(http.MethodGet)
 	v1router.HandleFunc("/images", imageService.ResizeNewImage).Methods(http.MethodPost)
+	v1router.HandleFunc("/images/from-url", imageService.ResizeNewImageFromURL).Methods(http.MethodPost)
+	v1router.HandleFunc("/images/batch", imageService.ResizeNewImages).Methods(http.MethodPost)
 	v1router.HandleFunc("/images/{id}", imageService.ResizeExistedImage).Methods(http.MethodPut)
+	v1router.HandleFunc("/images/{id}", imageService.DeleteImage).Methods(http.MethodDelete)
+	v1router.HandleFunc("/images/{id}/original", imageService.GetOriginal).Methods(http.MethodGet)
+	v1router.HandleFunc("/images/{id}/resized", imageService.GetResized).Methods(http.MethodGet)
+	v1router.HandleFunc("/uploads", imageService.CreateUpload).Methods(http.MethodPost)
+	v1router.HandleFunc("/uploads/{id}/complete", imageService.CompleteUpload).Methods(http.MethodPost)
+	v1router.HandleFunc("/tus", imageService.CreateTusUpload).Methods(http.MethodPost)
+	v1router.HandleFunc("/tus/{id}", imageService.GetTusUpload).Methods(http.MethodHead)
+	v1router.HandleFunc("/tus/{id}", imageService.PatchTusUpload).Methods(http.MethodPatch)
+	v1router.HandleFunc("/tus/{id}", imageService.DeleteTusUpload).Methods(http.MethodDelete)
+
+	v1router.HandleFunc("/events", eventsService.Stream).Methods(http.MethodGet)
+
+	v1router.HandleFunc("/exports", exportsService.GetExports).Methods(http.MethodGet)
+	v1router.HandleFunc("/exports", exportsService.CreateExport).Methods(http.MethodPost)
+	v1router.HandleFunc("/exports/{id}", exportsService.GetExport).Methods(http.MethodGet)
+
+	v1router.HandleFunc("/webhooks", hooksService.GetWebhooks).Methods(http.MethodGet)
+	v1router.HandleFunc("/webhooks", hooksService.CreateWebhook).Methods(http.MethodPost)
+	v1router.HandleFunc("/webhooks/{id}", hooksService.DeleteWebhook).Methods(http.MethodDelete)
+	v1router.HandleFunc("/webhooks/{id}/deliveries", hooksService.GetDeliveries).Methods(http.MethodGet)
+	v1router.HandleFunc("/webhooks/{id}/deliveries/{deliveryID}/replay", hooksService.ReplayDelivery).Methods(http.MethodPost)
 
 	var corsRouter = mux.NewRouter()
 	{
 		corsRouter.PathPrefix(config.Conf.BasePath).Handler(negroni.New(
 			cors.New(cors.Options{
-				AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+				AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodHead},
+				AllowedHeaders: []string{"UID", "Content-Type", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "If-None-Match", "If-Modified-Since", "Range"},
+				ExposedHeaders: []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Image-Id", "ETag", "Content-Range", "Content-Disposition"},
 			}),
 			negroni.Wrap(router),
 		))
 	}
 
-	return corsRouter
+	return corsRouter, nil
+}
+
+func subscribe(publisher events.Publisher, handlers ...events.Handler) {
+	for _, handler := range handlers {
+		if _, err := publisher.Subscribe(handler); err != nil {
+			logger.Log.Errorf("cannot subscribe to events due to: %s", err)
+		}
+	}
 }
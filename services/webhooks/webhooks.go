@@ -0,0 +1,282 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/services/common"
+	"github.com/Dimitriy14/image-resizing/usecases"
+	hooks "github.com/Dimitriy14/image-resizing/webhooks"
+)
+
+const secretSize = 32
+
+// Service provides functionality to manage users webhooks and their deliveries
+type Service interface {
+	CreateWebhook(w http.ResponseWriter, r *http.Request)
+	GetWebhooks(w http.ResponseWriter, r *http.Request)
+	DeleteWebhook(w http.ResponseWriter, r *http.Request)
+	GetDeliveries(w http.ResponseWriter, r *http.Request)
+	ReplayDelivery(w http.ResponseWriter, r *http.Request)
+}
+
+// WebhookRequest contains data for registering new webhook
+type WebhookRequest struct {
+	URL    string             `json:"url"`
+	Events []models.EventType `json:"events"`
+	Secret string             `json:"secret"`
+}
+
+// NewService creates new service
+func NewService(log logger.Logger, repo repository.WebhookRepository, dispatcher hooks.Dispatcher) Service {
+	return &serviceImpl{
+		log:        log,
+		repo:       repo,
+		dispatcher: dispatcher,
+	}
+}
+
+type serviceImpl struct {
+	log        logger.Logger
+	repo       repository.WebhookRepository
+	dispatcher hooks.Dispatcher
+}
+
+func (s *serviceImpl) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		req WebhookRequest
+	)
+
+	s.log.Debugf("Started creating webhook for user %q", uid)
+
+	if err := common.ReadRequestJSONBodyToStruct(r, &req); err != nil {
+		s.log.Errorf("cannot extract data from request due to: %s", err)
+		common.SendError(w, http.StatusBadRequest, "invalid input data", err)
+		return
+	}
+
+	if err := validateWebhookRequest(req); err != nil {
+		s.log.Errorf("invalid webhook: %s", err)
+		common.SendError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	if req.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			s.log.Errorf("cannot generate webhook secret due to: %s", err)
+			common.SendInternalServerError(w, "cannot generate webhook secret", err)
+			return
+		}
+		req.Secret = secret
+	}
+
+	events := make([]string, 0, len(req.Events))
+	for _, e := range req.Events {
+		events = append(events, string(e))
+	}
+
+	webhook, err := s.repo.SaveWebhook(models.Webhook{
+		ID:     uuid.New(),
+		UserID: uid,
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: events,
+	})
+	if err != nil {
+		s.log.Errorf("cannot save webhook due to: %s", err)
+		common.SendInternalServerError(w, "cannot save webhook", err)
+		return
+	}
+
+	s.log.Debugf("Successfully created webhook %q for user %q", webhook.ID, uid)
+
+	common.RenderJSONCreated(w, &webhook)
+}
+
+func (s *serviceImpl) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	s.log.Debugf("Started retrieving webhooks for user %q", uid)
+
+	webhooks, err := s.repo.GetWebhooks(uid)
+	if err != nil {
+		s.log.Errorf("cannot retrieve webhooks due to: %s", err)
+		common.SendInternalServerError(w, "cannot retrieve webhooks", err)
+		return
+	}
+
+	// secret is shown only once when webhook is created
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	s.log.Debugf("Successfully retrieved webhooks for user %q", uid)
+
+	common.RenderJSON(w, webhooks)
+}
+
+func (s *serviceImpl) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	webhook, ok := s.getWebhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	if err := s.repo.DeleteWebhook(uid, webhook.ID); err != nil {
+		s.log.Errorf("cannot delete webhook %q due to: %s", webhook.ID, err)
+		common.SendInternalServerError(w, "cannot delete webhook", err)
+		return
+	}
+
+	s.log.Debugf("Successfully deleted webhook %q for user %q", webhook.ID, uid)
+
+	common.RenderNoContent(w)
+}
+
+func (s *serviceImpl) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	uid := common.GetUserIDFromCtx(r.Context())
+
+	webhook, ok := s.getWebhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	deliveries, err := s.repo.GetDeliveries(uid, webhook.ID)
+	if err != nil {
+		s.log.Errorf("cannot retrieve deliveries of webhook %q due to: %s", webhook.ID, err)
+		common.SendInternalServerError(w, "cannot retrieve deliveries", err)
+		return
+	}
+
+	common.RenderJSON(w, deliveries)
+}
+
+func (s *serviceImpl) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	var (
+		uid = common.GetUserIDFromCtx(r.Context())
+		id  = mux.Vars(r)["deliveryID"]
+	)
+
+	webhook, ok := s.getWebhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse delivery id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid delivery id", err)
+		return
+	}
+
+	delivery, err := s.repo.GetDeliveryByID(uid, deliveryID)
+	if err == nil && delivery.WebhookID != webhook.ID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			common.SendNotFound(w, "delivery id is not found: %s", err)
+			return
+		}
+
+		s.log.Errorf("cannot retrieve delivery %q due to: %s", deliveryID, err)
+		common.SendInternalServerError(w, "cannot retrieve delivery", err)
+		return
+	}
+
+	replay, err := s.dispatcher.Replay(delivery)
+	if err != nil {
+		s.log.Errorf("cannot replay delivery %q due to: %s", deliveryID, err)
+		common.SendInternalServerError(w, "cannot replay delivery", err)
+		return
+	}
+
+	s.log.Debugf("Delivery %q has been replayed as %q for user %q", deliveryID, replay.ID, uid)
+
+	common.RenderJSONCreated(w, &replay)
+}
+
+// getWebhook retrieves webhook from path variable id, it writes an error response if webhook cannot be found
+func (s *serviceImpl) getWebhook(w http.ResponseWriter, r *http.Request, uid uuid.UUID) (models.Webhook, bool) {
+	id := mux.Vars(r)["id"]
+
+	webhookID, err := uuid.Parse(id)
+	if err != nil {
+		s.log.Errorf("cannot parse webhook id (%s) from request due to: %s", id, err)
+		common.SendError(w, http.StatusBadRequest, "invalid webhook id", err)
+		return models.Webhook{}, false
+	}
+
+	webhook, err := s.repo.GetWebhookByID(uid, webhookID)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			s.log.Errorf("cannot find webhook with id (%q) for user (%q) due to: %s", webhookID, uid, err)
+			common.SendNotFound(w, "webhook id is not found: %s", err)
+			return models.Webhook{}, false
+		}
+
+		s.log.Errorf("cannot retrieve webhook with id (%q) for user (%q) due to: %s", webhookID, uid, err)
+		common.SendInternalServerError(w, "cannot retrieve webhook due to db problems", err)
+		return models.Webhook{}, false
+	}
+
+	return webhook, true
+}
+
+func validateWebhookRequest(req WebhookRequest) error {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %s", err)
+	}
+
+	if err := usecases.CheckURL(u); err != nil {
+		return fmt.Errorf("invalid webhook url: %s", err)
+	}
+
+	// hostnames are resolved and checked again when deliveries are sent
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		allowed, err := usecases.ParseCIDRList(config.Conf.WebhookAllowedCIDRs)
+		if err != nil {
+			return err
+		}
+		if err := usecases.CheckIP(ip, allowed); err != nil {
+			return fmt.Errorf("webhook url address is not allowed: %s", err)
+		}
+	}
+
+	if len(req.Events) == 0 {
+		return errors.New("at least one event must be specified")
+	}
+
+	for _, e := range req.Events {
+		if !e.IsValid() {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+
+	return nil
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"mime"
+	"path"
+)
+
+// Metadata of objects of images, it is stored as S3 user metadata and object tags
+const (
+	MetaUserID  = "user-id"
+	MetaImageID = "image-id"
+	MetaVariant = "variant"
+)
+
+// ResizedCacheControl is Cache-Control of resized objects, it is set from StorageResizedCacheControl setting
+var ResizedCacheControl string
+
+// Attributes are stored with the object and returned by Stat, CDN caching and lifecycle rules rely on them.
+// Backends which cannot keep them ignore them
+type Attributes struct {
+	CacheControl string
+	// ContentDisposition suggests the filename to clients which download the object
+	ContentDisposition string
+	// Metadata names are lower case, values should be ASCII since they are sent as headers
+	Metadata map[string]string
+}
+
+// Equal reports whether both attributes are the same, nil and empty metadata are equal
+func (a Attributes) Equal(other Attributes) bool {
+	if a.CacheControl != other.CacheControl || a.ContentDisposition != other.ContentDisposition ||
+		len(a.Metadata) != len(other.Metadata) {
+		return false
+	}
+
+	for name, value := range a.Metadata {
+		if otherValue, ok := other.Metadata[name]; !ok || otherValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ImageAttributes returns attributes of the object of the image, filename is the name of uploaded original.
+// Keys of resized objects change with the size and the same size is resized to the same content,
+// so they are cached as immutable by ResizedCacheControl
+func ImageAttributes(p KeyParams, filename string) Attributes {
+	attrs := Attributes{
+		Metadata: map[string]string{
+			MetaUserID:  p.UserID.String(),
+			MetaImageID: p.ImageID.String(),
+			MetaVariant: p.Variant,
+		},
+	}
+
+	if p.Variant != VariantOriginal {
+		attrs.CacheControl = ResizedCacheControl
+	}
+	if filename != "" {
+		attrs.ContentDisposition = mime.FormatMediaType("inline", map[string]string{"filename": path.Base(filename)})
+	}
+
+	return attrs
+}
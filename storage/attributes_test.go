@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageAttributes(t *testing.T) {
+	var (
+		userID  = uuid.New()
+		imageID = uuid.New()
+	)
+
+	ResizedCacheControl = "public, max-age=31536000, immutable"
+	defer func() { ResizedCacheControl = "" }()
+
+	testCases := []struct {
+		name     string
+		variant  string
+		filename string
+		exp      Attributes
+	}{
+		{
+			name:     "Original case",
+			variant:  VariantOriginal,
+			filename: "cat.png",
+			exp: Attributes{
+				ContentDisposition: `inline; filename=cat.png`,
+				Metadata:           map[string]string{MetaUserID: userID.String(), MetaImageID: imageID.String(), MetaVariant: VariantOriginal},
+			},
+		},
+		{
+			name:     "Resized case",
+			variant:  ResizedVariant(100, 100),
+			filename: "my cat.png",
+			exp: Attributes{
+				CacheControl:       "public, max-age=31536000, immutable",
+				ContentDisposition: `inline; filename="my cat.png"`,
+				Metadata:           map[string]string{MetaUserID: userID.String(), MetaImageID: imageID.String(), MetaVariant: "100x100"},
+			},
+		},
+		{
+			name:    "Without filename case",
+			variant: VariantResized,
+			exp: Attributes{
+				CacheControl: "public, max-age=31536000, immutable",
+				Metadata:     map[string]string{MetaUserID: userID.String(), MetaImageID: imageID.String(), MetaVariant: VariantResized},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attrs := ImageAttributes(KeyParams{UserID: userID, ImageID: imageID, Variant: tc.variant}, tc.filename)
+			assert.Equal(t, tc.exp, attrs)
+			assert.True(t, tc.exp.Equal(attrs))
+		})
+	}
+}
+
+func TestAttributes_Equal(t *testing.T) {
+	attrs := Attributes{CacheControl: "no-cache", Metadata: map[string]string{MetaVariant: "100x100"}}
+
+	assert.True(t, Attributes{}.Equal(Attributes{Metadata: map[string]string{}}), "nil and empty metadata should be equal")
+	assert.True(t, attrs.Equal(Attributes{CacheControl: "no-cache", Metadata: map[string]string{MetaVariant: "100x100"}}))
+	assert.False(t, attrs.Equal(Attributes{CacheControl: "no-cache", Metadata: map[string]string{MetaVariant: "200x200"}}))
+	assert.False(t, attrs.Equal(Attributes{Metadata: map[string]string{MetaVariant: "100x100"}}))
+	assert.False(t, attrs.Equal(Attributes{CacheControl: "no-cache"}))
+}
@@ -1,12 +1,17 @@
 package aws
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"sync"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
 	"github.com/Dimitriy14/image-resizing/clients/bucket"
@@ -14,17 +19,62 @@ import (
 	"github.com/Dimitriy14/image-resizing/storage"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/google/uuid"
 )
 
-func NewStorage(bucketS3 *bucket.S3Client) storage.Storage {
-	return &storageImpl{
+const (
+	// sniffLen is the number of bytes which are enough to detect content type
+	sniffLen = 512
+
+	// sha256Metadata is the user metadata which keeps the checksum of the object
+	sha256Metadata = "sha256"
+
+	privateACL        = "private"
+	defaultPresignTTL = 15 * time.Minute
+)
+
+// Storage keeps objects in aws bucket, objects of private bucket are shared by presigned links
+type Storage interface {
+	storage.Storage
+	storage.Linker
+	storage.UploadPresigner
+	storage.AttributesSetter
+}
+
+// NewStorage creates storage of the configured bucket, links are built from AWSImageStorageURL
+// or, when it isn't set, from the url which the sdk uses for the bucket.
+// In private mode (AWSPrivate) objects are uploaded private and AWSACL is ignored
+func NewStorage(bucketS3 *bucket.S3Client) Storage {
+	return NewBucketStorage(bucketS3, config.Conf.AWSBucket, config.Conf.AWSImageStorageURL, "")
+}
+
+// NewBucketStorage creates storage of another bucket, e.g. the secondary one, with the same settings.
+// Links are built from storageURL or, when it is empty, from the url which the sdk uses for the bucket.
+// Objects are stored with storageClass, e.g. STANDARD_IA for the cold tier, empty one means the default class
+func NewBucketStorage(bucketS3 *bucket.S3Client, bucketName, storageURL, storageClass string) Storage {
+	if storageURL == "" {
+		storageURL = bucketS3.URL
+	}
+
+	s := &storageImpl{
 		bucketS3:         bucketS3,
-		bucketName:       config.Conf.AWSBucket,
+		bucketName:       bucketName,
 		acl:              config.Conf.AWSACL,
 		serverEncryption: config.Conf.AWSServerSideEncryption,
-		awsStorageUrl:    config.Conf.AWSImageStorageURL,
+		storageClass:     storageClass,
+		tagging:          config.Conf.AWSObjectTagging,
+		awsStorageUrl:    strings.TrimSuffix(storageURL, "/"),
+		private:          config.Conf.AWSPrivate,
+		presignTTL:       time.Duration(config.Conf.AWSPresignTTLSec) * time.Second,
+	}
+
+	if s.private {
+		s.acl = privateACL
 	}
+	if s.presignTTL <= 0 {
+		s.presignTTL = defaultPresignTTL
+	}
+
+	return s
 }
 
 type storageImpl struct {
@@ -32,89 +82,386 @@ type storageImpl struct {
 	bucketName       string
 	acl              string
 	serverEncryption string
+	storageClass     string
+	tagging          bool
 	awsStorageUrl    string
+	private          bool
+	presignTTL       time.Duration
 }
 
-// Upload saves a file to aws bucket and returns the url to // the file or an error if there's any
-func (s *storageImpl) Upload(filExt string, content []byte) (string, error) {
-	fileName := "pictures/" + uuid.New().String() + filExt
-	err := s.upload(fileName, content)
-	return fmt.Sprintf("%s/%s", s.awsStorageUrl, fileName), err
+// Put streams content to aws bucket, the uploader sends it by parts so the content isn't buffered as a whole.
+// Empty ACL and server side encryption aren't sent, S3-compatible stores may not support them, private objects
+// are always sent with private ACL.
+// Metadata of attributes is copied to tags when AWSObjectTagging is set
+func (s *storageImpl) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	contentType := opts.ContentType
+	if contentType == "" {
+		br := bufio.NewReaderSize(r, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		contentType = http.DetectContentType(head)
+		r = br
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(key),
+		Body:        storage.Verify(r, opts.SHA256),
+		ContentType: aws.String(contentType),
+	}
+	if metadata := putMetadata(opts); len(metadata) > 0 {
+		input.Metadata = metadata
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if s.tagging && len(opts.Metadata) > 0 {
+		input.Tagging = aws.String(tagging(opts.Metadata))
+	}
+	// S3 verifies the digest of single part uploads, multipart ones are verified by SHA256 while they are read
+	if opts.MD5 != "" {
+		input.ContentMD5 = aws.String(opts.MD5)
+	}
+	if acl := s.objectACL(opts); acl != "" {
+		input.ACL = aws.String(acl)
+	}
+	if s.serverEncryption != "" {
+		input.ServerSideEncryption = aws.String(s.serverEncryption)
+	}
+	if s.storageClass != "" {
+		input.StorageClass = aws.String(s.storageClass)
+	}
+
+	_, err := s.bucketS3.Uploader.UploadWithContext(ctx, input)
+
+	return convertUploadError(err)
 }
 
-// Download downloads file from aws bucket and returns its content
-func (s *storageImpl) Download(addr string) ([]byte, error) {
-	buf := aws.NewWriteAtBuffer([]byte{})
+// SetAttributes copies the object onto itself with replaced metadata, so the content isn't transferred.
+// The copy is conditional on the ETag of info, so the object overwritten since it was described isn't changed
+func (s *storageImpl) SetAttributes(ctx context.Context, info storage.ObjectInfo, attrs storage.Attributes) error {
+	opts := storage.PutOptions{
+		SHA256:     info.SHA256,
+		Private:    storage.Keys.Private(info.Key),
+		Attributes: attrs,
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucketName),
+		Key:               aws.String(info.Key),
+		CopySource:        aws.String(url.PathEscape(s.bucketName + "/" + info.Key)),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		ContentType:       aws.String(info.ContentType),
+		Metadata:          putMetadata(opts),
+	}
+	if info.ETag != "" {
+		input.CopySourceIfMatch = aws.String(`"` + info.ETag + `"`)
+	}
+	if attrs.CacheControl != "" {
+		input.CacheControl = aws.String(attrs.CacheControl)
+	}
+	if attrs.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(attrs.ContentDisposition)
+	}
+	if s.tagging {
+		input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+		input.Tagging = aws.String(tagging(attrs.Metadata))
+	}
+	if acl := s.objectACL(opts); acl != "" {
+		input.ACL = aws.String(acl)
+	}
+	if s.serverEncryption != "" {
+		input.ServerSideEncryption = aws.String(s.serverEncryption)
+	}
+	if s.storageClass != "" {
+		input.StorageClass = aws.String(s.storageClass)
+	}
+
+	_, err := s.bucketS3.S3.CopyObjectWithContext(ctx, input)
+
+	return convertError(err)
+}
 
-	a, err := url.Parse(addr)
+// Get returns the body of the object, it is read directly from the connection
+func (s *storageImpl) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.bucketS3.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
 	}
 
-	_, err = s.bucketS3.Downloader.Download(buf, &s3.GetObjectInput{
+	return out.Body, nil
+}
+
+// GetRange reads only the part of the object from the bucket
+func (s *storageImpl) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	out, err := s.bucketS3.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
-		Key:    aws.String(a.Path[1:]),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
 	})
+	if err != nil {
+		return nil, convertError(err)
+	}
 
-	return buf.Bytes(), err
+	return out.Body, nil
 }
 
-func (s *storageImpl) UploadWithOriginal(filExt string, originalImgContent, resizedImgContent []byte) (string, string, error) {
-	var (
-		originFileName  = "pictures/" + uuid.New().String() + filExt
-		resizedFileName = "pictures/" + uuid.New().String() + filExt
-		errc            = make(chan error, 2)
-		wg              = new(sync.WaitGroup)
-	)
+func (s *storageImpl) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	out, err := s.bucketS3.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return storage.ObjectInfo{}, convertError(err)
+	}
 
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		errc <- s.upload(originFileName, originalImgContent)
-	}()
+	info := storage.ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		ContentType:  aws.StringValue(out.ContentType),
+		ETag:         strings.Trim(aws.StringValue(out.ETag), `"`),
+		LastModified: aws.TimeValue(out.LastModified),
+		Attributes: storage.Attributes{
+			CacheControl:       aws.StringValue(out.CacheControl),
+			ContentDisposition: aws.StringValue(out.ContentDisposition),
+		},
+	}
 
-	go func() {
-		defer wg.Done()
-		errc <- s.upload(resizedFileName, resizedImgContent)
-	}()
+	// the sdk canonicalizes names of metadata like http headers
+	for name, value := range out.Metadata {
+		name = strings.ToLower(name)
+		if name == sha256Metadata {
+			info.SHA256 = aws.StringValue(value)
+			continue
+		}
 
-	go func() {
-		wg.Wait()
-		close(errc)
-	}()
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+		info.Metadata[name] = aws.StringValue(value)
+	}
 
-	for err := range errc {
-		if err != nil {
-			return "", "", err
+	return info, nil
+}
+
+func (s *storageImpl) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Stat(ctx, key)
+	switch err {
+	case nil:
+		return true, nil
+	case storage.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// List reads the bucket by pages, so memory usage doesn't depend on the number of objects
+func (s *storageImpl) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	var fnErr error
+
+	err := s.bucketS3.S3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			fnErr = fn(storage.ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				ETag:         strings.Trim(aws.StringValue(obj.ETag), `"`),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+			if fnErr != nil {
+				return false
+			}
 		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
 	}
 
-	return fmt.Sprintf("%s/%s", s.awsStorageUrl, originFileName), fmt.Sprintf("%s/%s", s.awsStorageUrl, resizedFileName), nil
+	return convertError(err)
 }
 
-func (s *storageImpl) DeleteImage(addr string) error {
-	a, err := url.Parse(addr)
-	if err != nil {
-		return err
+// Delete removes the object, S3 doesn't report an error for missing keys
+func (s *storageImpl) Delete(ctx context.Context, key string) error {
+	_, err := s.bucketS3.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+
+	return convertError(err)
+}
+
+func (s *storageImpl) URL(key string) string {
+	return fmt.Sprintf("%s/%s", s.awsStorageUrl, key)
+}
+
+// Link returns presigned link in private mode and permanent one otherwise
+func (s *storageImpl) Link(key string) (string, error) {
+	if !s.private {
+		return s.URL(key), nil
 	}
 
-	_, err = s.bucketS3.Uploader.S3.DeleteObject(&s3.DeleteObjectInput{
+	req, _ := s.bucketS3.S3.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
-		Key:    aws.String(a.Path[1:]),
+		Key:    aws.String(key),
 	})
 
+	link, err := req.Presign(s.presignTTL)
+	if err != nil {
+		return "", fmt.Errorf("cannot presign link of %q: %s", key, err)
+	}
+
+	return link, nil
+}
+
+// PresignPut signs content type, attributes, ACL and encryption headers, so the client cannot change them.
+// Positive opts.Size is signed as Content-Length, so S3 rejects content of another size
+func (s *storageImpl) PresignPut(key string, opts storage.PutOptions, ttl time.Duration) (storage.PresignedRequest, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(key),
+		ContentType: aws.String(opts.ContentType),
+	}
+	if opts.Size > 0 {
+		input.ContentLength = aws.Int64(opts.Size)
+	}
+	if metadata := putMetadata(opts); len(metadata) > 0 {
+		input.Metadata = metadata
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if s.tagging && len(opts.Metadata) > 0 {
+		input.Tagging = aws.String(tagging(opts.Metadata))
+	}
+	if acl := s.objectACL(opts); acl != "" {
+		input.ACL = aws.String(acl)
+	}
+	if s.serverEncryption != "" {
+		input.ServerSideEncryption = aws.String(s.serverEncryption)
+	}
+
+	req, _ := s.bucketS3.S3.PutObjectRequest(input)
+
+	link, header, err := req.PresignRequest(ttl)
+	if err != nil {
+		return storage.PresignedRequest{}, fmt.Errorf("cannot presign upload of %q: %s", key, err)
+	}
+
+	headers := make(map[string]string, len(header))
+	// signed header names are lower cased, so they cannot be read by header.Get
+	for name, values := range header {
+		headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+	}
+
+	return storage.PresignedRequest{
+		Method:  http.MethodPut,
+		URL:     link,
+		Headers: headers,
+	}, nil
+}
+
+// Key strips the storage or bucket url and the query (of presigned links) from the link,
+// links with another host are treated as "<host>/<key>"
+func (s *storageImpl) Key(link string) (string, error) {
+	link = strings.SplitN(link, "?", 2)[0]
+
+	for _, base := range []string{s.awsStorageUrl, s.bucketS3.URL} {
+		if base != "" && strings.HasPrefix(link, base+"/") {
+			return strings.TrimPrefix(link, base+"/"), nil
+		}
+	}
+
+	a, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	if len(a.Path) < 2 {
+		return "", fmt.Errorf("link %q doesn't contain object key", link)
+	}
+
+	return a.Path[1:], nil
+}
+
+// IsRetryable reports whether the failed call could succeed when it is repeated,
+// e.g. on connection errors, throttling, 429 or 5xx responses (S3 slows clients down by 503)
+func IsRetryable(err error) bool {
+	if err == nil || err == storage.ErrNotFound || err == storage.ErrChecksumMismatch {
+		return false
+	}
+	if aerr, ok := err.(awserr.RequestFailure); ok &&
+		(aerr.StatusCode() >= http.StatusInternalServerError || aerr.StatusCode() == http.StatusTooManyRequests) {
+		return true
+	}
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
+}
+
+// convertUploadError returns ErrChecksumMismatch when the content is rejected by S3 or by reading it
+func convertUploadError(err error) error {
+	for e := err; e != nil; {
+		if e == storage.ErrChecksumMismatch {
+			return e
+		}
+
+		aerr, ok := e.(awserr.Error)
+		if !ok {
+			break
+		}
+		if aerr.Code() == "BadDigest" {
+			return storage.ErrChecksumMismatch
+		}
+		e = aerr.OrigErr()
+	}
 	return err
 }
 
-func (s *storageImpl) upload(fileName string, content []byte) error {
-	_, err := s.bucketS3.Uploader.Upload(&s3manager.UploadInput{
-		Bucket:               aws.String(s.bucketName),
-		Key:                  aws.String(fileName),
-		ACL:                  aws.String(s.acl),
-		Body:                 bytes.NewReader(content),
-		ContentType:          aws.String(http.DetectContentType(content)),
-		ServerSideEncryption: aws.String(s.serverEncryption),
-	})
+// putMetadata returns user metadata of the object, the checksum is kept along with metadata of attributes
+func putMetadata(opts storage.PutOptions) map[string]*string {
+	metadata := make(map[string]*string, len(opts.Metadata)+1)
+	for name, value := range opts.Metadata {
+		metadata[name] = aws.String(value)
+	}
+	if opts.SHA256 != "" {
+		metadata[sha256Metadata] = aws.String(opts.SHA256)
+	}
+	return metadata
+}
 
+// tagging encodes metadata as tags of the object, so lifecycle rules could filter objects by them
+func tagging(metadata map[string]string) string {
+	tags := make(url.Values, len(metadata))
+	for name, value := range metadata {
+		tags.Set(name, value)
+	}
+	return tags.Encode()
+}
+
+func convertError(err error) error {
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+		return storage.ErrNotFound
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return storage.ErrNotFound
+	}
 	return err
 }
+
+// objectACL overrides the ACL of the bucket for private objects
+func (s *storageImpl) objectACL(opts storage.PutOptions) string {
+	if opts.Private {
+		return privateACL
+	}
+	return s.acl
+}
@@ -0,0 +1,451 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/clients/bucket"
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest/s3fake"
+)
+
+const testBucket = "test-bucket"
+
+func newTestStorage(t *testing.T) (storage.Storage, func()) {
+	srv := s3fake.New()
+	return newServerStorage(t, srv), srv.Close
+}
+
+// newServerStorage creates storage of the test bucket of the fake server
+func newServerStorage(t *testing.T, srv *s3fake.Server) *storageImpl {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("eu-central-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatalf("cannot create session: %s", err)
+	}
+
+	return &storageImpl{
+		bucketS3: &bucket.S3Client{
+			S3:       s3.New(sess),
+			Uploader: s3manager.NewUploader(sess),
+			URL:      srv.URL + "/" + testBucket,
+		},
+		bucketName:       testBucket,
+		acl:              "public-read",
+		serverEncryption: "AES256",
+		awsStorageUrl:    srv.URL + "/" + testBucket,
+	}
+}
+
+func TestStorageImpl_Conformance(t *testing.T) {
+	var cleanups []func()
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, cleanup := newTestStorage(t)
+		cleanups = append(cleanups, cleanup)
+		return s
+	})
+}
+
+func TestStorageImpl_Key(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	testCases := []struct {
+		name   string
+		link   string
+		expKey string
+		expErr bool
+	}{
+		{
+			name:   "Storage url case",
+			link:   s.URL("pictures/image.png"),
+			expKey: "pictures/image.png",
+		},
+		{
+			name:   "Another host case",
+			link:   "https://resized-images-yal.s3.eu-central-1.amazonaws.com/pictures/image.png",
+			expKey: "pictures/image.png",
+		},
+		{
+			name:   "Without key case",
+			link:   "https://resized-images-yal.s3.eu-central-1.amazonaws.com/",
+			expErr: true,
+		},
+		{
+			name:   "Invalid url case",
+			link:   "http://[::1",
+			expErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := s.Key(tc.link)
+			if tc.expErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expKey, key)
+		})
+	}
+}
+
+func TestNewStorage_URL(t *testing.T) {
+	defer func(conf config.Configuration) { config.Conf = conf }(config.Conf)
+
+	client := &bucket.S3Client{URL: "http://localhost:9000/images"}
+
+	config.Conf.AWSImageStorageURL = ""
+	assert.Equal(t, "http://localhost:9000/images/pictures/image.png", NewStorage(client).URL("pictures/image.png"), "url should be derived from the client")
+
+	config.Conf.AWSImageStorageURL = "https://cdn.example.com/"
+	assert.Equal(t, "https://cdn.example.com/pictures/image.png", NewStorage(client).URL("pictures/image.png"), "configured url should be used")
+}
+
+func TestStorageImpl_Link(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	impl := s.(*storageImpl)
+	assert.NoError(t, s.Put(context.Background(), "pictures/image.txt", strings.NewReader("hello"), storage.PutOptions{}))
+
+	link, err := impl.Link("pictures/image.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, s.URL("pictures/image.txt"), link, "public object should have permanent link")
+
+	impl.private = true
+	impl.presignTTL = time.Minute
+
+	link, err = impl.Link("pictures/image.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	u, err := url.Parse(link)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"), "private object should have presigned link")
+	assert.Equal(t, "60", u.Query().Get("X-Amz-Expires"))
+
+	key, err := s.Key(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "pictures/image.txt", key, "key should be parsed from presigned link")
+
+	resp, err := http.Get(link)
+	if assert.NoError(t, err) {
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "hello", string(content))
+	}
+}
+
+func TestStorageImpl_PresignPut(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	attrs := storage.Attributes{
+		ContentDisposition: `inline; filename="cat.png"`,
+		Metadata:           map[string]string{storage.MetaVariant: "original"},
+	}
+
+	presigned, err := s.(*storageImpl).PresignPut("pictures/upload.png", storage.PutOptions{
+		ContentType: "image/png",
+		Size:        int64(len("content")),
+		Attributes:  attrs,
+	}, time.Minute)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, http.MethodPut, presigned.Method)
+	assert.Equal(t, map[string]string{
+		"Content-Type":                 "image/png",
+		"Content-Length":               "7",
+		"Content-Disposition":          `inline; filename="cat.png"`,
+		"X-Amz-Meta-Variant":           "original",
+		"X-Amz-Acl":                    "public-read",
+		"X-Amz-Server-Side-Encryption": "AES256",
+	}, presigned.Headers, "headers should be signed")
+
+	u, err := url.Parse(presigned.URL)
+	if assert.NoError(t, err) {
+		assert.Contains(t, strings.Split(u.Query().Get("X-Amz-SignedHeaders"), ";"), "content-length", "size should be signed")
+	}
+
+	req, err := http.NewRequest(presigned.Method, presigned.URL, strings.NewReader("content"))
+	assert.NoError(t, err)
+	for name, value := range presigned.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	info, err := s.Stat(context.Background(), "pictures/upload.png")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "image/png", info.ContentType)
+		assert.Equal(t, int64(len("content")), info.Size)
+		assert.True(t, attrs.Equal(info.Attributes), "attributes should be stored, got %+v", info.Attributes)
+	}
+}
+
+// TestStorageImpl_List_Pages checks that listing continues over several pages of the bucket
+func TestStorageImpl_List_Pages(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	const objects = 250
+
+	ctx := context.Background()
+	for i := 0; i < objects; i++ {
+		key := fmt.Sprintf("pages/%03d.txt", i)
+		assert.NoError(t, s.Put(ctx, key, strings.NewReader(key), storage.PutOptions{Size: int64(len(key))}))
+	}
+
+	var listed []string
+	err := s.List(ctx, "pages/", func(info storage.ObjectInfo) error {
+		listed = append(listed, info.Key)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	if assert.Len(t, listed, objects, "all pages should be listed") {
+		assert.Equal(t, "pages/000.txt", listed[0])
+		assert.Equal(t, "pages/249.txt", listed[objects-1])
+	}
+}
+
+func TestStorageImpl_Put_Checksums(t *testing.T) {
+	var (
+		content = []byte("content of the object")
+		large   = bytes.Repeat([]byte("large"), 6<<20/5+1)
+		other   = []byte("other content")
+	)
+
+	testCases := []struct {
+		name    string
+		content []byte
+		opts    storage.PutOptions
+		expErr  error
+	}{
+		{
+			name:    "Good case",
+			content: content,
+			opts:    storage.PutOptions{SHA256: storage.Checksum(content), MD5: base64MD5(content)},
+		},
+		{
+			name:    "Multipart case",
+			content: large,
+			opts:    storage.PutOptions{SHA256: storage.Checksum(large)},
+		},
+		{
+			name:    "Wrong digest case",
+			content: content,
+			opts:    storage.PutOptions{MD5: base64MD5(other)},
+			expErr:  storage.ErrChecksumMismatch,
+		},
+		{
+			name:    "Wrong multipart checksum case",
+			content: large,
+			opts:    storage.PutOptions{SHA256: storage.Checksum(other)},
+			expErr:  storage.ErrChecksumMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, cleanup := newTestStorage(t)
+			defer cleanup()
+
+			ctx := context.Background()
+			err := s.Put(ctx, "pictures/image.txt", bytes.NewReader(tc.content), tc.opts)
+			assert.Equal(t, tc.expErr, err)
+
+			info, statErr := s.Stat(ctx, "pictures/image.txt")
+			if tc.expErr != nil {
+				assert.Equal(t, storage.ErrNotFound, statErr, "rejected content shouldn't be stored")
+				return
+			}
+			if assert.NoError(t, statErr) {
+				assert.Equal(t, tc.opts.SHA256, info.SHA256, "checksum should be kept in metadata")
+			}
+		})
+	}
+}
+
+func TestStorageImpl_Put_Attributes(t *testing.T) {
+	attrs := storage.Attributes{
+		CacheControl:       "public, max-age=31536000, immutable",
+		ContentDisposition: `inline; filename="cat.png"`,
+		Metadata:           map[string]string{storage.MetaImageID: "image", storage.MetaVariant: "100x100"},
+	}
+
+	testCases := []struct {
+		name       string
+		tagging    bool
+		size       int
+		expTagging string
+	}{
+		{name: "Good case", size: 10},
+		{name: "Tagging case", tagging: true, size: 10, expTagging: "image-id=image&variant=100x100"},
+		{name: "Multipart tagging case", tagging: true, size: 6<<20 + 1, expTagging: "image-id=image&variant=100x100"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := s3fake.New()
+			defer srv.Close()
+
+			s := newServerStorage(t, srv)
+			s.tagging = tc.tagging
+
+			var (
+				ctx     = context.Background()
+				content = bytes.Repeat([]byte("a"), tc.size)
+			)
+
+			err := s.Put(ctx, "pictures/image.png", bytes.NewReader(content), storage.PutOptions{
+				SHA256:     storage.Checksum(content),
+				Attributes: attrs,
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			info, err := s.Stat(ctx, "pictures/image.png")
+			if assert.NoError(t, err) {
+				assert.True(t, attrs.Equal(info.Attributes), "attributes should be kept, got %+v", info.Attributes)
+				assert.Equal(t, storage.Checksum(content), info.SHA256, "checksum shouldn't be reported as metadata")
+			}
+			assert.Equal(t, tc.expTagging, srv.Tagging(testBucket, "pictures/image.png"))
+		})
+	}
+}
+
+func TestStorageImpl_SetAttributes(t *testing.T) {
+	attrs := storage.Attributes{
+		CacheControl:       "public, max-age=31536000, immutable",
+		ContentDisposition: `inline; filename="cat.png"`,
+		Metadata:           map[string]string{storage.MetaImageID: "image", storage.MetaVariant: "100x100"},
+	}
+	content := []byte("content of the object")
+
+	testCases := []struct {
+		name       string
+		tagging    bool
+		overwrite  bool
+		expErr     bool
+		expTagging string
+	}{
+		{name: "Good case"},
+		{name: "Tagging case", tagging: true, expTagging: "image-id=image&variant=100x100"},
+		{name: "Overwritten object case", overwrite: true, expErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := s3fake.New()
+			defer srv.Close()
+
+			s := newServerStorage(t, srv)
+			s.tagging = tc.tagging
+
+			ctx := context.Background()
+			err := s.Put(ctx, "pictures/image.png", bytes.NewReader(content), storage.PutOptions{
+				ContentType: "image/png",
+				SHA256:      storage.Checksum(content),
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			info, err := s.Stat(ctx, "pictures/image.png")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if tc.overwrite {
+				assert.NoError(t, s.Put(ctx, "pictures/image.png", strings.NewReader("other"), storage.PutOptions{}))
+			}
+
+			err = s.SetAttributes(ctx, info, attrs)
+			if tc.expErr {
+				assert.Error(t, err, "overwritten object shouldn't be changed")
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			stored, err := s.Stat(ctx, "pictures/image.png")
+			if assert.NoError(t, err) {
+				assert.True(t, attrs.Equal(stored.Attributes), "attributes should be replaced, got %+v", stored.Attributes)
+				assert.Equal(t, "image/png", stored.ContentType)
+				assert.Equal(t, storage.Checksum(content), stored.SHA256, "checksum should be kept")
+			}
+			assert.Equal(t, tc.expTagging, srv.Tagging(testBucket, "pictures/image.png"))
+		})
+	}
+}
+
+func base64MD5(content []byte) string {
+	sum := md5.Sum(content)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"Not found case", storage.ErrNotFound, false},
+		{"Checksum mismatch case", storage.ErrChecksumMismatch, false},
+		{"Server error case", awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), http.StatusInternalServerError, "id"), true},
+		{"Slow down case", awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), http.StatusServiceUnavailable, "id"), true},
+		{"Throttling case", awserr.New("Throttling", "rate exceeded", nil), true},
+		{"Access denied case", awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "id"), false},
+		{"Canceled case", awserr.New(request.CanceledErrorCode, "canceled", context.Canceled), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.exp, IsRetryable(tc.err))
+		})
+	}
+}
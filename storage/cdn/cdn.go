@@ -0,0 +1,78 @@
+// Package cdn serves objects of the backend through one or more CDN hosts, links could be signed
+// and cached copies of overwritten or deleted objects are purged by the pluggable Purger
+package cdn
+
+import (
+	"context"
+	"io"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// Storage fronts the backend by the CDN, it replaces links of the backend by links of CDN hosts
+// and passes other calls to the backend as is. The CDN fetches objects from the backend,
+// so objects of private buckets should be available to it, e.g. by origin access identity
+type Storage struct {
+	storage.Storage
+	links  *URLBuilder
+	purger Purger
+}
+
+// NewStorage creates storage whose objects are linked by links, NopPurger is used when the purger is nil
+func NewStorage(backend storage.Storage, links *URLBuilder, purger Purger) *Storage {
+	if purger == nil {
+		purger = NopPurger{}
+	}
+
+	return &Storage{
+		Storage: backend,
+		links:   links,
+		purger:  purger,
+	}
+}
+
+// GetRange reads the range from the backend, the embedded backend doesn't expose it
+func (s *Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return storage.GetRange(ctx, s.Storage, key, offset, length)
+}
+
+// SetAttributes sets attributes by the backend, the embedded backend doesn't expose it
+func (s *Storage) SetAttributes(ctx context.Context, info storage.ObjectInfo, attrs storage.Attributes) error {
+	return storage.SetAttributes(ctx, s.Storage, info, attrs)
+}
+
+func (s *Storage) URL(key string) string {
+	return s.links.URL(key)
+}
+
+// Key accepts links of CDN hosts and links of the backend
+func (s *Storage) Key(link string) (string, error) {
+	if key, ok := s.links.Key(link); ok {
+		return key, nil
+	}
+	return s.Storage.Key(link)
+}
+
+// Link returns the link on the CDN host of the key, it is signed when the signing key is set
+func (s *Storage) Link(key string) (string, error) {
+	return s.links.Link(key)
+}
+
+// Purge purges cached copies of the objects by their permanent links
+func (s *Storage) Purge(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	links := make([]string, 0, len(keys))
+	for _, key := range keys {
+		links = append(links, s.links.URL(key))
+	}
+
+	return s.purger.Purge(ctx, links)
+}
+
+// Unwrap returns the backend, so its presigned uploads and handler are used as is
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
@@ -0,0 +1,234 @@
+package cdn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/resilient"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const backendURL = "http://localhost/files"
+
+var hosts = []string{"https://cdn1.example.com", "https://cdn2.example.com/images/"}
+
+func newBuilder(t *testing.T, key string) *URLBuilder {
+	b, err := NewURLBuilder(hosts, key, time.Hour)
+	if err != nil {
+		t.Fatalf("cannot create url builder: %s", err)
+	}
+	return b
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewStorage(memory.NewStorage(backendURL), newBuilder(t, ""), nil)
+	})
+}
+
+func TestNewURLBuilder(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hosts  []string
+		expErr bool
+	}{
+		{name: "Good case", hosts: hosts},
+		{name: "No hosts case", expErr: true},
+		{name: "Relative host case", hosts: []string{"cdn.example.com"}, expErr: true},
+		{name: "Unsupported scheme case", hosts: []string{"ftp://cdn.example.com"}, expErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewURLBuilder(tc.hosts, "", 0)
+			assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	assert.Equal(t, []string{"https://cdn1.example.com", "https://cdn2.example.com"}, ParseHosts(" https://cdn1.example.com,,https://cdn2.example.com "))
+	assert.Empty(t, ParseHosts(""))
+}
+
+func TestURLBuilder_URL(t *testing.T) {
+	var (
+		b    = newBuilder(t, "")
+		used = make(map[string]bool)
+	)
+
+	for i := 0; i < 100; i++ {
+		key := storage.NewKey(".png")
+		link := b.URL(key)
+
+		assert.Equal(t, link, b.URL(key), "key should be always linked to the same host")
+
+		parsed, ok := b.Key(link)
+		assert.True(t, ok)
+		assert.Equal(t, key, parsed)
+
+		used[strings.TrimSuffix(link, "/"+key)] = true
+	}
+
+	assert.Equal(t, map[string]bool{"https://cdn1.example.com": true, "https://cdn2.example.com/images": true}, used,
+		"keys should be spread over all hosts")
+}
+
+func TestURLBuilder_Link(t *testing.T) {
+	var (
+		key = "pictures/image.png"
+		now = time.Unix(1600000000, 0)
+	)
+
+	unsigned, err := newBuilder(t, "").Link(key)
+	assert.NoError(t, err)
+	assert.NotContains(t, unsigned, SignatureParam, "links shouldn't be signed without the key")
+
+	b := newBuilder(t, "secret")
+	b.now = func() time.Time { return now }
+
+	link, err := b.Link(key)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link, b.URL(key)+"?"), "signed link should point to the object: %s", link)
+	assert.NoError(t, b.Verify(link))
+
+	later, err := b.Link(key)
+	assert.NoError(t, err)
+	assert.Equal(t, link, later, "links issued within the window should be the same")
+
+	parsed, ok := b.Key(link)
+	assert.True(t, ok)
+	assert.Equal(t, key, parsed, "query of signed link should be ignored")
+
+	testCases := []struct {
+		name   string
+		link   string
+		now    time.Time
+		expErr error
+	}{
+		{name: "Valid until the end of the next window case", link: link, now: now.Add(time.Hour)},
+		{name: "Expired case", link: link, now: now.Add(2 * time.Hour), expErr: ErrLinkExpired},
+		{name: "Other object case", link: strings.Replace(link, "image.png", "other.png", 1), now: now, expErr: ErrInvalidSignature},
+		{name: "Extended expiration case", link: strings.Replace(link, "expires=1", "expires=2", 1), now: now, expErr: ErrInvalidSignature},
+		{name: "Missing signature case", link: b.URL(key), now: now, expErr: ErrInvalidSignature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b.now = func() time.Time { return tc.now }
+			assert.Equal(t, tc.expErr, b.Verify(tc.link))
+
+			other := newBuilder(t, "other secret")
+			other.now = b.now
+			assert.Equal(t, ErrInvalidSignature, other.Verify(tc.link), "link should be verified only by the signing key")
+		})
+	}
+}
+
+func TestStorage_Links(t *testing.T) {
+	var (
+		backend = memory.NewStorage(backendURL)
+		links   = newBuilder(t, "")
+		s       = resilient.NewStorage(logger.NewMokLogger(), NewStorage(backend, links, nil), nil)
+		key     = "pictures/image.png"
+	)
+
+	link, err := storage.Link(s, key)
+	assert.NoError(t, err)
+	assert.Equal(t, links.URL(key), link, "objects should be linked by the cdn")
+
+	parsed, err := s.Key(backend.URL(key))
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed, "links of the backend should be accepted")
+
+	_, ok := storage.AsServer(s)
+	assert.True(t, ok, "the backend should serve objects to the cdn")
+}
+
+type recordingPurger struct {
+	links []string
+	err   error
+}
+
+func (p *recordingPurger) Purge(_ context.Context, links []string) error {
+	p.links = append(p.links, links...)
+	return p.err
+}
+
+func TestStorage_Purge(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		links  = newBuilder(t, "secret")
+		purger = &recordingPurger{}
+		s      = resilient.NewStorage(logger.NewMokLogger(), NewStorage(memory.NewStorage(backendURL), links, purger), nil)
+		keys   = []string{"pictures/1.png", "pictures/2.png"}
+	)
+
+	assert.NoError(t, storage.Purge(ctx, s, keys...))
+	assert.Equal(t, []string{links.URL(keys[0]), links.URL(keys[1])}, purger.links, "permanent links should be purged")
+
+	purger.err = errors.New("PURGE ERROR")
+	assert.Error(t, storage.Purge(ctx, s, keys...))
+
+	assert.NoError(t, storage.Purge(ctx, memory.NewStorage(backendURL), keys...), "objects which aren't cached shouldn't be purged")
+}
+
+func TestHTTPPurger_Purge(t *testing.T) {
+	testCases := []struct {
+		name    string
+		token   string
+		status  int
+		expAuth string
+		expErr  bool
+	}{
+		{name: "Good case", status: http.StatusOK},
+		{name: "Token case", token: "token", status: http.StatusAccepted, expAuth: "Bearer token"},
+		{name: "Rejected case", status: http.StatusForbidden, expErr: true},
+		{name: "Server error case", status: http.StatusInternalServerError, expErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				received PurgeRequest
+				auth     string
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "/purge", r.URL.Path)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				auth = r.Header.Get("Authorization")
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			links := []string{"https://cdn1.example.com/pictures/1.png", "https://cdn2.example.com/pictures/2.png"}
+
+			err := NewHTTPPurger(srv.URL+"/purge", tc.token, nil).Purge(context.Background(), links)
+			assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+			assert.Equal(t, links, received.URLs)
+			assert.Equal(t, tc.expAuth, auth)
+		})
+	}
+}
+
+func TestHTTPPurger_Purge_Unavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	err := NewHTTPPurger(srv.URL, "", nil).Purge(context.Background(), []string{"https://cdn1.example.com/pictures/1.png"})
+	assert.Error(t, err)
+}
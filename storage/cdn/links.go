@@ -0,0 +1,153 @@
+package cdn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ExpiresParam and SignatureParam are query parameters of signed links
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+
+	defaultLinkTTL = time.Hour
+)
+
+var (
+	// ErrLinkExpired is returned when signed link is used after its expiration
+	ErrLinkExpired = errors.New("cdn link is expired")
+	// ErrInvalidSignature is returned when signed link is forged or modified
+	ErrInvalidSignature = errors.New("cdn link signature is invalid")
+)
+
+// URLBuilder builds links of objects on CDN hosts, every key is linked to the same host, so the object
+// is cached by one of them. Links are signed when the signing key is set, the CDN verifies them by
+// signature = hex(HMAC-SHA256(key, path + "?expires=" + expires)), where path is the escaped path of the link
+type URLBuilder struct {
+	hosts []string
+	key   []byte
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewURLBuilder creates builder of links on hosts, which are base urls possibly with a path,
+// e.g. https://cdn.example.com/images. Links aren't signed when the key is empty
+func NewURLBuilder(hosts []string, key string, ttl time.Duration) (*URLBuilder, error) {
+	b := &URLBuilder{
+		key: []byte(key),
+		ttl: ttl,
+		now: time.Now,
+	}
+
+	if b.ttl < time.Second {
+		b.ttl = defaultLinkTTL
+	}
+
+	for _, host := range hosts {
+		u, err := url.Parse(host)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("cdn host %q should be http or https url", host)
+		}
+		b.hosts = append(b.hosts, strings.TrimSuffix(host, "/"))
+	}
+
+	if len(b.hosts) == 0 {
+		return nil, errors.New("at least one cdn host is required")
+	}
+
+	return b, nil
+}
+
+// ParseHosts splits comma separated list of hosts
+func ParseHosts(list string) []string {
+	var hosts []string
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// URL returns the permanent link of the object, cached copies are purged by it
+func (b *URLBuilder) URL(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return b.hosts[h.Sum32()%uint32(len(b.hosts))] + "/" + key
+}
+
+// Link returns the link given to clients. Signed links expire at the end of the ttl window following
+// the current one, so links issued within a window are the same and clients keep their cached copies
+func (b *URLBuilder) Link(key string) (string, error) {
+	link := b.URL(key)
+	if len(b.key) == 0 {
+		return link, nil
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse link of %q: %s", key, err)
+	}
+
+	window := int64(b.ttl / time.Second)
+	expires := (b.now().Unix()/window + 2) * window
+
+	query := url.Values{}
+	query.Set(ExpiresParam, strconv.FormatInt(expires, 10))
+	query.Set(SignatureParam, b.signature(u.EscapedPath(), expires))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Verify checks that the link is signed by the builder and is not expired,
+// CDN edges which run Go could verify links by it
+func (b *URLBuilder) Verify(link string) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	query := u.Query()
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(b.signature(u.EscapedPath(), expires)), []byte(query.Get(SignatureParam))) {
+		return ErrInvalidSignature
+	}
+
+	if b.now().Unix() > expires {
+		return ErrLinkExpired
+	}
+
+	return nil
+}
+
+// Key returns the key of the object linked by one of the hosts, the query of signed links is ignored
+func (b *URLBuilder) Key(link string) (string, bool) {
+	link = strings.SplitN(link, "?", 2)[0]
+
+	for _, host := range b.hosts {
+		if strings.HasPrefix(link, host+"/") {
+			return strings.TrimPrefix(link, host+"/"), true
+		}
+	}
+
+	return "", false
+}
+
+func (b *URLBuilder) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(path + "?" + ExpiresParam + "=" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,85 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const defaultPurgeTimeout = 10 * time.Second
+
+// Purger removes cached copies of links from the CDN
+type Purger interface {
+	Purge(ctx context.Context, links []string) error
+}
+
+// NopPurger doesn't purge anything, it is used when the CDN has no purge API
+// and cached copies are kept until they expire
+type NopPurger struct{}
+
+func (NopPurger) Purge(context.Context, []string) error {
+	return nil
+}
+
+// PurgeRequest is the body of the request sent by HTTPPurger
+type PurgeRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// HTTPPurger sends links to the purge API of the CDN as POST of PurgeRequest,
+// the token is sent as bearer authorization when it is set
+type HTTPPurger struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// NewHTTPPurger creates purger of the endpoint, default client with timeout is used when the client is nil
+func NewHTTPPurger(endpoint, token string, client *http.Client) *HTTPPurger {
+	if client == nil {
+		client = &http.Client{Timeout: defaultPurgeTimeout}
+	}
+
+	return &HTTPPurger{
+		endpoint: endpoint,
+		token:    token,
+		client:   client,
+	}
+}
+
+// Purge fails unless the API responds with 2xx status
+func (p *HTTPPurger) Purge(ctx context.Context, links []string) error {
+	body, err := json.Marshal(PurgeRequest{URLs: links})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create purge request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send purge request: %s", err)
+	}
+	defer resp.Body.Close()
+	// the body is drained, so the connection is reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("purge api responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
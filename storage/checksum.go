@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// ErrChecksumMismatch is returned when content doesn't match its checksum, e.g. the object is corrupted
+var ErrChecksumMismatch = errors.New("content doesn't match its checksum")
+
+// Checksum returns hex encoded SHA-256 checksum of the content, it is kept by the image record
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentMD5 returns base64 encoded MD5 digest of the content as Content-MD5 header expects it
+func contentMD5(content []byte) string {
+	sum := md5.Sum(content)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Verify returns the reader of r which fails with ErrChecksumMismatch at the end of content
+// that doesn't match hex encoded SHA-256 checksum, r is returned as is when the checksum is empty
+func Verify(r io.Reader, checksum string) io.Reader {
+	if checksum == "" {
+		return r
+	}
+	return &verifier{r: r, hash: sha256.New(), checksum: checksum}
+}
+
+// Download reads the whole object and verifies it by the checksum unless it is empty
+func Download(ctx context.Context, s Storage, key, checksum string) ([]byte, error) {
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(Verify(body, checksum))
+}
+
+type verifier struct {
+	r        io.Reader
+	hash     hash.Hash
+	checksum string
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.checksum {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,188 @@
+// Package encrypted encrypts objects on the client side by AES-GCM with per-object data keys
+// wrapped by master keys which the service holds, so the backend never sees the content
+package encrypted
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	// sniffLen is the number of bytes which are enough to detect content type
+	sniffLen = 512
+
+	// encryptionMetadata marks encrypted objects by the format of their content, so Stat doesn't read them
+	encryptionMetadata = "encryption"
+	// plainSizeMetadata is the size of decrypted content, it is recorded when the size is known at Put
+	plainSizeMetadata = "plain-size"
+)
+
+// Storage encrypts objects of the backend. It isn't a storage.Wrapper, so presigned uploads, links
+// and files served by the backend are not used and encrypted objects are served by the API.
+// Objects stored before encryption was enabled are read as is until they are re-encrypted
+type Storage struct {
+	log     logger.Logger
+	backend storage.Storage
+	keys    *Keyring
+}
+
+// NewStorage creates storage which encrypts new objects by the current key of the keyring
+func NewStorage(log logger.Logger, backend storage.Storage, keys *Keyring) *Storage {
+	return &Storage{
+		log:     log,
+		backend: backend,
+		keys:    keys,
+	}
+}
+
+// Put encrypts content by a new data key, the content type is detected by the content before it is encrypted.
+// Checksums describe the content, so it is verified by them here and the backend isn't given them.
+// The encryption and the size of the content are recorded in metadata of the object
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	r = storage.Verify(r, opts.SHA256)
+	opts.SHA256, opts.MD5 = "", ""
+	opts.Metadata = encryptionMetadataOf(opts.Metadata, opts.Size)
+
+	if opts.ContentType == "" {
+		br := bufio.NewReaderSize(r, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		opts.ContentType = http.DetectContentType(head)
+		r = br
+	}
+	if opts.Size >= 0 {
+		opts.Size = encryptedSize(opts.Size)
+	}
+
+	encrypted, err := newEncrypter(s.keys, r)
+	if err != nil {
+		return err
+	}
+
+	return s.backend.Put(ctx, key, encrypted, opts)
+}
+
+// Get returns the decrypted content, ErrCorrupted is returned by Read when the content is modified
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(body)
+	if head, _ := br.Peek(len(magic)); !isEncrypted(head) {
+		return readCloser{Reader: br, Closer: body}, nil
+	}
+
+	content, _, err := newDecrypter(s.keys, br)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return readCloser{Reader: content, Closer: body}, nil
+}
+
+// Stat reports the size of decrypted content by metadata of the object, the header is read only from objects
+// stored without it. The checksum recorded by the backend is the one of encrypted content, so it isn't reported
+func (s *Storage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	info, err := s.backend.Stat(ctx, key)
+	if err != nil {
+		return info, err
+	}
+	info.SHA256 = ""
+
+	if _, ok := info.Metadata[encryptionMetadata]; ok {
+		info.Size = plainSize(info.Size)
+		if size, err := strconv.ParseInt(info.Metadata[plainSizeMetadata], 10, 64); err == nil {
+			info.Size = size
+		}
+		info.Metadata = attributesMetadata(info.Metadata)
+		return info, nil
+	}
+
+	body, err := s.backend.Get(ctx, key)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	defer body.Close()
+
+	head := make([]byte, len(magic))
+	if _, err = io.ReadFull(body, head); err == nil && isEncrypted(head) {
+		info.Size = plainSize(info.Size)
+	}
+
+	return info, nil
+}
+
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	return s.backend.Exists(ctx, key)
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	return s.backend.Delete(ctx, key)
+}
+
+// List reports sizes of decrypted content without reading the objects,
+// so sizes of objects stored before encryption was enabled are wrong
+func (s *Storage) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	return s.backend.List(ctx, prefix, func(info storage.ObjectInfo) error {
+		info.Size = plainSize(info.Size)
+		info.SHA256 = ""
+		info.Metadata = attributesMetadata(info.Metadata)
+		return fn(info)
+	})
+}
+
+// URL returns the link of the backend, it is never given to clients since it points to encrypted content
+func (s *Storage) URL(key string) string {
+	return s.backend.URL(key)
+}
+
+func (s *Storage) Key(link string) (string, error) {
+	return s.backend.Key(link)
+}
+
+// Link doesn't share encrypted objects, they are served by the API
+func (s *Storage) Link(string) (string, error) {
+	return "", storage.ErrNoLinks
+}
+
+// encryptionMetadataOf returns metadata of attributes with the encryption and the size of decrypted content,
+// negative size is unknown and isn't recorded
+func encryptionMetadataOf(metadata map[string]string, size int64) map[string]string {
+	withEncryption := make(map[string]string, len(metadata)+2)
+	for name, value := range metadata {
+		withEncryption[name] = value
+	}
+
+	withEncryption[encryptionMetadata] = magic
+	if size >= 0 {
+		withEncryption[plainSizeMetadata] = strconv.FormatInt(size, 10)
+	}
+	return withEncryption
+}
+
+// attributesMetadata returns metadata of attributes without the one recorded by Put
+func attributesMetadata(metadata map[string]string) map[string]string {
+	attrs := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		if name != encryptionMetadata && name != plainSizeMetadata {
+			attrs[name] = value
+		}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
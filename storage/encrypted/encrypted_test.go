@@ -0,0 +1,305 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/resilient"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const testURL = "http://localhost/files"
+
+var (
+	oldKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, masterKeySize))
+	newKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, masterKeySize))
+)
+
+func newKeyring(t *testing.T, current string) *Keyring {
+	keys, err := NewKeyring(current, map[string]string{"old": oldKey, "new": newKey})
+	if err != nil {
+		t.Fatalf("cannot create keyring: %s", err)
+	}
+	return keys
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewStorage(logger.NewMokLogger(), memory.NewStorage(testURL), newKeyring(t, "new"))
+	})
+}
+
+func TestStorage_Encryption(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		s       = NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "new"))
+		key     = "pictures/image.png"
+	)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		content := bytes.Repeat([]byte("secret"), size/6+1)[:size]
+
+		assert.NoError(t, s.Put(ctx, key, bytes.NewReader(content), storage.PutOptions{Size: int64(size)}))
+
+		stored := read(t, backend, key)
+		assert.Equal(t, encryptedSize(int64(size)), int64(len(stored)), "unexpected encrypted size of %d bytes", size)
+		assert.Equal(t, int64(size), plainSize(int64(len(stored))), "unexpected plain size of %d bytes", size)
+		// short content could occur in random ciphertext
+		if size >= 16 {
+			assert.False(t, bytes.Contains(stored, content[:size/2+1]), "content should be encrypted")
+		}
+
+		assert.Equal(t, content, read(t, s, key), "unexpected decrypted content of %d bytes", size)
+	}
+}
+
+func TestStorage_Corrupted(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		s       = NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "new"))
+		key     = "pictures/image.png"
+		content = bytes.Repeat([]byte("secret"), segmentSize/3)
+	)
+
+	assert.NoError(t, s.Put(ctx, key, bytes.NewReader(content), storage.PutOptions{Size: -1}))
+	stored := read(t, backend, key)
+
+	testCases := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{
+			name:   "Modified content case",
+			modify: func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		},
+		{
+			name:   "Truncated content case",
+			modify: func(b []byte) []byte { return b[:headerSize+segmentSize+tagSize] },
+		},
+		{
+			name:   "Modified key id case",
+			modify: func(b []byte) []byte { copy(b[len(magic)+1:], "old"); return b },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modified := tc.modify(append([]byte(nil), stored...))
+			assert.NoError(t, backend.Put(ctx, key, bytes.NewReader(modified), storage.PutOptions{Size: -1}))
+
+			body, err := s.Get(ctx, key)
+			if err == nil {
+				_, err = ioutil.ReadAll(body)
+				body.Close()
+			}
+			assert.Equal(t, ErrCorrupted, err)
+		})
+	}
+}
+
+func TestStorage_Plain(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		s       = NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "new"))
+		key     = "pictures/image.png"
+	)
+
+	assert.NoError(t, backend.Put(ctx, key, bytes.NewBufferString("stored before encryption"), storage.PutOptions{Size: -1}))
+
+	assert.Equal(t, "stored before encryption", string(read(t, s, key)), "not encrypted object should be read as is")
+
+	info, err := s.Stat(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("stored before encryption")), info.Size)
+}
+
+func TestStorage_Stat(t *testing.T) {
+	var (
+		content = bytes.Repeat([]byte("secret"), segmentSize/3)
+		attrs   = storage.Attributes{Metadata: map[string]string{storage.MetaVariant: "original"}}
+	)
+
+	testCases := []struct {
+		name        string
+		size        int64
+		withoutMeta bool
+		expReads    int
+	}{
+		{name: "Recorded size case", size: int64(len(content))},
+		{name: "Unknown size case", size: -1},
+		{name: "Stored without metadata case", size: int64(len(content)), withoutMeta: true, expReads: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				ctx     = context.Background()
+				backend = memory.NewStorage(testURL)
+				s       = NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "new"))
+				key     = "pictures/image.png"
+				reads   int
+			)
+
+			assert.NoError(t, s.Put(ctx, key, bytes.NewReader(content), storage.PutOptions{Size: tc.size, Attributes: attrs}))
+			if tc.withoutMeta {
+				// objects encrypted before metadata was recorded
+				stored := read(t, backend, key)
+				assert.NoError(t, backend.Put(ctx, key, bytes.NewReader(stored), storage.PutOptions{Size: -1, Attributes: attrs}))
+			}
+
+			backend.InjectFailure(func(op memory.Op, _ string) error {
+				if op == memory.OpGet {
+					reads++
+				}
+				return nil
+			})
+
+			info, err := s.Stat(ctx, key)
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(len(content)), info.Size, "size of decrypted content should be reported")
+				assert.True(t, attrs.Equal(info.Attributes), "metadata of encryption shouldn't be reported, got %+v", info.Attributes)
+			}
+			assert.Equal(t, tc.expReads, reads, "unexpected number of reads of the object")
+		})
+	}
+}
+
+func TestStorage_Reencrypt(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		encrypted = "pictures/old.png"
+		current   = "pictures/new.png"
+		plain     = "pictures/plain.png"
+		unmanaged = "backups/db.dump"
+	)
+
+	for _, dryRun := range []bool{true, false} {
+		backend := memory.NewStorage(testURL)
+
+		attrs := storage.Attributes{CacheControl: "no-cache", Metadata: map[string]string{storage.MetaVariant: "original"}}
+
+		old := NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "old"))
+		assert.NoError(t, old.Put(ctx, encrypted, bytes.NewBufferString(encrypted), storage.PutOptions{
+			ContentType: "image/png",
+			Size:        -1,
+			Attributes:  attrs,
+		}))
+
+		s := NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "new"))
+		assert.NoError(t, s.Put(ctx, current, bytes.NewBufferString(current), storage.PutOptions{Size: -1}))
+		for _, key := range []string{plain, unmanaged} {
+			assert.NoError(t, backend.Put(ctx, key, bytes.NewBufferString(key), storage.PutOptions{Size: -1}))
+		}
+
+		report, err := s.Reencrypt(ctx, dryRun)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, report.Objects, "only managed objects should be re-encrypted")
+		assert.Equal(t, 1, report.Current)
+		assert.Equal(t, 0, report.Failed)
+		assert.ElementsMatch(t, []Change{{Key: encrypted, From: "old"}, {Key: plain}}, report.Changes)
+
+		if dryRun {
+			assert.Equal(t, 0, report.Rewrapped+report.Encrypted)
+			assert.Equal(t, plain, string(read(t, backend, plain)), "dry run shouldn't change objects")
+			continue
+		}
+
+		assert.Equal(t, 1, report.Rewrapped)
+		assert.Equal(t, 1, report.Encrypted)
+
+		onlyNew := NewStorage(logger.NewMokLogger(), backend, keyringOf(t, "new", newKey))
+		for _, key := range []string{encrypted, current, plain} {
+			assert.Equal(t, key, string(read(t, onlyNew, key)), "object should be readable by the current key")
+		}
+
+		info, err := s.Stat(ctx, encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", info.ContentType, "content type should be kept")
+		assert.Equal(t, int64(len(encrypted)), info.Size)
+		assert.True(t, attrs.Equal(info.Attributes), "attributes should be kept")
+		assert.Equal(t, unmanaged, string(read(t, backend, unmanaged)), "unmanaged objects shouldn't be encrypted")
+	}
+}
+
+func TestStorage_Links(t *testing.T) {
+	var (
+		backend = memory.NewStorage(testURL)
+		s       = resilient.NewStorage(logger.NewMokLogger(), NewStorage(logger.NewMokLogger(), backend, newKeyring(t, "new")), nil)
+	)
+
+	_, err := storage.Link(s, "pictures/image.png")
+	assert.Equal(t, storage.ErrNoLinks, err, "encrypted objects shouldn't be linked")
+
+	_, ok := storage.AsServer(s)
+	assert.False(t, ok, "encrypted objects shouldn't be served by the backend")
+}
+
+func TestLoadKeyring(t *testing.T) {
+	file, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatalf("cannot create file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"old": "` + oldKey + `"}`)
+	file.Close()
+
+	testCases := []struct {
+		name    string
+		current string
+		list    string
+		file    string
+		expErr  bool
+	}{
+		{name: "List case", current: "new", list: "old:" + oldKey + ", new:" + newKey},
+		{name: "File case", current: "old", file: file.Name()},
+		{name: "List and file case", current: "new", list: "new:" + newKey, file: file.Name()},
+		{name: "Missing current key case", current: "other", list: "new:" + newKey, expErr: true},
+		{name: "Invalid pair case", current: "new", list: "new", expErr: true},
+		{name: "Short key case", current: "new", list: "new:" + base64.StdEncoding.EncodeToString([]byte("short")), expErr: true},
+		{name: "Not base64 key case", current: "new", list: "new:%%%", expErr: true},
+		{name: "Long key id case", current: "new", list: "new:" + newKey + ",key-id-which-is-longer-than-32-chars:" + oldKey, expErr: true},
+		{name: "Missing file case", current: "new", list: "new:" + newKey, file: file.Name() + "-missing", expErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := LoadKeyring(tc.current, tc.list, tc.file)
+			assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+			if err == nil {
+				assert.Equal(t, tc.current, keys.Current())
+			}
+		})
+	}
+}
+
+func keyringOf(t *testing.T, id, key string) *Keyring {
+	keys, err := NewKeyring(id, map[string]string{id: key})
+	if err != nil {
+		t.Fatalf("cannot create keyring: %s", err)
+	}
+	return keys
+}
+
+func read(t *testing.T, s storage.Storage, key string) []byte {
+	body, err := s.Get(context.Background(), key)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(body)
+	assert.NoError(t, err)
+	return content
+}
@@ -0,0 +1,127 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// maxKeyIDLen is the length of key id field of the header, so the header has fixed size
+	maxKeyIDLen = 32
+	// masterKeySize is the size of AES-256 keys
+	masterKeySize = 32
+)
+
+// Keyring contains master keys which wrap data keys of objects, new objects are encrypted by the current key.
+// Old keys are kept to read objects until they are re-encrypted
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates keyring of base64 encoded AES-256 keys by their ids
+func NewKeyring(current string, keys map[string]string) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not found", current)
+	}
+
+	k := &Keyring{
+		current: current,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for id, encoded := range keys {
+		if id == "" || len(id) > maxKeyIDLen {
+			return nil, fmt.Errorf("key id %q should have from 1 to %d characters", id, maxKeyIDLen)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not base64 encoded: %s", id, err)
+		}
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("key %q should have %d bytes, got %d", id, masterKeySize, len(key))
+		}
+
+		if k.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// LoadKeyring creates keyring of keys listed as comma separated `id:base64` pairs and keys of the file,
+// which is a JSON object of base64 encoded keys by their ids. Either of list and file could be empty
+func LoadKeyring(current, list, file string) (*Keyring, error) {
+	keys := make(map[string]string)
+
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read keys file: %s", err)
+		}
+		if err = json.Unmarshal(content, &keys); err != nil {
+			return nil, fmt.Errorf("cannot parse keys file: %s", err)
+		}
+	}
+
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("key should be set as id:base64, got %q", parts[0])
+		}
+		keys[parts[0]] = parts[1]
+	}
+
+	return NewKeyring(current, keys)
+}
+
+// Current returns id of the key which encrypts new objects
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// wrap encrypts the data key by the current key, the key id is authenticated with it
+func (k *Keyring) wrap(dataKey []byte) (string, []byte, error) {
+	aead := k.keys[k.current]
+
+	nonce := make([]byte, aead.NonceSize(), wrappedKeySize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	return k.current, aead.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key %q is not found", keyID)
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,134 @@
+package encrypted
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// Change is a change of the key which encrypts the object, From is empty for objects which aren't encrypted
+type Change struct {
+	Key  string
+	From string
+}
+
+// ReencryptReport describes objects whose key is changed, in dry run the changes are only planned
+type ReencryptReport struct {
+	Objects   int
+	Current   int
+	Rewrapped int
+	Encrypted int
+	Failed    int
+	Changes   []Change
+}
+
+// Reencrypt makes every object created by the service encrypted by the current key. Data keys of objects
+// encrypted by old keys are re-wrapped, so their content is copied as is, objects stored before encryption
+// was enabled are encrypted. Failed objects are reported, the error is returned only when the objects cannot be listed
+func (s *Storage) Reencrypt(ctx context.Context, dryRun bool) (ReencryptReport, error) {
+	var report ReencryptReport
+
+	err := s.backend.List(ctx, "", func(info storage.ObjectInfo) error {
+		if !storage.Managed(info.Key) {
+			return nil
+		}
+		report.Objects++
+
+		change, err := s.reencrypt(ctx, info.Key, dryRun)
+		switch {
+		case err != nil:
+			s.log.Errorf("cannot re-encrypt %q due to: %s", info.Key, err)
+			report.Failed++
+		case change == nil:
+			report.Current++
+		default:
+			report.Changes = append(report.Changes, *change)
+			if dryRun {
+				break
+			}
+			if change.From == "" {
+				report.Encrypted++
+			} else {
+				report.Rewrapped++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("cannot list objects: %s", err)
+	}
+
+	s.log.Infof("Finished re-encryption by key %q: %d objects, %d current, %d re-wrapped, %d encrypted, %d failed",
+		s.keys.Current(), report.Objects, report.Current, report.Rewrapped, report.Encrypted, report.Failed)
+
+	return report, nil
+}
+
+// reencrypt returns nil change when the object is encrypted by the current key
+func (s *Storage) reencrypt(ctx context.Context, key string, dryRun bool) (*Change, error) {
+	info, err := s.backend.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	br := bufio.NewReader(body)
+	if head, _ := br.Peek(len(magic)); !isEncrypted(head) {
+		if dryRun {
+			return &Change{Key: key}, nil
+		}
+		return &Change{Key: key}, s.Put(ctx, key, br, storage.PutOptions{
+			ContentType: info.ContentType,
+			Size:        info.Size,
+			Private:     storage.Keys.Private(key),
+			Attributes:  info.Attributes,
+		})
+	}
+
+	head := make([]byte, headerSize)
+	if _, err = io.ReadFull(br, head); err != nil {
+		return nil, ErrCorrupted
+	}
+
+	h, err := parseHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	if h.keyID == s.keys.Current() {
+		return nil, nil
+	}
+
+	change := &Change{Key: key, From: h.keyID}
+	if dryRun {
+		return change, nil
+	}
+
+	dataKey, err := s.keys.unwrap(h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if h.keyID, h.wrappedKey, err = s.keys.wrap(dataKey); err != nil {
+		return nil, err
+	}
+
+	// objects encrypted before the encryption was recorded in metadata get it
+	info.Metadata = encryptionMetadataOf(info.Metadata, plainSize(info.Size))
+
+	content := io.MultiReader(bytes.NewReader(h.marshal()), br)
+	return change, s.backend.Put(ctx, key, content, storage.PutOptions{
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		Private:     storage.Keys.Private(key),
+		Attributes:  info.Attributes,
+	})
+}
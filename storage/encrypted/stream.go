@@ -0,0 +1,266 @@
+package encrypted
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted object is the header followed by segments of the content, every segment is sealed by the data key
+// with the nonce built of the prefix, the number of the segment and the flag of the last one,
+// so reordered or truncated content isn't decrypted
+const (
+	magic       = "RSE1"
+	dataKeySize = 32
+	nonceSize   = 12
+	tagSize     = 16
+	prefixSize  = nonceSize - 5
+	segmentSize = 64 << 10
+
+	wrappedKeySize = nonceSize + dataKeySize + tagSize
+	headerSize     = len(magic) + 1 + maxKeyIDLen + wrappedKeySize + prefixSize
+)
+
+// ErrCorrupted is returned when the object cannot be decrypted, e.g. it was modified or its key is wrong
+var ErrCorrupted = errors.New("encrypted object is corrupted")
+
+type header struct {
+	keyID      string
+	wrappedKey []byte
+	prefix     []byte
+}
+
+func (h header) marshal() []byte {
+	b := make([]byte, 0, headerSize)
+	b = append(b, magic...)
+	b = append(b, byte(len(h.keyID)))
+	b = append(b, h.keyID...)
+	b = append(b, make([]byte, maxKeyIDLen-len(h.keyID))...)
+	b = append(b, h.wrappedKey...)
+	return append(b, h.prefix...)
+}
+
+func parseHeader(b []byte) (header, error) {
+	if len(b) != headerSize || string(b[:len(magic)]) != magic {
+		return header{}, ErrCorrupted
+	}
+	b = b[len(magic):]
+
+	idLen := int(b[0])
+	if idLen == 0 || idLen > maxKeyIDLen {
+		return header{}, ErrCorrupted
+	}
+	b = b[1:]
+
+	return header{
+		keyID:      string(b[:idLen]),
+		wrappedKey: b[maxKeyIDLen : maxKeyIDLen+wrappedKeySize],
+		prefix:     b[maxKeyIDLen+wrappedKeySize:],
+	}, nil
+}
+
+// isEncrypted reports whether the content starting with head is encrypted,
+// objects stored before encryption was enabled are read as is
+func isEncrypted(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(magic))
+}
+
+// encryptedSize returns the size of encrypted content of given size, the last segment is sealed even when it is empty
+func encryptedSize(size int64) int64 {
+	segments := (size + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(headerSize) + size + segments*tagSize
+}
+
+// plainSize returns the size of content which is encrypted to given size
+func plainSize(size int64) int64 {
+	body := size - int64(headerSize)
+	if body < tagSize {
+		return size
+	}
+
+	segments := (body + segmentSize + tagSize - 1) / (segmentSize + tagSize)
+	return body - segments*tagSize
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// encrypter reads content of src sealed by segments
+type encrypter struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	buf     []byte
+	out     []byte
+	done    bool
+}
+
+// newEncrypter generates the data key of the object and returns the reader of its encrypted content
+func newEncrypter(keys *Keyring, src io.Reader) (io.Reader, error) {
+	dataKey := make([]byte, dataKeySize)
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	keyID, wrapped, err := keys.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encrypter{
+		src:    bufio.NewReader(src),
+		aead:   aead,
+		prefix: prefix,
+		plain:  make([]byte, segmentSize),
+		buf:    make([]byte, 0, segmentSize+tagSize),
+		out:    header{keyID: keyID, wrappedKey: wrapped, prefix: prefix}.marshal(),
+	}, nil
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// seal seals the next segment, the segment is the last one when nothing follows it
+func (e *encrypter) seal() error {
+	n, err := io.ReadFull(e.src, e.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	last := err != nil
+	if !last {
+		if _, err = e.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	e.out = e.aead.Seal(e.buf[:0], segmentNonce(e.prefix, e.counter, last), e.plain[:n], nil)
+	e.counter++
+	e.done = last
+	return nil
+}
+
+// decrypter reads content of encrypted segments of src
+type decrypter struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	sealed  []byte
+	buf     []byte
+	out     []byte
+	done    bool
+}
+
+// newDecrypter reads the header of src, which should be already checked by isEncrypted
+func newDecrypter(keys *Keyring, src *bufio.Reader) (*decrypter, header, error) {
+	head := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrCorrupted
+		}
+		return nil, header{}, err
+	}
+
+	h, err := parseHeader(head)
+	if err != nil {
+		return nil, header{}, err
+	}
+
+	dataKey, err := keys.unwrap(h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, header{}, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, header{}, err
+	}
+
+	return &decrypter{
+		src:    src,
+		aead:   aead,
+		prefix: h.prefix,
+		sealed: make([]byte, segmentSize+tagSize),
+		buf:    make([]byte, 0, segmentSize),
+	}, h, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decrypter) open() error {
+	n, err := io.ReadFull(d.src, d.sealed)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	last := err != nil
+	if !last {
+		if _, err = d.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	d.out, err = d.aead.Open(d.buf[:0], segmentNonce(d.prefix, d.counter, last), d.sealed[:n], nil)
+	if err != nil {
+		return ErrCorrupted
+	}
+
+	d.counter++
+	d.done = last
+	return nil
+}
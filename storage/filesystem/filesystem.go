@@ -0,0 +1,314 @@
+package filesystem
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	dirPerm         = 0755
+	filePerm        = 0644
+	privateFilePerm = 0600
+	sniffLen        = 512
+)
+
+// Storage keeps objects as files under the root directory and serves them by Handler
+type Storage interface {
+	storage.Storage
+	storage.Server
+}
+
+// NewStorage creates storage rooted at root directory, baseURL is the url where Handler is mounted
+func NewStorage(root, baseURL string) (Storage, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(root, dirPerm); err != nil {
+		return nil, fmt.Errorf("cannot create storage directory: %s", err)
+	}
+
+	return &storageImpl{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+type storageImpl struct {
+	root    string
+	baseURL string
+}
+
+// Put writes content to a temporary file and renames it, so readers never see partially written objects.
+// Content which doesn't match opts.SHA256 is rejected, checksums aren't kept.
+// Private objects are readable only by the owner, so Handler doesn't serve them
+func (s *storageImpl) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(name), dirPerm); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, contextReader{ctx: ctx, r: storage.Verify(r, opts.SHA256)}); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(filePerm)
+	if opts.Private {
+		perm = privateFilePerm
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *storageImpl) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return f, nil
+}
+
+func (s *storageImpl) GetRange(_ context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return storage.LimitBody(f, length), nil
+}
+
+func (s *storageImpl) Stat(_ context.Context, key string) (storage.ObjectInfo, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return storage.ObjectInfo{}, convertError(err)
+	}
+
+	if info.IsDir() {
+		return storage.ObjectInfo{}, storage.ErrNotFound
+	}
+
+	contentType, err := detectContentType(name)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	return storage.ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  contentType,
+		ETag:         etag(info),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (s *storageImpl) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Stat(ctx, key)
+	switch err {
+	case nil:
+		return true, nil
+	case storage.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s *storageImpl) Delete(_ context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List walks the root, temporary files of unfinished uploads are skipped
+func (s *storageImpl) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	return filepath.Walk(s.root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed while walking
+				return nil
+			}
+			return err
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && name != s.root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		return fn(storage.ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			ETag:         etag(info),
+			LastModified: info.ModTime(),
+		})
+	})
+}
+
+func (s *storageImpl) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *storageImpl) Key(link string) (string, error) {
+	if !strings.HasPrefix(link, s.baseURL+"/") {
+		return "", fmt.Errorf("link %q doesn't belong to the storage", link)
+	}
+	return strings.TrimPrefix(link, s.baseURL+"/"), nil
+}
+
+// Handler serves files without directory listings, private objects aren't served
+func (s *storageImpl) Handler() http.Handler {
+	files := http.FileServer(http.Dir(s.root))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := s.path(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if info, err := os.Stat(name); err != nil || info.IsDir() || info.Mode().Perm()&0004 == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+// path converts the key to the file name, keys which point outside of the root are rejected
+func (s *storageImpl) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || clean[1:] != key {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("invalid object key %q", key)
+		}
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func detectContentType(name string) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", convertError(err)
+	}
+	defer f.Close()
+
+	head, err := bufio.NewReaderSize(f, sniffLen).Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+
+	return http.DetectContentType(head), nil
+}
+
+// etag is derived from modification time and size, since files are replaced by rename on every Put
+func etag(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+func convertError(err error) error {
+	if os.IsNotExist(err) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+// contextReader stops copying when the context is canceled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
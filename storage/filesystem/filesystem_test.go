@@ -0,0 +1,123 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const testURL = "http://localhost:8182/resizer/files"
+
+func newTestStorage(t *testing.T) (Storage, func()) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+
+	s, err := NewStorage(root, testURL+"/")
+	if err != nil {
+		t.Fatalf("cannot create storage: %s", err)
+	}
+
+	return s, func() { os.RemoveAll(root) }
+}
+
+func TestStorageImpl_Conformance(t *testing.T) {
+	var cleanups []func()
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, cleanup := newTestStorage(t)
+		cleanups = append(cleanups, cleanup)
+		return s
+	})
+}
+
+func TestStorageImpl_InvalidKeys(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	for _, key := range []string{"", "../outside.png", "pictures/../../outside.png", "/absolute.png", "pictures/.hidden", "pictures//image.png"} {
+		err := s.Put(context.Background(), key, bytes.NewReader(nil), storage.PutOptions{})
+		assert.Error(t, err, "key %q should be rejected", key)
+	}
+}
+
+func TestStorageImpl_URLKey(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	link := s.URL("pictures/image.png")
+	assert.Equal(t, testURL+"/pictures/image.png", link)
+
+	key, err := s.Key(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "pictures/image.png", key)
+
+	_, err = s.Key("https://bucket.s3.amazonaws.com/pictures/image.png")
+	assert.Error(t, err, "link of another storage should be rejected")
+}
+
+func TestStorageImpl_Handler(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	err := s.Put(context.Background(), "pictures/image.txt", bytes.NewBufferString("hello"), storage.PutOptions{})
+	assert.NoError(t, err)
+	err = s.Put(context.Background(), "exports/export.zip", bytes.NewBufferString("archive"), storage.PutOptions{Private: true})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		path    string
+		expCode int
+		expBody string
+	}{
+		{
+			name:    "File case",
+			path:    "/pictures/image.txt",
+			expCode: http.StatusOK,
+			expBody: "hello",
+		},
+		{
+			name:    "Directory listing case",
+			path:    "/pictures/",
+			expCode: http.StatusNotFound,
+		},
+		{
+			name:    "Private file case",
+			path:    "/exports/export.zip",
+			expCode: http.StatusNotFound,
+		},
+		{
+			name:    "Missing file case",
+			path:    "/pictures/missing.txt",
+			expCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			assert.Equal(t, tc.expCode, rr.Code, "unexpected status code")
+			if tc.expBody != "" {
+				assert.Equal(t, tc.expBody, rr.Body.String())
+			}
+		})
+	}
+}
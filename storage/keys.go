@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultKeyTemplate groups objects by user and month, so lifecycle rules and reports could use prefixes
+	DefaultKeyTemplate = "{user}/{yyyy}/{mm}/{imageID}/{variant}.{ext}"
+
+	// VariantOriginal is the variant of uploaded original
+	VariantOriginal = "original"
+	// VariantResized is the variant of resized image whose size is unknown, e.g. migrated one
+	VariantResized = "resized"
+	// VariantExport is the variant of export archive
+	VariantExport = "export"
+)
+
+var (
+	// Keys builds keys of stored objects, it is set from StorageKeyTemplate setting
+	Keys KeyTemplate
+
+	placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+	// defaultKeys is used by zero KeyTemplate
+	defaultKeys KeyTemplate
+
+	// placeholderPatterns match values of placeholders in keys built by a template
+	placeholderPatterns = map[string]string{
+		"{user}":    `[0-9a-f-]{36}`,
+		"{yyyy}":    `[0-9]{4}`,
+		"{mm}":      `[0-9]{2}`,
+		"{dd}":      `[0-9]{2}`,
+		"{imageID}": `[0-9a-f-]{36}`,
+		"{variant}": `[^/]+`,
+		"{ext}":     `[^/]*`,
+	}
+)
+
+// KeyParams describes the object whose key is built
+type KeyParams struct {
+	UserID uuid.UUID
+	// ImageID is the id of the image the object belongs to, exports use their own id
+	ImageID uuid.UUID
+	Variant string
+	// Ext is the file extension with or without leading dot
+	Ext string
+	// Time is the time when the object is stored, current time is used when it is zero
+	Time time.Time
+}
+
+// KeyTemplate builds keys by template with {user}, {yyyy}, {mm}, {dd}, {imageID}, {variant} and {ext} placeholders,
+// zero value uses DefaultKeyTemplate
+type KeyTemplate struct {
+	template string
+	pattern  *regexp.Regexp
+}
+
+// NewKeyTemplate validates the template, empty template means DefaultKeyTemplate.
+// The template must contain {imageID} and {variant}, so keys of different objects don't collide
+func NewKeyTemplate(template string) (KeyTemplate, error) {
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+
+	if strings.HasPrefix(template, "/") {
+		return KeyTemplate{}, fmt.Errorf("key template %q shouldn't start with slash", template)
+	}
+
+	for _, required := range []string{"{imageID}", "{variant}"} {
+		if !strings.Contains(template, required) {
+			return KeyTemplate{}, fmt.Errorf("key template %q should contain %s", template, required)
+		}
+	}
+
+	var (
+		pattern strings.Builder
+		last    int
+	)
+
+	pattern.WriteString("^")
+	for _, loc := range placeholderRe.FindAllStringIndex(template, -1) {
+		placeholder := template[loc[0]:loc[1]]
+		placeholderPattern, ok := placeholderPatterns[placeholder]
+		if !ok {
+			return KeyTemplate{}, fmt.Errorf("unknown placeholder %s in key template %q", placeholder, template)
+		}
+
+		literal := template[last:loc[0]]
+		if placeholder == "{ext}" && strings.HasSuffix(literal, ".") {
+			// the dot is omitted for objects without extension
+			literal = strings.TrimSuffix(literal, ".")
+			placeholderPattern = `(?:\.[^/]*)?`
+		}
+
+		if placeholder == "{variant}" {
+			// the variant is lazy, so the extension which follows it isn't captured
+			placeholderPattern = "(?P<variant>" + placeholderPattern + "?)"
+		}
+
+		pattern.WriteString(regexp.QuoteMeta(literal))
+		pattern.WriteString(placeholderPattern)
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	pattern.WriteString("$")
+
+	return KeyTemplate{template: template, pattern: regexp.MustCompile(pattern.String())}, nil
+}
+
+// Key builds the key of the object, dot before {ext} is omitted for objects without extension
+func (t KeyTemplate) Key(p KeyParams) string {
+	t = t.orDefault()
+
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+	p.Time = p.Time.UTC()
+
+	ext := strings.TrimPrefix(p.Ext, ".")
+	template := t.template
+	if ext == "" {
+		template = strings.Replace(template, ".{ext}", "{ext}", -1)
+	}
+
+	return strings.NewReplacer(
+		"{user}", p.UserID.String(),
+		"{yyyy}", p.Time.Format("2006"),
+		"{mm}", p.Time.Format("01"),
+		"{dd}", p.Time.Format("02"),
+		"{imageID}", p.ImageID.String(),
+		"{variant}", p.Variant,
+		"{ext}", strings.ToLower(ext),
+	).Replace(template)
+}
+
+// Match reports whether the key is built by the template, keys of other layouts need migration
+func (t KeyTemplate) Match(key string) bool {
+	return t.orDefault().pattern.MatchString(key)
+}
+
+// Variant returns the variant of the key built by the template
+func (t KeyTemplate) Variant(key string) (string, bool) {
+	t = t.orDefault()
+
+	match := t.pattern.FindStringSubmatch(key)
+	if match == nil {
+		return "", false
+	}
+
+	for i, name := range t.pattern.SubexpNames() {
+		if name == "variant" {
+			return match[i], true
+		}
+	}
+	return "", false
+}
+
+// String returns the template
+func (t KeyTemplate) String() string {
+	return t.orDefault().template
+}
+
+func (t KeyTemplate) orDefault() KeyTemplate {
+	if t.pattern != nil {
+		return t
+	}
+
+	return defaultKeys
+}
+
+func init() {
+	var err error
+	if defaultKeys, err = NewKeyTemplate(DefaultKeyTemplate); err != nil {
+		panic(err)
+	}
+}
+
+// Managed reports whether the object is created by the service, other objects of the bucket are never collected
+func Managed(key string) bool {
+	return Keys.Match(key) || strings.HasPrefix(key, LegacyKeyPrefix) || strings.HasPrefix(key, ChunkKeyPrefix)
+}
+
+// Private reports whether objects of the key are stored private, i.e. export archives.
+// Backends don't report the ACL of objects, so copies and rewrites keep it by the key
+func (t KeyTemplate) Private(key string) bool {
+	variant, ok := t.Variant(key)
+	return ok && variant == VariantExport
+}
+
+// ResizedVariant returns the variant of the image resized to given size,
+// resizing to the same size produces the same content, so it could be stored under the same key
+func ResizedVariant(width, height uint) string {
+	return fmt.Sprintf("%dx%d", width, height)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyTemplate_Key(t *testing.T) {
+	var (
+		userID  = uuid.MustParse("5e3f2c1a-0d4b-4c7e-9f6a-2b8d1e0c3a7f")
+		imageID = uuid.MustParse("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d")
+		stored  = time.Date(2019, time.March, 7, 23, 30, 0, 0, time.FixedZone("EET", 2*60*60))
+	)
+
+	testCases := []struct {
+		name     string
+		template string
+		params   KeyParams
+		exp      string
+	}{
+		{
+			name:   "Default template case",
+			params: KeyParams{UserID: userID, ImageID: imageID, Variant: VariantOriginal, Ext: ".PNG", Time: stored},
+			exp:    "5e3f2c1a-0d4b-4c7e-9f6a-2b8d1e0c3a7f/2019/03/9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d/original.png",
+		},
+		{
+			name:     "Custom template case",
+			template: "images/{yyyy}-{mm}-{dd}/{imageID}-{variant}.{ext}",
+			params:   KeyParams{UserID: userID, ImageID: imageID, Variant: ResizedVariant(200, 100), Ext: "jpg", Time: stored},
+			exp:      "images/2019-03-07/9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d-200x100.jpg",
+		},
+		{
+			name:   "Without extension case",
+			params: KeyParams{UserID: userID, ImageID: imageID, Variant: VariantResized, Time: stored},
+			exp:    "5e3f2c1a-0d4b-4c7e-9f6a-2b8d1e0c3a7f/2019/03/9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d/resized",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := NewKeyTemplate(tc.template)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			key := keys.Key(tc.params)
+			assert.Equal(t, tc.exp, key)
+			assert.True(t, keys.Match(key), "built key should match the template")
+		})
+	}
+}
+
+func TestKeyTemplate_Match(t *testing.T) {
+	var keys KeyTemplate
+
+	built := keys.Key(KeyParams{UserID: uuid.New(), ImageID: uuid.New(), Variant: VariantOriginal, Ext: ".jpg"})
+
+	testCases := []struct {
+		name string
+		key  string
+		exp  bool
+	}{
+		{"Built key case", built, true},
+		{"Legacy key case", "pictures/" + uuid.New().String() + ".jpg", false},
+		{"Nested key case", "prefix/" + built, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.exp, keys.Match(tc.key))
+		})
+	}
+}
+
+func TestKeyTemplate_Variant(t *testing.T) {
+	var keys KeyTemplate
+
+	testCases := []struct {
+		name       string
+		key        string
+		expVariant string
+		expOK      bool
+	}{
+		{"Original case", keys.Key(KeyParams{ImageID: uuid.New(), Variant: VariantOriginal, Ext: ".jpg"}), VariantOriginal, true},
+		{"Resized case", keys.Key(KeyParams{ImageID: uuid.New(), Variant: ResizedVariant(100, 50), Ext: ".jpg"}), "100x50", true},
+		{"Legacy key case", "pictures/" + uuid.New().String() + ".jpg", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			variant, ok := keys.Variant(tc.key)
+			assert.Equal(t, tc.expOK, ok)
+			assert.Equal(t, tc.expVariant, variant)
+		})
+	}
+}
+
+func TestKeyTemplate_Private(t *testing.T) {
+	var keys KeyTemplate
+
+	assert.True(t, keys.Private(keys.Key(KeyParams{ImageID: uuid.New(), Variant: VariantExport, Ext: ".zip"})), "archive should be private")
+	assert.False(t, keys.Private(keys.Key(KeyParams{ImageID: uuid.New(), Variant: VariantOriginal, Ext: ".jpg"})), "original shouldn't be private")
+	assert.False(t, keys.Private("pictures/"+uuid.New().String()+".jpg"), "legacy key shouldn't be private")
+}
+
+func TestNewKeyTemplate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		template string
+		expFail  bool
+	}{
+		{name: "Default case", template: ""},
+		{name: "Flat case", template: "{imageID}_{variant}{ext}"},
+		{name: "Without image id case", template: "{user}/{variant}.{ext}", expFail: true},
+		{name: "Without variant case", template: "{user}/{imageID}.{ext}", expFail: true},
+		{name: "Unknown placeholder case", template: "{bucket}/{imageID}/{variant}.{ext}", expFail: true},
+		{name: "Leading slash case", template: "/{imageID}/{variant}.{ext}", expFail: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewKeyTemplate(tc.template)
+			assert.Equal(t, tc.expFail, err != nil, "unexpected error: %v", err)
+		})
+	}
+}
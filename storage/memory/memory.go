@@ -0,0 +1,294 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// Op is a storage operation which could be failed by injected failure
+type Op string
+
+// Operations which could be failed, Exists and serving by Handler are treated as Stat and Get
+const (
+	OpPut    Op = "put"
+	OpGet    Op = "get"
+	OpStat   Op = "stat"
+	OpDelete Op = "delete"
+)
+
+// FailureFunc decides whether the operation on the key should fail, it returns nil to let it proceed
+type FailureFunc func(op Op, key string) error
+
+type object struct {
+	data        []byte
+	contentType string
+	etag        string
+	sha256      string
+	attrs       storage.Attributes
+	modified    time.Time
+}
+
+// Storage keeps objects in memory, it is safe for concurrent use
+type Storage struct {
+	baseURL string
+
+	mu      sync.RWMutex
+	objects map[string]object
+	failure FailureFunc
+}
+
+// NewStorage creates empty storage, links of objects start with baseURL
+func NewStorage(baseURL string) *Storage {
+	return &Storage{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		objects: make(map[string]object),
+	}
+}
+
+// InjectFailure makes operations fail when fn returns an error, nil fn removes the failure
+func (s *Storage) InjectFailure(fn FailureFunc) {
+	s.mu.Lock()
+	s.failure = fn
+	s.mu.Unlock()
+}
+
+// Keys returns keys of all stored objects
+func (s *Storage) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Put reads the whole content before storing it, so readers never see partially written objects.
+// Content which doesn't match opts.SHA256 is rejected
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	if err := s.check(ctx, OpPut, key); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(storage.Verify(r, opts.SHA256))
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	sum := md5.Sum(data)
+
+	s.mu.Lock()
+	s.objects[key] = object{
+		data:        data,
+		contentType: contentType,
+		etag:        hex.EncodeToString(sum[:]),
+		sha256:      storage.Checksum(data),
+		attrs:       copyAttributes(opts.Attributes),
+		modified:    time.Now(),
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.get(ctx, OpGet, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// stored data is never modified, so it is shared with the reader without copying
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// GetRange returns the part of the object, failures are injected as for OpGet
+func (s *Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	obj, err := s.get(ctx, OpGet, key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := obj.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length < int64(len(data)) {
+		data = data[:length]
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *Storage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	obj, err := s.get(ctx, OpStat, key)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	return storage.ObjectInfo{
+		Key:          key,
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		ETag:         obj.etag,
+		LastModified: obj.modified,
+		SHA256:       obj.sha256,
+		Attributes:   copyAttributes(obj.attrs),
+	}, nil
+}
+
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.get(ctx, OpStat, key)
+	switch err {
+	case nil:
+		return true, nil
+	case storage.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.check(ctx, OpDelete, key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Storage) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	keys := s.Keys()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		info, err := s.Stat(ctx, key)
+		if err == storage.ErrNotFound {
+			// deleted while listing
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *Storage) Key(link string) (string, error) {
+	if !strings.HasPrefix(link, s.baseURL+"/") {
+		return "", fmt.Errorf("link %q doesn't belong to the storage", link)
+	}
+	return strings.TrimPrefix(link, s.baseURL+"/"), nil
+}
+
+// Handler serves stored objects, it should be mounted at the path of base url
+func (s *Storage) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+
+		obj, err := s.get(r.Context(), OpGet, key)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		if obj.attrs.CacheControl != "" {
+			w.Header().Set("Cache-Control", obj.attrs.CacheControl)
+		}
+		if obj.attrs.ContentDisposition != "" {
+			w.Header().Set("Content-Disposition", obj.attrs.ContentDisposition)
+		}
+		http.ServeContent(w, r, key, obj.modified, bytes.NewReader(obj.data))
+	})
+}
+
+// copyAttributes copies metadata, so callers never share it with stored objects
+func copyAttributes(attrs storage.Attributes) storage.Attributes {
+	if attrs.Metadata == nil {
+		return attrs
+	}
+
+	metadata := make(map[string]string, len(attrs.Metadata))
+	for name, value := range attrs.Metadata {
+		metadata[name] = value
+	}
+	attrs.Metadata = metadata
+	return attrs
+}
+
+func (s *Storage) get(ctx context.Context, op Op, key string) (object, error) {
+	if err := s.check(ctx, op, key); err != nil {
+		return object{}, err
+	}
+
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return object{}, storage.ErrNotFound
+	}
+
+	return obj, nil
+}
+
+func (s *Storage) check(ctx context.Context, op Op, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	failure := s.failure
+	s.mu.RUnlock()
+
+	if failure != nil {
+		return failure(op, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const testURL = "http://localhost:8182/resizer/files"
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewStorage(testURL)
+	})
+}
+
+func TestStorage_InjectFailure(t *testing.T) {
+	var (
+		s       = NewStorage(testURL)
+		ctx     = context.Background()
+		failure = errors.New("FAILURE")
+	)
+
+	assert.NoError(t, s.Put(ctx, "pictures/ok.txt", bytes.NewBufferString("ok"), storage.PutOptions{}))
+
+	s.InjectFailure(func(op Op, key string) error {
+		if op == OpPut && key == "pictures/broken.txt" {
+			return failure
+		}
+		return nil
+	})
+
+	assert.Equal(t, failure, s.Put(ctx, "pictures/broken.txt", bytes.NewBufferString("broken"), storage.PutOptions{}))
+	assert.NoError(t, s.Put(ctx, "pictures/another.txt", bytes.NewBufferString("another"), storage.PutOptions{}))
+	assert.ElementsMatch(t, []string{"pictures/ok.txt", "pictures/another.txt"}, s.Keys())
+
+	s.InjectFailure(nil)
+	assert.NoError(t, s.Put(ctx, "pictures/broken.txt", bytes.NewBufferString("fixed"), storage.PutOptions{}))
+}
+
+func TestStorage_Handler(t *testing.T) {
+	s := NewStorage(testURL)
+	assert.NoError(t, s.Put(context.Background(), "pictures/image.txt", bytes.NewBufferString("hello"), storage.PutOptions{}))
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pictures/image.txt", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "hello", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pictures/missing.txt", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
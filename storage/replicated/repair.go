@@ -0,0 +1,94 @@
+package replicated
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	// SidePrimary names the primary storage in repair report
+	SidePrimary = "primary"
+	// SideSecondary names the secondary storage in repair report
+	SideSecondary = "secondary"
+)
+
+// Copy is a copy of the object which is missing in one of the storages
+type Copy struct {
+	Key  string
+	From string
+	To   string
+}
+
+// RepairReport describes copied objects, in dry run the copies are only planned
+type RepairReport struct {
+	Objects int
+	Missing int
+	Copied  int
+	Failed  int
+	Copies  []Copy
+}
+
+// Repair copies objects created by the service which are missing in one of the storages to the other one.
+// Objects deleted from the primary while their replicas weren't deleted are restored, run gc afterwards
+// to remove them if they aren't referenced. Failed copies are reported, the error is returned only
+// when the objects cannot be listed
+func (s *Storage) Repair(ctx context.Context, dryRun bool) (RepairReport, error) {
+	var (
+		report RepairReport
+		// copied keys are skipped when the storage they are copied to is listed
+		copied = make(map[string]bool)
+	)
+
+	sides := []struct {
+		name, otherName string
+		from, to        storage.Storage
+	}{
+		{SidePrimary, SideSecondary, s.primary, s.secondary},
+		{SideSecondary, SidePrimary, s.secondary, s.primary},
+	}
+
+	for _, side := range sides {
+		err := side.from.List(ctx, "", func(info storage.ObjectInfo) error {
+			if !storage.Managed(info.Key) || copied[info.Key] {
+				return nil
+			}
+			report.Objects++
+
+			exists, err := side.to.Exists(ctx, info.Key)
+			if err != nil {
+				s.log.Errorf("cannot check %q in %s storage due to: %s", info.Key, side.otherName, err)
+				report.Failed++
+				return nil
+			}
+			if exists {
+				return nil
+			}
+
+			report.Missing++
+			report.Copies = append(report.Copies, Copy{Key: info.Key, From: side.name, To: side.otherName})
+			if dryRun {
+				return nil
+			}
+
+			if err = storage.Copy(ctx, side.from, side.to, info.Key); err != nil {
+				s.log.Errorf("cannot copy %q to %s storage due to: %s", info.Key, side.otherName, err)
+				report.Failed++
+				return nil
+			}
+
+			copied[info.Key] = true
+			report.Copied++
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("cannot list %s storage: %s", side.name, err)
+		}
+	}
+
+	s.log.Infof("Finished repair of replicas: %d objects, %d missing, %d copied, %d failed",
+		report.Objects, report.Missing, report.Copied, report.Failed)
+
+	return report, nil
+}
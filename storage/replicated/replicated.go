@@ -0,0 +1,248 @@
+// Package replicated keeps objects in two backends, so the secondary one could serve them when the primary is lost
+package replicated
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	// ModeSync replicates objects before Put and Delete return, they fail when the secondary fails
+	ModeSync = "sync"
+	// ModeAsync replicates objects in background, objects which aren't replicated before restart are fixed by repair
+	ModeAsync = "async"
+
+	queueSize = 1024
+	workers   = 4
+)
+
+// Storage writes objects to the primary and replicates them to the secondary, reads fall back to the secondary
+// when the primary misses the object or fails. Objects are listed, linked and served by the primary only
+type Storage struct {
+	log       logger.Logger
+	primary   storage.Storage
+	secondary storage.Storage
+	async     bool
+	queue     chan string
+
+	mu      sync.Mutex
+	pending int
+	done    *sync.Cond
+}
+
+// NewStorage creates storage which replicates objects in the mode, workers of async mode are started at once
+func NewStorage(log logger.Logger, primary, secondary storage.Storage, mode string) (*Storage, error) {
+	s := &Storage{
+		log:       log,
+		primary:   primary,
+		secondary: secondary,
+	}
+	s.done = sync.NewCond(&s.mu)
+
+	switch mode {
+	case ModeSync:
+	case "", ModeAsync:
+		s.async = true
+		s.queue = make(chan string, queueSize)
+		for i := 0; i < workers; i++ {
+			go s.work()
+		}
+	default:
+		return nil, fmt.Errorf("unknown replication mode %q", mode)
+	}
+
+	return s, nil
+}
+
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	if err := s.primary.Put(ctx, key, r, opts); err != nil {
+		return err
+	}
+
+	return s.Replicate(ctx, key)
+}
+
+// SetAttributes sets attributes of the primary object, which is replicated then as the one stored by Put
+func (s *Storage) SetAttributes(ctx context.Context, info storage.ObjectInfo, attrs storage.Attributes) error {
+	if err := storage.SetAttributes(ctx, s.primary, info, attrs); err != nil {
+		return err
+	}
+
+	return s.Replicate(ctx, info.Key)
+}
+
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.primary.Get(ctx, key)
+	if err == nil {
+		return body, nil
+	}
+
+	body, secondaryErr := s.secondary.Get(ctx, key)
+	if secondaryErr != nil {
+		return nil, err
+	}
+
+	s.log.Warnf("object %q is read from the secondary storage, the primary has failed: %s", key, err)
+	return body, nil
+}
+
+func (s *Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, err := storage.GetRange(ctx, s.primary, key, offset, length)
+	if err == nil {
+		return body, nil
+	}
+
+	body, secondaryErr := storage.GetRange(ctx, s.secondary, key, offset, length)
+	if secondaryErr != nil {
+		return nil, err
+	}
+
+	s.log.Warnf("range of %q is read from the secondary storage, the primary has failed: %s", key, err)
+	return body, nil
+}
+
+func (s *Storage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	info, err := s.primary.Stat(ctx, key)
+	if err == nil {
+		return info, nil
+	}
+
+	info, secondaryErr := s.secondary.Stat(ctx, key)
+	if secondaryErr != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	return info, nil
+}
+
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := s.primary.Exists(ctx, key)
+	if exists {
+		return true, nil
+	}
+
+	if secondaryExists, _ := s.secondary.Exists(ctx, key); secondaryExists {
+		return true, nil
+	}
+
+	return false, err
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.primary.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	if s.async {
+		s.enqueue(key)
+		return nil
+	}
+
+	if err := s.secondary.Delete(ctx, key); err != nil {
+		return fmt.Errorf("cannot delete replica of %q: %s", key, err)
+	}
+	return nil
+}
+
+// List lists objects of the primary
+func (s *Storage) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	return s.primary.List(ctx, prefix, fn)
+}
+
+func (s *Storage) URL(key string) string {
+	return s.primary.URL(key)
+}
+
+func (s *Storage) Key(link string) (string, error) {
+	return s.primary.Key(link)
+}
+
+// Unwrap returns the primary, so objects uploaded by presigned requests should be passed to Replicate
+func (s *Storage) Unwrap() storage.Storage {
+	return s.primary
+}
+
+// Secondary returns the storage where objects are replicated
+func (s *Storage) Secondary() storage.Storage {
+	return s.secondary
+}
+
+// Find returns the replicated storage in the chain of decorators, e.g. the hot tier
+func Find(s storage.Storage) (*Storage, bool) {
+	for ; s != nil; s = storage.Unwrap(s) {
+		if replicas, ok := s.(*Storage); ok {
+			return replicas, true
+		}
+	}
+	return nil, false
+}
+
+// Replicate copies the object of the primary to the secondary, in async mode it is only queued
+func (s *Storage) Replicate(ctx context.Context, key string) error {
+	if s.async {
+		s.enqueue(key)
+		return nil
+	}
+
+	if err := storage.Copy(ctx, s.primary, s.secondary, key); err != nil {
+		return fmt.Errorf("cannot replicate %q: %s", key, err)
+	}
+	return nil
+}
+
+// Flush waits until queued replications are done, it should be called before exit
+func (s *Storage) Flush() {
+	s.mu.Lock()
+	for s.pending > 0 {
+		s.done.Wait()
+	}
+	s.mu.Unlock()
+}
+
+// enqueue doesn't block when the queue is full, the object is left for repair then
+func (s *Storage) enqueue(key string) {
+	s.mu.Lock()
+	s.pending++
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- key:
+	default:
+		s.log.Errorf("replication queue is full, %q should be fixed by repair", key)
+		s.finish()
+	}
+}
+
+func (s *Storage) work() {
+	for key := range s.queue {
+		if err := s.sync(context.Background(), key); err != nil {
+			s.log.Errorf("cannot replicate %q due to: %s", key, err)
+		}
+		s.finish()
+	}
+}
+
+// sync makes the secondary match the current state of the object in the primary,
+// so the order in which replications of the same key are done doesn't matter
+func (s *Storage) sync(ctx context.Context, key string) error {
+	err := storage.Copy(ctx, s.primary, s.secondary, key)
+	if err != storage.ErrNotFound {
+		return err
+	}
+
+	return s.secondary.Delete(ctx, key)
+}
+
+func (s *Storage) finish() {
+	s.mu.Lock()
+	s.pending--
+	if s.pending == 0 {
+		s.done.Broadcast()
+	}
+	s.mu.Unlock()
+}
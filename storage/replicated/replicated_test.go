@@ -0,0 +1,196 @@
+package replicated
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const (
+	primaryURL   = "http://localhost/primary"
+	secondaryURL = "http://localhost/secondary"
+)
+
+var errBackend = errors.New("BACKEND ERROR")
+
+func newTestStorage(t *testing.T, mode string) (*Storage, *memory.Storage, *memory.Storage) {
+	primary, secondary := memory.NewStorage(primaryURL), memory.NewStorage(secondaryURL)
+
+	s, err := NewStorage(logger.NewMokLogger(), primary, secondary, mode)
+	if err != nil {
+		t.Fatalf("cannot create storage: %s", err)
+	}
+
+	return s, primary, secondary
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	for _, mode := range []string{ModeSync, ModeAsync} {
+		t.Run(mode, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storage.Storage {
+				s, _, _ := newTestStorage(t, mode)
+				return s
+			})
+		})
+	}
+}
+
+func TestNewStorage_Mode(t *testing.T) {
+	_, err := NewStorage(logger.NewMokLogger(), memory.NewStorage(primaryURL), memory.NewStorage(secondaryURL), "eventual")
+	assert.Error(t, err)
+}
+
+func TestStorage_Replication(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         string
+		secondaryErr error
+		expErr       bool
+	}{
+		{name: "Sync case", mode: ModeSync},
+		{name: "Async case", mode: ModeAsync},
+		{name: "Sync failure case", mode: ModeSync, secondaryErr: errBackend, expErr: true},
+		{name: "Async failure case", mode: ModeAsync, secondaryErr: errBackend},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				ctx                     = context.Background()
+				s, primary, secondary   = newTestStorage(t, tc.mode)
+				key                     = "pictures/image.png"
+				expPrimary, expReplicas = []string{key}, []string{key}
+			)
+
+			secondary.InjectFailure(func(memory.Op, string) error { return tc.secondaryErr })
+			if tc.secondaryErr != nil {
+				expReplicas = []string{}
+			}
+
+			err := s.Put(ctx, key, bytes.NewBufferString("content"), storage.PutOptions{ContentType: "image/png", Size: -1})
+			assert.Equal(t, tc.expErr, err != nil, "unexpected error: %v", err)
+			s.Flush()
+
+			assert.Equal(t, expPrimary, primary.Keys(), "object should be stored in the primary")
+			assert.Equal(t, expReplicas, secondary.Keys(), "unexpected replicas")
+
+			if tc.secondaryErr == nil {
+				info, err := secondary.Stat(ctx, key)
+				assert.NoError(t, err)
+				assert.Equal(t, "image/png", info.ContentType, "content type should be replicated")
+			}
+
+			secondary.InjectFailure(nil)
+			assert.NoError(t, secondary.Put(ctx, key, bytes.NewBufferString("content"), storage.PutOptions{Size: -1}))
+
+			assert.NoError(t, s.Delete(ctx, key))
+			s.Flush()
+
+			assert.Empty(t, primary.Keys(), "object should be deleted from the primary")
+			assert.Empty(t, secondary.Keys(), "replica should be deleted")
+		})
+	}
+}
+
+func TestStorage_Fallback(t *testing.T) {
+	var (
+		ctx                   = context.Background()
+		s, primary, secondary = newTestStorage(t, ModeSync)
+		key                   = "pictures/image.png"
+	)
+
+	assert.NoError(t, secondary.Put(ctx, key, bytes.NewBufferString("replica"), storage.PutOptions{Size: -1}))
+
+	for _, primaryErr := range []error{nil, errBackend} {
+		primary.InjectFailure(func(memory.Op, string) error { return primaryErr })
+
+		content, err := s.Get(ctx, key)
+		if assert.NoError(t, err, "missing or failed object should be read from the secondary") {
+			data, _ := ioutil.ReadAll(content)
+			assert.Equal(t, "replica", string(data))
+			assert.NoError(t, content.Close())
+		}
+
+		_, err = s.Stat(ctx, key)
+		assert.NoError(t, err)
+
+		exists, err := s.Exists(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	primary.InjectFailure(nil)
+	_, err := s.Get(ctx, "pictures/missing.png")
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	primary.InjectFailure(func(memory.Op, string) error { return errBackend })
+	_, err = s.Stat(ctx, "pictures/missing.png")
+	assert.Equal(t, errBackend, err, "error of the primary should be returned when the secondary misses the object")
+}
+
+func TestReplicate(t *testing.T) {
+	var (
+		ctx                   = context.Background()
+		s, primary, secondary = newTestStorage(t, ModeSync)
+		key                   = "pictures/image.png"
+	)
+
+	// e.g. uploaded by presigned request
+	assert.NoError(t, primary.Put(ctx, key, bytes.NewBufferString("content"), storage.PutOptions{Size: -1}))
+
+	assert.NoError(t, storage.Replicate(ctx, s, key))
+	assert.Equal(t, []string{key}, secondary.Keys())
+
+	assert.NoError(t, storage.Replicate(ctx, primary, key), "not replicated storage should be skipped")
+}
+
+func TestStorage_Repair(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		primaryOnly   = "pictures/primary.png"
+		secondaryOnly = "pictures/secondary.png"
+		both          = "pictures/both.png"
+		unmanaged     = "other/file.txt"
+	)
+
+	for _, dryRun := range []bool{true, false} {
+		s, primary, secondary := newTestStorage(t, ModeSync)
+
+		for _, key := range []string{primaryOnly, both, unmanaged} {
+			assert.NoError(t, primary.Put(ctx, key, bytes.NewBufferString(key), storage.PutOptions{Size: -1}))
+		}
+		for _, key := range []string{secondaryOnly, both} {
+			assert.NoError(t, secondary.Put(ctx, key, bytes.NewBufferString(key), storage.PutOptions{Size: -1}))
+		}
+
+		report, err := s.Repair(ctx, dryRun)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 4, report.Objects, "managed objects of both storages should be listed")
+		assert.Equal(t, 2, report.Missing)
+		assert.Equal(t, 0, report.Failed)
+		assert.ElementsMatch(t, []Copy{
+			{Key: primaryOnly, From: SidePrimary, To: SideSecondary},
+			{Key: secondaryOnly, From: SideSecondary, To: SidePrimary},
+		}, report.Copies)
+
+		if dryRun {
+			assert.Equal(t, 0, report.Copied)
+			assert.ElementsMatch(t, []string{primaryOnly, both, unmanaged}, primary.Keys(), "dry run shouldn't copy objects")
+			continue
+		}
+
+		assert.Equal(t, 2, report.Copied)
+		assert.ElementsMatch(t, []string{primaryOnly, secondaryOnly, both, unmanaged}, primary.Keys())
+		assert.ElementsMatch(t, []string{primaryOnly, secondaryOnly, both}, secondary.Keys(), "unmanaged objects shouldn't be copied")
+	}
+}
@@ -0,0 +1,129 @@
+package resilient
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of the circuit breaker
+type State string
+
+// States of the circuit breaker
+const (
+	// StateClosed lets all calls through
+	StateClosed State = "closed"
+	// StateOpen fails all calls until the open timeout passes
+	StateOpen State = "open"
+	// StateHalfOpen lets a single probe through, its result closes or opens the breaker again
+	StateHalfOpen State = "half-open"
+)
+
+// Health describes the circuit breaker
+type Health struct {
+	State               State      `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+}
+
+// breaker opens after threshold consecutive failures, so calls fail fast instead of waiting for a broken backend
+type breaker struct {
+	threshold   int
+	openTimeout time.Duration
+	now         func() time.Time
+	// onChange is called under the lock, it mustn't call the breaker
+	onChange func(from, to State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, openTimeout time.Duration, onChange func(from, to State)) *breaker {
+	return &breaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		now:         time.Now,
+		onChange:    onChange,
+		state:       StateClosed,
+	}
+}
+
+// allow reports whether the call could be made, the allowed call must be finished by success, failure or ignore
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(StateHalfOpen)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+
+	return true
+}
+
+// success is reported when the backend has responded, even with an error like missing object
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.setState(StateClosed)
+}
+
+// failure is reported when the backend hasn't responded in time or has failed
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(StateOpen)
+	}
+}
+
+// ignore is reported when the call tells nothing about the backend, e.g. it is canceled by the caller
+func (b *breaker) ignore() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *breaker) health() Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := Health{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		h.OpenedAt = &openedAt
+	}
+
+	return h
+}
+
+func (b *breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,417 @@
+// Package resilient decorates a storage with deadlines, retries and a circuit breaker,
+// so a slow or failing backend doesn't block request goroutines
+package resilient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultAttempts         = 3
+	defaultBackoff          = 100 * time.Millisecond
+	defaultMaxBackoff       = 2 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerOpen      = 30 * time.Second
+)
+
+// ErrTimeout is returned when the backend hasn't responded before the deadline
+var ErrTimeout = errors.New("storage hasn't responded in time")
+
+// RetryableFunc reports whether the failed call could succeed when it is repeated,
+// failures of retryable calls are counted by the circuit breaker as well
+type RetryableFunc func(err error) bool
+
+// Storage calls the wrapped storage with a deadline per attempt and retries retryable failures with jittered
+// exponential backoff. After StorageBreakerThreshold consecutive failures calls fail fast with storage.ErrUnavailable
+// for StorageBreakerOpenSec, then a single probe decides whether the backend is back.
+// Optional interfaces of the wrapped storage are available by storage.AsLinker and similar
+type Storage struct {
+	log       logger.Logger
+	wrapped   storage.Storage
+	retryable RetryableFunc
+
+	timeout    time.Duration
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *breaker
+}
+
+// NewStorage decorates s, nil retryable treats all errors except storage.ErrNotFound
+// and storage.ErrChecksumMismatch as retryable
+func NewStorage(log logger.Logger, s storage.Storage, retryable RetryableFunc) *Storage {
+	r := &Storage{
+		log:        log,
+		wrapped:    s,
+		retryable:  retryable,
+		timeout:    time.Duration(config.Conf.StorageTimeoutSec) * time.Second,
+		attempts:   config.Conf.StorageRetryAttempts,
+		backoff:    time.Duration(config.Conf.StorageRetryBackoffMS) * time.Millisecond,
+		maxBackoff: time.Duration(config.Conf.StorageRetryMaxBackoffMS) * time.Millisecond,
+	}
+
+	if r.retryable == nil {
+		r.retryable = func(err error) bool { return err != storage.ErrNotFound && err != storage.ErrChecksumMismatch }
+	}
+	if r.timeout <= 0 {
+		r.timeout = defaultTimeout
+	}
+	if r.attempts <= 0 {
+		r.attempts = defaultAttempts
+	}
+	if r.backoff <= 0 {
+		r.backoff = defaultBackoff
+	}
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = defaultMaxBackoff
+	}
+
+	threshold := config.Conf.StorageBreakerThreshold
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	openTimeout := time.Duration(config.Conf.StorageBreakerOpenSec) * time.Second
+	if openTimeout <= 0 {
+		openTimeout = defaultBreakerOpen
+	}
+	r.breaker = newBreaker(threshold, openTimeout, r.logStateChange)
+
+	return r
+}
+
+// Put is retried only when r could be rewound, e.g. it is bytes.Reader or a file.
+// Failures to read other content, e.g. a broken request body, aren't failures of the backend.
+// Other content is read as fast as the caller sends it, e.g. by a slow client, so its upload isn't limited
+// by the deadline and is bounded by the caller context only
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	rewind := rewinder(r)
+
+	if rewind == nil {
+		source := &sourceReader{r: r}
+		return s.do(ctx, "put", key, nil, func(ctx context.Context) error {
+			err := s.wrapped.Put(ctx, key, source, opts)
+			if err != nil && source.err != nil {
+				return callerError{err}
+			}
+			return err
+		})
+	}
+
+	return s.do(ctx, "put", key, rewind, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) error {
+			return s.wrapped.Put(ctx, key, r, opts)
+		})
+	})
+}
+
+// Get limits the time until the backend responds, the body is read within the caller context
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+
+	err := s.do(ctx, "get", key, repeat, func(ctx context.Context) error {
+		release, err := s.deadline(ctx, func(ctx context.Context) (err error) {
+			body, err = s.wrapped.Get(ctx, key)
+			return err
+		})
+		if err != nil {
+			if err == ErrTimeout && body != nil {
+				// the backend has responded right at the deadline, the body is already canceled
+				body.Close()
+			}
+			return err
+		}
+
+		body = &releasingBody{ReadCloser: body, release: release}
+		return nil
+	})
+
+	return body, err
+}
+
+// GetRange is limited as Get
+func (s *Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	var body io.ReadCloser
+
+	err := s.do(ctx, "get range", key, repeat, func(ctx context.Context) error {
+		release, err := s.deadline(ctx, func(ctx context.Context) (err error) {
+			body, err = storage.GetRange(ctx, s.wrapped, key, offset, length)
+			return err
+		})
+		if err != nil {
+			if err == ErrTimeout && body != nil {
+				body.Close()
+			}
+			return err
+		}
+
+		body = &releasingBody{ReadCloser: body, release: release}
+		return nil
+	})
+
+	return body, err
+}
+
+// SetAttributes is repeated as a whole, since the content of the fallback is read from the backend again
+func (s *Storage) SetAttributes(ctx context.Context, info storage.ObjectInfo, attrs storage.Attributes) error {
+	return s.do(ctx, "set attributes", info.Key, repeat, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) error {
+			return storage.SetAttributes(ctx, s.wrapped, info, attrs)
+		})
+	})
+}
+
+func (s *Storage) Stat(ctx context.Context, key string) (info storage.ObjectInfo, err error) {
+	err = s.do(ctx, "stat", key, repeat, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) (err error) {
+			info, err = s.wrapped.Stat(ctx, key)
+			return err
+		})
+	})
+
+	return info, err
+}
+
+func (s *Storage) Exists(ctx context.Context, key string) (exists bool, err error) {
+	err = s.do(ctx, "exists", key, repeat, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) (err error) {
+			exists, err = s.wrapped.Exists(ctx, key)
+			return err
+		})
+	})
+
+	return exists, err
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	return s.do(ctx, "delete", key, repeat, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) error {
+			return s.wrapped.Delete(ctx, key)
+		})
+	})
+}
+
+// List isn't limited by the deadline, since listing of the whole bucket takes long.
+// It is retried only until the first object is passed to fn, errors of fn are returned as is
+func (s *Storage) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	var listed bool
+
+	rewind := func() error {
+		if listed {
+			return errors.New("objects have been listed already")
+		}
+		return nil
+	}
+
+	return s.do(ctx, "list", prefix, rewind, func(ctx context.Context) error {
+		return s.wrapped.List(ctx, prefix, func(info storage.ObjectInfo) error {
+			listed = true
+			if err := fn(info); err != nil {
+				return callerError{err}
+			}
+			return nil
+		})
+	})
+}
+
+func (s *Storage) URL(key string) string {
+	return s.wrapped.URL(key)
+}
+
+func (s *Storage) Key(link string) (string, error) {
+	return s.wrapped.Key(link)
+}
+
+// Unwrap returns the decorated storage
+func (s *Storage) Unwrap() storage.Storage {
+	return s.wrapped
+}
+
+// Health returns the state of the circuit breaker
+func (s *Storage) Health() Health {
+	return s.breaker.health()
+}
+
+// do makes attempts of the call until it succeeds, fails with not retryable error, the attempts are exhausted
+// or the caller gives up. rewind prepares the call to be repeated, nil rewind means that it cannot be repeated
+func (s *Storage) do(ctx context.Context, op, key string, rewind func() error, attempt func(ctx context.Context) error) error {
+	if !s.breaker.allow() {
+		return storage.ErrUnavailable
+	}
+
+	var err error
+	for i := 1; ; i++ {
+		err = attempt(ctx)
+		if !s.failed(ctx, err) || i >= s.attempts || rewind == nil {
+			break
+		}
+		if rewindErr := rewind(); rewindErr != nil {
+			s.log.Debugf("Storage %s of %q cannot be retried: %s", op, key, rewindErr)
+			break
+		}
+
+		s.log.Warnf("storage %s of %q failed (attempt %d of %d): %s", op, key, i, s.attempts, err)
+		if !sleep(ctx, s.delay(i)) {
+			break
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		s.breaker.ignore()
+	case s.failed(ctx, err):
+		s.breaker.failure()
+	default:
+		s.breaker.success()
+	}
+
+	if stop, ok := err.(callerError); ok {
+		return stop.err
+	}
+	return err
+}
+
+// failed reports whether the error is the failure of the backend
+func (s *Storage) failed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if _, ok := err.(callerError); ok {
+		return false
+	}
+
+	return err == ErrTimeout || s.retryable(err)
+}
+
+// call calls fn within the deadline
+func (s *Storage) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	release, err := s.deadline(ctx, fn)
+	release()
+	return err
+}
+
+// deadline cancels the context of fn when it doesn't return in time, the context stays alive after fn returns
+// until release is called, so the result could be read after the deadline (e.g. the body of the object)
+func (s *Storage) deadline(ctx context.Context, fn func(ctx context.Context) error) (release func(), err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(s.timeout, cancel)
+
+	err = fn(ctx)
+	if !timer.Stop() {
+		// the context was canceled by the timer, so fn has failed or its result is unusable
+		cancel()
+		return cancel, ErrTimeout
+	}
+	if err != nil {
+		cancel()
+	}
+
+	return cancel, err
+}
+
+// delay doubles the backoff after every attempt, the half of it is random,
+// so instances of the service don't retry simultaneously
+func (s *Storage) delay(attempt int) time.Duration {
+	d := s.backoff
+	for i := 1; i < attempt && d < s.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.maxBackoff {
+		d = s.maxBackoff
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (s *Storage) logStateChange(from, to State) {
+	switch to {
+	case StateOpen:
+		s.log.Errorf("storage circuit breaker is open, calls fail fast (was %s)", from)
+	case StateHalfOpen:
+		s.log.Infof("Storage circuit breaker is half-open, probing the backend")
+	case StateClosed:
+		s.log.Infof("Storage circuit breaker is closed, the backend is available")
+	}
+}
+
+// callerError is returned by the callback passed by the caller, it isn't the failure of the backend
+type callerError struct {
+	err error
+}
+
+func (e callerError) Error() string {
+	return e.err.Error()
+}
+
+// sourceReader remembers the error of the content passed by the caller
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// releasingBody releases the context of Get when the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// repeat is the rewind of idempotent calls
+func repeat() error {
+	return nil
+}
+
+// rewinder returns the rewind which seeks r back to its current position, or nil when r isn't seekable
+func rewinder(r io.Reader) func() error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return nil
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+
+	return func() error {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return fmt.Errorf("cannot rewind content: %s", err)
+		}
+		return nil
+	}
+}
+
+// sleep waits for d, it returns false when ctx is done earlier
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,323 @@
+package resilient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const testURL = "http://localhost:8182/resizer/files"
+
+var errBackend = errors.New("BACKEND ERROR")
+
+func init() {
+	config.Conf.StorageRetryBackoffMS = 1
+	config.Conf.StorageRetryMaxBackoffMS = 2
+	config.Conf.StorageBreakerThreshold = 2
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewStorage(logger.NewMokLogger(), memory.NewStorage(testURL), nil)
+	})
+}
+
+func TestStorage_Retries(t *testing.T) {
+	testCases := []struct {
+		name        string
+		failures    int32
+		failure     error
+		content     func() io.Reader
+		expErr      error
+		expAttempts int32
+	}{
+		{
+			name:        "Good case",
+			content:     func() io.Reader { return bytes.NewReader([]byte("content")) },
+			expAttempts: 1,
+		},
+		{
+			name:        "Retried failure case",
+			failures:    2,
+			failure:     errBackend,
+			content:     func() io.Reader { return bytes.NewReader([]byte("content")) },
+			expAttempts: 3,
+		},
+		{
+			name:        "Exhausted attempts case",
+			failures:    3,
+			failure:     errBackend,
+			content:     func() io.Reader { return bytes.NewReader([]byte("content")) },
+			expErr:      errBackend,
+			expAttempts: 3,
+		},
+		{
+			name:        "Not retryable failure case",
+			failures:    1,
+			failure:     storage.ErrNotFound,
+			content:     func() io.Reader { return bytes.NewReader([]byte("content")) },
+			expErr:      storage.ErrNotFound,
+			expAttempts: 1,
+		},
+		{
+			name:        "Not seekable content case",
+			failures:    1,
+			failure:     errBackend,
+			content:     func() io.Reader { return bytes.NewBufferString("content") },
+			expErr:      errBackend,
+			expAttempts: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				ctx      = context.Background()
+				backend  = memory.NewStorage(testURL)
+				attempts int32
+			)
+
+			backend.InjectFailure(func(op memory.Op, key string) error {
+				if atomic.AddInt32(&attempts, 1) <= tc.failures {
+					return tc.failure
+				}
+				return nil
+			})
+
+			s := NewStorage(logger.NewMokLogger(), backend, nil)
+
+			err := s.Put(ctx, "pictures/image.png", tc.content(), storage.PutOptions{Size: -1})
+			assert.Equal(t, tc.expErr, err)
+			assert.Equal(t, tc.expAttempts, attempts, "unexpected number of attempts")
+
+			if tc.expErr == nil {
+				content, err := s.Get(ctx, "pictures/image.png")
+				if assert.NoError(t, err) {
+					data, _ := ioutil.ReadAll(content)
+					assert.Equal(t, "content", string(data), "retried content should be stored from the start")
+					assert.NoError(t, content.Close())
+				}
+			}
+		})
+	}
+}
+
+func TestStorage_Breaker(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		calls   int32
+		down    = true
+		now     = time.Now()
+	)
+
+	backend.InjectFailure(func(op memory.Op, key string) error {
+		atomic.AddInt32(&calls, 1)
+		if down {
+			return errBackend
+		}
+		return nil
+	})
+
+	s := NewStorage(logger.NewMokLogger(), backend, nil)
+	s.breaker.now = func() time.Time { return now }
+
+	_, err := s.Stat(ctx, "pictures/image.png")
+	assert.Equal(t, errBackend, err)
+	assert.Equal(t, StateClosed, s.Health().State, "breaker should be closed before the threshold")
+
+	_, err = s.Stat(ctx, "pictures/image.png")
+	assert.Equal(t, errBackend, err)
+	assert.Equal(t, StateOpen, s.Health().State, "breaker should be open after the threshold")
+	assert.Equal(t, 2, s.Health().ConsecutiveFailures)
+
+	calls = 0
+	assert.Equal(t, storage.ErrUnavailable, s.Delete(ctx, "pictures/image.png"))
+	assert.Zero(t, calls, "backend shouldn't be called while breaker is open")
+
+	now = now.Add(defaultBreakerOpen)
+	assert.Equal(t, errBackend, s.Delete(ctx, "pictures/image.png"))
+	assert.Equal(t, int32(3), calls, "probe should be retried")
+	assert.Equal(t, StateOpen, s.Health().State, "failed probe should open breaker again")
+
+	now = now.Add(defaultBreakerOpen)
+	down = false
+	_, err = s.Stat(ctx, "pictures/image.png")
+	assert.Equal(t, storage.ErrNotFound, err, "missing object means that backend is available")
+	assert.Equal(t, Health{State: StateClosed}, s.Health())
+}
+
+func TestStorage_BrokenContent(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		errRead = errors.New("READ ERROR")
+	)
+
+	s := NewStorage(logger.NewMokLogger(), backend, nil)
+
+	for i := 0; i < 3; i++ {
+		content := io.MultiReader(bytes.NewBufferString("partial"), &failingReader{err: errRead})
+		err := s.Put(ctx, "pictures/image.png", content, storage.PutOptions{Size: -1})
+		assert.Equal(t, errRead, err)
+	}
+
+	assert.Equal(t, Health{State: StateClosed}, s.Health(), "broken content shouldn't be counted as backend failure")
+}
+
+func TestStorage_SlowContent(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+	)
+
+	s := NewStorage(logger.NewMokLogger(), backend, nil)
+	s.timeout = 20 * time.Millisecond
+
+	content := io.MultiReader(bytes.NewBufferString("slow "), &slowReader{delay: 2 * s.timeout}, bytes.NewBufferString("content"))
+	assert.NoError(t, s.Put(ctx, "pictures/image.png", content, storage.PutOptions{Size: -1}),
+		"content sent by a slow caller shouldn't be stopped by the deadline")
+	assert.Equal(t, Health{State: StateClosed}, s.Health())
+
+	stored, err := storage.Download(ctx, backend, "pictures/image.png", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "slow content", string(stored))
+}
+
+// slowReader waits for the delay and ends
+type slowReader struct {
+	delay time.Duration
+}
+
+func (r *slowReader) Read([]byte) (int, error) {
+	time.Sleep(r.delay)
+	return 0, io.EOF
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestStorage_Timeout(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		delay   = time.Duration(0)
+	)
+
+	assert.NoError(t, backend.Put(ctx, "pictures/image.png", bytes.NewBufferString("content"), storage.PutOptions{Size: -1}))
+
+	s := NewStorage(logger.NewMokLogger(), slowStorage{Storage: backend, delay: &delay}, nil)
+	s.timeout = 20 * time.Millisecond
+
+	content, err := s.Get(ctx, "pictures/image.png")
+	if assert.NoError(t, err) {
+		time.Sleep(2 * s.timeout)
+		data, err := ioutil.ReadAll(content)
+		assert.NoError(t, err, "body should be readable after the deadline")
+		assert.Equal(t, "content", string(data))
+		assert.NoError(t, content.Close())
+	}
+
+	delay = time.Second
+	started := time.Now()
+	_, err = s.Stat(ctx, "pictures/image.png")
+	assert.Equal(t, ErrTimeout, err)
+	assert.True(t, time.Since(started) < delay, "call should be stopped by the deadline")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.Stat(canceled, "pictures/image.png")
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, s.Health().ConsecutiveFailures, "call canceled by the caller shouldn't be counted")
+}
+
+func TestStorage_List(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		backend = memory.NewStorage(testURL)
+		s       = NewStorage(logger.NewMokLogger(), backend, nil)
+		stop    = errors.New("STOP")
+		listed  []string
+	)
+
+	for _, key := range []string{"pictures/1.png", "pictures/2.png"} {
+		assert.NoError(t, backend.Put(ctx, key, bytes.NewBufferString(key), storage.PutOptions{Size: -1}))
+	}
+
+	for i := 0; i < config.Conf.StorageBreakerThreshold; i++ {
+		err := s.List(ctx, "pictures/", func(info storage.ObjectInfo) error {
+			listed = append(listed, info.Key)
+			return stop
+		})
+		assert.Equal(t, stop, err, "error of the callback should be returned as is")
+	}
+
+	assert.Equal(t, []string{"pictures/1.png", "pictures/1.png"}, listed, "listing shouldn't be retried after the error of the callback")
+	assert.Equal(t, Health{State: StateClosed}, s.Health(), "error of the callback shouldn't be counted by breaker")
+}
+
+func TestStorage_Unwrap(t *testing.T) {
+	backend := memory.NewStorage(testURL)
+	s := NewStorage(logger.NewMokLogger(), backend, nil)
+
+	server, ok := storage.AsServer(s)
+	assert.True(t, ok, "optional interfaces of wrapped storage should be found")
+	assert.Equal(t, backend, server)
+
+	_, ok = storage.AsLinker(s)
+	assert.False(t, ok)
+}
+
+func TestStorage_delay(t *testing.T) {
+	s := &Storage{backoff: 100 * time.Millisecond, maxBackoff: time.Second}
+
+	testCases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 4, max: 800 * time.Millisecond},
+		{attempt: 10, max: time.Second},
+	}
+
+	for _, tc := range testCases {
+		for i := 0; i < 10; i++ {
+			d := s.delay(tc.attempt)
+			assert.True(t, d >= tc.max/2 && d <= tc.max, "delay %s of attempt %d is out of [%s, %s]", d, tc.attempt, tc.max/2, tc.max)
+		}
+	}
+}
+
+// slowStorage delays Stat until the delay passes or ctx is done
+type slowStorage struct {
+	storage.Storage
+	delay *time.Duration
+}
+
+func (s slowStorage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	select {
+	case <-time.After(*s.delay):
+		return s.Storage.Stat(ctx, key)
+	case <-ctx.Done():
+		return storage.ObjectInfo{}, ctx.Err()
+	}
+}
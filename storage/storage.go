@@ -1,9 +1,372 @@
 package storage
 
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// BackendAWS keeps objects in aws s3 bucket
+	BackendAWS = "aws"
+	// BackendFilesystem keeps objects in local directory and serves them by the service
+	BackendFilesystem = "filesystem"
+	// BackendMemory keeps objects in memory until restart, it is intended for tests and previews
+	BackendMemory = "memory"
+
+	// FilesPath is the path under BasePath where the service serves stored files if backend requires it
+	FilesPath = "/files"
+	// ColdFilesPath is the path under BasePath where the service serves files of the cold tier if backend requires it
+	ColdFilesPath = "/files-cold"
+
+	// LegacyKeyPrefix is the prefix of keys which were generated before key templates
+	LegacyKeyPrefix = "pictures/"
+	// ChunkKeyPrefix is the prefix of temporary chunks of resumable uploads
+	ChunkKeyPrefix = "tus/"
+)
+
+var (
+	// Client is the storage chosen by StorageBackend setting
+	Client Storage
+
+	// ErrNotFound is returned when object with requested key doesn't exist
+	ErrNotFound = errors.New("object is not found")
+	// ErrUnavailable is returned without calling the backend while it is considered down
+	ErrUnavailable = errors.New("storage is temporarily unavailable")
+	// ErrNoLinks is returned by Link of storages whose objects aren't shared by links, e.g. encrypted ones,
+	// such objects are served by the API
+	ErrNoLinks = errors.New("objects of the storage aren't shared by links")
+)
+
 //go:generate mockgen -destination=../mocks/mock-storage.go -mock_names=Storage=MockStorage -package=mocks github.com/Dimitriy14/image-resizing/storage Storage
 type Storage interface {
-	Upload(fileExt string, content []byte) (link string, err error)
-	UploadWithOriginal(filExt string, originalImgContent, resizedImgContent []byte) (string, string, error)
-	Download(addr string) (fileContent []byte, err error)
-	DeleteImage(addr string) error
+	// Put stores content read from r, the object becomes visible only when the whole content has been read
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error
+	// Get returns the content of the object, it must be closed by the caller
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes the object, it doesn't fail if the object doesn't exist
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every object whose key starts with prefix, listing stops when fn returns an error
+	// and the error is returned. Listed objects could miss ContentType
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+
+	// URL returns the link of the object
+	URL(key string) string
+	// Key returns the key of the object which is available by the link
+	Key(link string) (string, error)
+}
+
+// Linker is implemented by backends which share objects by temporary links, e.g. private buckets
+type Linker interface {
+	// Link returns the link of the object which is given to clients
+	Link(key string) (string, error)
+}
+
+// UploadPresigner is implemented by backends which accept uploads directly from clients
+type UploadPresigner interface {
+	// PresignPut returns the request which uploads the object with opts.ContentType and opts.Attributes
+	// of opts.Size bytes, it expires after ttl
+	PresignPut(key string, opts PutOptions, ttl time.Duration) (PresignedRequest, error)
+}
+
+// PresignedRequest is a request which the client sends to the storage as is
+type PresignedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Headers are signed, so the request must contain exactly them
+	Headers map[string]string `json:"headers"`
+}
+
+// RangeReader is implemented by storages which read a part of the object without reading the rest of it
+type RangeReader interface {
+	// GetRange returns up to length bytes of the object which start at offset, it must be closed by the caller
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// AttributesSetter is implemented by storages which replace attributes of the stored object without
+// transferring its content, e.g. by server side copy
+type AttributesSetter interface {
+	// SetAttributes replaces attributes of the object described by info, its content type and checksum are kept
+	SetAttributes(ctx context.Context, info ObjectInfo, attrs Attributes) error
+}
+
+// Server is implemented by backends whose objects are served by the service itself
+type Server interface {
+	// Handler serves stored objects, it should be mounted at the path of storage url
+	Handler() http.Handler
+}
+
+// Replicator is implemented by storages which copy objects to another backend,
+// objects written around the storage (e.g. by presigned uploads) are replicated by Replicate
+type Replicator interface {
+	Replicate(ctx context.Context, key string) error
+}
+
+// Purger is implemented by storages whose objects are cached by a CDN, cached copies of objects
+// which are overwritten or deleted are purged by Purge
+type Purger interface {
+	Purge(ctx context.Context, keys ...string) error
+}
+
+// Tiered is implemented by storages which keep rarely read objects in a cheaper cold tier,
+// objects are moved between tiers by tiering.Mover
+type Tiered interface {
+	Hot() Storage
+	Cold() Storage
+}
+
+// Wrapper is implemented by decorators of another storage, optional interfaces of the wrapped storage
+// are found by AsLinker, AsUploadPresigner and AsServer. Decorators which change stored content
+// shouldn't implement it, so clients never bypass them
+type Wrapper interface {
+	Unwrap() Storage
+}
+
+// PutOptions describes stored content
+type PutOptions struct {
+	// ContentType is detected by content when it is empty
+	ContentType string
+	// Size is the length of content, -1 means that it is unknown
+	Size int64
+	// SHA256 is hex encoded checksum of content, backends reject content which doesn't match it
+	SHA256 string
+	// MD5 is base64 encoded digest of content which is sent as Content-MD5, so S3 verifies content on its side
+	MD5 string
+	// Private objects aren't public whatever the storage defaults are, e.g. the bucket ACL,
+	// they are read only through the service
+	Private bool
+	Attributes
+}
+
+// ObjectInfo contains metadata of stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	// SHA256 is the checksum recorded by the backend when the object was stored, it is empty
+	// when the backend doesn't keep checksums
+	SHA256 string
+	// Attributes are empty when the backend doesn't keep them, they could be missed by List
+	Attributes
+}
+
+// NewKey generates unique key outside of Keys layout, it is used for temporary objects and tests
+func NewKey(ext string) string {
+	return LegacyKeyPrefix + uuid.New().String() + ext
+}
+
+// Object is the content stored by Upload
+type Object struct {
+	Key     string
+	Content []byte
+	Attributes
+}
+
+// Upload stores the object, its checksums are sent with it
+func Upload(ctx context.Context, s Storage, obj Object) error {
+	opts := bytesOptions(obj.Content)
+	opts.Attributes = obj.Attributes
+	return s.Put(ctx, obj.Key, bytes.NewReader(obj.Content), opts)
+}
+
+// UploadWithOriginal stores both versions of the image concurrently
+func UploadWithOriginal(ctx context.Context, s Storage, original, resized Object) error {
+	var (
+		errs = make([]error, 2)
+		wg   = new(sync.WaitGroup)
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i, obj := range []Object{original, resized} {
+		wg.Add(1)
+		go func(i int, obj Object) {
+			defer wg.Done()
+			if errs[i] = Upload(ctx, s, obj); errs[i] != nil {
+				cancel()
+			}
+		}(i, obj)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Copy copies the object with its content type, checksum and attributes, ErrNotFound is returned when from misses it
+func Copy(ctx context.Context, from, to Storage, key string) error {
+	info, err := from.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	content, err := from.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return to.Put(ctx, key, content, PutOptions{
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		SHA256:      info.SHA256,
+		Private:     Keys.Private(key),
+		Attributes:  info.Attributes,
+	})
+}
+
+// SetAttributes replaces attributes of the object described by info, storages which aren't AttributesSetters
+// store the object again, its content is verified by info.SHA256.
+// Decorators implement AttributesSetter by it, so the object reaches all their backends
+func SetAttributes(ctx context.Context, s Storage, info ObjectInfo, attrs Attributes) error {
+	if setter, ok := s.(AttributesSetter); ok {
+		return setter.SetAttributes(ctx, info, attrs)
+	}
+
+	content, err := s.Get(ctx, info.Key)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return s.Put(ctx, info.Key, content, PutOptions{
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		SHA256:      info.SHA256,
+		Private:     Keys.Private(info.Key),
+		Attributes:  attrs,
+	})
+}
+
+// Link returns the link of the object which is given to clients,
+// it is temporary when the storage is a Linker and permanent otherwise
+func Link(s Storage, key string) (string, error) {
+	if l, ok := AsLinker(s); ok {
+		return l.Link(key)
+	}
+	return s.URL(key), nil
+}
+
+// Replicate replicates the object which is written around s, it does nothing when objects aren't replicated
+func Replicate(ctx context.Context, s Storage, key string) error {
+	for ; s != nil; s = Unwrap(s) {
+		if r, ok := s.(Replicator); ok {
+			return r.Replicate(ctx, key)
+		}
+	}
+	return nil
+}
+
+// Purge purges cached copies of the objects, it does nothing when objects aren't cached
+func Purge(ctx context.Context, s Storage, keys ...string) error {
+	for ; s != nil; s = Unwrap(s) {
+		if p, ok := s.(Purger); ok {
+			return p.Purge(ctx, keys...)
+		}
+	}
+	return nil
+}
+
+// GetRange reads the part of the object, storages which aren't RangeReaders read and skip the beginning of it.
+// Decorators implement RangeReader by it, since a range of the backend isn't a range of changed content
+func GetRange(ctx context.Context, s Storage, key string, offset, length int64) (io.ReadCloser, error) {
+	if r, ok := s.(RangeReader); ok {
+		return r.GetRange(ctx, key, offset, length)
+	}
+
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = io.CopyN(ioutil.Discard, body, offset); err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+
+	return LimitBody(body, length), nil
+}
+
+// LimitBody returns the body which reads up to n bytes of body and closes it
+func LimitBody(body io.ReadCloser, n int64) io.ReadCloser {
+	return limitedBody{Reader: io.LimitReader(body, n), Closer: body}
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Unwrap returns the storage decorated by s or nil when s isn't a Wrapper
+func Unwrap(s Storage) Storage {
+	if w, ok := s.(Wrapper); ok {
+		return w.Unwrap()
+	}
+	return nil
+}
+
+// AsLinker returns the first Linker in the chain of decorators
+func AsLinker(s Storage) (Linker, bool) {
+	for ; s != nil; s = Unwrap(s) {
+		if l, ok := s.(Linker); ok {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// AsUploadPresigner returns the first UploadPresigner in the chain of decorators
+func AsUploadPresigner(s Storage) (UploadPresigner, bool) {
+	for ; s != nil; s = Unwrap(s) {
+		if p, ok := s.(UploadPresigner); ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// AsServer returns the first Server in the chain of decorators
+func AsServer(s Storage) (Server, bool) {
+	for ; s != nil; s = Unwrap(s) {
+		if srv, ok := s.(Server); ok {
+			return srv, true
+		}
+	}
+	return nil, false
+}
+
+// AsTiered returns the first Tiered in the chain of decorators
+func AsTiered(s Storage) (Tiered, bool) {
+	for ; s != nil; s = Unwrap(s) {
+		if t, ok := s.(Tiered); ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func bytesOptions(content []byte) PutOptions {
+	return PutOptions{
+		ContentType: http.DetectContentType(content),
+		Size:        int64(len(content)),
+		SHA256:      Checksum(content),
+		MD5:         contentMD5(content),
+	}
 }
@@ -0,0 +1,441 @@
+// Package s3fake provides a minimal S3-compatible server which is enough to run aws storage in tests.
+// It supports path-style object requests including multipart uploads, copies of objects and ListObjectsV2,
+// user metadata, tags and Content-MD5 of single part uploads, authentication is not checked.
+package s3fake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultMaxKeys is the page size of listing, it is small to make paging visible in tests
+const defaultMaxKeys = 100
+
+type object struct {
+	data        []byte
+	contentType string
+	etag        string
+	metadata    http.Header
+	tagging     string
+	modified    time.Time
+}
+
+type upload struct {
+	bucket      string
+	key         string
+	contentType string
+	metadata    http.Header
+	tagging     string
+	parts       map[int][]byte
+}
+
+// Server keeps objects of all buckets in memory
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]object
+	uploads map[string]*upload
+}
+
+// New starts the server, it should be closed by Close
+func New() *Server {
+	s := &Server{
+		objects: make(map[string]object),
+		uploads: make(map[string]*upload),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if r.Method == http.MethodGet && parts[0] != "" && (len(parts) == 1 || parts[1] == "") && r.URL.Query().Get("list-type") == "2" {
+		s.listObjects(w, r, parts[0])
+		return
+	}
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "only object requests are supported")
+		return
+	}
+
+	var (
+		bucket, key = parts[0], parts[1]
+		query       = r.URL.Query()
+		uploadID    = query.Get("uploadId")
+	)
+
+	switch {
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && uploadID != "":
+		s.uploadPart(w, r, uploadID)
+	case r.Method == http.MethodPost && uploadID != "":
+		s.completeMultipartUpload(w, r, uploadID)
+	case r.Method == http.MethodDelete && uploadID != "":
+		s.mu.Lock()
+		delete(s.uploads, uploadID)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, bucket+"/"+key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "request isn't supported")
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	if digest := r.Header.Get("Content-MD5"); digest != "" {
+		sum := md5.Sum(data)
+		if digest != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+			return
+		}
+	}
+
+	obj := newObject(data, r.Header.Get("Content-Type"), md5Hex(data), userMetadata(r.Header))
+	obj.tagging = r.Header.Get("X-Amz-Tagging")
+
+	s.mu.Lock()
+	s.objects[bucket+"/"+key] = obj
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", `"`+obj.etag+`"`)
+}
+
+// copyObject copies the object of the source, metadata and tags are replaced only by REPLACE directives
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[source]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if etag := r.Header.Get("X-Amz-Copy-Source-If-Match"); etag != "" && strings.Trim(etag, `"`) != obj.etag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+
+	copied := newObject(obj.data, obj.contentType, obj.etag, obj.metadata)
+	copied.tagging = obj.tagging
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		copied = newObject(obj.data, r.Header.Get("Content-Type"), obj.etag, userMetadata(r.Header))
+		copied.tagging = obj.tagging
+	}
+	if r.Header.Get("X-Amz-Tagging-Directive") == "REPLACE" {
+		copied.tagging = r.Header.Get("X-Amz-Tagging")
+	}
+	s.objects[bucket+"/"+key] = copied
+
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: `"` + copied.etag + `"`, LastModified: copied.modified.UTC().Format(time.RFC3339)})
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.mu.Lock()
+	obj, ok := s.objects[bucket+"/"+key]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	data, status := obj.data, http.StatusOK
+	// only single "bytes=first-last" ranges are supported, as the storage requests them
+	var first, last int
+	if n, _ := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last); n == 2 && first < len(data) {
+		if last >= len(data) {
+			last = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+		data, status = data[first:last+1], http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Type", obj.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", `"`+obj.etag+`"`)
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+	for name, values := range obj.metadata {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// listObjects lists keys in lexical order, the continuation token is the last listed key
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	var (
+		query   = r.URL.Query()
+		prefix  = query.Get("prefix")
+		after   = query.Get("continuation-token")
+		maxKeys = defaultMaxKeys
+	)
+
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = n
+	}
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		key := strings.TrimPrefix(name, bucket+"/")
+		if key != name && strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	truncated := len(keys) > maxKeys
+	if truncated {
+		keys = keys[:maxKeys]
+	}
+
+	contents := make([]content, 0, len(keys))
+	for _, key := range keys {
+		obj := s.objects[bucket+"/"+key]
+		contents = append(contents, content{
+			Key:          key,
+			LastModified: obj.modified.UTC().Format(time.RFC3339Nano),
+			ETag:         `"` + obj.etag + `"`,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	s.mu.Unlock()
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		NextContinuationToken string    `xml:",omitempty"`
+		Contents              []content `xml:"Contents"`
+	}{
+		Name:        bucket,
+		Prefix:      prefix,
+		KeyCount:    len(contents),
+		MaxKeys:     maxKeys,
+		IsTruncated: truncated,
+		Contents:    contents,
+	}
+	if truncated {
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	writeXML(w, result)
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	id := uuid.New().String()
+
+	s.mu.Lock()
+	s.uploads[id] = &upload{
+		bucket:      bucket,
+		key:         key,
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    userMetadata(r.Header),
+		tagging:     r.Header.Get("X-Amz-Tagging"),
+		parts:       make(map[int][]byte),
+	}
+	s.mu.Unlock()
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, uploadID string) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	u, ok := s.uploads[uploadID]
+	if ok {
+		u.parts[number] = data
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+
+	w.Header().Set("ETag", `"`+md5Hex(data)+`"`)
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	var complete struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	numbers := make([]int, 0, len(complete.Parts))
+	for _, p := range complete.Parts {
+		numbers = append(numbers, p.PartNumber)
+	}
+	sort.Ints(numbers)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[uploadID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+
+	var data, sums bytes.Buffer
+	for _, n := range numbers {
+		part, ok := u.parts[n]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d wasn't uploaded", n))
+			return
+		}
+		data.Write(part)
+		sum := md5.Sum(part)
+		sums.Write(sum[:])
+	}
+
+	obj := newObject(data.Bytes(), u.contentType, fmt.Sprintf("%s-%d", md5Hex(sums.Bytes()), len(numbers)), u.metadata)
+	obj.tagging = u.tagging
+	s.objects[u.bucket+"/"+u.key] = obj
+	delete(s.uploads, uploadID)
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Location: s.URL + "/" + u.bucket + "/" + u.key, Bucket: u.bucket, Key: u.key, ETag: `"` + obj.etag + `"`})
+}
+
+func newObject(data []byte, contentType, etag string, metadata http.Header) object {
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+
+	return object{
+		data:        data,
+		contentType: contentType,
+		etag:        etag,
+		metadata:    metadata,
+		modified:    time.Now(),
+	}
+}
+
+// userMetadata returns x-amz-meta-*, Cache-Control and Content-Disposition headers which are returned with the object
+func userMetadata(header http.Header) http.Header {
+	metadata := make(http.Header)
+	for name, values := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") || name == "Cache-Control" || name == "Content-Disposition" {
+			metadata[name] = values
+		}
+	}
+	return metadata
+}
+
+// Tagging returns tags of the object as they were sent by x-amz-tagging header
+func (s *Server) Tagging(bucket, key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[bucket+"/"+key].tagging
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,390 @@
+// Package storagetest contains conformance tests which every storage.Storage implementation should pass
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// Factory creates empty storage for a single test
+type Factory func(t *testing.T) storage.Storage
+
+const (
+	// largeSize is bigger than the part size of S3 multipart uploads
+	largeSize = 6<<20 + 123
+
+	concurrentUploads = 16
+)
+
+// pngHeader is enough to detect image/png content type
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+// Run runs all conformance tests against storages created by newStorage
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"Overwrite", testOverwrite},
+		{"ContentType", testContentType},
+		{"Missing", testMissing},
+		{"Range", testRange},
+		{"Delete", testDelete},
+		{"URLKey", testURLKey},
+		{"List", testList},
+		{"ConcurrentUploads", testConcurrentUploads},
+		{"ConcurrentOverwrite", testConcurrentOverwrite},
+		{"LargeObject", testLargeObject},
+		{"Checksum", testChecksum},
+		{"Attributes", testAttributes},
+		{"SetAttributes", testSetAttributes},
+	}
+
+	for _, tt := range tests {
+		test := tt.test
+		t.Run(tt.name, func(t *testing.T) {
+			test(t, newStorage(t))
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	content := []byte("content of the object")
+	key := storage.NewKey(".txt")
+
+	err := s.Put(ctx, key, bytes.NewReader(content), storage.PutOptions{Size: int64(len(content))})
+	if !assert.NoError(t, err, "put failed") {
+		return
+	}
+
+	assert.Equal(t, content, get(t, s, key), "unexpected content")
+
+	info, err := s.Stat(ctx, key)
+	if assert.NoError(t, err, "stat failed") {
+		assert.Equal(t, key, info.Key)
+		assert.Equal(t, int64(len(content)), info.Size)
+		assert.NotEmpty(t, info.ETag, "etag should be set")
+		assert.False(t, info.LastModified.IsZero(), "last modified should be set")
+	}
+
+	exists, err := s.Exists(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, exists, "stored object should exist")
+}
+
+func testOverwrite(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := storage.NewKey(".txt")
+
+	assert.NoError(t, s.Put(ctx, key, bytes.NewBufferString("first"), storage.PutOptions{Size: -1}))
+	assert.NoError(t, s.Put(ctx, key, bytes.NewBufferString("second version"), storage.PutOptions{Size: -1}))
+
+	assert.Equal(t, []byte("second version"), get(t, s, key), "object should be replaced")
+
+	info, err := s.Stat(ctx, key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len("second version")), info.Size)
+	}
+}
+
+func testContentType(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	content := append(pngHeader, make([]byte, 64)...)
+
+	detected := storage.NewKey(".png")
+	assert.NoError(t, s.Put(ctx, detected, bytes.NewReader(content), storage.PutOptions{Size: int64(len(content))}))
+
+	info, err := s.Stat(ctx, detected)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "image/png", info.ContentType, "content type should be detected")
+	}
+}
+
+func testMissing(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := storage.NewKey(".txt")
+
+	_, err := s.Get(ctx, key)
+	assert.Equal(t, storage.ErrNotFound, err, "get of missing object should return ErrNotFound")
+
+	_, err = s.Stat(ctx, key)
+	assert.Equal(t, storage.ErrNotFound, err, "stat of missing object should return ErrNotFound")
+
+	exists, err := s.Exists(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, exists, "missing object shouldn't exist")
+}
+
+func testRange(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := storage.NewKey(".txt")
+
+	assert.NoError(t, s.Put(ctx, key, bytes.NewBufferString("0123456789"), storage.PutOptions{Size: -1}))
+
+	testCases := []struct {
+		offset, length int64
+		exp            string
+	}{
+		{0, 3, "012"},
+		{4, 3, "456"},
+		{8, 5, "89"},
+	}
+
+	for _, tc := range testCases {
+		body, err := storage.GetRange(ctx, s, key, tc.offset, tc.length)
+		if assert.NoError(t, err) {
+			content, err := ioutil.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.exp, string(content), "unexpected range %d+%d", tc.offset, tc.length)
+			assert.NoError(t, body.Close())
+		}
+	}
+
+	_, err := storage.GetRange(ctx, s, storage.NewKey(".txt"), 0, 1)
+	assert.Equal(t, storage.ErrNotFound, err, "range of missing object should return ErrNotFound")
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := storage.NewKey(".txt")
+
+	assert.NoError(t, s.Put(ctx, key, bytes.NewBufferString("content"), storage.PutOptions{Size: -1}))
+	assert.NoError(t, s.Delete(ctx, key), "delete failed")
+
+	exists, err := s.Exists(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, exists, "deleted object shouldn't exist")
+
+	_, err = s.Get(ctx, key)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	assert.NoError(t, s.Delete(ctx, key), "deleting missing object shouldn't fail")
+}
+
+func testURLKey(t *testing.T, s storage.Storage) {
+	for _, key := range []string{storage.NewKey(".jpg"), "pictures/nested/dir/image.png", "image-without-dir"} {
+		link := s.URL(key)
+		assert.NotEqual(t, key, link, "url should differ from key")
+
+		parsed, err := s.Key(link)
+		assert.NoError(t, err)
+		assert.Equal(t, key, parsed, "key should be parsed from url")
+	}
+}
+
+func testList(t *testing.T, s storage.Storage) {
+	var (
+		ctx     = context.Background()
+		prefix  = "list-" + uuid.New().String() + "/"
+		keys    = []string{prefix + "a.txt", prefix + "b.txt", prefix + "nested/dir/c.txt"}
+		errStop = errors.New("stop")
+	)
+
+	for _, key := range append(keys, "other-"+prefix+"d.txt") {
+		assert.NoError(t, s.Put(ctx, key, bytes.NewBufferString(key), storage.PutOptions{Size: -1}))
+	}
+
+	var listed []string
+	err := s.List(ctx, prefix, func(info storage.ObjectInfo) error {
+		listed = append(listed, info.Key)
+		assert.Equal(t, int64(len(info.Key)), info.Size, "unexpected size of %s", info.Key)
+		assert.False(t, info.LastModified.IsZero(), "last modified should be set")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, keys, listed, "only objects with prefix should be listed")
+
+	calls := 0
+	err = s.List(ctx, prefix, func(storage.ObjectInfo) error {
+		calls++
+		return errStop
+	})
+	assert.Equal(t, errStop, err, "error of fn should be returned")
+	assert.Equal(t, 1, calls, "listing should stop on error")
+}
+
+func testConcurrentUploads(t *testing.T, s storage.Storage) {
+	var (
+		ctx  = context.Background()
+		keys = make([]string, concurrentUploads)
+		wg   sync.WaitGroup
+	)
+
+	for i := range keys {
+		keys[i] = storage.NewKey(".txt")
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := []byte(fmt.Sprintf("content of object %d", i))
+			assert.NoError(t, s.Put(ctx, keys[i], bytes.NewReader(content), storage.PutOptions{Size: int64(len(content))}))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		assert.Equal(t, fmt.Sprintf("content of object %d", i), string(get(t, s, key)), "objects are mixed up")
+	}
+}
+
+// testConcurrentOverwrite checks that readers see one of the written versions as a whole
+func testConcurrentOverwrite(t *testing.T, s storage.Storage) {
+	var (
+		ctx      = context.Background()
+		key      = storage.NewKey(".txt")
+		versions = make(map[string]bool, concurrentUploads)
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < concurrentUploads; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, 32<<10)
+		versions[string(content)] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Put(ctx, key, bytes.NewReader(content), storage.PutOptions{Size: int64(len(content))}))
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, versions[string(get(t, s, key))], "object should be one of written versions")
+}
+
+func testLargeObject(t *testing.T, s storage.Storage) {
+	var (
+		ctx     = context.Background()
+		key     = storage.NewKey(".bin")
+		content = make([]byte, largeSize)
+	)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	// size is unknown, so the content has to be streamed
+	err := s.Put(ctx, key, ioutil.NopCloser(bytes.NewReader(content)), storage.PutOptions{Size: -1})
+	if !assert.NoError(t, err, "put failed") {
+		return
+	}
+
+	assert.True(t, bytes.Equal(content, get(t, s, key)), "large object is corrupted")
+
+	info, err := s.Stat(ctx, key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(largeSize), info.Size)
+	}
+}
+
+func testChecksum(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	content := []byte("content of the object")
+	key := storage.NewKey(".txt")
+
+	if !assert.NoError(t, storage.Upload(ctx, s, storage.Object{Key: key, Content: content}), "upload failed") {
+		return
+	}
+
+	info, err := s.Stat(ctx, key)
+	if assert.NoError(t, err) && info.SHA256 != "" {
+		assert.Equal(t, storage.Checksum(content), info.SHA256, "recorded checksum should be the one of the content")
+	}
+
+	downloaded, err := storage.Download(ctx, s, key, storage.Checksum(content))
+	assert.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+
+	_, err = storage.Download(ctx, s, key, storage.Checksum([]byte("other content")))
+	assert.Equal(t, storage.ErrChecksumMismatch, err, "download of different content should fail")
+
+	corrupted := storage.NewKey(".txt")
+	err = s.Put(ctx, corrupted, bytes.NewReader(content), storage.PutOptions{
+		Size:   int64(len(content)),
+		SHA256: storage.Checksum([]byte("other content")),
+	})
+	assert.Equal(t, storage.ErrChecksumMismatch, err, "content which doesn't match its checksum should be rejected")
+
+	exists, err := s.Exists(ctx, corrupted)
+	assert.NoError(t, err)
+	assert.False(t, exists, "rejected content shouldn't be stored")
+}
+
+// testAttributes checks that attributes are either kept or ignored by the backend
+func testAttributes(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := storage.NewKey(".png")
+	attrs := storage.Attributes{
+		CacheControl:       "public, max-age=60",
+		ContentDisposition: `inline; filename="image.png"`,
+		Metadata:           map[string]string{storage.MetaVariant: "100x100"},
+	}
+
+	err := storage.Upload(ctx, s, storage.Object{Key: key, Content: pngHeader, Attributes: attrs})
+	if !assert.NoError(t, err, "upload failed") {
+		return
+	}
+
+	info, err := s.Stat(ctx, key)
+	if assert.NoError(t, err) && !info.Attributes.Equal(storage.Attributes{}) {
+		assert.True(t, attrs.Equal(info.Attributes), "unexpected attributes: %+v", info.Attributes)
+	}
+}
+
+// testSetAttributes checks that attributes are replaced without changing the content and its checksum
+func testSetAttributes(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := storage.NewKey(".png")
+	attrs := storage.Attributes{
+		CacheControl: "public, max-age=60",
+		Metadata:     map[string]string{storage.MetaVariant: "100x100"},
+	}
+
+	if !assert.NoError(t, storage.Upload(ctx, s, storage.Object{Key: key, Content: pngHeader}), "upload failed") {
+		return
+	}
+
+	info, err := s.Stat(ctx, key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	info.SHA256 = storage.Checksum(pngHeader)
+
+	if !assert.NoError(t, storage.SetAttributes(ctx, s, info, attrs)) {
+		return
+	}
+
+	assert.Equal(t, pngHeader, get(t, s, key), "content should be kept")
+
+	info, err = s.Stat(ctx, key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "image/png", info.ContentType, "content type should be kept")
+		if !info.Attributes.Equal(storage.Attributes{}) {
+			assert.True(t, attrs.Equal(info.Attributes), "unexpected attributes: %+v", info.Attributes)
+		}
+		if info.SHA256 != "" {
+			assert.Equal(t, storage.Checksum(pngHeader), info.SHA256, "checksum should be kept")
+		}
+	}
+}
+
+func get(t *testing.T, s storage.Storage, key string) []byte {
+	r, err := s.Get(context.Background(), key)
+	if !assert.NoError(t, err, "get failed") {
+		return nil
+	}
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(r)
+	assert.NoError(t, err, "read failed")
+	return content
+}
@@ -0,0 +1,118 @@
+// Package tiered keeps rarely read objects in a cheaper cold backend, e.g. another bucket with infrequent access class
+package tiered
+
+import (
+	"context"
+	"io"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+// Storage writes objects to the hot tier and reads them from the cold tier when the hot one misses them,
+// so objects moved between tiers are available during and after the move. Objects are moved by tiering.Mover
+type Storage struct {
+	hot  storage.Storage
+	cold storage.Storage
+}
+
+// NewStorage creates tiered storage
+func NewStorage(hot, cold storage.Storage) *Storage {
+	return &Storage{
+		hot:  hot,
+		cold: cold,
+	}
+}
+
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	return s.hot.Put(ctx, key, r, opts)
+}
+
+// SetAttributes sets attributes of the object of the hot tier, objects of the cold one are passed to Cold()
+func (s *Storage) SetAttributes(ctx context.Context, info storage.ObjectInfo, attrs storage.Attributes) error {
+	return storage.SetAttributes(ctx, s.hot, info, attrs)
+}
+
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.hot.Get(ctx, key)
+	if err != storage.ErrNotFound {
+		return body, err
+	}
+
+	return s.cold.Get(ctx, key)
+}
+
+func (s *Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, err := storage.GetRange(ctx, s.hot, key, offset, length)
+	if err != storage.ErrNotFound {
+		return body, err
+	}
+
+	return storage.GetRange(ctx, s.cold, key, offset, length)
+}
+
+func (s *Storage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	info, err := s.hot.Stat(ctx, key)
+	if err != storage.ErrNotFound {
+		return info, err
+	}
+
+	return s.cold.Stat(ctx, key)
+}
+
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := s.hot.Exists(ctx, key)
+	if exists || err != nil {
+		return exists, err
+	}
+
+	return s.cold.Exists(ctx, key)
+}
+
+// Delete removes the object from both tiers
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.hot.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	return s.cold.Delete(ctx, key)
+}
+
+// List lists the hot tier and then the cold one, objects which are being moved could be listed twice
+func (s *Storage) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	if err := s.hot.List(ctx, prefix, fn); err != nil {
+		return err
+	}
+
+	return s.cold.List(ctx, prefix, fn)
+}
+
+// URL returns the link of the hot tier, links of objects moved to the cold tier are built by Cold
+func (s *Storage) URL(key string) string {
+	return s.hot.URL(key)
+}
+
+// Key accepts links of both tiers
+func (s *Storage) Key(link string) (string, error) {
+	key, err := s.hot.Key(link)
+	if err == nil {
+		return key, nil
+	}
+
+	if key, coldErr := s.cold.Key(link); coldErr == nil {
+		return key, nil
+	}
+	return "", err
+}
+
+// Unwrap returns the hot tier
+func (s *Storage) Unwrap() storage.Storage {
+	return s.hot
+}
+
+func (s *Storage) Hot() storage.Storage {
+	return s.hot
+}
+
+func (s *Storage) Cold() storage.Storage {
+	return s.cold
+}
@@ -0,0 +1,82 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/storagetest"
+)
+
+const (
+	hotURL  = "http://localhost/files"
+	coldURL = "http://localhost/cold"
+)
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewStorage(memory.NewStorage(hotURL), memory.NewStorage(coldURL))
+	})
+}
+
+func TestStorage_Cold(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		hot, cold = memory.NewStorage(hotURL), memory.NewStorage(coldURL)
+		s         = NewStorage(hot, cold)
+		key       = "pictures/image.png"
+	)
+
+	assert.NoError(t, cold.Put(ctx, key, bytes.NewBufferString("cold"), storage.PutOptions{Size: -1}))
+
+	content, err := s.Get(ctx, key)
+	if assert.NoError(t, err, "object missed by the hot tier should be read from the cold one") {
+		data, _ := ioutil.ReadAll(content)
+		assert.Equal(t, "cold", string(data))
+		assert.NoError(t, content.Close())
+	}
+
+	_, err = s.Stat(ctx, key)
+	assert.NoError(t, err)
+
+	exists, err := s.Exists(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	key, err = s.Key(coldURL + "/" + key)
+	assert.NoError(t, err, "links of the cold tier should be accepted")
+	assert.Equal(t, "pictures/image.png", key)
+
+	failure := errors.New("HOT ERROR")
+	hot.InjectFailure(func(memory.Op, string) error { return failure })
+	_, err = s.Get(ctx, key)
+	assert.Equal(t, failure, err, "failure of the hot tier shouldn't be hidden by the cold one")
+	hot.InjectFailure(nil)
+
+	assert.NoError(t, hot.Put(ctx, key, bytes.NewBufferString("hot"), storage.PutOptions{Size: -1}))
+	assert.NoError(t, s.Delete(ctx, key))
+	assert.Empty(t, hot.Keys())
+	assert.Empty(t, cold.Keys(), "object should be deleted from both tiers")
+}
+
+func TestStorage_Unwrap(t *testing.T) {
+	hot := memory.NewStorage(hotURL)
+	s := NewStorage(hot, memory.NewStorage(coldURL))
+
+	server, ok := storage.AsServer(s)
+	assert.True(t, ok, "optional interfaces of the hot tier should be found")
+	assert.Equal(t, hot, server)
+
+	tiers, ok := storage.AsTiered(s)
+	assert.True(t, ok)
+	assert.Equal(t, s, tiers)
+
+	_, ok = storage.AsTiered(hot)
+	assert.False(t, ok)
+}
@@ -0,0 +1,187 @@
+// Package tiering moves originals which aren't read for TieringColdAfterDays to the cold tier of the storage
+// and restores originals of cold images which are read again
+package tiering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/storage"
+)
+
+const (
+	defaultColdAfter = 30 * 24 * time.Hour
+	batchSize        = 100
+)
+
+var errImageChanged = errors.New("image has been deleted or its original has been replaced during the move")
+
+// Move is a move of the original between tiers
+type Move struct {
+	ImageID uuid.UUID
+	Key     string
+	To      models.StorageTier
+}
+
+// Report describes moved originals, in dry run the moves are only planned
+type Report struct {
+	Images   int
+	Moved    int
+	Restored int
+	Failed   int
+	Moves    []Move
+}
+
+// Mover moves originals between tiers by the time they were read for the last time.
+// The original is copied, deleted from the previous tier and only then its tier is saved, the tiered storage
+// reads it from both tiers, so the image is available during the move and failed moves are repeated by the next run
+type Mover struct {
+	log       logger.Logger
+	repo      repository.Repository
+	hot       storage.Storage
+	cold      storage.Storage
+	coldAfter time.Duration
+	interval  time.Duration
+}
+
+// NewMover creates mover, it doesn't move anything until Start or Move is called
+func NewMover(log logger.Logger, repo repository.Repository, tiers storage.Tiered) *Mover {
+	m := &Mover{
+		log:       log,
+		repo:      repo,
+		hot:       tiers.Hot(),
+		cold:      tiers.Cold(),
+		coldAfter: time.Duration(config.Conf.TieringColdAfterDays) * 24 * time.Hour,
+		interval:  time.Duration(config.Conf.TieringIntervalMin) * time.Minute,
+	}
+
+	if m.coldAfter <= 0 {
+		m.coldAfter = defaultColdAfter
+	}
+
+	return m
+}
+
+// SetColdAfter overrides TieringColdAfterDays setting
+func (m *Mover) SetColdAfter(coldAfter time.Duration) {
+	m.coldAfter = coldAfter
+}
+
+// Start moves originals in background every TieringIntervalMin, zero interval disables the job
+func (m *Mover) Start() {
+	if m.interval <= 0 {
+		m.log.Infof("Scheduled tiering of originals is disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := m.Move(context.Background(), false); err != nil {
+				m.log.Errorf("tiering of originals failed due to: %s", err)
+			}
+		}
+	}()
+}
+
+// Move moves originals which aren't read since the cutoff to the cold tier and restores cold ones read since it,
+// failed moves are reported and skipped, the error is returned only when the images cannot be read
+func (m *Mover) Move(ctx context.Context, dryRun bool) (Report, error) {
+	var (
+		report Report
+		cutoff = time.Now().UTC().Add(-m.coldAfter)
+		lastID uuid.UUID
+	)
+
+	m.log.Infof("Started tiering of originals read before %s, dry run: %t", cutoff.Format(time.RFC3339), dryRun)
+
+	for {
+		images, err := m.repo.GetImagesToMove(cutoff, lastID, batchSize)
+		if err != nil {
+			return report, fmt.Errorf("cannot retrieve images: %s", err)
+		}
+
+		for _, img := range images {
+			report.Images++
+
+			to := models.TierCold
+			if img.Tier == models.TierCold {
+				to = models.TierHot
+			}
+			report.Moves = append(report.Moves, Move{ImageID: img.ID, Key: img.OriginalKey, To: to})
+
+			if dryRun {
+				continue
+			}
+
+			if err = m.moveImage(ctx, img, to); err != nil {
+				m.log.Errorf("cannot move original of image %q to %s tier due to: %s", img.ID, to, err)
+				report.Failed++
+				continue
+			}
+
+			if to == models.TierCold {
+				report.Moved++
+			} else {
+				report.Restored++
+			}
+		}
+
+		if len(images) < batchSize {
+			break
+		}
+		lastID = images[len(images)-1].ID
+	}
+
+	m.log.Infof("Finished tiering of originals: %d images, %d moved, %d restored, %d failed",
+		report.Images, report.Moved, report.Restored, report.Failed)
+
+	return report, nil
+}
+
+func (m *Mover) moveImage(ctx context.Context, img models.Images, to models.StorageTier) error {
+	from, dst := m.hot, m.cold
+	if to == models.TierHot {
+		from, dst = m.cold, m.hot
+	}
+
+	err := storage.Copy(ctx, from, dst, img.OriginalKey)
+	if err == storage.ErrNotFound {
+		// the previous move has failed after the original was deleted from the source tier
+		var exists bool
+		if exists, err = dst.Exists(ctx, img.OriginalKey); err == nil && !exists {
+			err = storage.ErrNotFound
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot copy %s: %s", img.OriginalKey, err)
+	}
+
+	if err = from.Delete(ctx, img.OriginalKey); err != nil {
+		return fmt.Errorf("cannot delete %s from the previous tier: %s", img.OriginalKey, err)
+	}
+
+	set, err := m.repo.SetImageTier(img, to)
+	if err != nil {
+		return fmt.Errorf("cannot save the tier: %s", err)
+	}
+	if !set {
+		// the copy isn't referenced anymore
+		if err = dst.Delete(ctx, img.OriginalKey); err != nil {
+			m.log.Errorf("cannot delete copy of %q due to: %s", img.OriginalKey, err)
+		}
+		return errImageChanged
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package tiering
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/storage"
+	"github.com/Dimitriy14/image-resizing/storage/memory"
+	"github.com/Dimitriy14/image-resizing/storage/tiered"
+)
+
+func TestMover_Move(t *testing.T) {
+	log := logger.NewMokLogger()
+	logger.Log = log
+
+	var (
+		unread   = models.Images{ID: uuid.New(), OriginalKey: "pictures/unread.png", Tier: models.TierHot}
+		read     = models.Images{ID: uuid.New(), OriginalKey: "pictures/read.png", Tier: models.TierCold}
+		resumed  = models.Images{ID: uuid.New(), OriginalKey: "pictures/resumed.png", Tier: models.TierHot}
+		missing  = models.Images{ID: uuid.New(), OriginalKey: "pictures/missing.png", Tier: models.TierHot}
+		dbErr    = errors.New("DB ERROR")
+		allMoves = []Move{
+			{ImageID: unread.ID, Key: unread.OriginalKey, To: models.TierCold},
+			{ImageID: read.ID, Key: read.OriginalKey, To: models.TierHot},
+			{ImageID: resumed.ID, Key: resumed.OriginalKey, To: models.TierCold},
+			{ImageID: missing.ID, Key: missing.OriginalKey, To: models.TierCold},
+		}
+	)
+
+	testCases := []struct {
+		name      string
+		dryRun    bool
+		getErr    error
+		setTier   bool
+		setErr    error
+		expReport Report
+		expHot    []string
+		expCold   []string
+		expFail   bool
+	}{
+		{
+			name:      "Good case",
+			setTier:   true,
+			expReport: Report{Images: 4, Moved: 2, Restored: 1, Failed: 1, Moves: allMoves},
+			expHot:    []string{read.OriginalKey},
+			expCold:   []string{unread.OriginalKey, resumed.OriginalKey},
+		},
+		{
+			name:      "Dry run case",
+			dryRun:    true,
+			expReport: Report{Images: 4, Moves: allMoves},
+			expHot:    []string{unread.OriginalKey},
+			expCold:   []string{read.OriginalKey, resumed.OriginalKey},
+		},
+		{
+			name:      "Changed image case",
+			expReport: Report{Images: 4, Failed: 4, Moves: allMoves},
+			expHot:    []string{},
+			expCold:   []string{},
+		},
+		{
+			name:      "Save tier error case",
+			setErr:    dbErr,
+			expReport: Report{Images: 4, Failed: 4, Moves: allMoves},
+			expHot:    []string{read.OriginalKey},
+			expCold:   []string{unread.OriginalKey, resumed.OriginalKey},
+		},
+		{
+			name:    "Retrieve images error case",
+			getErr:  dbErr,
+			expHot:  []string{unread.OriginalKey},
+			expCold: []string{read.OriginalKey, resumed.OriginalKey},
+			expFail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				ctx       = context.Background()
+				hot, cold = memory.NewStorage("http://localhost/files"), memory.NewStorage("http://localhost/cold")
+			)
+
+			assert.NoError(t, hot.Put(ctx, unread.OriginalKey, bytes.NewBufferString("unread"), storage.PutOptions{Size: -1}))
+			assert.NoError(t, cold.Put(ctx, read.OriginalKey, bytes.NewBufferString("read"), storage.PutOptions{Size: -1}))
+			// the previous move has deleted the original from the hot tier, but hasn't saved the tier
+			assert.NoError(t, cold.Put(ctx, resumed.OriginalKey, bytes.NewBufferString("resumed"), storage.PutOptions{Size: -1}))
+
+			repo := mocks.NewMockRepository(ctrl)
+			repo.EXPECT().GetImagesToMove(gomock.Any(), uuid.Nil, batchSize).DoAndReturn(func(cutoff time.Time, _ uuid.UUID, _ int) ([]models.Images, error) {
+				assert.WithinDuration(t, time.Now().Add(-time.Hour), cutoff, time.Minute, "unexpected cutoff")
+				return []models.Images{unread, read, resumed, missing}, tc.getErr
+			})
+			repo.EXPECT().SetImageTier(gomock.Any(), gomock.Any()).DoAndReturn(func(img models.Images, tier models.StorageTier) (bool, error) {
+				assert.NotEqual(t, img.Tier, tier, "image should be moved to the other tier")
+				return tc.setTier, tc.setErr
+			}).AnyTimes()
+
+			m := NewMover(log, repo, tiered.NewStorage(hot, cold))
+			m.SetColdAfter(time.Hour)
+
+			report, err := m.Move(ctx, tc.dryRun)
+			assert.Equal(t, tc.expFail, err != nil, "unexpected error: %v", err)
+			if !tc.expFail {
+				assert.Equal(t, tc.expReport, report)
+			}
+
+			assert.ElementsMatch(t, tc.expHot, hot.Keys(), "unexpected originals of the hot tier")
+			assert.ElementsMatch(t, tc.expCold, cold.Keys(), "unexpected originals of the cold tier")
+		})
+	}
+}
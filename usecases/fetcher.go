@@ -0,0 +1,289 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Dimitriy14/image-resizing/config"
+)
+
+const (
+	defaultFetchMaxSize      = 10 << 20
+	defaultFetchTimeout      = 10 * time.Second
+	defaultFetchMaxRedirects = 3
+	sniffLen                 = 512
+)
+
+var (
+	// ErrRemoteUnavailable is a cause of errors when remote server cannot be reached or responds with an error
+	ErrRemoteUnavailable = errors.New("remote server is unavailable")
+	// ErrForbiddenAddress is a cause of errors when url points to a private, loopback or link-local address
+	ErrForbiddenAddress = errors.New("address is not allowed")
+
+	// blockedNetworks are not reachable by fetcher unless they are allowed by config
+	blockedNetworks = parseCIDRs(
+		"0.0.0.0/8",      // "this" network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local, cloud metadata endpoints
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"224.0.0.0/4",    // multicast
+		"240.0.0.0/4",    // reserved
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	)
+
+	imageExtensions = map[string]string{
+		"image/jpeg": ".jpg",
+		"image/png":  ".png",
+		"image/gif":  ".gif",
+		"image/bmp":  ".bmp",
+	}
+)
+
+//go:generate mockgen -destination=../mocks/mock-fetcher.go -mock_names=ImageFetcher=MockFetcher -package=mocks github.com/Dimitriy14/image-resizing/usecases ImageFetcher
+type ImageFetcher interface {
+	// Fetch downloads an image, the returned filename has an extension of detected image format
+	Fetch(ctx context.Context, rawURL string) (content []byte, filename string, err error)
+}
+
+// NewImageFetcher creates fetcher limited by RemoteFetch* settings
+func NewImageFetcher() (ImageFetcher, error) {
+	f := &fetcherImpl{
+		maxSize:      int64(config.Conf.RemoteFetchMaxSize),
+		maxRedirects: config.Conf.RemoteFetchMaxRedirects,
+	}
+
+	if f.maxSize <= 0 {
+		f.maxSize = defaultFetchMaxSize
+	}
+	if f.maxRedirects <= 0 {
+		f.maxRedirects = defaultFetchMaxRedirects
+	}
+
+	timeout := time.Duration(config.Conf.RemoteFetchTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+
+	allowed, err := ParseCIDRList(config.Conf.RemoteFetchAllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	f.client = NewRestrictedClient(timeout, allowed)
+	f.client.CheckRedirect = f.checkRedirect
+
+	return f, nil
+}
+
+// NewRestrictedClient creates client which cannot reach private, loopback and link-local addresses
+// unless they belong to allowed networks, it is used for urls given by users
+func NewRestrictedClient(timeout time.Duration, allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// the address is checked after DNS resolution, so a hostname cannot be used to reach blocked networks
+		Control: func(_, address string, _ syscall.RawConn) error {
+			return checkAddress(address, allowed)
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// environment proxies would make the checked address a proxy one
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+	}
+}
+
+type fetcherImpl struct {
+	client       *http.Client
+	maxSize      int64
+	maxRedirects int
+}
+
+func (f *fetcherImpl) Fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url: %s", err)
+	}
+
+	if err = CheckURL(u); err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", classifyRequestError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Wrapf(ErrRemoteUnavailable, "remote server responded with status %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > f.maxSize {
+		return nil, "", fmt.Errorf("image size %d exceeds the limit of %d bytes", resp.ContentLength, f.maxSize)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, "", errors.Wrapf(ErrRemoteUnavailable, "cannot read image: %s", err)
+	}
+
+	if int64(len(content)) > f.maxSize {
+		return nil, "", fmt.Errorf("image exceeds the limit of %d bytes", f.maxSize)
+	}
+
+	// Content-Type header of remote server is not trusted, the format is detected by content
+	contentType := http.DetectContentType(content[:min(len(content), sniffLen)])
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	return content, filename(resp.Request.URL, ext), nil
+}
+
+func (f *fetcherImpl) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > f.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", f.maxRedirects)
+	}
+	return CheckURL(req.URL)
+}
+
+func checkAddress(address string, allowed []*net.IPNet) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.Wrapf(ErrForbiddenAddress, "cannot parse ip %q", host)
+	}
+
+	return CheckIP(ip, allowed)
+}
+
+// CheckIP returns ErrForbiddenAddress when ip belongs to blocked networks and none of allowed ones
+func CheckIP(ip net.IP, allowed []*net.IPNet) error {
+	for _, n := range allowed {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return errors.Wrapf(ErrForbiddenAddress, "%s belongs to %s", ip, n)
+		}
+	}
+
+	return nil
+}
+
+// CheckURL accepts absolute http(s) urls without credentials
+func CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return errors.New("url host is empty")
+	}
+
+	if u.User != nil {
+		return errors.New("url with credentials is not allowed")
+	}
+
+	return nil
+}
+
+// classifyRequestError separates blocked addresses and redirect policy violations from network failures
+func classifyRequestError(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+
+	if opErr, ok := urlErr.Err.(*net.OpError); ok {
+		if errors.Cause(opErr.Err) == ErrForbiddenAddress {
+			return opErr.Err
+		}
+		return errors.Wrapf(ErrRemoteUnavailable, "%s", err)
+	}
+
+	if urlErr.Timeout() {
+		return errors.Wrapf(ErrRemoteUnavailable, "%s", err)
+	}
+
+	return err
+}
+
+func filename(u *url.URL, ext string) string {
+	name := strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))
+	if name == "" || name == "." || name == "/" {
+		name = "image"
+	}
+	return name + ext
+}
+
+// ParseCIDRList parses networks set by config
+func ParseCIDRList(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %s", cidr, err)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks, err := ParseCIDRList(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,159 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+)
+
+func TestFetcherImpl_Fetch(t *testing.T) {
+	img := imaging.New(10, 10, color.White)
+	buf := new(bytes.Buffer)
+	if err := imaging.Encode(buf, img, imaging.PNG); err != nil {
+		t.Fatalf("Cannot encode img: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cat", func(w http.ResponseWriter, r *http.Request) {
+		// wrong header shouldn't matter, format is detected by content
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(buf.Bytes())
+	})
+	mux.HandleFunc("/text.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("definitely not an image"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/cat", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/to-file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot parse server address: %s", err)
+	}
+
+	testCases := []struct {
+		name        string
+		url         string
+		allowed     []string
+		maxSize     int
+		expFilename string
+		expCause    error
+		wantErr     bool
+	}{
+		{
+			name:        "Good case",
+			url:         server.URL + "/cat",
+			allowed:     []string{"127.0.0.1/32"},
+			expFilename: "cat.png",
+		},
+		{
+			name:        "Redirect case",
+			url:         server.URL + "/redirect",
+			allowed:     []string{"127.0.0.1/32"},
+			expFilename: "cat.png",
+		},
+		{
+			name:     "Loopback is blocked case",
+			url:      server.URL + "/cat",
+			expCause: ErrForbiddenAddress,
+			wantErr:  true,
+		},
+		{
+			name:     "Hostname resolved to loopback is blocked case",
+			url:      "http://localhost:" + port + "/cat",
+			expCause: ErrForbiddenAddress,
+			wantErr:  true,
+		},
+		{
+			name:    "Unsupported scheme case",
+			url:     "file:///etc/passwd",
+			wantErr: true,
+		},
+		{
+			name:    "Redirect to unsupported scheme case",
+			url:     server.URL + "/to-file",
+			allowed: []string{"127.0.0.1/32"},
+			wantErr: true,
+		},
+		{
+			name:    "Too many redirects case",
+			url:     server.URL + "/loop",
+			allowed: []string{"127.0.0.1/32"},
+			wantErr: true,
+		},
+		{
+			name:    "Too large image case",
+			url:     server.URL + "/cat",
+			allowed: []string{"127.0.0.1/32"},
+			maxSize: 10,
+			wantErr: true,
+		},
+		{
+			name:    "Not an image case",
+			url:     server.URL + "/text.png",
+			allowed: []string{"127.0.0.1/32"},
+			wantErr: true,
+		},
+		{
+			name:     "Remote error case",
+			url:      server.URL + "/missing",
+			allowed:  []string{"127.0.0.1/32"},
+			expCause: ErrRemoteUnavailable,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config.Conf.RemoteFetchAllowedCIDRs = tc.allowed
+			config.Conf.RemoteFetchMaxSize = tc.maxSize
+
+			f, err := NewImageFetcher()
+			if err != nil {
+				t.Fatalf("cannot create fetcher: %s", err)
+			}
+
+			content, filename, err := f.Fetch(context.Background(), tc.url)
+			if tc.wantErr {
+				assert.Error(t, err)
+				if tc.expCause != nil {
+					assert.Equal(t, tc.expCause, errors.Cause(err), "unexpected error cause: %s", err)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, buf.Bytes(), content)
+			assert.Equal(t, tc.expFilename, filename)
+		})
+	}
+}
+
+func TestNewImageFetcherInvalidAllowlist(t *testing.T) {
+	config.Conf.RemoteFetchAllowedCIDRs = []string{"not a network"}
+	defer func() { config.Conf.RemoteFetchAllowedCIDRs = nil }()
+
+	_, err := NewImageFetcher()
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package usecases
 import (
 	"bytes"
 	"image"
+	"io"
 
 	"github.com/Dimitriy14/image-resizing/models"
 	"github.com/disintegration/imaging"
@@ -10,7 +11,8 @@ import (
 
 //go:generate mockgen -destination=../mocks/mock-resizer.go -mock_names=ImageResizer=MockResizer -package=mocks github.com/Dimitriy14/image-resizing/usecases ImageResizer
 type ImageResizer interface {
-	Resize(imageContent []byte, params models.ResizeParams) ([]byte, error)
+	// Resize decodes the image directly from r, so the encoded content doesn't have to be buffered
+	Resize(r io.Reader, params models.ResizeParams) ([]byte, error)
 }
 
 func NewImageResizer() ImageResizer {
@@ -20,11 +22,7 @@ func NewImageResizer() ImageResizer {
 type resiserImpl struct {
 }
 
-func (r *resiserImpl) Resize(imageContent []byte, params models.ResizeParams) ([]byte, error) {
-	var (
-		imageReader = bytes.NewReader(imageContent)
-	)
-
+func (r *resiserImpl) Resize(imageReader io.Reader, params models.ResizeParams) ([]byte, error) {
 	img, formatName, err := image.Decode(imageReader)
 	if err != nil {
 		return nil, err
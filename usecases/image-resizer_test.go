@@ -58,7 +58,7 @@ func TestResiserImpl_Resize(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			s := NewImageResizer()
 
-			resized, err := s.Resize(tc.imageContent, tc.params)
+			resized, err := s.Resize(bytes.NewReader(tc.imageContent), tc.params)
 			if err != nil {
 				if tc.wantError {
 					t.Skipf("Expected error: %s", err)
@@ -0,0 +1,339 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/repository"
+	"github.com/Dimitriy14/image-resizing/usecases"
+)
+
+const (
+	// HeaderEvent contains the type of delivered event
+	HeaderEvent = "X-Resizer-Event"
+	// HeaderDelivery contains the id of delivery, it is the same for all retries
+	HeaderDelivery = "X-Resizer-Delivery"
+	// HeaderTimestamp contains unix time when the payload has been signed
+	HeaderTimestamp = "X-Resizer-Timestamp"
+	// HeaderSignature contains HMAC-SHA256 signature of the payload
+	HeaderSignature = "X-Resizer-Signature"
+
+	signaturePrefix = "sha256="
+	queueSize       = 1024
+
+	defaultWorkers        = 4
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultTimeout        = 10 * time.Second
+)
+
+//go:generate mockgen -destination=../mocks/mock-dispatcher.go -mock_names=Notifier=MockNotifier,Dispatcher=MockDispatcher -package=mocks github.com/Dimitriy14/image-resizing/webhooks Notifier,Dispatcher
+
+// Notifier is notified about image lifecycle events
+type Notifier interface {
+	Notify(event models.Event)
+}
+
+// Dispatcher sends events to the webhooks registered by users
+type Dispatcher interface {
+	Notifier
+	Start()
+	Replay(delivery models.WebhookDelivery) (models.WebhookDelivery, error)
+}
+
+// NewDispatcher creates new dispatcher, it doesn't send anything until Start is called.
+// The client of NewClient without allowed networks is used when the client is nil
+func NewDispatcher(log logger.Logger, repo repository.WebhookRepository, client *http.Client) Dispatcher {
+	d := &dispatcherImpl{
+		log:            log,
+		repo:           repo,
+		client:         client,
+		events:         make(chan models.Event, queueSize),
+		deliveries:     make(chan models.WebhookDelivery, queueSize),
+		workers:        config.Conf.WebhookWorkers,
+		maxAttempts:    config.Conf.WebhookMaxAttempts,
+		initialBackoff: time.Duration(config.Conf.WebhookInitialBackoffMS) * time.Millisecond,
+	}
+
+	if d.workers <= 0 {
+		d.workers = defaultWorkers
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = defaultMaxAttempts
+	}
+	if d.initialBackoff <= 0 {
+		d.initialBackoff = defaultInitialBackoff
+	}
+	if d.client == nil {
+		d.client = newClient(nil)
+	}
+
+	return d
+}
+
+// NewClient creates client of webhook receivers which cannot reach private, loopback and link-local addresses,
+// e.g. cloud metadata endpoints, except WebhookAllowedCIDRs. Redirects aren't followed, so receivers cannot
+// point deliveries to blocked addresses, 3xx responses fail deliveries
+func NewClient() (*http.Client, error) {
+	allowed, err := usecases.ParseCIDRList(config.Conf.WebhookAllowedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebhookAllowedCIDRs: %s", err)
+	}
+	return newClient(allowed), nil
+}
+
+func newClient(allowed []*net.IPNet) *http.Client {
+	timeout := time.Duration(config.Conf.WebhookTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	client := usecases.NewRestrictedClient(timeout, allowed)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}
+
+type dispatcherImpl struct {
+	log            logger.Logger
+	repo           repository.WebhookRepository
+	client         *http.Client
+	events         chan models.Event
+	deliveries     chan models.WebhookDelivery
+	workers        int
+	maxAttempts    int
+	initialBackoff time.Duration
+}
+
+// Start runs background workers and resumes deliveries which were pending before restart
+func (d *dispatcherImpl) Start() {
+	go d.fanOut()
+	for i := 0; i < d.workers; i++ {
+		go d.work()
+	}
+
+	go d.resumePending()
+}
+
+// Notify queues an event without blocking the caller
+func (d *dispatcherImpl) Notify(event models.Event) {
+	select {
+	case d.events <- event:
+	default:
+		d.log.Errorf("webhook queue is full, dropping event %s (%s)", event.ID, event.Type)
+	}
+}
+
+// Replay sends the payload of already existed delivery once again as a new delivery
+func (d *dispatcherImpl) Replay(delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	replay, err := d.repo.SaveDelivery(models.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: delivery.WebhookID,
+		UserID:    delivery.UserID,
+		EventID:   delivery.EventID,
+		Event:     delivery.Event,
+		Payload:   delivery.Payload,
+		Status:    models.DeliveryPending,
+	})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	d.enqueue(replay)
+	return replay, nil
+}
+
+func (d *dispatcherImpl) fanOut() {
+	for event := range d.events {
+		hooks, err := d.repo.GetWebhooksForEvent(event.UserID, event.Type)
+		if err != nil {
+			d.log.Errorf("cannot retrieve webhooks for event %s (%s) due to: %s", event.ID, event.Type, err)
+			continue
+		}
+
+		if len(hooks) == 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			d.log.Errorf("cannot marshal event %s due to: %s", event.ID, err)
+			continue
+		}
+
+		for _, hook := range hooks {
+			delivery := models.WebhookDelivery{
+				// every instance of the service may receive the event from broker,
+				// the id derived from webhook and event lets only one of them send it
+				ID:        uuid.NewSHA1(hook.ID, event.ID[:]),
+				WebhookID: hook.ID,
+				UserID:    event.UserID,
+				EventID:   event.ID,
+				Event:     event.Type,
+				Payload:   string(payload),
+				Status:    models.DeliveryPending,
+			}
+
+			created, err := d.repo.CreateDelivery(delivery)
+			if err != nil {
+				d.log.Errorf("cannot save delivery of event %s to webhook %s due to: %s", event.ID, hook.ID, err)
+				continue
+			}
+
+			if created {
+				d.enqueue(delivery)
+			}
+		}
+	}
+}
+
+func (d *dispatcherImpl) resumePending() {
+	deliveries, err := d.repo.GetPendingDeliveries()
+	if err != nil {
+		d.log.Errorf("cannot retrieve pending webhook deliveries due to: %s", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		d.schedule(delivery)
+	}
+}
+
+func (d *dispatcherImpl) work() {
+	for delivery := range d.deliveries {
+		d.attempt(delivery)
+	}
+}
+
+func (d *dispatcherImpl) enqueue(delivery models.WebhookDelivery) {
+	select {
+	case d.deliveries <- delivery:
+	default:
+		d.log.Errorf("webhook delivery queue is full, delivery %s stays pending", delivery.ID)
+	}
+}
+
+// schedule enqueues the delivery when its next attempt is due
+func (d *dispatcherImpl) schedule(delivery models.WebhookDelivery) {
+	if delivery.NextAttemptAt == nil {
+		d.enqueue(delivery)
+		return
+	}
+
+	wait := time.Until(*delivery.NextAttemptAt)
+	if wait <= 0 {
+		d.enqueue(delivery)
+		return
+	}
+
+	time.AfterFunc(wait, func() { d.enqueue(delivery) })
+}
+
+func (d *dispatcherImpl) attempt(delivery models.WebhookDelivery) {
+	hook, err := d.repo.GetWebhookByID(delivery.UserID, delivery.WebhookID)
+	if err != nil {
+		d.log.Errorf("cannot retrieve webhook %s for delivery %s due to: %s", delivery.WebhookID, delivery.ID, err)
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = fmt.Sprintf("webhook is not available: %s", err)
+		delivery.NextAttemptAt = nil
+		d.save(delivery)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = d.send(hook, delivery)
+	if err == nil {
+		delivery.Status = models.DeliverySucceeded
+		delivery.LastError = ""
+		delivery.NextAttemptAt = nil
+		d.save(delivery)
+		return
+	}
+
+	d.log.Warnf("attempt %d of delivery %s to %s failed: %s", delivery.Attempts, delivery.ID, hook.URL, err)
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.Status = models.DeliveryFailed
+		delivery.NextAttemptAt = nil
+		d.save(delivery)
+		return
+	}
+
+	next := time.Now().Add(d.backoff(delivery.Attempts))
+	delivery.NextAttemptAt = &next
+	d.save(delivery)
+	d.schedule(delivery)
+}
+
+// backoff doubles the delay after every failed attempt
+func (d *dispatcherImpl) backoff(attempts int) time.Duration {
+	return d.initialBackoff * time.Duration(1<<uint(attempts-1))
+}
+
+func (d *dispatcherImpl) save(delivery models.WebhookDelivery) {
+	if _, err := d.repo.SaveDelivery(delivery); err != nil {
+		d.log.Errorf("cannot save delivery %s due to: %s", delivery.ID, err)
+	}
+}
+
+func (d *dispatcherImpl) send(hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	var (
+		body      = []byte(delivery.Payload)
+		timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	)
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the value of signature header for the payload: HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header received by webhook receiver
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
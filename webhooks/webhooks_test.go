@@ -0,0 +1,211 @@
+package webhooks
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dimitriy14/image-resizing/config"
+	"github.com/Dimitriy14/image-resizing/logger"
+	"github.com/Dimitriy14/image-resizing/mocks"
+	"github.com/Dimitriy14/image-resizing/models"
+	"github.com/Dimitriy14/image-resizing/usecases"
+)
+
+const testSecret = "secret"
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	signature := Sign(testSecret, "1577836800", body)
+
+	assert.True(t, Verify(testSecret, "1577836800", body, signature), "signature should be valid")
+	assert.False(t, Verify("another secret", "1577836800", body, signature), "signature with another secret should be invalid")
+	assert.False(t, Verify(testSecret, "1577836801", body, signature), "signature with another timestamp should be invalid")
+}
+
+func TestDispatcherImpl_Notify(t *testing.T) {
+	config.Conf.WebhookInitialBackoffMS = 1
+	config.Conf.WebhookMaxAttempts = 3
+
+	testCases := []struct {
+		name        string
+		failures    int32
+		redirect    bool
+		blocked     bool
+		expStatus   models.DeliveryStatus
+		expAttempts int
+		expCalls    int32
+	}{
+		{
+			name:        "Good case",
+			expStatus:   models.DeliverySucceeded,
+			expAttempts: 1,
+			expCalls:    1,
+		},
+		{
+			name:        "Retried case",
+			failures:    2,
+			expStatus:   models.DeliverySucceeded,
+			expAttempts: 3,
+			expCalls:    3,
+		},
+		{
+			name:        "Exhausted attempts case",
+			failures:    3,
+			expStatus:   models.DeliveryFailed,
+			expAttempts: 3,
+			expCalls:    3,
+		},
+		{
+			name:        "Redirect case",
+			redirect:    true,
+			expStatus:   models.DeliveryFailed,
+			expAttempts: 3,
+			expCalls:    3,
+		},
+		{
+			name:        "Blocked address case",
+			blocked:     true,
+			expStatus:   models.DeliveryFailed,
+			expAttempts: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				calls   int32
+				userID  = uuid.New()
+				event   = models.NewEvent(models.EventImageCreated, userID, models.Images{ID: uuid.New()})
+				results = make(chan models.WebhookDelivery, 10)
+			)
+
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.True(t, Verify(testSecret, r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)), "invalid signature")
+				assert.Equal(t, string(models.EventImageCreated), r.Header.Get(HeaderEvent))
+
+				if atomic.AddInt32(&calls, 1) <= tc.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if tc.redirect {
+					http.Redirect(w, r, "/redirected", http.StatusFound)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer receiver.Close()
+
+			hook := models.Webhook{ID: uuid.New(), UserID: userID, URL: receiver.URL, Secret: testSecret}
+
+			repo := mocks.NewMockWebhookRepository(ctrl)
+			repo.EXPECT().GetPendingDeliveries().Return(nil, nil).AnyTimes()
+			repo.EXPECT().GetWebhooksForEvent(userID, models.EventImageCreated).Return([]models.Webhook{hook}, nil)
+			repo.EXPECT().GetWebhookByID(userID, hook.ID).Return(hook, nil).AnyTimes()
+			repo.EXPECT().CreateDelivery(gomock.Any()).DoAndReturn(func(d models.WebhookDelivery) (bool, error) {
+				assert.Equal(t, models.DeliveryPending, d.Status, "new delivery should be pending")
+				return true, nil
+			})
+			repo.EXPECT().SaveDelivery(gomock.Any()).DoAndReturn(func(d models.WebhookDelivery) (models.WebhookDelivery, error) {
+				if d.Status != models.DeliveryPending {
+					results <- d
+				}
+				return d, nil
+			}).AnyTimes()
+
+			client := newClient(parseCIDRs(t, "127.0.0.1/32"))
+			if tc.blocked {
+				client = nil
+			}
+
+			d := NewDispatcher(logger.NewMokLogger(), repo, client)
+			d.Start()
+			d.Notify(event)
+
+			select {
+			case delivery := <-results:
+				assert.Equal(t, tc.expStatus, delivery.Status, "unexpected delivery status")
+				assert.Equal(t, tc.expAttempts, delivery.Attempts, "unexpected number of attempts")
+				assert.Equal(t, event.ID, delivery.EventID, "unexpected event id")
+				assert.Equal(t, tc.expCalls, atomic.LoadInt32(&calls), "unexpected number of receiver calls")
+			case <-time.After(5 * time.Second):
+				t.Fatal("delivery hasn't been finished")
+			}
+		})
+	}
+}
+
+func TestDispatcherImpl_Replay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		received = make(chan string, 1)
+		results  = make(chan models.WebhookDelivery, 1)
+		userID   = uuid.New()
+	)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(HeaderDelivery)
+	}))
+	defer receiver.Close()
+
+	hook := models.Webhook{ID: uuid.New(), UserID: userID, URL: receiver.URL, Secret: testSecret}
+	original := models.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: hook.ID,
+		UserID:    userID,
+		Event:     models.EventImageDeleted,
+		Payload:   `{}`,
+		Status:    models.DeliveryFailed,
+		Attempts:  5,
+	}
+
+	repo := mocks.NewMockWebhookRepository(ctrl)
+	repo.EXPECT().GetPendingDeliveries().Return(nil, nil).AnyTimes()
+	repo.EXPECT().GetWebhookByID(userID, hook.ID).Return(hook, nil)
+	repo.EXPECT().SaveDelivery(gomock.Any()).DoAndReturn(func(d models.WebhookDelivery) (models.WebhookDelivery, error) {
+		if d.Status != models.DeliveryPending {
+			results <- d
+		}
+		return d, nil
+	}).Times(2)
+
+	d := NewDispatcher(logger.NewMokLogger(), repo, newClient(parseCIDRs(t, "127.0.0.1/32")))
+	d.Start()
+
+	replay, err := d.Replay(original)
+	assert.NoError(t, err)
+	assert.NotEqual(t, original.ID, replay.ID, "replay should be a new delivery")
+	assert.Equal(t, 0, replay.Attempts, "replay should start from the first attempt")
+
+	select {
+	case id := <-received:
+		assert.Equal(t, replay.ID.String(), id, "unexpected delivery id")
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay hasn't been delivered")
+	}
+
+	delivery := <-results
+	assert.Equal(t, models.DeliverySucceeded, delivery.Status, "unexpected delivery status")
+}
+
+func parseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	allowed, err := usecases.ParseCIDRList(cidrs)
+	if err != nil {
+		t.Fatalf("cannot parse cidrs: %s", err)
+	}
+	return allowed
+}